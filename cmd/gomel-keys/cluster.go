@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+)
+
+// Names of the files of a cluster bundle.
+const (
+	committeeFile = "committee.ka"
+	manifestFile  = "manifest.json"
+	launcherFile  = "launch.sh"
+)
+
+// clusterPorts are the addresses of a member, in the order of the ports assigned to them.
+// The ports of a member start at the base port plus portsPerMember times the number of members on the same host before it.
+var clusterPorts = []struct {
+	setup    bool
+	syncType string
+}{
+	{false, "rmc"}, {false, "mcast"}, {false, "fetch"}, {false, "gossip"}, {false, "cert"},
+	{true, "rmc"}, {true, "fetch"}, {true, "gossip"},
+}
+
+// adminPort is the offset of the port of the admin server of a member, right after its addresses.
+var adminPort = len(clusterPorts)
+
+const portsPerMember = 10
+
+// manifestEntry tells how to start a member of the cluster.
+type manifestEntry struct {
+	Pid     uint16   `json:"pid"`
+	Name    string   `json:"name"`
+	Host    string   `json:"host"`
+	Command []string `json:"command"`
+}
+
+func memberFile(pid int) string { return strconv.Itoa(pid) + ".pk" }
+func paramsFile(pid int) string { return strconv.Itoa(pid) + ".params.json" }
+
+// cluster implements the cluster subcommand, creating a complete bundle for running a committee,
+// or changing the keys in an existing one.
+func cluster(args []string) {
+	flags := flag.NewFlagSet("cluster", flag.ExitOnError)
+	schemeName := flags.String("scheme", signing.DefaultScheme, "signature scheme for signing units, one of: "+strings.Join(signing.Schemes(), ", "))
+	hosts := flags.String("hosts", "127.0.0.1", "a comma separated list of hosts, the members are assigned to them in turn")
+	basePort := flags.Int("port", 9000, "the first port used on every host, each member uses "+strconv.Itoa(portsPerMember)+" consecutive ports")
+	dir := flags.String("out", ".", "the directory of the bundle")
+	rekey := flags.Int("rekey", -1, "instead of creating a bundle, generate new keys for the member with the given pid in an existing one")
+	rotateP2P := flags.Bool("rotate_p2p", false, "instead of creating a bundle, generate new p2p keys for all the members of an existing one")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gomel-keys cluster [options] <number>, or gomel-keys cluster -out <dir> (-rekey <pid> | -rotate_p2p).")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var err error
+	switch {
+	case *rekey >= 0:
+		err = rekeyMember(*dir, *rekey)
+	case *rotateP2P:
+		err = rotateP2PKeys(*dir)
+	default:
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return
+		}
+		var nProc int
+		nProc, err = strconv.Atoi(flags.Arg(0))
+		if err != nil || nProc < 4 {
+			fmt.Fprintln(os.Stderr, "The number of members has to be at least 4.")
+			return
+		}
+		var scheme signing.Scheme
+		scheme, err = signing.Lookup(*schemeName)
+		if err == nil {
+			err = newCluster(*dir, scheme, nProc, strings.Split(*hosts, ","), *basePort)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+}
+
+// newCluster writes a bundle with member files, the committee file, parameters of every member, the manifest and the launcher.
+func newCluster(dir string, scheme signing.Scheme, nProc int, hosts []string, basePort int) error {
+	for i, host := range hosts {
+		hosts[i] = strings.TrimSpace(host)
+		if hosts[i] == "" {
+			return errors.New("empty host on the list of hosts")
+		}
+	}
+	perHost := (nProc + len(hosts) - 1) / len(hosts)
+	if basePort <= 0 || basePort+portsPerMember*perHost > 1<<16 {
+		return errors.New("the base port leaves too few ports for the members")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	committee := &config.Committee{SetupAddresses: make(map[string][]string), Addresses: make(map[string][]string)}
+	members := make([]*config.Member, nProc)
+	manifest := make([]manifestEntry, nProc)
+	for pid := 0; pid < nProc; pid++ {
+		host := hosts[pid%len(hosts)]
+		first := basePort + portsPerMember*(pid/len(hosts))
+		for i, addr := range clusterPorts {
+			address := host + ":" + strconv.Itoa(first+i)
+			if addr.setup {
+				committee.SetupAddresses[addr.syncType] = append(committee.SetupAddresses[addr.syncType], address)
+			} else {
+				committee.Addresses[addr.syncType] = append(committee.Addresses[addr.syncType], address)
+			}
+		}
+		name := "node" + strconv.Itoa(pid)
+		committee.Metadata = append(committee.Metadata, map[string]string{"name": name, "host": host})
+
+		ks := makeMemberKeys(scheme, nil)
+		committee.PublicKeys = append(committee.PublicKeys, ks.publicKey)
+		committee.RMCVerificationKeys = append(committee.RMCVerificationKeys, ks.verKey)
+		committee.P2PPublicKeys = append(committee.P2PPublicKeys, ks.p2pPubKey)
+		members[pid] = &config.Member{Pid: uint16(pid), PrivateKey: ks.privateKey, RMCSecretKey: ks.sekKey, P2PSecretKey: ks.p2pSecKey}
+
+		params := map[string]interface{}{
+			config.ConsensusSection: map[string]interface{}{
+				"AdminAddress": host + ":" + strconv.Itoa(first+adminPort),
+			},
+		}
+		if err := writeJSON(filepath.Join(dir, paramsFile(pid)), params); err != nil {
+			return err
+		}
+		manifest[pid] = manifestEntry{
+			Pid:     uint16(pid),
+			Name:    name,
+			Host:    host,
+			Command: []string{"gomel", "-priv", memberFile(pid), "-keys_addrs", committeeFile, "-params", paramsFile(pid)},
+		}
+	}
+	if err := storeBundle(dir, committee, members); err != nil {
+		return err
+	}
+	if err := writeJSON(filepath.Join(dir, manifestFile), manifest); err != nil {
+		return err
+	}
+	return writeLauncher(filepath.Join(dir, launcherFile), manifest)
+}
+
+// rekeyMember replaces all the keys of a single member of an existing bundle.
+func rekeyMember(dir string, pid int) error {
+	committee, members, err := loadBundle(dir)
+	if err != nil {
+		return err
+	}
+	if pid >= len(members) {
+		return errors.New("no member with pid " + strconv.Itoa(pid))
+	}
+	scheme, err := signing.Lookup(committee.Scheme())
+	if err != nil {
+		return err
+	}
+	ks := makeMemberKeys(scheme, nil)
+	committee.PublicKeys[pid] = ks.publicKey
+	committee.RMCVerificationKeys[pid] = ks.verKey
+	committee.P2PPublicKeys[pid] = ks.p2pPubKey
+	members[pid] = &config.Member{Pid: uint16(pid), PrivateKey: ks.privateKey, RMCSecretKey: ks.sekKey, P2PSecretKey: ks.p2pSecKey}
+	return storeBundle(dir, committee, members)
+}
+
+// rotateP2PKeys replaces the p2p keys of all the members of an existing bundle.
+func rotateP2PKeys(dir string) error {
+	committee, members, err := loadBundle(dir)
+	if err != nil {
+		return err
+	}
+	for pid, m := range members {
+		pub, sec, err := p2p.GenerateKeys()
+		if err != nil {
+			return err
+		}
+		committee.P2PPublicKeys[pid] = pub
+		m.P2PSecretKey = sec
+	}
+	return storeBundle(dir, committee, members)
+}
+
+// loadBundle reads the committee file of a bundle and the files of all the members.
+func loadBundle(dir string) (*config.Committee, []*config.Member, error) {
+	f, err := os.Open(filepath.Join(dir, committeeFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	committee, err := config.LoadCommittee(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	members := make([]*config.Member, len(committee.PublicKeys))
+	for pid := range members {
+		f, err := os.Open(filepath.Join(dir, memberFile(pid)))
+		if err != nil {
+			return nil, nil, err
+		}
+		members[pid], err = config.LoadMember(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, errors.New(memberFile(pid) + ": " + err.Error())
+		}
+		if members[pid].PrivateKey == nil {
+			return nil, nil, errors.New(memberFile(pid) + ": the private key is kept by a remote signer")
+		}
+	}
+	return committee, members, nil
+}
+
+// storeBundle writes the committee file and the files of all the members.
+func storeBundle(dir string, committee *config.Committee, members []*config.Member) error {
+	for pid, m := range members {
+		err := writeFile(filepath.Join(dir, memberFile(pid)), 0600, func(w io.Writer) error { return config.StoreMember(w, m) })
+		if err != nil {
+			return err
+		}
+	}
+	return writeFile(filepath.Join(dir, committeeFile), 0644, func(w io.Writer) error { return config.StoreCommittee(w, committee) })
+}
+
+// writeLauncher writes a script starting the members assigned to the host given as its argument, or all of them.
+func writeLauncher(path string, manifest []manifestEntry) error {
+	return writeFile(path, 0755, func(w io.Writer) error {
+		lines := []string{
+			"#!/bin/sh",
+			"# Starts the members of the cluster assigned to the host given as the argument, all of them if there is none.",
+			"# It has to be run in the directory of the bundle, with gomel in PATH.",
+			"HOST=$1",
+		}
+		for _, m := range manifest {
+			lines = append(lines, fmt.Sprintf("if [ -z \"$HOST\" ] || [ \"$HOST\" = \"%s\" ]; then %s > %d.out 2>&1 & fi", m.Host, strings.Join(m.Command, " "), m.Pid))
+		}
+		lines = append(lines, "wait", "")
+		_, err := io.WriteString(w, strings.Join(lines, "\n"))
+		return err
+	})
+}
+
+func writeJSON(path string, v interface{}) error {
+	return writeFile(path, 0644, func(w io.Writer) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+func writeFile(path string, perm os.FileMode, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+)
+
+var _ = Describe("cluster", func() {
+	const nProc = 4
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cluster")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	// load reads the files of the given member the way gomel does and builds its config.
+	load := func(pid int) config.Config {
+		f, err := os.Open(filepath.Join(dir, committeeFile))
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+		committee, err := config.LoadCommittee(f)
+		Expect(err).NotTo(HaveOccurred())
+
+		f, err = os.Open(filepath.Join(dir, memberFile(pid)))
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+		member, err := config.LoadMember(f)
+		Expect(err).NotTo(HaveOccurred())
+
+		f, err = os.Open(filepath.Join(dir, paramsFile(pid)))
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+		params, err := config.LoadParams(f)
+		Expect(err).NotTo(HaveOccurred())
+
+		return config.New(member, committee, params)
+	}
+
+	readMembers := func() [][]byte {
+		files := make([][]byte, nProc)
+		for pid := range files {
+			var err error
+			files[pid], err = ioutil.ReadFile(filepath.Join(dir, memberFile(pid)))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		return files
+	}
+
+	It("should create a bundle with valid configs of all the members", func() {
+		for _, name := range signing.Schemes() {
+			scheme, err := signing.Lookup(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newCluster(dir, scheme, nProc, []string{"10.0.0.1", "10.0.0.2"}, 9000)).To(Succeed())
+			for pid := 0; pid < nProc; pid++ {
+				cnf := load(pid)
+				Expect(config.Valid(cnf)).To(Succeed(), name)
+				Expect(cnf.Pid).To(Equal(uint16(pid)))
+				Expect(cnf.AdminAddress).NotTo(BeEmpty())
+			}
+		}
+		for _, file := range []string{manifestFile, launcherFile} {
+			_, err := os.Stat(filepath.Join(dir, file))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	It("should change only the keys of the rekeyed member", func() {
+		scheme, err := signing.Lookup(signing.DefaultScheme)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(newCluster(dir, scheme, nProc, []string{"127.0.0.1"}, 9000)).To(Succeed())
+		before, _, err := loadBundle(dir)
+		Expect(err).NotTo(HaveOccurred())
+		filesBefore := readMembers()
+
+		Expect(rekeyMember(dir, 2)).To(Succeed())
+		after, _, err := loadBundle(dir)
+		Expect(err).NotTo(HaveOccurred())
+		filesAfter := readMembers()
+
+		for pid := 0; pid < nProc; pid++ {
+			same := pid != 2
+			Expect(string(filesAfter[pid]) == string(filesBefore[pid])).To(Equal(same))
+			Expect(after.PublicKeys[pid].Encode() == before.PublicKeys[pid].Encode()).To(Equal(same))
+			Expect(after.RMCVerificationKeys[pid].Encode() == before.RMCVerificationKeys[pid].Encode()).To(Equal(same))
+			Expect(after.P2PPublicKeys[pid].Encode() == before.P2PPublicKeys[pid].Encode()).To(Equal(same))
+			Expect(config.Valid(load(pid))).To(Succeed())
+		}
+		Expect(after.Addresses).To(Equal(before.Addresses))
+		Expect(after.SetupAddresses).To(Equal(before.SetupAddresses))
+		Expect(after.Metadata).To(Equal(before.Metadata))
+	})
+
+	It("should refuse to rekey a member outside the committee", func() {
+		scheme, err := signing.Lookup(signing.DefaultScheme)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(newCluster(dir, scheme, nProc, []string{"127.0.0.1"}, 9000)).To(Succeed())
+		filesBefore := readMembers()
+
+		Expect(rekeyMember(dir, nProc)).NotTo(Succeed())
+		Expect(readMembers()).To(Equal(filesBefore))
+	})
+})
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
@@ -24,8 +25,8 @@ type memberKeys struct {
 	addresses  map[string][]string
 }
 
-func makeMemberKeys(addresses map[string][]string) memberKeys {
-	pubKey, privKey, _ := signing.GenerateKeys()
+func makeMemberKeys(scheme signing.Scheme, addresses map[string][]string) memberKeys {
+	pubKey, privKey, _ := scheme.GenerateKeys(nil)
 	verKey, sekKey, _ := bn256.GenerateKeys()
 	p2pPubKey, p2pSecKey, _ := p2p.GenerateKeys()
 
@@ -54,19 +55,35 @@ func makeAddrMap(addrs string, addresses map[string][]string) {
 			addresses["fetch"] = append(addresses["fetch"], addr[1:])
 		case 'g':
 			addresses["gossip"] = append(addresses["gossip"], addr[1:])
+		case 'c':
+			addresses["cert"] = append(addresses["cert"], addr[1:])
 		}
 	}
 }
 
 // This program generates files with random keys and local addresses for a committee of the specified size.
 // These files are intended to be used for local and AWS tests of the gomel binary.
+// The cluster subcommand generates a complete bundle for running a committee, see cluster.
 func main() {
-	usageMsg := "Usage: gomel-keys <number> [<addresses_file>]."
-	if len(os.Args) != 2 && len(os.Args) != 3 {
+	if len(os.Args) > 1 && os.Args[1] == "cluster" {
+		cluster(os.Args[2:])
+		return
+	}
+	schemeName := flag.String("scheme", signing.DefaultScheme, "signature scheme for signing units, one of: "+strings.Join(signing.Schemes(), ", "))
+	legacy := flag.Bool("legacy", false, "write the committee file in the line format readable by older versions")
+	flag.Parse()
+	args := flag.Args()
+	usageMsg := "Usage: gomel-keys [-scheme <name>] [-legacy] <number> [<addresses_file>], or gomel-keys cluster -h for a complete bundle."
+	if len(args) != 1 && len(args) != 2 {
 		fmt.Fprintln(os.Stderr, usageMsg)
 		return
 	}
-	nProc, err := strconv.Atoi(os.Args[1])
+	scheme, err := signing.Lookup(*schemeName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	nProc, err := strconv.Atoi(args[0])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, usageMsg)
 		return
@@ -78,7 +95,7 @@ func main() {
 
 	setupAddresses := make(map[string][]string)
 	addresses := make(map[string][]string)
-	if len(os.Args) == 2 {
+	if len(args) == 1 {
 		for i := 0; i < nProc; i++ {
 			addresses["rmc"] = append(addresses["rmc"], "127.0.0.1:"+strconv.Itoa(9000+i))
 			addresses["mcast"] = append(addresses["mcast"], "127.0.0.1:"+strconv.Itoa(10000+i))
@@ -87,11 +104,12 @@ func main() {
 			setupAddresses["rmc"] = append(setupAddresses["rmc"], "127.0.0.1:"+strconv.Itoa(13000+i))
 			setupAddresses["fetch"] = append(setupAddresses["fetch"], "127.0.0.1:"+strconv.Itoa(14000+i))
 			setupAddresses["gossip"] = append(setupAddresses["gossip"], "127.0.0.1:"+strconv.Itoa(15000+i))
+			addresses["cert"] = append(addresses["cert"], "127.0.0.1:"+strconv.Itoa(16000+i))
 		}
 	} else {
-		f, err := os.Open(os.Args[2])
+		f, err := os.Open(args[1])
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Cannot open file ", os.Args[2])
+			fmt.Fprintln(os.Stderr, "Cannot open file ", args[1])
 			return
 		}
 		defer f.Close()
@@ -109,7 +127,7 @@ func main() {
 	}
 	keys := []memberKeys{}
 	for pid := 0; pid < nProc; pid++ {
-		keys = append(keys, makeMemberKeys(addresses))
+		keys = append(keys, makeMemberKeys(scheme, addresses))
 	}
 	committee := &config.Committee{}
 	committee.SetupAddresses = setupAddresses
@@ -141,7 +159,11 @@ func main() {
 		return
 	}
 	defer f.Close()
-	err = config.StoreCommittee(f, committee)
+	if *legacy {
+		err = config.StoreCommitteeLines(f, committee)
+	} else {
+		err = config.StoreCommittee(f, committee)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
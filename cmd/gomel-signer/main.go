@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/signer"
+)
+
+// This program keeps the private key of a committee member and signs the units of that member for a gomel process
+// started with -signer, so that the key does not have to be present on the host running the process.
+// The private key file is the one generated by gomel-keys; the copy given to the gomel process should have
+// its first word replaced with "remote". Both processes authenticate each other with the P2P secret key kept in both files.
+func main() {
+	privFilename := flag.String("priv", "", "a file with private keys and process id")
+	addr := flag.String("listen", "unix:gomel-signer.sock", "an address (host:port or unix:path) on which to accept signing requests")
+	stateDir := flag.String("state", ".", "a directory for the records of the last signed units, protecting against forks after a restart, empty keeps them only in memory")
+	flag.Parse()
+
+	if *privFilename == "" {
+		fmt.Fprintln(os.Stderr, "Please provide a file with private keys and pid.")
+		return
+	}
+	file, err := os.Open(*privFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open private key file \"%s\", because: %s.\n", *privFilename, err.Error())
+		return
+	}
+	member, err := config.LoadMember(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid private key file \"%s\", because: %s.\n", *privFilename, err.Error())
+		return
+	}
+	if member.PrivateKey == nil {
+		fmt.Fprintf(os.Stderr, "The private key file \"%s\" does not contain the private key.\n", *privFilename)
+		return
+	}
+
+	signers := make(map[string]gomel.Signer)
+	for _, domain := range []string{signer.SetupDomain, signer.ConsensusDomain} {
+		path := ""
+		if *stateDir != "" {
+			path = filepath.Join(*stateDir, domain+".signed")
+		}
+		local, err := signer.NewLocal(member.Pid, member.PrivateKey, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot load the record of the last signed unit from \"%s\", because: %s.\n", path, err.Error())
+			return
+		}
+		signers[domain] = local
+	}
+
+	server := signer.NewServer(*addr, signers, signer.NewSecret(member.P2PSecretKey), zerolog.New(os.Stderr))
+	if err := server.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Listening on \"%s\" failed because: %s.\n", *addr, err.Error())
+		return
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	server.Stop()
+}
@@ -4,14 +4,25 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/mempool"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
 	"gitlab.com/alephledger/consensus-go/pkg/run"
+	"gitlab.com/alephledger/consensus-go/pkg/signer"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
 	"gitlab.com/alephledger/core-go/pkg/core"
 	"gitlab.com/alephledger/core-go/pkg/tests"
 )
@@ -28,6 +39,18 @@ func getMember(filename string) (*config.Member, error) {
 	return config.LoadMember(file)
 }
 
+func getParams(filename string) (*config.Params, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return config.LoadParams(file)
+}
+
 func getCommittee(filename string) (*config.Committee, error) {
 	if filename == "" {
 		return nil, errors.New("please provide a file with keys and addresses of the committee")
@@ -40,28 +63,125 @@ func getCommittee(filename string) (*config.Committee, error) {
 	return config.LoadCommittee(file)
 }
 
+func getHandover(filename string) (*config.Handover, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return config.LoadHandover(file)
+}
+
+// getChange prepares the committee change proposed by this process, removing the members with the given comma separated pids
+// and adding the ones from the given committee file. Returns nil if no change is proposed.
+func getChange(conf config.Config, removed, addedFilename string) (*config.CommitteeChange, error) {
+	if removed == "" && addedFilename == "" {
+		return nil, nil
+	}
+	var pids []uint16
+	for _, field := range strings.Split(removed, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		pid, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, uint16(pid))
+	}
+	var added *config.Committee
+	if addedFilename != "" {
+		file, err := os.Open(addedFilename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if added, err = config.LoadNewMembers(file); err != nil {
+			return nil, err
+		}
+	}
+	return config.NewCommitteeChange(conf, pids, added)
+}
+
 type cliOptions struct {
 	privFilename      string
+	signerAddr        string
 	keysAddrsFilename string
+	paramsFilename    string
 	cpuProfFilename   string
 	memProfFilename   string
 	traceFilename     string
+	storeDir          string
+	preblockDir       string
+	preblockEpochs    int
+	streamAddr        string
+	certFilename      string
+	submitAddr        string
+	batchSize         int
+	mempoolSize       int
+	certInterval      int
+	metricsAddr       string
+	adminAddr         string
 	epochs            int
+	forever           bool
+	reconfig          bool
+	join              string
+	handover          string
+	remove            string
+	add               string
 	units             int
+	parents           string
+	parentWait        time.Duration
+	parentExtra       int
+	unitInterval      time.Duration
+	unitMinData       int
+	unitDataWait      time.Duration
 	output            int
 	setup             bool
 	mutexFraction     int
 	blockFraction     int
 	delay             int64
+	given             map[string]bool // the flags given explicitly
+}
+
+// overrides tells if the value of the given flag should be put in the config. Without a file with parameters
+// all such flags set the config, with one only the flags given explicitly override the parameters from the file.
+func (o cliOptions) overrides(name string) bool {
+	return o.paramsFilename == "" || o.given[name]
 }
 
 func getOptions() cliOptions {
 	var result cliOptions
 	flag.BoolVar(&result.setup, "setup", true, "a flag whether a setup should be run")
 	flag.StringVar(&result.privFilename, "priv", "", "a file with private keys and process id")
+	flag.StringVar(&result.signerAddr, "signer", "", "an address (host:port or unix:path) of a gomel-signer holding the private key, required if the private key file says \""+config.RemoteKey+"\"")
 	flag.StringVar(&result.keysAddrsFilename, "keys_addrs", "", "a file with keys and associated addresses")
+	flag.StringVar(&result.paramsFilename, "params", "", "a JSON file with protocol parameters overriding the defaults, see config.Params")
+	flag.StringVar(&result.storeDir, "store", "", "a directory for persisting units, allowing to recover after a crash")
+	flag.StringVar(&result.preblockDir, "preblocks", "", "a directory for a log of preblocks the consumer subscribes to, empty passes preblocks to the consumer directly")
+	flag.IntVar(&result.preblockEpochs, "preblock_epochs", 10, "number of the most recent epochs kept in the log of preblocks, 0 keeps all of them")
+	flag.StringVar(&result.streamAddr, "stream", "", "an address (host:port or unix:path) on which consumers in other processes can subscribe to the preblock log, requires -preblocks")
+	flag.StringVar(&result.submitAddr, "submit", "", "an address (host:port or unix:path) on which to accept transactions instead of generating random data, requires -preblocks")
+	flag.IntVar(&result.batchSize, "batch", 1<<16, "maximal number of bytes of transactions put in a single unit")
+	flag.IntVar(&result.mempoolSize, "mempool", 1<<26, "maximal number of bytes of transactions waiting to be put in units")
+	flag.StringVar(&result.certFilename, "certs", "certificates", "a file to which finality certificates of preblocks are appended")
+	flag.IntVar(&result.certInterval, "cert_interval", 0, "number of levels between finality certificates, also issued at the end of each epoch, 0 disables them")
+	flag.StringVar(&result.metricsAddr, "metrics", "", "an address on which to serve Prometheus metrics under /metrics, empty disables it")
+	flag.StringVar(&result.adminAddr, "admin", "", "an address on which to serve the status of the process as JSON under /status and /dag, empty disables it")
 	flag.IntVar(&result.epochs, "epochs", 0, "number of epochs to run")
+	flag.BoolVar(&result.forever, "forever", false, "a flag whether to produce new epochs until interrupted")
+	flag.BoolVar(&result.reconfig, "reconfig", false, "a flag whether to apply committee changes agreed in preblocks")
+	flag.StringVar(&result.join, "join", "", "a handover file, written with -handover by a member of the old committee, of the change after which this process joins the committee given in keys_addrs; also used to restart after a change")
+	flag.StringVar(&result.handover, "handover", "", "a file to which the handover of every committee change agreed in preblocks is written, requires -reconfig")
+	flag.StringVar(&result.remove, "remove", "", "comma separated pids of members this process proposes to remove from the committee, requires -reconfig")
+	flag.StringVar(&result.add, "add", "", "a file with keys and addresses of members this process proposes to add to the committee, requires -reconfig")
 	flag.IntVar(&result.units, "units", 0, "number of levels to produce in each epoch")
+	flag.StringVar(&result.parents, "parents", "", "the strategy of choosing parents of units: \""+config.MaxLevelParents+"\", \""+config.FixedLevelParents+"\", \""+config.WaitParents+"\" or \""+config.LatencyParents+"\", empty chooses the default one")
+	flag.DurationVar(&result.parentWait, "parent_wait", 0, "the longest time the \""+config.WaitParents+"\" and \""+config.LatencyParents+"\" strategies wait for more parents")
+	flag.IntVar(&result.parentExtra, "parent_extra", 1, "the number of parents above a quorum the \""+config.WaitParents+"\" strategy waits for")
+	flag.DurationVar(&result.unitInterval, "unit_interval", 0, "the shortest time between two units carrying data")
+	flag.IntVar(&result.unitMinData, "unit_min_data", 0, "the number of bytes of transactions a unit waits for when accepting them with -submit, 0 disables waiting")
+	flag.DurationVar(&result.unitDataWait, "unit_data_wait", 100*time.Millisecond, "the longest time a unit waits for unit_min_data bytes of transactions")
 	flag.IntVar(&result.output, "output", 1, "type of preblock consumer (0 ignore, 1 control sum, 2 data")
 	flag.StringVar(&result.cpuProfFilename, "cpuprof", "", "the name of the file with cpu-profile results")
 	flag.StringVar(&result.memProfFilename, "memprof", "", "the name of the file with mem-profile results")
@@ -70,6 +190,8 @@ func getOptions() cliOptions {
 	flag.IntVar(&result.blockFraction, "bf", 0, "the sampling fraction of goroutine blocking events")
 	flag.Int64Var(&result.delay, "delay", 0, "number of seconds to wait before running the protocol")
 	flag.Parse()
+	result.given = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { result.given[f.Name] = true })
 	return result
 }
 
@@ -108,6 +230,17 @@ func main() {
 		defer trace.Stop()
 	}
 
+	// expose metrics
+	if options.metricsAddr != "" {
+		srv, errc := metrics.Serve(options.metricsAddr)
+		defer srv.Close()
+		go func() {
+			if err := <-errc; err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "Metrics server failed because: %s.\n", err.Error())
+			}
+		}()
+	}
+
 	fmt.Fprintln(os.Stdout, "Starting process...")
 
 	// get member
@@ -122,8 +255,64 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Invalid key file \"%s\", because: %s.\n", options.keysAddrsFilename, err.Error())
 		return
 	}
+	// get protocol parameters
+	params, err := getParams(options.paramsFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid parameters file \"%s\", because: %s.\n", options.paramsFilename, err.Error())
+		return
+	}
 	// get committee config
-	consensusConfig := config.New(member, committee)
+	consensusConfig := config.New(member, committee, params)
+	if options.overrides("cert_interval") {
+		consensusConfig.CertificateInterval = options.certInterval
+	}
+	if options.overrides("certs") {
+		consensusConfig.CertificateFile = options.certFilename
+	}
+	if options.overrides("reconfig") {
+		consensusConfig.CommitteeChanges = options.reconfig
+	}
+	if options.overrides("parents") {
+		consensusConfig.ParentStrategy = options.parents
+	}
+	if options.overrides("parent_wait") {
+		consensusConfig.ParentWait = options.parentWait
+	}
+	if options.overrides("parent_extra") {
+		consensusConfig.ParentExtra = options.parentExtra
+	}
+	if options.overrides("unit_interval") {
+		consensusConfig.UnitInterval = options.unitInterval
+	}
+	if options.overrides("unit_min_data") {
+		consensusConfig.UnitMinData = options.unitMinData
+	}
+	if options.overrides("unit_data_wait") {
+		consensusConfig.UnitDataWait = options.unitDataWait
+	}
+	if options.overrides("handover") {
+		consensusConfig.HandoverFile = options.handover
+	}
+	if options.join != "" {
+		handover, err := getHandover(options.join)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid handover file \"%s\", because: %s.\n", options.join, err.Error())
+			return
+		}
+		if consensusConfig, err = config.Join(consensusConfig, handover); err != nil {
+			fmt.Fprintf(os.Stderr, "Joining the committee failed because: %s.\n", err.Error())
+			return
+		}
+	}
+	if consensusConfig.Proposal, err = getChange(consensusConfig, options.remove, options.add); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid committee change because: %s.\n", err.Error())
+		return
+	}
+	if options.signerAddr != "" {
+		remote := signer.NewRemote(options.signerAddr, signer.ConsensusDomain, signer.NewSecret(member.P2PSecretKey), consensusConfig.Timeout)
+		defer remote.Close()
+		consensusConfig.Signer = remote
+	}
 	if err := config.Valid(consensusConfig); err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid consensus configuration because: %s.\n", err.Error())
 		return
@@ -131,13 +320,49 @@ func main() {
 	if options.epochs != 0 {
 		consensusConfig.NumberOfEpochs = options.epochs
 	}
+	if options.forever {
+		consensusConfig.NumberOfEpochs = 0
+	}
+	if options.overrides("admin") {
+		consensusConfig.AdminAddress = options.adminAddr
+	}
 	if options.units != 0 {
 		consensusConfig.EpochLength = options.units
 		consensusConfig.LastLevel = consensusConfig.EpochLength + consensusConfig.OrderStartLevel - 1
 	}
+	if options.storeDir != "" {
+		consensusConfig.UnitStoreDir = filepath.Join(options.storeDir, "consensus")
+		consensusConfig.LastUnitFile = filepath.Join(options.storeDir, "consensus.last")
+	}
+
+	// services started here write to the log of the process
+	log, err := logging.NewLogger(consensusConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Creating log file \"%s\" failed because: %s.\n", consensusConfig.LogFile, err.Error())
+		return
+	}
 
-	// create mock data source
-	dataSource := tests.RandomDataSource(rbpu)
+	// create mock data source, or a mempool accepting transactions from clients
+	var dataSource core.DataSource = tests.RandomDataSource(rbpu)
+	var submitServer *mempool.Server
+	if options.submitAddr != "" {
+		if options.preblockDir == "" {
+			fmt.Fprintln(os.Stderr, "Accepting transactions requires a preblock log, please provide -preblocks.")
+			return
+		}
+		if options.batchSize <= 0 || options.batchSize > config.MaxDataBytesPerUnit {
+			fmt.Fprintf(os.Stderr, "Batch size has to be positive and at most %d bytes.\n", int(config.MaxDataBytesPerUnit))
+			return
+		}
+		mp := mempool.New(options.batchSize, options.mempoolSize)
+		submitServer = mempool.NewServer(options.submitAddr, mp, log)
+		if err := submitServer.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "Accepting transactions on \"%s\" failed because: %s.\n", options.submitAddr, err.Error())
+			return
+		}
+		defer submitServer.Stop()
+		dataSource = mp
+	}
 
 	// create preblock sink with mock consumer
 	preblockSink := make(chan *core.Preblock)
@@ -154,26 +379,92 @@ func main() {
 		}
 	}()
 
+	// open the preblock log and pass its preblocks to the consumer
+	var preblockLog *stream.Log
+	if options.streamAddr != "" && options.preblockDir == "" {
+		fmt.Fprintln(os.Stderr, "Serving preblocks to other processes requires a preblock log, please provide -preblocks.")
+		return
+	}
+	if options.preblockDir != "" {
+		preblockLog, err = stream.Open(options.preblockDir, options.preblockEpochs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Opening preblock log in \"%s\" failed because: %s.\n", options.preblockDir, err.Error())
+			return
+		}
+		defer preblockLog.Close()
+		if options.streamAddr != "" {
+			streamServer := stream.NewServer(options.streamAddr, preblockLog, log)
+			if err := streamServer.Start(); err != nil {
+				fmt.Fprintf(os.Stderr, "Serving preblock log on \"%s\" failed because: %s.\n", options.streamAddr, err.Error())
+				return
+			}
+			defer streamServer.Stop()
+		}
+		subscription, err := preblockLog.Subscribe(stream.Position{Epoch: consensusConfig.FirstEpoch})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Subscribing to preblock log failed because: %s.\n", err.Error())
+			return
+		}
+		defer subscription.Cancel()
+		go func() {
+			defer close(preblockSink)
+			for entry := range subscription.Entries() {
+				if submitServer != nil {
+					submitServer.Ordered(entry.Position, entry.Preblock)
+				}
+				preblockSink <- entry.Preblock
+			}
+		}()
+	}
+
 	// initialize process
 	var start, stop func()
-	if options.setup {
-		setupConfig := config.NewSetup(member, committee)
+	if options.join != "" {
+		if preblockLog != nil {
+			start, stop, err = run.JoinStream(consensusConfig, dataSource, preblockLog)
+		} else {
+			start, stop, err = run.Join(consensusConfig, dataSource, preblockSink)
+		}
+	} else if options.setup {
+		setupConfig := config.NewSetup(member, committee, params)
+		if options.signerAddr != "" {
+			remote := signer.NewRemote(options.signerAddr, signer.SetupDomain, signer.NewSecret(member.P2PSecretKey), setupConfig.Timeout)
+			defer remote.Close()
+			setupConfig.Signer = remote
+		}
 		if err := config.ValidSetup(setupConfig); err != nil {
 			fmt.Fprintf(os.Stderr, "Invalid setup configuration because: %s.\n", err.Error())
 			return
 		}
-		start, stop, err = run.Process(setupConfig, consensusConfig, dataSource, preblockSink)
+		if options.storeDir != "" {
+			setupConfig.UnitStoreDir = filepath.Join(options.storeDir, "setup")
+			setupConfig.LastUnitFile = filepath.Join(options.storeDir, "setup.last")
+		}
+		if preblockLog != nil {
+			start, stop, err = run.Stream(setupConfig, consensusConfig, dataSource, preblockLog)
+		} else {
+			start, stop, err = run.Process(setupConfig, consensusConfig, dataSource, preblockSink)
+		}
 	} else {
-		start, stop, err = run.NoBeacon(consensusConfig, dataSource, preblockSink)
+		if preblockLog != nil {
+			start, stop, err = run.NoBeaconStream(consensusConfig, dataSource, preblockLog)
+		} else {
+			start, stop, err = run.NoBeacon(consensusConfig, dataSource, preblockSink)
+		}
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Process died with %s.\n", err.Error())
 		return
 	}
 
-	// run process
+	// run process until it finishes or gets interrupted
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 	start()
-	<-done
+	select {
+	case <-done:
+	case <-interrupt:
+	}
 	stop()
 
 	// dump profiles
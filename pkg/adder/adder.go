@@ -8,6 +8,7 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/config"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
 )
 
 // adder is a buffer zone where preunits wait to be added to dag. A preunit with
@@ -27,6 +28,7 @@ type adder struct {
 	waiting     map[gomel.Hash]*waitingPreunit
 	waitingByID map[uint64]*waitingPreunit
 	missing     map[uint64]*missingPreunit
+	reported    [2]int // sizes of waiting and missing last added to the metrics
 	finished    chan struct{}
 	mx          sync.Mutex
 	wg          sync.WaitGroup
@@ -48,9 +50,6 @@ func New(dag gomel.Dag, conf config.Config, syncer gomel.Syncer, alert gomel.Ale
 		log:         log.With().Int(lg.Service, lg.AdderService).Logger(),
 	}
 	for i := range ad.ready {
-		if uint16(i) == ad.conf.Pid {
-			continue
-		}
 		ad.ready[i] = make(chan *waitingPreunit, conf.EpochLength)
 		ad.wg.Add(1)
 		go func(ch chan *waitingPreunit) {
@@ -59,6 +58,7 @@ func New(dag gomel.Dag, conf config.Config, syncer gomel.Syncer, alert gomel.Ale
 				select {
 				case wp := <-ch:
 					ad.handleReady(wp)
+					ad.conf.Activity.Done(gomel.Adding, 1)
 				case <-ad.finished:
 					return
 				}
@@ -73,6 +73,14 @@ func New(dag gomel.Dag, conf config.Config, syncer gomel.Syncer, alert gomel.Ale
 func (ad *adder) Close() {
 	close(ad.finished)
 	ad.wg.Wait()
+	for _, ch := range ad.ready {
+		ad.conf.Activity.Done(gomel.Adding, len(ch))
+	}
+	ad.mx.Lock()
+	metrics.AdderWaiting.Sub(float64(ad.reported[0]))
+	metrics.AdderMissing.Sub(float64(ad.reported[1]))
+	ad.reported = [2]int{}
+	ad.mx.Unlock()
 	ad.log.Info().Msg(lg.ServiceStopped)
 }
 
@@ -97,21 +105,32 @@ func (ad *adder) AddPreunits(source uint16, preunits ...gomel.Preunit) []error {
 	alreadyInDag := ad.dag.GetUnits(hashes)
 
 	failed := make([]bool, len(preunits))
+	var toVerify []int
 	for i, pu := range preunits {
 		if alreadyInDag[i] == nil {
 			err := ad.checkCorrectness(pu)
 			if err != nil {
 				getErrors()[i] = err
 				failed[i] = true
+			} else {
+				toVerify = append(toVerify, i)
 			}
 		} else {
 			getErrors()[i] = gomel.NewDuplicateUnit(alreadyInDag[i])
 			failed[i] = true
 		}
 	}
+	valid := ad.verifySignatures(preunits, toVerify)
+	for _, i := range toVerify {
+		if !valid[i] {
+			getErrors()[i] = gomel.NewDataError("invalid signature")
+			failed[i] = true
+		}
+	}
 
 	ad.mx.Lock()
 	defer ad.mx.Unlock()
+	defer ad.report()
 	for i, pu := range preunits {
 		if !failed[i] {
 			getErrors()[i] = ad.addToWaiting(pu, source)
@@ -120,6 +139,13 @@ func (ad *adder) AddPreunits(source uint16, preunits ...gomel.Preunit) []error {
 	return errors
 }
 
+// Backlog returns the number of preunits waiting in the buffer zone and the number of their parents that were never received.
+func (ad *adder) Backlog() (int, int) {
+	ad.mx.Lock()
+	defer ad.mx.Unlock()
+	return len(ad.waiting), len(ad.missing)
+}
+
 // addPreunit as a waitingPreunit to the buffer zone.
 // This method must be called under mutex!
 func (ad *adder) addToWaiting(pu gomel.Preunit, source uint16) error {
@@ -148,6 +174,19 @@ func (ad *adder) addToWaiting(pu gomel.Preunit, source uint16) error {
 	return nil
 }
 
+// report updates the metrics with the current sizes of the buffer zone. Does nothing once the adder is closed.
+// This method must be called under mutex!
+func (ad *adder) report() {
+	select {
+	case <-ad.finished:
+		return
+	default:
+	}
+	metrics.AdderWaiting.Add(float64(len(ad.waiting) - ad.reported[0]))
+	metrics.AdderMissing.Add(float64(len(ad.missing) - ad.reported[1]))
+	ad.reported = [2]int{len(ad.waiting), len(ad.missing)}
+}
+
 // sendIfReady checks if a waitingPreunit is ready (has no waiting or missing parents).
 // If yes, the preunit is sent to the channel corresponding to its dedicated worker.
 func (ad *adder) sendIfReady(wp *waitingPreunit) {
@@ -155,6 +194,7 @@ func (ad *adder) sendIfReady(wp *waitingPreunit) {
 		select {
 		case <-ad.finished:
 		default:
+			ad.conf.Activity.Add(gomel.Adding)
 			ad.ready[wp.pu.Creator()] <- wp
 		}
 	}
@@ -214,16 +254,18 @@ func (ad *adder) handleReady(wp *waitingPreunit) {
 }
 
 func (ad *adder) handleInvalidControlHash(sourcePID uint16, witness gomel.Preunit, parentCandidates []gomel.Unit) {
+	// peers unable to fetch by hash are asked for all the units with the IDs of the parents, including some that are witnesses of forks
 	ids := make([]uint64, 0, len(witness.View().Heights))
 	for pid, height := range witness.View().Heights {
 		ids = append(ids, gomel.ID(height, uint16(pid), witness.EpochID()))
 	}
-	// this should trigger download of all parents, including some that are witnesses of forks,
-	// and start an alert while they are added
-	ad.syncer.RequestFetch(sourcePID, ids)
+	// this should trigger download of the exact parents of the witness, including the ones that are forks
+	// of our parent candidates, and start an alert while they are added
+	ad.syncer.RequestFetchByHash(sourcePID, []*gomel.Hash{witness.Hash()}, 1, ids)
 }
 
-// checkCorrectness checks very basic correctness of the given preunit: creator and signature.
+// checkCorrectness checks very basic correctness of the given preunit: creator and epoch.
+// Signatures are checked afterwards for the whole chunk of preunits, see verifySignatures.
 func (ad *adder) checkCorrectness(pu gomel.Preunit) error {
 	if pu.Creator() >= ad.dag.NProc() {
 		return gomel.NewDataError("invalid creator")
@@ -233,8 +275,5 @@ func (ad *adder) checkCorrectness(pu gomel.Preunit) error {
 			fmt.Sprintf("invalid EpochID - expected %d, but received %d instead", ad.dag.EpochID(), pu.EpochID()),
 		)
 	}
-	if !ad.conf.PublicKeys[pu.Creator()].Verify(pu) {
-		return gomel.NewDataError("invalid signature")
-	}
 	return nil
 }
@@ -0,0 +1,13 @@
+package adder_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAdder(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Adder Suite")
+}
@@ -0,0 +1,138 @@
+package adder_test
+
+import (
+	"runtime"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+
+	. "gitlab.com/alephledger/consensus-go/pkg/adder"
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/dag"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+// batchKey is a public key that counts the batches it verifies.
+type batchKey struct {
+	gomel.PublicKey
+	batches int32
+}
+
+func (bk *batchKey) VerifyBatch(pus []gomel.Preunit) []bool {
+	atomic.AddInt32(&bk.batches, 1)
+	result := make([]bool, len(pus))
+	for i, pu := range pus {
+		result[i] = bk.Verify(pu)
+	}
+	return result
+}
+
+var _ = Describe("Adder", func() {
+	const nProc = 4
+
+	var (
+		conf   config.Config
+		privs  []gomel.PrivateKey
+		adder  gomel.Adder
+		forger gomel.PrivateKey
+	)
+
+	// dealings returns n dealing units with distinct data, created by consecutive processes.
+	// Every third one is signed with a wrong key.
+	dealings := func(n int) []gomel.Preunit {
+		result := make([]gomel.Preunit, n)
+		for i := range result {
+			creator := uint16(i % nProc)
+			priv := privs[creator]
+			if i%3 == 2 {
+				priv = forger
+			}
+			result[i] = tests.NewPreunit(creator, gomel.EmptyCrown(nProc), core.Data{byte(i)}, nil, priv)
+		}
+		return result
+	}
+
+	expectErrors := func(errs []error, n int) {
+		Expect(errs).To(HaveLen(n))
+		for i, err := range errs {
+			if i%3 == 2 {
+				Expect(err).To(BeAssignableToTypeOf(&gomel.DataError{}))
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		conf = config.Empty()
+		conf.NProc = nProc
+		conf.EpochLength = 100
+		conf.PublicKeys = make([]gomel.PublicKey, nProc)
+		privs = make([]gomel.PrivateKey, nProc)
+		for pid := range privs {
+			conf.PublicKeys[pid], privs[pid], _ = signing.GenerateKeys()
+		}
+		_, forger, _ = signing.GenerateKeys()
+	})
+
+	JustBeforeEach(func() {
+		adder = New(dag.New(conf, 0), conf, nil, gomel.NopAlerter(), zerolog.Nop())
+	})
+
+	AfterEach(func() {
+		adder.Close()
+	})
+
+	It("should report invalid signatures in a small chunk", func() {
+		expectErrors(adder.AddPreunits(1, dealings(5)...), 5)
+	})
+
+	It("should report invalid signatures in a large chunk", func() {
+		expectErrors(adder.AddPreunits(1, dealings(40)...), 40)
+	})
+
+	Context("when the scheme supports batch verification", func() {
+		var key *batchKey
+
+		BeforeEach(func() {
+			key = &batchKey{PublicKey: conf.PublicKeys[0]}
+			conf.PublicKeys[0] = key
+		})
+
+		It("should verify units of a creator in batches", func() {
+			expectErrors(adder.AddPreunits(1, dealings(40)...), 40)
+			Expect(atomic.LoadInt32(&key.batches)).To(BeNumerically(">", 0))
+		})
+
+		Context("on many goroutines", func() {
+			var procs int
+
+			BeforeEach(func() {
+				procs = runtime.GOMAXPROCS(4)
+			})
+
+			AfterEach(func() {
+				runtime.GOMAXPROCS(procs)
+			})
+
+			It("should split the units of a creator among the workers", func() {
+				pus := make([]gomel.Preunit, 40)
+				for i := range pus {
+					priv := privs[0]
+					if i%3 == 2 {
+						priv = forger
+					}
+					pus[i] = tests.NewPreunit(0, gomel.EmptyCrown(nProc), core.Data{byte(i)}, nil, priv)
+				}
+				expectErrors(adder.AddPreunits(1, pus...), 40)
+				Expect(atomic.LoadInt32(&key.batches)).To(BeEquivalentTo(4))
+			})
+		})
+	})
+})
@@ -0,0 +1,80 @@
+package adder
+
+import (
+	"runtime"
+	"sync"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// minParallel is the smallest number of signatures that are worth verifying on many goroutines.
+const minParallel = 16
+
+// verifySignatures checks the signatures of the preunits with the given indices and reports which of them are correct.
+// Large chunks, like the ones received during a catch-up, are verified in parallel. Preunits of a single creator
+// are verified together if the signature scheme of the creator supports batch verification.
+// The creators of the preunits have to be already checked.
+func (ad *adder) verifySignatures(preunits []gomel.Preunit, indices []int) []bool {
+	valid := make([]bool, len(preunits))
+	workers := runtime.GOMAXPROCS(0)
+	if len(indices) < minParallel {
+		workers = 1
+	}
+	chunk := (len(indices) + workers - 1) / workers
+
+	// every task consists of preunits of a single creator
+	byCreator := make(map[uint16][]int)
+	for _, i := range indices {
+		creator := preunits[i].Creator()
+		byCreator[creator] = append(byCreator[creator], i)
+	}
+	var tasks [][]int
+	for _, idx := range byCreator {
+		for len(idx) > chunk {
+			tasks = append(tasks, idx[:chunk])
+			idx = idx[chunk:]
+		}
+		tasks = append(tasks, idx)
+	}
+
+	verify := func(task []int) {
+		key := ad.conf.PublicKeys[preunits[task[0]].Creator()]
+		if bv, ok := key.(gomel.BatchVerifier); ok && len(task) > 1 {
+			batch := make([]gomel.Preunit, len(task))
+			for j, i := range task {
+				batch[j] = preunits[i]
+			}
+			for j, ok := range bv.VerifyBatch(batch) {
+				valid[task[j]] = ok
+			}
+			return
+		}
+		for _, i := range task {
+			valid[i] = key.Verify(preunits[i])
+		}
+	}
+
+	if workers == 1 {
+		for _, task := range tasks {
+			verify(task)
+		}
+		return valid
+	}
+	queue := make(chan []int, len(tasks))
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				verify(task)
+			}
+		}()
+	}
+	wg.Wait()
+	return valid
+}
@@ -54,6 +54,7 @@ func (ad *adder) checkParents(wp *waitingPreunit) []int {
 func (ad *adder) remove(wp *waitingPreunit) {
 	ad.mx.Lock()
 	defer ad.mx.Unlock()
+	defer ad.report()
 	if wp.failed {
 		ad.removeFailed(wp)
 	} else {
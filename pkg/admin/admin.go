@@ -0,0 +1,165 @@
+// Package admin implements an HTTP server reporting the status of a running process.
+//
+// The server answers GET requests with JSON documents:
+//
+//	/status      the current and previous epoch, heights of the maximal units of every process,
+//	             the last timing unit, known forkers, the backlog of the adders and the health of syncing with every peer
+//	/dag?epoch=N all the units of the given epoch, or of the current one if no epoch is given
+package admin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+)
+
+// Server serves the status of an orderer over HTTP.
+type Server struct {
+	addr    string
+	orderer gomel.Orderer
+	srv     *http.Server
+	log     zerolog.Logger
+}
+
+// NewServer constructs a server reporting the status of the given orderer on the given address.
+func NewServer(addr string, orderer gomel.Orderer, log zerolog.Logger) *Server {
+	s := &Server{
+		addr:    addr,
+		orderer: orderer,
+		log:     log.With().Int(lg.Service, lg.AdminService).Logger(),
+	}
+	s.srv = &http.Server{Handler: s.Handler()}
+	return s
+}
+
+// Handler returns the HTTP handler of the server, without starting it.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.status)
+	mux.HandleFunc("/dag", s.dag)
+	return mux
+}
+
+// Start listens on the address of the server and serves requests in the background.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.srv.Serve(ln); err != http.ErrServerClosed {
+			s.log.Error().Str("where", "admin.Serve").Msg(err.Error())
+		}
+	}()
+	s.log.Info().Msg(lg.ServiceStarted)
+	return nil
+}
+
+// Stop closes the server.
+func (s *Server) Stop() {
+	s.srv.Close()
+	s.log.Info().Msg(lg.ServiceStopped)
+}
+
+// unitInfo describes a unit. Parents are given by their heights, like in the unit's view of the dag.
+type unitInfo struct {
+	Epoch   gomel.EpochID
+	Creator uint16
+	Height  int
+	Level   int
+	Hash    string
+	Parents []int
+}
+
+func newUnitInfo(u gomel.Unit) *unitInfo {
+	return &unitInfo{
+		Epoch:   u.EpochID(),
+		Creator: u.Creator(),
+		Height:  u.Height(),
+		Level:   u.Level(),
+		Hash:    base64.StdEncoding.EncodeToString(u.Hash()[:]),
+		Parents: u.View().Heights,
+	}
+}
+
+type statusInfo struct {
+	Pid        uint16
+	NProc      uint16
+	Epoch      *gomel.EpochID
+	Previous   *gomel.EpochID
+	MaxHeights []int
+	LastTiming *unitInfo
+	Forkers    []uint16
+	Waiting    int
+	Missing    int
+	Peers      []gomel.PeerStatus
+	Failure    string
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	st := s.orderer.Status()
+	info := &statusInfo{
+		Pid:        st.Pid,
+		NProc:      st.NProc,
+		Epoch:      st.Epoch,
+		Previous:   st.Previous,
+		MaxHeights: st.MaxHeights,
+		Forkers:    st.Forkers,
+		Waiting:    st.Waiting,
+		Missing:    st.Missing,
+		Peers:      st.Peers,
+		Failure:    st.Failure,
+	}
+	if st.LastTiming != nil {
+		info.LastTiming = newUnitInfo(st.LastTiming)
+	}
+	s.reply(w, info)
+}
+
+func (s *Server) dag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	var epoch gomel.EpochID
+	if param := r.URL.Query().Get("epoch"); param != "" {
+		id, err := strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid epoch: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		epoch = gomel.EpochID(id)
+	} else {
+		current := s.orderer.Status().Epoch
+		if current == nil {
+			http.Error(w, "the process has not started yet", http.StatusServiceUnavailable)
+			return
+		}
+		epoch = *current
+	}
+	// without heights, Delta returns all the units of the epoch
+	units := s.orderer.Delta([2]*gomel.DagInfo{{Epoch: epoch}, nil})
+	result := make([]*unitInfo, len(units))
+	for i, u := range units {
+		result[i] = newUnitInfo(u)
+	}
+	s.reply(w, result)
+}
+
+func (s *Server) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Error().Str("where", "admin.reply").Msg(err.Error())
+	}
+}
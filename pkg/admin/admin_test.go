@@ -0,0 +1,150 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/admin"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+)
+
+type statusOrderer struct {
+	gomel.Orderer
+	dag    gomel.Dag
+	status *gomel.Status
+}
+
+func (o *statusOrderer) Status() *gomel.Status {
+	return o.status
+}
+
+func (o *statusOrderer) Delta(info [2]*gomel.DagInfo) []gomel.Unit {
+	if info[0] == nil || info[0].Epoch != o.dag.EpochID() {
+		return nil
+	}
+	return o.dag.UnitsAbove(info[0].Heights)
+}
+
+var _ = Describe("Server", func() {
+
+	var (
+		ord     *statusOrderer
+		handler http.Handler
+	)
+
+	get := func(path string, v interface{}) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code == http.StatusOK {
+			Expect(json.Unmarshal(rec.Body.Bytes(), v)).To(Succeed())
+		}
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		dag, _, err := tests.CreateDagFromTestFile("../testdata/dags/4/regular.txt", tests.NewTestDagFactory())
+		Expect(err).NotTo(HaveOccurred())
+		epoch := dag.EpochID()
+		ord = &statusOrderer{
+			Orderer: tests.NewOrderer(),
+			dag:     dag,
+			status: &gomel.Status{
+				Pid:        1,
+				NProc:      dag.NProc(),
+				Epoch:      &epoch,
+				MaxHeights: gomel.MaxView(dag).Heights,
+				LastTiming: dag.MaximalUnitsPerProcess().Get(0)[0],
+				Forkers:    []uint16{3},
+				Waiting:    5,
+				Missing:    2,
+				Peers:      []gomel.PeerStatus{{Pid: 0, Successes: 7}, {Pid: 1}, {Pid: 2, Failures: 3, Consecutive: 3}, {Pid: 3}},
+				Failure:    "unable to join",
+			},
+		}
+		handler = admin.NewServer("", ord, zerolog.Nop()).Handler()
+	})
+
+	Describe("status", func() {
+
+		It("should report the status of the orderer", func() {
+			var status struct {
+				Pid        uint16
+				Epoch      *gomel.EpochID
+				Previous   *gomel.EpochID
+				MaxHeights []int
+				LastTiming struct {
+					Creator uint16
+					Height  int
+					Level   int
+				}
+				Forkers []uint16
+				Waiting int
+				Missing int
+				Peers   []gomel.PeerStatus
+				Failure string
+			}
+			Expect(get("/status", &status)).To(Equal(http.StatusOK))
+			Expect(status.Pid).To(Equal(uint16(1)))
+			Expect(*status.Epoch).To(Equal(ord.dag.EpochID()))
+			Expect(status.Previous).To(BeNil())
+			Expect(status.MaxHeights).To(Equal(ord.status.MaxHeights))
+			Expect(status.LastTiming.Creator).To(Equal(uint16(0)))
+			Expect(status.LastTiming.Height).To(Equal(ord.status.LastTiming.Height()))
+			Expect(status.LastTiming.Level).To(Equal(ord.status.LastTiming.Level()))
+			Expect(status.Forkers).To(Equal([]uint16{3}))
+			Expect(status.Waiting).To(Equal(5))
+			Expect(status.Missing).To(Equal(2))
+			Expect(status.Peers).To(HaveLen(4))
+			Expect(status.Peers[0].Successes).To(Equal(7))
+			Expect(status.Peers[2].Consecutive).To(Equal(3))
+			Expect(status.Failure).To(Equal("unable to join"))
+		})
+
+		It("should refuse other methods than GET", func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("POST", "/status", nil))
+			Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Describe("dag", func() {
+
+		It("should dump all the units of the current epoch", func() {
+			var units []struct {
+				Creator uint16
+				Height  int
+				Hash    string
+				Parents []int
+			}
+			Expect(get("/dag", &units)).To(Equal(http.StatusOK))
+			Expect(units).To(HaveLen(len(ord.dag.UnitsAbove(nil))))
+			for _, u := range units {
+				Expect(u.Parents).To(HaveLen(int(ord.dag.NProc())))
+				Expect(u.Hash).NotTo(BeEmpty())
+			}
+		})
+
+		It("should return nothing for an unknown epoch", func() {
+			var units []interface{}
+			Expect(get("/dag?epoch=7", &units)).To(Equal(http.StatusOK))
+			Expect(units).To(BeEmpty())
+		})
+
+		It("should reject an invalid epoch", func() {
+			var units []interface{}
+			Expect(get("/dag?epoch=x", &units)).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should report a process that has not started", func() {
+			ord.status.Epoch = nil
+			var units []interface{}
+			Expect(get("/dag", &units)).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
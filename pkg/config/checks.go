@@ -4,10 +4,13 @@ import (
 	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
 	"gitlab.com/alephledger/consensus-go/pkg/dag/check"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/network/secure"
 )
 
 const (
@@ -44,8 +47,8 @@ func checkKeys(cnf Config) error {
 		return gomel.NewConfigError("nProc set to 0 during keys check")
 	}
 
-	if cnf.PrivateKey == nil {
-		return gomel.NewConfigError("Private key is missing")
+	if cnf.PrivateKey == nil && cnf.Signer == nil {
+		return gomel.NewConfigError("Private key and signer are missing")
 	}
 	if err := noNils(cnf.PublicKeys, cnf.NProc, "PublicKeys"); err != nil {
 		return err
@@ -69,14 +72,17 @@ func checkKeys(cnf Config) error {
 }
 
 func checkSyncConf(cnf Config, setup bool) error {
-	if cnf.Timeout == 0*time.Second {
-		return gomel.NewConfigError("timeout cannot be 0")
+	if cnf.Timeout <= 0*time.Second {
+		return gomel.NewConfigError("timeout has to be positive")
 	}
-	if cnf.FetchInterval == 0*time.Second {
-		return gomel.NewConfigError("fetch interval cannot be 0")
+	if cnf.FetchInterval <= 0*time.Second {
+		return gomel.NewConfigError("fetch interval has to be positive")
 	}
-	if cnf.GossipAbove == 0 {
-		return gomel.NewConfigError("GossipAbove cannot be 0")
+	if cnf.GossipInterval < 0*time.Second {
+		return gomel.NewConfigError("gossip interval cannot be negative")
+	}
+	if cnf.GossipAbove <= 0 {
+		return gomel.NewConfigError("GossipAbove has to be positive")
 	}
 
 	n := int(cnf.NProc)
@@ -94,18 +100,29 @@ func checkSyncConf(cnf Config, setup bool) error {
 	if !setup && !ok(cnf.MCastAddresses) {
 		return gomel.NewConfigError("wrong number of mcast addresses")
 	}
+	// apart from multicast, every sync protocol starts with a handshake taking a round trip, which is impossible over one-way connections
+	for _, netType := range []string{cnf.RMCNetType, cnf.GossipNetType, cnf.FetchNetType} {
+		if strings.TrimPrefix(netType, secure.Prefix) == "udp" {
+			return gomel.NewConfigError("network type " + netType + " cannot be used for syncing")
+		}
+	}
 
-	if cnf.GossipWorkers[0] == 0 {
-		return gomel.NewConfigError("nIn gossip workers cannot be 0")
+	// the first versions of gossip and fetch encode units with signatures of the size of ed25519 ones
+	if (cnf.GossipVersion == 1 || cnf.FetchVersion == 1) && len(cnf.PublicKeys) > 0 && signing.SchemeOf(cnf.PublicKeys[0].Encode()) != signing.DefaultScheme {
+		return gomel.NewConfigError("the first versions of gossip and fetch support only " + signing.DefaultScheme + " keys")
 	}
-	if cnf.GossipWorkers[1] == 0 {
-		return gomel.NewConfigError("nOut gossip workers cannot be 0")
+
+	if cnf.GossipWorkers[0] <= 0 {
+		return gomel.NewConfigError("nIn gossip workers has to be positive")
+	}
+	if cnf.GossipWorkers[1] <= 0 {
+		return gomel.NewConfigError("nOut gossip workers has to be positive")
 	}
-	if cnf.FetchWorkers[0] == 0 {
-		return gomel.NewConfigError("nIn fetch workers cannot be 0")
+	if cnf.FetchWorkers[0] <= 0 {
+		return gomel.NewConfigError("nIn fetch workers has to be positive")
 	}
-	if cnf.FetchWorkers[1] == 0 {
-		return gomel.NewConfigError("nOut fetch workers cannot be 0")
+	if cnf.FetchWorkers[1] <= 0 {
+		return gomel.NewConfigError("nOut fetch workers has to be positive")
 	}
 
 	return nil
@@ -141,13 +158,30 @@ func valid(cnf Config) error {
 	if cnf.EpochLength < 1 {
 		return gomel.NewConfigError("EpochLength is " + strconv.Itoa(cnf.EpochLength))
 	}
+	if cnf.NumberOfEpochs < 0 {
+		return gomel.NewConfigError("NumberOfEpochs is " + strconv.Itoa(cnf.NumberOfEpochs))
+	}
+
+	// linear checks
+	if cnf.OrderStartLevel < 0 {
+		return gomel.NewConfigError("OrderStartLevel is " + strconv.Itoa(cnf.OrderStartLevel))
+	}
+	if cnf.FirstDecidingRound < 1 {
+		return gomel.NewConfigError("FirstDecidingRound is " + strconv.Itoa(cnf.FirstDecidingRound))
+	}
+	if cnf.ZeroVoteRoundForCommonVote < 0 {
+		return gomel.NewConfigError("ZeroVoteRoundForCommonVote is " + strconv.Itoa(cnf.ZeroVoteRoundForCommonVote))
+	}
+	if cnf.CommonVoteDeterministicPrefix < 0 {
+		return gomel.NewConfigError("CommonVoteDeterministicPrefix is " + strconv.Itoa(cnf.CommonVoteDeterministicPrefix))
+	}
 
 	// log checks
 	if cnf.LogFile == "" {
 		return gomel.NewConfigError("missing log filename")
 	}
-	if cnf.LogBuffer == 0 {
-		return gomel.NewConfigError("Log buffer cannot be 0")
+	if cnf.LogBuffer <= 0 {
+		return gomel.NewConfigError("Log buffer has to be positive")
 	}
 
 	// keys checks
@@ -155,6 +189,52 @@ func valid(cnf Config) error {
 		return err
 	}
 
+	if err := checkParentStrategy(cnf); err != nil {
+		return err
+	}
+
+	if err := checkPacing(cnf); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkPacing(cnf Config) error {
+	if cnf.UnitInterval < 0 {
+		return gomel.NewConfigError("UnitInterval is " + cnf.UnitInterval.String())
+	}
+	if cnf.UnitMaxData < 0 || cnf.UnitMaxData > MaxDataBytesPerUnit {
+		return gomel.NewConfigError("UnitMaxData is " + strconv.Itoa(cnf.UnitMaxData))
+	}
+	if cnf.UnitMinData < 0 || (cnf.UnitMaxData > 0 && cnf.UnitMinData > cnf.UnitMaxData) || cnf.UnitMinData > MaxDataBytesPerUnit {
+		return gomel.NewConfigError("UnitMinData is " + strconv.Itoa(cnf.UnitMinData))
+	}
+	if cnf.UnitMinData > 0 && cnf.UnitDataWait <= 0 {
+		return gomel.NewConfigError("waiting for UnitMinData requires a positive UnitDataWait")
+	}
+	return nil
+}
+
+func checkParentStrategy(cnf Config) error {
+	switch cnf.ParentStrategy {
+	case "":
+		return nil
+	case FixedLevelParents:
+		return nil
+	case MaxLevelParents, WaitParents, LatencyParents:
+		if !cnf.CanSkipLevel {
+			return gomel.NewConfigError("parent strategy " + cnf.ParentStrategy + " skips levels, which is not allowed")
+		}
+	default:
+		return gomel.NewConfigError("unknown parent strategy " + cnf.ParentStrategy)
+	}
+	if cnf.ParentStrategy != MaxLevelParents && cnf.ParentWait <= 0 {
+		return gomel.NewConfigError("parent strategy " + cnf.ParentStrategy + " requires a positive ParentWait")
+	}
+	if cnf.ParentExtra < 0 {
+		return gomel.NewConfigError("ParentExtra is " + strconv.Itoa(cnf.ParentExtra))
+	}
 	return nil
 }
 
@@ -172,11 +252,37 @@ func Valid(cnf Config) error {
 	if err := checkChecks(cnf.Checks, consensusChecks); err != nil {
 		return err
 	}
+	if cnf.Proposal != nil && !cnf.CommitteeChanges {
+		return gomel.NewConfigError("proposing a committee change requires CommitteeChanges")
+	}
 
 	if err := checkSyncConf(cnf, false); err != nil {
 		return err
 	}
 
+	if err := checkFinalityConf(cnf); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkFinalityConf(cnf Config) error {
+	if cnf.CertificateInterval < 0 {
+		return gomel.NewConfigError("CertificateInterval is " + strconv.Itoa(cnf.CertificateInterval))
+	}
+	if cnf.CertificateInterval == 0 {
+		return nil
+	}
+	if cnf.CommitteeChanges {
+		return gomel.NewConfigError("certificates cannot be combined with committee changes")
+	}
+	if len(cnf.CertAddresses) != int(cnf.NProc) {
+		return gomel.NewConfigError("wrong number of cert addresses")
+	}
+	if cnf.CertificateFile == "" {
+		return gomel.NewConfigError("missing certificate filename")
+	}
 	return nil
 }
 
@@ -194,6 +300,9 @@ func ValidSetup(cnf Config) error {
 	if cnf.CRPFixedPrefix != 0 {
 		return gomel.NewConfigError("CRPFixedPrefix connot be nonzero in setup")
 	}
+	if cnf.EpochLength != 1 || cnf.NumberOfEpochs != 1 {
+		return gomel.NewConfigError("setup consists of a single epoch of length 1")
+	}
 	if len(cnf.Checks) != len(setupChecks) {
 		return gomel.NewConfigError("wrong number of checks")
 	}
@@ -2,9 +2,11 @@ package config
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
 
@@ -19,7 +21,7 @@ type Member struct {
 	// The process id of this member.
 	Pid uint16
 
-	// The private key of this committee member.
+	// The private key of this committee member, nil if it is kept by a remote signer.
 	PrivateKey gomel.PrivateKey
 
 	// The secret key of this committee member use for RMC.
@@ -45,23 +47,40 @@ type Committee struct {
 
 	// Addresses of all committee members
 	Addresses map[string][]string
+
+	// Optional data about the committee members, like a name or a region, ordered according to process ids.
+	// Nil if there is no such data about any member.
+	Metadata []map[string]string
 }
 
 const malformedData = "malformed committee data"
 
+// Types of addresses of committee members, in the order they are stored in.
+var (
+	setupAddressTypes = []string{"rmc", "fetch", "gossip"}
+	addressTypes      = []string{"rmc", "mcast", "fetch", "gossip", "cert"}
+)
+
+// RemoteKey stands in a member file for a private key that is kept by a remote signer.
+const RemoteKey = "remote"
+
 // LoadMember loads the data from the given reader and creates a member.
 func LoadMember(r io.Reader) (*Member, error) {
 	scanner := bufio.NewScanner(r)
 	scanner.Split(bufio.ScanWords)
 
 	// read private key, secret key, decryption key and pid. Assumes one line of the form
-	// "key secret_key decryption_key pid"
+	// "key secret_key decryption_key pid", where key can be RemoteKey
 	if !scanner.Scan() {
 		return nil, errors.New(malformedData)
 	}
-	privateKey, err := signing.DecodePrivateKey(scanner.Text())
-	if err != nil {
-		return nil, err
+	var privateKey gomel.PrivateKey
+	if scanner.Text() != RemoteKey {
+		var err error
+		privateKey, err = signing.DecodePrivateKey(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if !scanner.Scan() {
@@ -111,6 +130,8 @@ func parseAddrs(addrsList string) map[string]string {
 			addrs["gossip"] = addr[1:]
 		case 'm':
 			addrs["mcast"] = addr[1:]
+		case 'c':
+			addrs["cert"] = addr[1:]
 		}
 	}
 	return addrs
@@ -146,50 +167,84 @@ func addAddr(commAddrMap map[string][]string, addrMap map[string]string) {
 }
 
 // LoadCommittee loads the data from the given reader and creates a committee.
+// It reads both the JSON format written by StoreCommittee and the line format written by StoreCommitteeLines.
 func LoadCommittee(r io.Reader) (*Committee, error) {
-	scanner := bufio.NewScanner(r)
+	c, err := LoadNewMembers(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.PublicKeys) < 4 {
+		return nil, errors.New(malformedData)
+	}
+	return c, nil
+}
 
-	c := &Committee{SetupAddresses: make(map[string][]string), Addresses: make(map[string][]string)}
-	for scanner.Scan() {
-		pk, p2pPK, vk, setupAddrs, addrs, err := parseCommitteeLine(scanner.Text())
-		if err != nil {
-			return nil, err
-		}
+// LoadNewMembers loads the members added by a committee change (see NewCommitteeChange), in any format read by LoadCommittee.
+// Unlike LoadCommittee, it accepts any number of members.
+func LoadNewMembers(r io.Reader) (*Committee, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isCommitteeJSON(data) {
+		return readCommitteeJSON(data)
+	}
+	return readCommittee(bytes.NewReader(data))
+}
 
-		publicKey, err := signing.DecodePublicKey(pk)
-		if err != nil {
-			return nil, err
-		}
+// Scheme returns the name of the signature scheme used by the committee for signing units.
+func (c *Committee) Scheme() string {
+	if len(c.PublicKeys) == 0 {
+		return signing.DefaultScheme
+	}
+	return signing.SchemeOf(c.PublicKeys[0].Encode())
+}
 
-		p2pPublicKey, err := p2p.DecodePublicKey(p2pPK)
-		if err != nil {
-			return nil, err
-		}
+// addMember parses a single committee line and appends the member described by it to the committee.
+func (c *Committee) addMember(line string) error {
+	pk, p2pPK, vk, setupAddrs, addrs, err := parseCommitteeLine(line)
+	if err != nil {
+		return err
+	}
 
-		verificationKey, err := bn256.DecodeVerificationKey(vk)
-		if err != nil {
-			return nil, err
-		}
+	publicKey, err := signing.DecodePublicKey(pk)
+	if err != nil {
+		return err
+	}
+	return c.appendMember(publicKey, p2pPK, vk, setupAddrs, addrs)
+}
 
-		c.PublicKeys = append(c.PublicKeys, publicKey)
-		c.P2PPublicKeys = append(c.P2PPublicKeys, p2pPublicKey)
-		c.RMCVerificationKeys = append(c.RMCVerificationKeys, verificationKey)
-		addAddr(c.SetupAddresses, setupAddrs)
-		addAddr(c.Addresses, addrs)
+// appendMember decodes the remaining keys of a member and appends it to the committee.
+func (c *Committee) appendMember(publicKey gomel.PublicKey, p2pPK, vk string, setupAddrs, addrs map[string]string) error {
+	if len(c.PublicKeys) > 0 && signing.SchemeOf(publicKey.Encode()) != c.Scheme() {
+		return errors.New("all committee members have to use the same signature scheme, expected " + c.Scheme())
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	p2pPublicKey, err := p2p.DecodePublicKey(p2pPK)
+	if err != nil {
+		return err
 	}
-	if len(c.PublicKeys) < 4 {
-		return nil, errors.New(malformedData)
+
+	verificationKey, err := bn256.DecodeVerificationKey(vk)
+	if err != nil {
+		return err
 	}
-	return c, nil
+
+	c.PublicKeys = append(c.PublicKeys, publicKey)
+	c.P2PPublicKeys = append(c.P2PPublicKeys, p2pPublicKey)
+	c.RMCVerificationKeys = append(c.RMCVerificationKeys, verificationKey)
+	addAddr(c.SetupAddresses, setupAddrs)
+	addAddr(c.Addresses, addrs)
+	return nil
 }
 
 // StoreMember writes the given member to the writer.
 func StoreMember(w io.Writer, m *Member) error {
-	_, err := io.WriteString(w, m.PrivateKey.Encode())
+	privateKey := RemoteKey
+	if m.PrivateKey != nil {
+		privateKey = m.PrivateKey.Encode()
+	}
+	_, err := io.WriteString(w, privateKey)
 	if err != nil {
 		return err
 	}
@@ -244,8 +299,9 @@ func storeAddresses(w io.Writer, pid int, addresses map[string][]string, types [
 	return nil
 }
 
-// StoreCommittee writes the given committee to the writer.
-func StoreCommittee(w io.Writer, c *Committee) error {
+// StoreCommitteeLines writes the given committee to the writer in the line format, one member per line.
+// The format is readable by older versions, but it cannot contain the metadata of members.
+func StoreCommitteeLines(w io.Writer, c *Committee) error {
 	for i := range c.PublicKeys {
 		// store public keys
 		if _, err := io.WriteString(w, c.PublicKeys[i].Encode()); err != nil {
@@ -269,14 +325,14 @@ func StoreCommittee(w io.Writer, c *Committee) error {
 			return err
 		}
 		// store setup addresses
-		if err := storeAddresses(w, i, c.SetupAddresses, []string{"rmc", "fetch", "gossip"}); err != nil {
+		if err := storeAddresses(w, i, c.SetupAddresses, setupAddressTypes); err != nil {
 			return err
 		}
 		if _, err := io.WriteString(w, "|"); err != nil {
 			return err
 		}
 		// store addresses
-		if err := storeAddresses(w, i, c.Addresses, []string{"rmc", "mcast", "fetch", "gossip"}); err != nil {
+		if err := storeAddresses(w, i, c.Addresses, addressTypes); err != nil {
 			return err
 		}
 		if _, err := io.WriteString(w, "\n"); err != nil {
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+)
+
+// CommitteeVersion is the version of the JSON committee format written by StoreCommittee.
+// LoadCommittee refuses files of newer versions.
+const CommitteeVersion = 1
+
+// committeeFile is the JSON form of a committee, e.g.
+//
+//	{
+//	  "version": 1,
+//	  "members": [
+//	    {
+//	      "signing_key": {"scheme": "ed25519", "key": "..."},
+//	      "p2p_key": "...",
+//	      "rmc_key": "...",
+//	      "setup_addresses": {"fetch": "10.0.0.1:14000", "gossip": "10.0.0.1:15000", "rmc": "10.0.0.1:13000"},
+//	      "addresses": {"cert": "...", "fetch": "...", "gossip": "...", "mcast": "...", "rmc": "..."},
+//	      "metadata": {"name": "alice", "region": "eu-west"}
+//	    },
+//	    ...
+//	  ]
+//	}
+type committeeFile struct {
+	Version int               `json:"version"`
+	Members []committeeMember `json:"members"`
+}
+
+type committeeMember struct {
+	SigningKey     signingKey        `json:"signing_key"`
+	P2PKey         string            `json:"p2p_key"`
+	RMCKey         string            `json:"rmc_key"`
+	SetupAddresses map[string]string `json:"setup_addresses"`
+	Addresses      map[string]string `json:"addresses"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+// signingKey is a public key for signing units, with the key untagged and its scheme given explicitly.
+type signingKey struct {
+	Scheme string `json:"scheme"`
+	Key    string `json:"key"`
+}
+
+// isCommitteeJSON checks if the committee data is in the JSON format rather than in the line format.
+func isCommitteeJSON(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+func readCommitteeJSON(data []byte) (*Committee, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var file committeeFile
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Version < 1 || file.Version > CommitteeVersion {
+		return nil, errors.New("unsupported committee format version " + strconv.Itoa(file.Version))
+	}
+	c := &Committee{SetupAddresses: make(map[string][]string), Addresses: make(map[string][]string)}
+	hasMetadata := false
+	for pid, m := range file.Members {
+		member := "member " + strconv.Itoa(pid) + ": "
+		if m.SigningKey.Scheme == "" {
+			m.SigningKey.Scheme = signing.DefaultScheme
+		}
+		scheme, err := signing.Lookup(m.SigningKey.Scheme)
+		if err != nil {
+			return nil, errors.New(member + err.Error())
+		}
+		publicKey, err := scheme.DecodePublicKey(m.SigningKey.Key)
+		if err != nil {
+			return nil, errors.New(member + err.Error())
+		}
+		if err := checkAddressTypes(m.SetupAddresses, file.Members[0].SetupAddresses, setupAddressTypes); err != nil {
+			return nil, errors.New(member + "setup addresses: " + err.Error())
+		}
+		if err := checkAddressTypes(m.Addresses, file.Members[0].Addresses, addressTypes); err != nil {
+			return nil, errors.New(member + "addresses: " + err.Error())
+		}
+		if err := c.appendMember(publicKey, m.P2PKey, m.RMCKey, m.SetupAddresses, m.Addresses); err != nil {
+			return nil, errors.New(member + err.Error())
+		}
+		c.Metadata = append(c.Metadata, m.Metadata)
+		hasMetadata = hasMetadata || m.Metadata != nil
+	}
+	if !hasMetadata {
+		c.Metadata = nil
+	}
+	return c, nil
+}
+
+// checkAddressTypes checks that the addresses are of known types, and of the same types as the addresses of the first member.
+func checkAddressTypes(addrs, first map[string]string, known []string) error {
+	isKnown := make(map[string]bool, len(known))
+	for _, syncType := range known {
+		isKnown[syncType] = true
+		_, has := addrs[syncType]
+		_, firstHas := first[syncType]
+		if has != firstHas {
+			return errors.New("members have to have addresses of the same types, " + syncType + " differs from the first member")
+		}
+	}
+	for syncType := range addrs {
+		if !isKnown[syncType] {
+			return errors.New("unknown type " + syncType)
+		}
+	}
+	return nil
+}
+
+// StoreCommittee writes the given committee to the writer in the JSON format of version CommitteeVersion.
+func StoreCommittee(w io.Writer, c *Committee) error {
+	file := committeeFile{Version: CommitteeVersion, Members: make([]committeeMember, len(c.PublicKeys))}
+	for pid := range c.PublicKeys {
+		scheme, key := signing.Untag(c.PublicKeys[pid].Encode())
+		m := committeeMember{
+			SigningKey:     signingKey{Scheme: scheme, Key: key},
+			P2PKey:         c.P2PPublicKeys[pid].Encode(),
+			RMCKey:         c.RMCVerificationKeys[pid].Encode(),
+			SetupAddresses: make(map[string]string),
+			Addresses:      make(map[string]string),
+		}
+		for syncType, addrs := range c.SetupAddresses {
+			m.SetupAddresses[syncType] = addrs[pid]
+		}
+		for syncType, addrs := range c.Addresses {
+			m.Addresses[syncType] = addrs[pid]
+		}
+		if pid < len(c.Metadata) {
+			m.Metadata = c.Metadata[pid]
+		}
+		file.Members[pid] = m
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -13,6 +13,14 @@ import (
 	"gitlab.com/alephledger/core-go/pkg/crypto/tss"
 )
 
+// Parent strategies of the creator, see creator.ParentStrategy.
+const (
+	MaxLevelParents   = "max"     // build on the highest possible level as soon as possible
+	FixedLevelParents = "fixed"   // build on every consecutive level
+	WaitParents       = "wait"    // wait up to ParentWait for ParentExtra parents above a quorum
+	LatencyParents    = "latency" // wait up to ParentWait for parents of processes that are usually fast
+)
+
 // Config represents a complete configuration needed for a process to start.
 // Exported type is a pointer type to make sure that we always deal with only one underlying struct.
 type Config *conf
@@ -21,25 +29,46 @@ type conf struct {
 	Pid   uint16
 	NProc uint16
 	// epoch
-	EpochLength    int
-	NumberOfEpochs int
-	LastLevel      int // LastLevel = EpochLength + OrderStartLevel - 1
-	CanSkipLevel   bool
-	Checks         []gomel.UnitChecker
+	EpochLength      int
+	NumberOfEpochs   int           // 0 means that new epochs are produced forever
+	FirstEpoch       gomel.EpochID // epoch in which this process joins the committee
+	LastLevel        int           // LastLevel = EpochLength + OrderStartLevel - 1
+	CanSkipLevel     bool
+	ParentStrategy   string           // one of the *Parents constants, empty chooses MaxLevelParents or FixedLevelParents according to CanSkipLevel
+	ParentWait       time.Duration    // the longest time WaitParents and LatencyParents wait for more parents
+	ParentExtra      int              // the number of parents above a quorum WaitParents waits for
+	CommitteeChanges bool             // apply committee changes agreed in preblocks, see orderer.NewReconfigurable
+	Proposal         *CommitteeChange // a committee change proposed in every epoch until it is agreed, see NewCommitteeChange
+	HandoverFile     string           // file to which the handover of every agreed committee change is written, see Join
+	Checks           []gomel.UnitChecker
+	// pacing of units carrying data, see creator.BatchingDataSource
+	UnitInterval time.Duration // the shortest time between two such units
+	UnitMaxData  int           // the largest data of a unit in bytes, taken from a batching source, 0 means MaxDataBytesPerUnit
+	UnitMinData  int           // the amount of data in bytes a unit waits for, at most UnitDataWait, 0 disables waiting
+	UnitDataWait time.Duration
 	// log
 	LogFile   string
 	LogLevel  int
 	LogHuman  bool
 	LogBuffer int
+	// store
+	UnitStoreDir string // directory for persisting units, empty disables persistence
+	LastUnitFile string // file for persisting the last unit signed by this process, protects against forking after a restart
+	// admin
+	AdminAddress string // address of the HTTP server reporting the status of the process, empty disables it
+	// simulation
+	Activity *gomel.Activity // counts work in progress for a driver feeding the process with units, nil outside of simulations
+	Clock    *gomel.Clock    // the time used for choosing parents and pacing units, nil means the real time
 	// keys
 	WTKey         *tss.WeakThresholdKey
 	PrivateKey    gomel.PrivateKey
+	Signer        gomel.Signer // signs units instead of PrivateKey if set, e.g. in another process holding the key
 	PublicKeys    []gomel.PublicKey
 	P2PPublicKeys []*p2p.PublicKey
 	P2PSecretKey  *p2p.SecretKey
 	RMCPrivateKey *bn256.SecretKey
 	RMCPublicKeys []*bn256.VerificationKey
-	// sync
+	// sync, network types are "tcp" (default), "udp" (multicast only), "pers" or "mem", prefixed with "secure+" to encrypt the connections
 	GossipAbove     int
 	FetchInterval   time.Duration
 	GossipInterval  time.Duration
@@ -53,7 +82,14 @@ type conf struct {
 	MCastAddresses  []string
 	MCastNetType    string
 	GossipWorkers   [2]int // nIn, nOut
+	GossipVersion   int    // newest version of the gossip protocol offered to peers, 0 means the newest one implemented
 	FetchWorkers    [2]int // nIn, nOut
+	FetchVersion    int    // newest version of the fetch protocol offered to peers, 0 means the newest one implemented
+	// finality
+	CertificateInterval int    // certify preblocks every that many levels and at the end of each epoch, 0 disables certificates
+	CertificateFile     string // file to which the certificates are appended
+	CertAddresses       []string
+	CertNetType         string
 	// linear
 	OrderStartLevel               int
 	CRPFixedPrefix                uint16
@@ -68,23 +104,31 @@ func AddCheck(c Config, check gomel.UnitChecker) {
 }
 
 // NewSetup returns a Config for setup phase given Member and Committee data.
-func NewSetup(m *Member, c *Committee) Config {
+// The defaults can be overridden with parameters loaded from a file, see Params.
+func NewSetup(m *Member, c *Committee, params ...*Params) Config {
 	cnf := requiredByLinear()
 	addKeys(cnf, m, c)
 	addSyncConf(cnf, c.SetupAddresses, true)
 	addLogConf(cnf, strconv.Itoa(int(cnf.Pid))+".setup")
 	addSetupConf(cnf)
+	for _, p := range params {
+		p.apply(cnf, SetupSection)
+	}
 	addLastLevel(cnf)
 	return cnf
 }
 
 // New returns a Config for regular consensus run from the given Member and Committee data.
-func New(m *Member, c *Committee) Config {
+// The defaults can be overridden with parameters loaded from a file, see Params.
+func New(m *Member, c *Committee, params ...*Params) Config {
 	cnf := requiredByLinear()
 	addKeys(cnf, m, c)
 	addSyncConf(cnf, c.Addresses, false)
 	addLogConf(cnf, strconv.Itoa(int(cnf.Pid)))
 	addConsensusConf(cnf)
+	for _, p := range params {
+		p.apply(cnf, ConsensusSection)
+	}
 	addLastLevel(cnf)
 	return cnf
 }
@@ -123,6 +167,9 @@ func addSyncConf(cnf Config, addresses map[string][]string, setup bool) {
 	cnf.MCastNetType = "tcp"
 	cnf.MCastAddresses = addresses["mcast"]
 
+	cnf.CertNetType = "tcp"
+	cnf.CertAddresses = addresses["cert"]
+
 	n := int(cnf.NProc)
 	cnf.GossipWorkers = [2]int{n/20 + 1, n/40 + 1}
 	cnf.FetchWorkers = [2]int{n / 2, n / 4}
@@ -153,6 +200,12 @@ func addConsensusConf(cnf Config) {
 	cnf.Checks = consensusChecks
 }
 
+// IsLastEpoch checks if the given epoch is the last one that should be produced.
+// It is always false if NumberOfEpochs is 0.
+func IsLastEpoch(cnf Config, epoch gomel.EpochID) bool {
+	return cnf.NumberOfEpochs > 0 && int(epoch) == cnf.NumberOfEpochs-1
+}
+
 func requiredByLinear() Config {
 	return &conf{
 		FirstDecidingRound:            3,
@@ -4,11 +4,16 @@ import (
 	"bufio"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	. "gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
 
 	"bytes"
 )
@@ -47,6 +52,23 @@ var _ = Describe("Config", func() {
 				Expect(buf.Bytes()).To(Equal(fileContent))
 			})
 		})
+		Describe("When the private key is kept by a remote signer", func() {
+			It("Should store and load the member without it", func() {
+				file, err := os.Open("../testdata/test_pk.txt")
+				defer file.Close()
+				Expect(err).NotTo(HaveOccurred())
+				member, err := LoadMember(bufio.NewReader(file))
+				Expect(err).NotTo(HaveOccurred())
+				member.PrivateKey = nil
+				buf := bytes.NewBuffer([]byte{})
+				Expect(StoreMember(buf, member)).To(Succeed())
+				Expect(buf.String()).To(HavePrefix(RemoteKey + " "))
+				loaded, err := LoadMember(buf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(loaded.PrivateKey).To(BeNil())
+				Expect(loaded.Pid).To(Equal(member.Pid))
+			})
+		})
 	})
 	Describe("committee", func() {
 		Describe("When loaded from a test file ", func() {
@@ -76,11 +98,100 @@ var _ = Describe("Config", func() {
 			})
 			It("Should have the same content", func() {
 				buf := bytes.NewBuffer([]byte{})
-				err := StoreCommittee(buf, committee)
+				err := StoreCommitteeLines(buf, committee)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(buf.Bytes()).To(Equal(fileContent))
 			})
 		})
+		Describe("When loaded from a JSON file", func() {
+			var committee *Committee
+			BeforeEach(func() {
+				file, err := os.Open("../testdata/test_committee.txt")
+				defer file.Close()
+				Expect(err).NotTo(HaveOccurred())
+				committee, err = LoadCommittee(bufio.NewReader(file))
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should be the same as the one from the line format", func() {
+				file, err := os.Open("../testdata/test_committee.json")
+				defer file.Close()
+				Expect(err).NotTo(HaveOccurred())
+				loaded, err := LoadCommittee(bufio.NewReader(file))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(loaded).To(Equal(committee))
+			})
+			It("Should round-trip with metadata", func() {
+				committee.Metadata = make([]map[string]string, len(committee.PublicKeys))
+				committee.Metadata[1] = map[string]string{"name": "bob", "region": "eu-west"}
+				buf := bytes.NewBuffer([]byte{})
+				Expect(StoreCommittee(buf, committee)).To(Succeed())
+				Expect(buf.String()).To(ContainSubstring(`"region": "eu-west"`))
+				loaded, err := LoadCommittee(buf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(loaded).To(Equal(committee))
+			})
+			Describe("with mistakes", func() {
+				var encoded string
+				BeforeEach(func() {
+					buf := bytes.NewBuffer([]byte{})
+					Expect(StoreCommittee(buf, committee)).To(Succeed())
+					encoded = buf.String()
+				})
+				load := func(s string) error {
+					_, err := LoadCommittee(bytes.NewBufferString(s))
+					return err
+				}
+				It("Should refuse an unsupported version", func() {
+					Expect(load(strings.Replace(encoded, `"version": 1`, `"version": 2`, 1))).To(HaveOccurred())
+					Expect(load(strings.Replace(encoded, `"version": 1,`, ``, 1))).To(HaveOccurred())
+				})
+				It("Should refuse unknown fields and address types", func() {
+					Expect(load(strings.Replace(encoded, `"p2p_key"`, `"p2p"`, 1))).To(HaveOccurred())
+					Expect(load(strings.Replace(encoded, `"gossip"`, `"g"`, 1))).To(HaveOccurred())
+				})
+				It("Should refuse members with addresses of different types", func() {
+					Expect(load(strings.Replace(encoded, `"mcast"`, `"cert"`, 1))).To(HaveOccurred())
+				})
+				It("Should refuse an unknown signature scheme", func() {
+					Expect(load(strings.Replace(encoded, `"scheme": "ed25519"`, `"scheme": "rot13"`, 1))).To(HaveOccurred())
+				})
+			})
+		})
+		Describe("When using another signature scheme", func() {
+			var committee *Committee
+			BeforeEach(func() {
+				file, err := os.Open("../testdata/test_committee.txt")
+				defer file.Close()
+				Expect(err).NotTo(HaveOccurred())
+				committee, err = LoadCommittee(bufio.NewReader(file))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(committee.Scheme()).To(Equal(signing.DefaultScheme))
+			})
+			It("Should load the tagged keys", func() {
+				bls, err := signing.Lookup("bls")
+				Expect(err).NotTo(HaveOccurred())
+				for i := range committee.PublicKeys {
+					committee.PublicKeys[i], _, err = bls.GenerateKeys(nil)
+					Expect(err).NotTo(HaveOccurred())
+				}
+				buf := bytes.NewBuffer([]byte{})
+				Expect(StoreCommittee(buf, committee)).To(Succeed())
+				loaded, err := LoadCommittee(buf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(loaded.Scheme()).To(Equal("bls"))
+				Expect(loaded.PublicKeys).To(Equal(committee.PublicKeys))
+			})
+			It("Should refuse a committee mixing schemes", func() {
+				bls, err := signing.Lookup("bls")
+				Expect(err).NotTo(HaveOccurred())
+				committee.PublicKeys[1], _, err = bls.GenerateKeys(nil)
+				Expect(err).NotTo(HaveOccurred())
+				buf := bytes.NewBuffer([]byte{})
+				Expect(StoreCommittee(buf, committee)).To(Succeed())
+				_, err = LoadCommittee(buf)
+				Expect(err).To(HaveOccurred())
+			})
+		})
 	})
 	Describe("defaults", func() {
 		var (
@@ -113,6 +224,218 @@ var _ = Describe("Config", func() {
 			err := Valid(cnf)
 			Expect(err).NotTo(HaveOccurred())
 		})
+		It("should accept an unbounded number of epochs", func() {
+			cnf = New(m, c)
+			cnf.NumberOfEpochs = 0
+			Expect(Valid(cnf)).NotTo(HaveOccurred())
+			Expect(IsLastEpoch(cnf, 0)).To(BeFalse())
+			Expect(IsLastEpoch(cnf, 1<<31)).To(BeFalse())
+		})
+		It("should require addresses and a file for certificates", func() {
+			cnf = New(m, c)
+			cnf.CertificateInterval = 10
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.CertAddresses = cnf.RMCAddresses
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.CertificateFile = "certificates"
+			Expect(Valid(cnf)).To(Succeed())
+			cnf.CommitteeChanges = true
+			Expect(Valid(cnf)).To(HaveOccurred())
+		})
+		It("should refuse one-way network types for syncing protocols other than multicast", func() {
+			cnf = New(m, c)
+			cnf.GossipNetType = "udp"
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.GossipNetType = "secure+udp"
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.GossipNetType = "secure+tcp"
+			Expect(Valid(cnf)).To(Succeed())
+			cnf.MCastNetType = "udp"
+			Expect(Valid(cnf)).To(Succeed())
+			cnf.MCastNetType = "secure+udp"
+			Expect(Valid(cnf)).To(Succeed())
+		})
+		It("should refuse the first versions of gossip and fetch with keys of another scheme", func() {
+			cnf = New(m, c)
+			cnf.GossipVersion = 1
+			cnf.FetchVersion = 1
+			Expect(Valid(cnf)).To(Succeed())
+			bls, err := signing.Lookup("bls")
+			Expect(err).NotTo(HaveOccurred())
+			for i := range cnf.PublicKeys {
+				cnf.PublicKeys[i], _, err = bls.GenerateKeys(nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.GossipVersion = 0
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.FetchVersion = 2
+			Expect(Valid(cnf)).To(Succeed())
+		})
+		It("should check the parent strategy", func() {
+			cnf = New(m, c)
+			cnf.ParentStrategy = "unknown"
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.ParentStrategy = WaitParents
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.ParentWait = time.Second
+			cnf.ParentExtra = 1
+			Expect(Valid(cnf)).To(Succeed())
+			cnf.ParentExtra = -1
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.ParentExtra = 0
+			cnf.ParentStrategy = LatencyParents
+			Expect(Valid(cnf)).To(Succeed())
+			cnf.CanSkipLevel = false
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.ParentStrategy = FixedLevelParents
+			Expect(Valid(cnf)).To(Succeed())
+		})
+		It("should check the pacing of units", func() {
+			cnf = New(m, c)
+			cnf.UnitMaxData = MaxDataBytesPerUnit + 1
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.UnitMaxData = 1000
+			cnf.UnitMinData = 2000
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.UnitMinData = 500
+			Expect(Valid(cnf)).To(HaveOccurred())
+			cnf.UnitDataWait = time.Second
+			Expect(Valid(cnf)).To(Succeed())
+			cnf.UnitInterval = -time.Second
+			Expect(Valid(cnf)).To(HaveOccurred())
+		})
+		It("should recognize the last of a bounded number of epochs", func() {
+			cnf = New(m, c)
+			cnf.NumberOfEpochs = 3
+			Expect(IsLastEpoch(cnf, 1)).To(BeFalse())
+			Expect(IsLastEpoch(cnf, 2)).To(BeTrue())
+		})
+		Describe("committee changes", func() {
+			BeforeEach(func() {
+				cnf = New(m, c)
+			})
+			It("should refuse to shrink the committee below four members", func() {
+				_, err := NewCommitteeChange(cnf, []uint16{0}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+			It("should refuse to remove an unknown member", func() {
+				_, err := ApplyChange(CommitteeOf(cnf), &CommitteeChange{Removed: []uint16{7}})
+				Expect(err).To(HaveOccurred())
+			})
+			It("should refuse to add an existing member", func() {
+				_, err := ApplyChange(CommitteeOf(cnf), &CommitteeChange{Added: c})
+				Expect(err).To(HaveOccurred())
+			})
+			It("should decode an encoded change", func() {
+				ch, err := NewCommitteeChange(cnf, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				data := ch.Encode()
+				Expect(IsCommitteeChange(data)).To(BeTrue())
+				decoded, err := DecodeCommitteeChange(data)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decoded.Proposal()).To(Equal(ch.Proposal()))
+				Expect(decoded.Key).To(Equal(ch.Key))
+			})
+			Describe("with keys dealt by several members", func() {
+				var (
+					confs []Config
+					ch    *CommitteeChange
+					keys  []DealtKey
+				)
+				BeforeEach(func() {
+					confs = make([]Config, cnf.NProc)
+					for i := range confs {
+						member := *cnf
+						member.Pid = uint16(i)
+						member.GossipWorkers = [2]int{7, 3}
+						confs[i] = &member
+					}
+					tests.AddP2PKeys(confs...)
+					keys = nil
+					for i := uint16(0); i < gomel.MinimalTrusted(cnf.NProc); i++ {
+						proposal, err := NewCommitteeChange(confs[i], nil, nil)
+						Expect(err).NotTo(HaveOccurred())
+						keys = append(keys, DealtKey{Dealer: i, Key: proposal.Key})
+						ch = proposal
+					}
+				})
+				It("should produce a config for the new committee", func() {
+					for _, member := range confs {
+						next, err := Reconfigure(member, ch, keys)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(next).NotTo(BeNil())
+						Expect(next.Pid).To(Equal(member.Pid))
+						Expect(next.NProc).To(Equal(cnf.NProc))
+						Expect(next.WTKey).NotTo(BeNil())
+						Expect(next.GossipWorkers).To(Equal([2]int{7, 3}))
+						Expect(next.FetchWorkers).To(Equal(member.FetchWorkers))
+						Expect(Valid(next)).NotTo(HaveOccurred())
+					}
+				})
+				It("should refuse a key dealt by too few members", func() {
+					_, err := Reconfigure(confs[0], ch, keys[:len(keys)-1])
+					Expect(err).To(HaveOccurred())
+				})
+				It("should refuse a key dealt twice by the same member", func() {
+					keys[1].Dealer = keys[0].Dealer
+					_, err := Reconfigure(confs[0], ch, keys)
+					Expect(err).To(HaveOccurred())
+				})
+			})
+		})
+		Describe("parameters file", func() {
+			load := func(s string) (*Params, error) {
+				return LoadParams(bytes.NewBufferString(s))
+			}
+			It("should override the defaults, with sections applied only in their phase", func() {
+				p, err := load(`{
+					"Timeout": "10s",
+					"GossipWorkers": [4, 2],
+					"LogHuman": true,
+					"Setup": {"LogFile": "setup.log"},
+					"Consensus": {"EpochLength": 100, "CRPFixedPrefix": 3, "FirstDecidingRound": 5}
+				}`)
+				Expect(err).NotTo(HaveOccurred())
 
+				cnf = New(m, c, p)
+				Expect(Valid(cnf)).To(Succeed())
+				Expect(cnf.Timeout).To(Equal(10 * time.Second))
+				Expect(cnf.GossipWorkers).To(Equal([2]int{4, 2}))
+				Expect(cnf.LogHuman).To(BeTrue())
+				Expect(cnf.EpochLength).To(Equal(100))
+				Expect(cnf.LastLevel).To(Equal(99))
+				Expect(cnf.CRPFixedPrefix).To(Equal(uint16(3)))
+				Expect(cnf.FirstDecidingRound).To(Equal(5))
+				Expect(cnf.FetchInterval).To(Equal(New(m, c).FetchInterval))
+
+				setup := NewSetup(m, c, p)
+				Expect(ValidSetup(setup)).To(Succeed())
+				Expect(setup.Timeout).To(Equal(10 * time.Second))
+				Expect(setup.LogFile).To(Equal("setup.log"))
+				Expect(setup.EpochLength).To(Equal(1))
+				Expect(setup.FirstDecidingRound).To(Equal(New(m, c).FirstDecidingRound))
+			})
+			It("should refuse unknown parameters and values of a wrong type", func() {
+				_, err := load(`{"NoSuchParameter": 1}`)
+				Expect(err).To(HaveOccurred())
+				_, err = load(`{"Pid": 1}`)
+				Expect(err).To(HaveOccurred())
+				_, err = load(`{"Consensus": {"EpochLength": "long"}}`)
+				Expect(err).To(HaveOccurred())
+				_, err = load(`{"Timeout": 5}`)
+				Expect(err).To(HaveOccurred())
+				_, err = load(`{"Timeout": "5 minutes"}`)
+				Expect(err).To(HaveOccurred())
+				_, err = load(`{"Timeout": "5s"`)
+				Expect(err).To(HaveOccurred())
+			})
+			It("should leave invalid values to the validation", func() {
+				p, err := load(`{"FirstDecidingRound": 0, "Setup": {"EpochLength": 3}, "Consensus": {"FetchWorkers": [-1, 1]}}`)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(Valid(New(m, c, p))).To(HaveOccurred())
+				Expect(ValidSetup(NewSetup(m, c, p))).To(HaveOccurred())
+			})
+		})
 	})
 })
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// Sections of a parameters file applied only in one phase of the protocol.
+const (
+	SetupSection     = "Setup"
+	ConsensusSection = "Consensus"
+)
+
+// tunable lists the fields of Config that can be set in a parameters file.
+var tunable = map[string]bool{
+	// epoch
+	"EpochLength": true, "NumberOfEpochs": true, "CanSkipLevel": true, "CommitteeChanges": true, "HandoverFile": true,
+	"ParentStrategy": true, "ParentWait": true, "ParentExtra": true,
+	"UnitInterval": true, "UnitMaxData": true, "UnitMinData": true, "UnitDataWait": true,
+	// log
+	"LogFile": true, "LogLevel": true, "LogHuman": true, "LogBuffer": true,
+	// store and admin
+	"UnitStoreDir": true, "LastUnitFile": true, "AdminAddress": true,
+	// sync
+	"GossipAbove": true, "FetchInterval": true, "GossipInterval": true, "Timeout": true,
+	"RMCNetType": true, "GossipNetType": true, "FetchNetType": true, "MCastNetType": true, "CertNetType": true,
+	"GossipWorkers": true, "GossipVersion": true, "FetchWorkers": true, "FetchVersion": true,
+	// finality
+	"CertificateInterval": true, "CertificateFile": true,
+	// linear
+	"OrderStartLevel": true, "CRPFixedPrefix": true, "ZeroVoteRoundForCommonVote": true,
+	"FirstDecidingRound": true, "CommonVoteDeterministicPrefix": true,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Params override the defaults set by New and NewSetup, so that the protocol can be tuned without recompiling.
+// They are read from a JSON object whose keys are the names of the Config fields, durations given as strings like "1.5s".
+// The SetupSection and ConsensusSection objects contain parameters overriding the common ones only in that phase, e.g.
+//
+//	{"Timeout": "10s", "GossipWorkers": [4, 2], "Consensus": {"EpochLength": 100, "CRPFixedPrefix": 7}}
+//
+// The values are checked only by Valid and ValidSetup, after being applied.
+type Params struct {
+	common    map[string]reflect.Value
+	setup     map[string]reflect.Value
+	consensus map[string]reflect.Value
+}
+
+// LoadParams reads parameters from the given reader. Unknown parameters and values of a wrong type are errors.
+func LoadParams(r io.Reader) (*Params, error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&fields); err != nil {
+		return nil, err
+	}
+	result := &Params{}
+	var err error
+	if result.setup, err = decodeSection(fields, SetupSection); err != nil {
+		return nil, err
+	}
+	if result.consensus, err = decodeSection(fields, ConsensusSection); err != nil {
+		return nil, err
+	}
+	if result.common, err = decodeParams(fields); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// decodeSection decodes the parameters of the given section and removes it from the fields.
+func decodeSection(fields map[string]json.RawMessage, name string) (map[string]reflect.Value, error) {
+	raw, ok := fields[name]
+	if !ok {
+		return nil, nil
+	}
+	delete(fields, name)
+	var section map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &section); err != nil {
+		return nil, errors.New("section " + name + ": " + err.Error())
+	}
+	return decodeParams(section)
+}
+
+func decodeParams(fields map[string]json.RawMessage) (map[string]reflect.Value, error) {
+	result := make(map[string]reflect.Value, len(fields))
+	for name, raw := range fields {
+		field, ok := reflect.TypeOf(conf{}).FieldByName(name)
+		if !ok || !tunable[name] {
+			return nil, errors.New("unknown parameter " + name)
+		}
+		value, err := decodeValue(raw, field.Type)
+		if err != nil {
+			return nil, errors.New("parameter " + name + ": " + err.Error())
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+func decodeValue(raw json.RawMessage, typ reflect.Type) (reflect.Value, error) {
+	if typ == durationType {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return reflect.Value{}, errors.New("a duration should be a string like \"1.5s\"")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(d), nil
+	}
+	value := reflect.New(typ)
+	if err := json.Unmarshal(raw, value.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return value.Elem(), nil
+}
+
+// apply sets the common parameters and then the ones from the given section.
+func (p *Params) apply(cnf Config, section string) {
+	if p == nil {
+		return
+	}
+	set := func(values map[string]reflect.Value) {
+		for name, value := range values {
+			reflect.ValueOf(cnf).Elem().FieldByName(name).Set(value)
+		}
+	}
+	set(p.common)
+	if section == SetupSection {
+		set(p.setup)
+	} else {
+		set(p.consensus)
+	}
+}
@@ -0,0 +1,402 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+	"gitlab.com/alephledger/core-go/pkg/crypto/encrypt"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+	"gitlab.com/alephledger/core-go/pkg/crypto/tss"
+)
+
+// changePrefix marks unit data that carries a proposal of a committee change.
+const changePrefix = "gomel committee change\n"
+
+// CommitteeChange describes a modification of the committee that takes effect at the beginning of an epoch.
+// Members listed in Removed leave the committee, the remaining ones keep their relative order and are followed by Added.
+// Key is a threshold key for the new committee, dealt by the member proposing the change and encrypted for every new member.
+// The key of the new committee is never the key of a single dealer, it combines keys dealt by several proposers (see Reconfigure).
+type CommitteeChange struct {
+	Removed []uint16
+	Added   *Committee
+	Key     []byte
+}
+
+// DealtKey is the key of a proposal of a committee change, together with the pid of the member that dealt it.
+type DealtKey struct {
+	Dealer uint16 `json:"dealer"`
+	Key    []byte `json:"key"`
+}
+
+// Handover is everything a member of the committee resulting from a change needs to start working in it, without
+// the units in which the change was agreed: the committee before the change, the change and the keys dealt by its proposers.
+// Members of the old committee write it when the change is agreed (see conf.HandoverFile), members added by the change
+// and members restarted after it read it with Join.
+type Handover struct {
+	Epoch     gomel.EpochID // the first epoch of the new committee
+	Committee *Committee    // the committee before the change
+	Change    *CommitteeChange
+	Keys      []DealtKey
+}
+
+// handoverFile is the JSON form of a Handover, with the committee in the line format and the change without a key.
+type handoverFile struct {
+	Epoch     gomel.EpochID `json:"epoch"`
+	Committee string        `json:"committee"`
+	Change    []byte        `json:"change"`
+	Keys      []DealtKey    `json:"keys"`
+}
+
+// NewCommitteeChange prepares a proposal of a committee change on behalf of the member described by the given config.
+// The proposal is put as data in units of this member when set as conf.Proposal; it is applied when
+// a quorum of the committee proposes the same change in units ordered within a single epoch.
+func NewCommitteeChange(cnf Config, removed []uint16, added *Committee) (*CommitteeChange, error) {
+	ch := &CommitteeChange{Removed: removed, Added: added}
+	c, err := ApplyChange(CommitteeOf(cnf), ch)
+	if err != nil {
+		return nil, err
+	}
+	n := uint16(len(c.PublicKeys))
+	keys := make([]encrypt.SymmetricKey, n)
+	for i, pk := range c.P2PPublicKeys {
+		keys[i], err = p2p.Key(p2p.NewSharedSecret(cnf.P2PSecretKey, pk))
+		if err != nil {
+			return nil, err
+		}
+	}
+	tc, err := tss.NewRandom(n, gomel.MinimalTrusted(n)).Encrypt(keys)
+	if err != nil {
+		return nil, err
+	}
+	ch.Key = tc.Encode()
+	return ch, nil
+}
+
+// IsCommitteeChange checks if the given unit data carries a proposal of a committee change.
+func IsCommitteeChange(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(changePrefix))
+}
+
+// Proposal returns the encoded change without the key. Proposals of the same change made by different members are equal.
+func (ch *CommitteeChange) Proposal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(changePrefix)
+	removed := make([]string, len(ch.Removed))
+	for i, pid := range ch.Removed {
+		removed[i] = strconv.Itoa(int(pid))
+	}
+	buf.WriteString(strings.Join(removed, " "))
+	buf.WriteString("\n")
+	n := 0
+	if ch.Added != nil {
+		n = len(ch.Added.PublicKeys)
+	}
+	buf.WriteString(strconv.Itoa(n))
+	buf.WriteString("\n")
+	if n > 0 {
+		StoreCommitteeLines(&buf, ch.Added)
+	}
+	return buf.Bytes()
+}
+
+// Encode returns the change in a form that can be put as data in a unit.
+func (ch *CommitteeChange) Encode() []byte {
+	return append(ch.Proposal(), ch.Key...)
+}
+
+// DecodeCommitteeChange reads a committee change from unit data.
+func DecodeCommitteeChange(data []byte) (*CommitteeChange, error) {
+	if !IsCommitteeChange(data) {
+		return nil, errors.New("not a committee change")
+	}
+	r := bufio.NewReader(bytes.NewReader(data[len(changePrefix):]))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.New(malformedData)
+	}
+	ch := &CommitteeChange{}
+	for _, field := range strings.Fields(line) {
+		pid, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		ch.Removed = append(ch.Removed, uint16(pid))
+	}
+	if line, err = r.ReadString('\n'); err != nil {
+		return nil, errors.New(malformedData)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 0 {
+		return nil, errors.New(malformedData)
+	}
+	var lines bytes.Buffer
+	for i := 0; i < n; i++ {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, errors.New(malformedData)
+		}
+		lines.WriteString(line)
+	}
+	if ch.Added, err = readCommittee(&lines); err != nil {
+		return nil, err
+	}
+	if ch.Key, err = ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// CommitteeOf returns the public data about the committee described by the given config.
+// Setup addresses are not part of the config, so they are left empty.
+func CommitteeOf(cnf Config) *Committee {
+	c := &Committee{
+		PublicKeys:          cnf.PublicKeys,
+		P2PPublicKeys:       cnf.P2PPublicKeys,
+		RMCVerificationKeys: cnf.RMCPublicKeys,
+		SetupAddresses:      make(map[string][]string),
+		Addresses:           make(map[string][]string),
+	}
+	for syncType, addresses := range map[string][]string{"rmc": cnf.RMCAddresses, "gossip": cnf.GossipAddresses, "fetch": cnf.FetchAddresses, "mcast": cnf.MCastAddresses, "cert": cnf.CertAddresses} {
+		if len(addresses) > 0 {
+			c.Addresses[syncType] = addresses
+		}
+	}
+	return c
+}
+
+// StoreHandover writes the given handover to the writer.
+func StoreHandover(w io.Writer, h *Handover) error {
+	var committee bytes.Buffer
+	if err := StoreCommitteeLines(&committee, h.Committee); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(handoverFile{h.Epoch, committee.String(), h.Change.Proposal(), h.Keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// LoadHandover reads a handover written by StoreHandover.
+func LoadHandover(r io.Reader) (*Handover, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var file handoverFile
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	c, err := readCommittee(strings.NewReader(file.Committee))
+	if err != nil {
+		return nil, err
+	}
+	if len(c.PublicKeys) < 4 {
+		return nil, errors.New(malformedData)
+	}
+	ch, err := DecodeCommitteeChange(file.Change)
+	if err != nil {
+		return nil, err
+	}
+	return &Handover{Epoch: file.Epoch, Committee: c, Change: ch, Keys: file.Keys}, nil
+}
+
+// ApplyChange returns the committee resulting from applying the given change to the given committee.
+func ApplyChange(c *Committee, ch *CommitteeChange) (*Committee, error) {
+	n := len(c.PublicKeys)
+	removed := make(map[uint16]bool)
+	for _, pid := range ch.Removed {
+		if int(pid) >= n || removed[pid] {
+			return nil, errors.New("invalid pid among removed members: " + strconv.Itoa(int(pid)))
+		}
+		removed[pid] = true
+	}
+	result := &Committee{SetupAddresses: make(map[string][]string), Addresses: make(map[string][]string)}
+	add := func(from *Committee, i int) {
+		result.PublicKeys = append(result.PublicKeys, from.PublicKeys[i])
+		result.P2PPublicKeys = append(result.P2PPublicKeys, from.P2PPublicKeys[i])
+		result.RMCVerificationKeys = append(result.RMCVerificationKeys, from.RMCVerificationKeys[i])
+		for syncType, addresses := range from.Addresses {
+			result.Addresses[syncType] = append(result.Addresses[syncType], addresses[i])
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !removed[uint16(i)] {
+			add(c, i)
+		}
+	}
+	if ch.Added != nil {
+		for i := range ch.Added.PublicKeys {
+			add(ch.Added, i)
+		}
+	}
+	if len(result.PublicKeys) < 4 {
+		return nil, errors.New("committee would be too small after the change")
+	}
+	keys := make([]string, len(result.PublicKeys))
+	for i, pk := range result.PublicKeys {
+		keys[i] = pk.Encode()
+	}
+	sort.Strings(keys)
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			return nil, errors.New("duplicated member after the change")
+		}
+	}
+	for syncType, addresses := range result.Addresses {
+		if len(addresses) != len(result.PublicKeys) {
+			return nil, errors.New("missing " + syncType + " addresses of some members after the change")
+		}
+	}
+	return result, nil
+}
+
+// Reconfigure returns a config for the committee resulting from the given change. The threshold key of the new committee
+// combines the given keys, dealt by distinct members proposing the change. There have to be at least MinimalTrusted of them,
+// so at least one was dealt by an honest member and no dealer knows the secret of the combined key.
+// All the parameters of the protocol are kept, including the numbers of sync workers, only the data about the committee is replaced.
+// Returns nil (and no error) if the member described by the given config does not belong to the new committee.
+func Reconfigure(cnf Config, ch *CommitteeChange, keys []DealtKey) (Config, error) {
+	if err := checkDealers(keys, cnf.NProc); err != nil {
+		return nil, err
+	}
+	c, err := ApplyChange(CommitteeOf(cnf), ch)
+	if err != nil {
+		return nil, err
+	}
+	own := cnf.PublicKeys[cnf.Pid].Encode()
+	pid := -1
+	for i, pk := range c.PublicKeys {
+		if pk.Encode() == own {
+			pid = i
+			break
+		}
+	}
+	if pid < 0 {
+		return nil, nil
+	}
+	n := uint16(len(c.PublicKeys))
+	wtk, err := combineKeys(cnf.P2PSecretKey, cnf.P2PPublicKeys, keys, uint16(pid), n)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *cnf
+	result := &next
+	result.Pid = uint16(pid)
+	result.NProc = n
+	result.Proposal = nil
+	result.PublicKeys = c.PublicKeys
+	result.P2PPublicKeys = c.P2PPublicKeys
+	result.RMCPublicKeys = c.RMCVerificationKeys
+	result.RMCAddresses = c.Addresses["rmc"]
+	result.GossipAddresses = c.Addresses["gossip"]
+	result.FetchAddresses = c.Addresses["fetch"]
+	result.MCastAddresses = c.Addresses["mcast"]
+	result.CertAddresses = c.Addresses["cert"]
+	result.WTKey = wtk
+	return result, nil
+}
+
+// Join returns a config for working in the committee resulting from the change in the given handover, from its first epoch on.
+// The given config has to describe that committee, like the ones built by New from its committee file. Only the threshold key
+// and the first epoch are taken from the handover, the key is combined from the dealt keys exactly as in Reconfigure.
+func Join(cnf Config, h *Handover) (Config, error) {
+	if err := checkDealers(h.Keys, uint16(len(h.Committee.PublicKeys))); err != nil {
+		return nil, err
+	}
+	c, err := ApplyChange(h.Committee, h.Change)
+	if err != nil {
+		return nil, err
+	}
+	if !sameMembers(c, CommitteeOf(cnf)) {
+		return nil, errors.New("the committee after the change differs from the committee of the config")
+	}
+	wtk, err := combineKeys(cnf.P2PSecretKey, h.Committee.P2PPublicKeys, h.Keys, cnf.Pid, cnf.NProc)
+	if err != nil {
+		return nil, err
+	}
+	next := *cnf
+	result := &next
+	result.FirstEpoch = h.Epoch
+	result.WTKey = wtk
+	return result, nil
+}
+
+// checkDealers checks that the keys were dealt by at least MinimalTrusted distinct members of a committee of the given size.
+func checkDealers(keys []DealtKey, nProc uint16) error {
+	if len(keys) < int(gomel.MinimalTrusted(nProc)) {
+		return errors.New("too few dealers of the committee key")
+	}
+	dealers := make(map[uint16]bool)
+	for _, dk := range keys {
+		if dk.Dealer >= nProc || dealers[dk.Dealer] {
+			return errors.New("unknown or repeated dealer of the committee key: " + strconv.Itoa(int(dk.Dealer)))
+		}
+		dealers[dk.Dealer] = true
+	}
+	return nil
+}
+
+// combineKeys decrypts our shares of the given dealt keys and combines them into the threshold key of a committee of size n,
+// in which we have the given pid. The dealers are members of the old committee with the given P2P public keys.
+func combineKeys(secret *p2p.SecretKey, dealers []*p2p.PublicKey, keys []DealtKey, pid, n uint16) (*tss.WeakThresholdKey, error) {
+	verifier := bn256.NewPolyVerifier(int(n), int(gomel.MinimalTrusted(n)))
+	tks := make([]*tss.ThresholdKey, len(keys))
+	for i, dk := range keys {
+		key, err := p2p.Key(p2p.NewSharedSecret(secret, dealers[dk.Dealer]))
+		if err != nil {
+			return nil, err
+		}
+		tk, ok, err := tss.Decode(dk.Key, dk.Dealer, pid, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !tk.PolyVerify(verifier) {
+			return nil, errors.New("invalid committee key dealt by " + strconv.Itoa(int(dk.Dealer)))
+		}
+		tks[i] = tk
+	}
+	providers := make(map[uint16]bool)
+	for i := uint16(0); i < n; i++ {
+		providers[i] = true
+	}
+	return tss.CreateWTK(tks, providers), nil
+}
+
+// sameMembers checks if the two committees consist of members with the same keys, in the same order.
+func sameMembers(c, d *Committee) bool {
+	if len(c.PublicKeys) != len(d.PublicKeys) {
+		return false
+	}
+	for i := range c.PublicKeys {
+		if c.PublicKeys[i].Encode() != d.PublicKeys[i].Encode() ||
+			c.P2PPublicKeys[i].Encode() != d.P2PPublicKeys[i].Encode() ||
+			c.RMCVerificationKeys[i].Encode() != d.RMCVerificationKeys[i].Encode() {
+			return false
+		}
+	}
+	return true
+}
+
+// readCommittee reads committee lines until the end of the given reader, without checking the size of the committee.
+func readCommittee(r io.Reader) (*Committee, error) {
+	scanner := bufio.NewScanner(r)
+	c := &Committee{SetupAddresses: make(map[string][]string), Addresses: make(map[string][]string)}
+	for scanner.Scan() {
+		if err := c.addMember(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
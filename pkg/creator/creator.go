@@ -3,20 +3,26 @@ package creator
 
 import (
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
 	"gitlab.com/alephledger/consensus-go/pkg/unit"
 	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/utils"
 )
 
 // Creator is a component responsible for producing new units. It reads units produced by other
 // committee members from some external channel (aka unit belt) and stores the ones with the highest
 // level as possible parents (candidates). Whenever there are enough parents to produce a unit on a new level,
 // Creator collects data from its DataSource, and random source data using the provided function, then builds,
-// signs and sends (using a function given to the constructor) a new unit.
+// signs and sends (using a function given to the constructor) a new unit. Units carrying data can be postponed
+// to collect more of it, see BatchingDataSource.
+// Creator never signs two units of the same height in the same epoch, also across restarts if conf.LastUnitFile is set.
 type Creator struct {
 	conf              config.Config
 	ds                core.DataSource
@@ -33,9 +39,38 @@ type Creator struct {
 	mx                sync.Mutex
 	epochProofBuilder func(gomel.EpochID) EpochProofBuilder
 	epochProof        EpochProofBuilder
+	guard             *signing.Record // the last unit we signed, nothing that could be its fork is ever signed
+	unsigned          *unsignedUnit   // unit the signer failed to sign, see sign
+	strategy          ParentStrategy
+	pacer             *pacer
+	recovered         []gomel.Unit // units to start with, see Recover
+	dealingData       core.Data    // data of a dealing unit from the starting epoch, see Recover
+	awaitingProof     bool         // our dealing unit waits for a proof of the epoch copied from a dealing unit of another process
+	proposed          bool         // conf.Proposal was put in a unit of the current epoch
+	committees        func(gomel.EpochID) (config.Config, bool)
+	pending           *pendingEpoch // epoch we should switch to as soon as its committee is known
+	retired           bool          // we are not a member of the committee anymore
 	log               zerolog.Logger
 }
 
+// unsignedUnit holds everything a unit is built from, so that exactly the same unit can be signed again.
+type unsignedUnit struct {
+	epoch   gomel.EpochID
+	parents []gomel.Unit
+	level   int
+	data    core.Data
+	rsData  []byte
+}
+
+// signRetryInterval is the time between attempts to sign a unit the signer failed to sign.
+const signRetryInterval = 100 * time.Millisecond
+
+// pendingEpoch is an epoch switch postponed until the committee of the epoch is known.
+type pendingEpoch struct {
+	epoch gomel.EpochID
+	data  core.Data
+}
+
 // New constructs a creator that uses provided config, data source and logger.
 // send function is called on each created unit.
 // rsData provides random source data for the given level, parents and epoch.
@@ -64,6 +99,10 @@ func NewForEpoch(
 	log zerolog.Logger,
 
 ) *Creator {
+	g, err := signing.OpenRecord(conf.LastUnitFile)
+	if err != nil {
+		log.Error().Str("where", "creator.NewForEpoch.OpenRecord").Msg(err.Error())
+	}
 	return &Creator{
 		conf:              conf,
 		ds:                dataSource,
@@ -75,10 +114,61 @@ func NewForEpoch(
 		frozen:            make(map[uint16]bool),
 		epochProofBuilder: epochProofBuilder,
 		epoch:             epoch,
+		guard:             g,
+		strategy:          NewParentStrategy(conf),
+		pacer:             newPacer(conf, dataSource),
+		dealingData:       core.Data{},
 		log:               log,
 	}
 }
 
+// Recover makes the creator continue its work in the epoch of the given dag, with units from that dag
+// (usually recovered from a unit store) as initial parent candidates. It has to be called before CreateUnits.
+// Our own units found in the dag prevent the creator from building units of the same heights again.
+func (cr *Creator) Recover(dag gomel.Dag) {
+	cr.epoch = dag.EpochID()
+	cr.recovered = nil
+	dag.MaximalUnitsPerProcess().Iterate(func(units []gomel.Unit) bool {
+		cr.recovered = append(cr.recovered, units...)
+		return true
+	})
+	if cr.epoch == gomel.EpochID(0) {
+		return
+	}
+	// all the dealing units of an epoch contain the same proof that the previous epoch has finished
+	dag.UnitsOnLevel(0).Iterate(func(units []gomel.Unit) bool {
+		for _, u := range units {
+			if gomel.Dealing(u) {
+				cr.dealingData = u.Data()
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// WithCommittees makes the creator ask the given function for the config of every epoch it enters,
+// allowing the committee (and our pid within it) to change between epochs. The function reports whether
+// the committee of the epoch is already known; a nil config means that we are not a member of that committee.
+// The creator does not switch to an epoch with an unknown committee, and stops producing units once it leaves the committee.
+// It has to be called before CreateUnits.
+func (cr *Creator) WithCommittees(committees func(gomel.EpochID) (config.Config, bool)) {
+	cr.committees = committees
+}
+
+// WithParentStrategy makes the creator choose the parents of its units with the given strategy
+// instead of the one chosen in the config. It has to be called before CreateUnits.
+func (cr *Creator) WithParentStrategy(strategy ParentStrategy) {
+	cr.strategy = strategy
+}
+
+// Watch makes the creator stop updating parent candidates of processes that the given alerter finds to be forkers.
+func (cr *Creator) Watch(alerter gomel.Alerter) utils.ObserverManager {
+	return alerter.AddForkObserver(func(u, _ gomel.Preunit) {
+		cr.freezeParent(u.Creator())
+	})
+}
+
 // CreateUnits executes the main loop of the creator. Units appearing on unitBelt are examined and stored to
 // be used as parents of future units. When there are enough new parents, a new unit is produced.
 // lastTiming is a channel on which the last timing unit of each epoch is expected to appear.
@@ -87,59 +177,122 @@ func (cr *Creator) CreateUnits(unitBelt, lastTiming <-chan gomel.Unit, alerter g
 	defer func() {
 		cr.log.Log().Msg(lg.CreatorFinished)
 	}()
-	om := alerter.AddForkObserver(func(u, _ gomel.Preunit) {
-		cr.freezeParent(u.Creator())
-	})
+	om := cr.Watch(alerter)
 	defer om.RemoveObserver()
-	cr.newEpoch(cr.epoch, core.Data{})
-
-	for u := range unitBelt {
-		cr.mx.Lock()
-		// Step 1: update candidates with all units waiting on the unit belt
-		cr.update(u)
-		n := len(unitBelt)
-		for i := 0; i < n; i++ {
-			cr.update(<-unitBelt)
-		}
-		for cr.ready() {
-			// Step 2: get parents and level using current strategy
-			parents, level := cr.buildParents()
-			// Step 3: create unit
-			cr.createUnit(parents, level, cr.getData(level, lastTiming))
+	cr.newEpoch(cr.epoch, cr.dealingData)
+
+	// retry signals when the creator should try building a unit again, nil if it waits for new units only
+	var retry <-chan time.Time
+	if cr.unsigned != nil {
+		retry = cr.conf.Clock.After(signRetryInterval)
+	}
+	defer func() {
+		cr.conf.Clock.Stop(retry)
+	}()
+	for {
+		select {
+		case u, ok := <-unitBelt:
+			if !ok {
+				return
+			}
+			cr.mx.Lock()
+			// Step 1: update candidates with all units waiting on the unit belt
+			cr.update(u)
+			n := len(unitBelt)
+			for i := 0; i < n; i++ {
+				cr.update(<-unitBelt)
+			}
+			if cr.pending != nil {
+				cr.newEpoch(cr.pending.epoch, cr.pending.data)
+			}
+			next := cr.createReady(lastTiming)
+			cr.conf.Clock.Stop(retry)
+			retry = next
+			cr.mx.Unlock()
+			cr.conf.Activity.Done(gomel.Adding, n+1)
+		case <-retry:
+			cr.mx.Lock()
+			retry = cr.createReady(lastTiming)
+			cr.mx.Unlock()
+			if cr.conf.Clock != nil {
+				// a driver running its own clock registered the timer as work
+				cr.conf.Activity.Done(gomel.Adding, 1)
+			}
 		}
-		cr.mx.Unlock()
 	}
 }
 
-func (cr *Creator) buildParents() (parents []gomel.Unit, level int) {
-	if cr.conf.CanSkipLevel {
-		level = cr.level
-		parents = cr.getParents()
-	} else {
-		level = cr.candidates[cr.conf.Pid].Level() + 1
-		parents = cr.getParentsForLevel(level)
+// createReady creates units as long as the creator is ready and neither the parent strategy nor the pacing of units wants to wait.
+// Returns a channel signalling when the creator should try again, nil if it waits for new units only.
+// This method must be called under mutex!
+func (cr *Creator) createReady(lastTiming <-chan gomel.Unit) <-chan time.Time {
+	if cr.unsigned != nil && cr.unsigned.epoch < cr.epoch {
+		// a unit of a past epoch is of no use anymore, and nothing in the current epoch can be its fork
+		cr.unsigned = nil
+	}
+	if cr.unsigned != nil && !cr.sign(cr.unsigned) {
+		return cr.conf.Clock.After(signRetryInterval)
+	}
+	for cr.ready() {
+		// Step 2: get parents and level using current strategy
+		parents, level, retry := cr.strategy.Parents(&Candidates{
+			Units:  cr.candidates,
+			Pid:    cr.conf.Pid,
+			Epoch:  cr.epoch,
+			Level:  cr.level,
+			Quorum: cr.quorum,
+		})
+		if parents == nil {
+			if retry.IsZero() {
+				return nil
+			}
+			return cr.conf.Clock.After(cr.conf.Clock.Until(retry))
+		}
+		// Step 3: wait if it is too early for a unit carrying data
+		carriesData := level <= cr.conf.LastLevel
+		if carriesData {
+			if next := cr.pacer.next(cr.conf.Clock.Now()); !next.IsZero() {
+				return cr.conf.Clock.After(cr.conf.Clock.Until(next))
+			}
+		}
+		// Step 4: create unit
+		if !cr.createUnit(parents, level, cr.getData(level, lastTiming)) {
+			break
+		}
+		if carriesData {
+			cr.pacer.built(cr.conf.Clock.Now())
+		}
+	}
+	if cr.unsigned != nil {
+		return cr.conf.Clock.After(signRetryInterval)
 	}
-	return
+	return nil
 }
 
 // ready checks if the creator is ready to produce a new unit. Usually that means:
 // "do we have enough new candidates to produce a unit with level higher than the previous one?"
-// Besides that, we stop producing units for the current epoch after creating a unit with signature share.
+// Besides that, we stop producing units for the current epoch after creating a unit with signature share,
+// and we wait until our own candidate reaches the last unit we have signed (in case of a restart).
 func (cr *Creator) ready() bool {
-	return !cr.epochDone && cr.level > cr.candidates[cr.conf.Pid].Level()
+	own := cr.candidates[cr.conf.Pid]
+	return !cr.epochDone && own != nil && cr.level > own.Level() && cr.guard.Reached(own.EpochID(), own.Height()) && cr.guard.Allows(cr.epoch, own.Height()+1)
 }
 
 // getData produces a piece of data to be included in a unit on a given level.
-// For regular units the provided DataSource is used
+// For regular units the provided DataSource is used, see unitData, except for the first one of every epoch
+// if we propose a committee change: that one carries the proposal.
 // For finishing units it's either nil or, if available, an encoded threshold signature share
 // of hash and id of the last timing unit (obtained from preblockMaker on lastTiming channel)
 func (cr *Creator) getData(level int, lastTiming <-chan gomel.Unit) core.Data {
 	if level <= cr.conf.LastLevel {
-		if cr.ds != nil {
-			return cr.ds.GetData()
+		if cr.conf.Proposal != nil && !cr.proposed {
+			cr.proposed = true
+			return cr.conf.Proposal.Encode()
 		}
-		return core.Data{}
+		return cr.unitData()
 	}
+	// when the process is driven step by step, the timing unit has to be decided before we look for it
+	cr.conf.Activity.Wait(gomel.Ordering)
 	for {
 		// in a rare case there can be timing units from previous epochs left on lastTiming channel.
 		// the purpose of this loop is to drain and ignore them.
@@ -150,7 +303,7 @@ func (cr *Creator) getData(level int, lastTiming <-chan gomel.Unit) core.Data {
 			}
 			if timingUnit.EpochID() == cr.epoch {
 				cr.epochDone = true
-				if int(cr.epoch) == cr.conf.NumberOfEpochs-1 {
+				if config.IsLastEpoch(cr.conf, cr.epoch) {
 					// the epoch we just finished is the last epoch we were supposed to produce
 					return core.Data{}
 				}
@@ -169,10 +322,16 @@ func (cr *Creator) update(u gomel.Unit) {
 	cr.log.Debug().Uint16(lg.Creator, u.Creator()).Uint32(lg.Epoch, uint32(u.EpochID())).Int(lg.Height, u.Height()).Int(lg.Level, u.Level()).Uint16(lg.Size, cr.onMaxLvl).Msg(lg.CreatorProcessingUnit)
 
 	// if the unit is from an older epoch or unit's creator is known to be a forker, we simply ignore it
-	if cr.frozen[u.Creator()] || u.EpochID() < cr.epoch {
+	if cr.retired || cr.frozen[u.Creator()] || u.EpochID() < cr.epoch {
 		return
 	}
 
+	// all the dealing units of an epoch carry the same proof, so we can use it for our own dealing unit
+	if cr.awaitingProof && u.EpochID() == cr.epoch && gomel.Dealing(u) {
+		cr.awaitingProof = false
+		cr.createUnit(make([]gomel.Unit, cr.conf.NProc), 0, u.Data())
+	}
+
 	// If the unit is from a new epoch, switch to that epoch.
 	// Since units appear on the belt in order they were added to the dag,
 	// the first unit from a new epoch is always a dealing unit.
@@ -194,7 +353,23 @@ func (cr *Creator) update(u gomel.Unit) {
 		return
 	}
 
+	cr.strategy.Update(u)
 	cr.updateCandidates(u)
+	cr.catchUp(u)
+}
+
+// catchUp looks for our own units below the given unit. It matters only after a restart, when
+// we have signed units that we no longer have. Such units are learned from other processes and used as
+// our candidates, until we reach the last signed one and can safely continue. If that unit never reached
+// anyone, we stay silent for the rest of the epoch, as building anything else at its height would be a fork.
+func (cr *Creator) catchUp(u gomel.Unit) {
+	own := cr.candidates[cr.conf.Pid]
+	if own != nil && cr.guard.Reached(own.EpochID(), own.Height()) {
+		return
+	}
+	for _, v := range u.Floor(cr.conf.Pid) {
+		cr.updateCandidates(v)
+	}
 }
 
 // updateCandidates puts the provided unit in parent candidates provided that
@@ -236,52 +411,115 @@ func (cr *Creator) resetEpoch() {
 func (cr *Creator) freezeParent(pid uint16) gomel.Unit {
 	cr.mx.Lock()
 	defer cr.mx.Unlock()
-	u := cr.candidates[cr.conf.Pid].Parents()[pid]
+	var u gomel.Unit
+	if own := cr.candidates[cr.conf.Pid]; own != nil {
+		u = own.Parents()[pid]
+	}
 	cr.candidates[pid] = u
 	cr.frozen[pid] = true
 	cr.log.Warn().Uint16(lg.Creator, pid).Msg(lg.FreezedParent)
 	return u
 }
 
-// getParents returns a copy of current parent candidates.
-func (cr *Creator) getParents() []gomel.Unit {
-	result := make([]gomel.Unit, cr.conf.NProc)
-	copy(result, cr.candidates)
-	MakeConsistent(result)
-	return result
+// createUnit creates a unit with the given parents, level, and data. Assumes provided parameters
+// are consistent, that means level == gomel.LevelFromParents(parents) and cr.epoch == parents[i].EpochID()
+// Returns false if the unit was not created, because it could be a fork of a unit signed earlier or the signer failed.
+func (cr *Creator) createUnit(parents []gomel.Unit, level int, data core.Data) bool {
+	if cr.unsigned != nil && cr.unsigned.epoch == cr.epoch {
+		// nothing else can be built before the unit waiting for the signer is signed
+		return false
+	}
+	height := 0
+	if own := parents[cr.conf.Pid]; own != nil {
+		height = own.Height() + 1
+	}
+	if !cr.guard.Allows(cr.epoch, height) {
+		cr.log.Warn().Uint32(lg.Epoch, uint32(cr.epoch)).Int(lg.Height, height).Msg(lg.RefusedToSign)
+		return false
+	}
+	rsData := cr.rsData(level, parents, cr.epoch)
+	return cr.sign(&unsignedUnit{cr.epoch, parents, level, data, rsData})
 }
 
-// getParentsForLevel returns a set of candidates such that their level is at most level-1.
-func (cr *Creator) getParentsForLevel(level int) []gomel.Unit {
-	result := make([]gomel.Unit, cr.conf.NProc)
-	for i, u := range cr.candidates {
-		for u != nil && u.Level() >= level {
-			u = gomel.Predecessor(u)
+// sign signs the given unit and sends it. Returns false if the unit was not created.
+// If the signer fails, the unit is kept and signed again, unchanged, before anything else is built:
+// the signer might have signed it before failing to respond, and then it refuses to sign anything else of the same height.
+func (cr *Creator) sign(uu *unsignedUnit) bool {
+	var u gomel.Unit
+	if cr.conf.Signer != nil {
+		var err error
+		u, err = unit.NewSigned(cr.conf.Pid, uu.epoch, uu.parents, uu.level, uu.data, uu.rsData, cr.conf.Signer)
+		if err != nil {
+			cr.log.Error().Str("where", "creator.sign").Msg(err.Error())
+			cr.unsigned = uu
+			return false
 		}
-		result[i] = u
+	} else {
+		u = unit.New(cr.conf.Pid, uu.epoch, uu.parents, uu.level, uu.data, uu.rsData, cr.conf.PrivateKey)
 	}
-	MakeConsistent(result)
-	return result
-}
-
-// createUnit creates a unit with the given parents, level, and data. Assumes provided parameters
-// are consistent, that means level == gomel.LevelFromParents(parents) and cr.epoch == parents[i].EpochID()
-func (cr *Creator) createUnit(parents []gomel.Unit, level int, data core.Data) {
-	rsData := cr.rsData(level, parents, cr.epoch)
-	u := unit.New(cr.conf.Pid, cr.epoch, parents, level, data, rsData, cr.conf.PrivateKey)
-	cr.log.Info().Uint32(lg.Epoch, uint32(u.EpochID())).Int(lg.Height, u.Height()).Int(lg.Level, level).Msg(lg.UnitCreated)
+	cr.unsigned = nil
+	if err := cr.guard.Store(u); err != nil {
+		cr.log.Error().Str("where", "creator.sign.record").Msg(err.Error())
+		return false
+	}
+	cr.log.Info().Uint32(lg.Epoch, uint32(u.EpochID())).Int(lg.Height, u.Height()).Int(lg.Level, uu.level).Msg(lg.UnitCreated)
+	metrics.UnitsCreated.Inc()
 	cr.send(u)
 	cr.update(u)
+	return true
 }
 
 // newEpoch switches the creator to a chosen epoch, resets candidates and shares and creates a dealing with the provided data.
+// Recovered units of that epoch are used as candidates, and the dealing is not created if we already have one.
+// Without data proving that the previous epoch has finished, the dealing waits for such data from another process.
 func (cr *Creator) newEpoch(epoch gomel.EpochID, data core.Data) {
+	if cr.committees != nil {
+		conf, known := cr.committees(epoch)
+		if !known {
+			cr.pending = &pendingEpoch{epoch, data}
+			return
+		}
+		cr.pending = nil
+		if conf == nil {
+			if !cr.retired {
+				cr.log.Log().Uint32(lg.Epoch, uint32(epoch)).Msg(lg.LeftCommittee)
+			}
+			cr.retired = true
+			cr.epochDone = true
+			return
+		}
+		cr.setCommittee(conf)
+	}
 	cr.epoch = epoch
 	cr.epochDone = false
 	cr.resetEpoch()
 	cr.epochProof = cr.epochProofBuilder(epoch)
 	cr.log.Log().Uint32(lg.Epoch, uint32(epoch)).Msg(lg.NewEpoch)
-	cr.createUnit(make([]gomel.Unit, cr.conf.NProc), 0, data)
+	for _, u := range cr.recovered {
+		cr.updateCandidates(u)
+	}
+	cr.recovered = nil
+	cr.awaitingProof = false
+	cr.proposed = false
+	if cr.candidates[cr.conf.Pid] == nil {
+		if epoch > 0 && len(data) == 0 {
+			cr.awaitingProof = true
+			return
+		}
+		cr.createUnit(make([]gomel.Unit, cr.conf.NProc), 0, data)
+	}
+}
+
+// setCommittee makes the creator work with the given config from now on. If the committee differs
+// from the current one, all the state depending on the committee is rebuilt.
+func (cr *Creator) setCommittee(conf config.Config) {
+	if conf == cr.conf {
+		return
+	}
+	cr.conf = conf
+	cr.candidates = make([]gomel.Unit, conf.NProc)
+	cr.quorum = gomel.MinimalQuorum(conf.NProc)
+	cr.frozen = make(map[uint16]bool)
 }
 
 // MakeConsistent ensures that the set of parents follows "parent consistency rule". Modifies the provided unit slice in place.
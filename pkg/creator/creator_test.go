@@ -1,6 +1,10 @@
 package creator_test
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 
 	. "github.com/onsi/ginkgo"
@@ -21,6 +25,35 @@ type privateKeyStub struct {
 func (privateKeyStub) Sign(*gomel.Hash) gomel.Signature { return gomel.ZeroHash[:] }
 func (privateKeyStub) Encode() string                   { return "" }
 
+// signerStub signs only dealing units.
+type signerStub struct {
+}
+
+func (signerStub) SignUnit(pu gomel.Preunit) (gomel.Signature, error) {
+	if pu.Height() > 0 {
+		return nil, errors.New("refused")
+	}
+	return []byte("signed"), nil
+}
+
+// flakySigner fails the first attempts to sign, like a remote signer timing out, recording the hashes of units it was asked to sign.
+type flakySigner struct {
+	mx       sync.Mutex
+	failures int
+	hashes   []gomel.Hash
+}
+
+func (fs *flakySigner) SignUnit(pu gomel.Preunit) (gomel.Signature, error) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	fs.hashes = append(fs.hashes, *pu.Hash())
+	if fs.failures > 0 {
+		fs.failures--
+		return nil, errors.New("timeout")
+	}
+	return []byte("signed"), nil
+}
+
 type testEpochProofBuilder struct {
 	verify func(gomel.Preunit) bool
 }
@@ -481,4 +514,147 @@ var _ = Describe("creator", func() {
 			Expect(len(unitRec)).To(Equal(0))
 		})
 	})
+
+	Describe("using a signer instead of a private key", func() {
+		It("should sign units with it and not send the ones it refused to sign", func() {
+			nProc := uint16(4)
+			cnf := config.Empty()
+			cnf.NProc = nProc
+			cnf.NumberOfEpochs = 2
+			cnf.Signer = signerStub{}
+
+			unitRec := make(chan gomel.Unit, 2)
+			send := func(u gomel.Unit) {
+				unitRec <- u
+			}
+
+			creator := newCreator(cnf, send)
+			alerter := gomel.NopAlerter()
+			unitBelt := make(chan gomel.Unit, 1)
+			lastTiming := make(chan gomel.Unit, 1)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				creator.CreateUnits(unitBelt, lastTiming, alerter)
+			}()
+
+			dag, _ := tests.NewTestDagFactoryWithEpochID(gomel.EpochID(0)).CreateDag(cnf.NProc)
+
+			parents := make([]gomel.Unit, nProc)
+			for pid := uint16(1); pid < cnf.NProc; pid++ {
+				pu := tests.NewPreunit(pid, gomel.EmptyCrown(cnf.NProc), make([]byte, 8), nil, privateKeyStub{})
+				unitBelt <- tests.FromPreunit(pu, parents, dag)
+			}
+
+			createdUnit := <-unitRec
+			Expect(createdUnit.Height()).To(Equal(0))
+			Expect(createdUnit.Signature()).To(Equal(gomel.Signature("signed")))
+
+			close(unitBelt)
+			wg.Wait()
+			Expect(len(unitRec)).To(Equal(0))
+		})
+	})
+
+	Describe("using a signer that fails", func() {
+		It("should sign the same unit again until it succeeds", func() {
+			nProc := uint16(4)
+			cnf := config.Empty()
+			cnf.NProc = nProc
+			cnf.NumberOfEpochs = 2
+			signer := &flakySigner{failures: 2}
+			cnf.Signer = signer
+
+			unitRec := make(chan gomel.Unit, 2)
+			send := func(u gomel.Unit) {
+				unitRec <- u
+			}
+			unitBelt := make(chan gomel.Unit)
+			lastTiming := make(chan gomel.Unit)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				newCreator(cnf, send).CreateUnits(unitBelt, lastTiming, gomel.NopAlerter())
+			}()
+
+			createdUnit := <-unitRec
+			Expect(createdUnit.Height()).To(Equal(0))
+			close(unitBelt)
+			wg.Wait()
+
+			signer.mx.Lock()
+			defer signer.mx.Unlock()
+			Expect(signer.hashes).To(HaveLen(3))
+			for _, h := range signer.hashes {
+				Expect(h).To(Equal(*createdUnit.Hash()))
+			}
+		})
+	})
+
+	Describe("restarted with the same file for the last signed unit", func() {
+		It("should not sign its dealing unit again and continue on top of the old one", func() {
+			dir, err := ioutil.TempDir("", "creator")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			nProc := uint16(4)
+			cnf := config.Empty()
+			cnf.NProc = nProc
+			cnf.NumberOfEpochs = 2
+			cnf.PrivateKey = privateKeyStub{}
+			cnf.LastUnitFile = filepath.Join(dir, "last")
+
+			unitRec := make(chan gomel.Unit, 2)
+			send := func(u gomel.Unit) {
+				unitRec <- u
+			}
+			alerter := gomel.NopAlerter()
+			lastTiming := make(chan gomel.Unit)
+
+			// the first run produces only the dealing unit
+			unitBelt := make(chan gomel.Unit)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				newCreator(cnf, send).CreateUnits(unitBelt, lastTiming, alerter)
+			}()
+			ownDealing := <-unitRec
+			Expect(ownDealing.Height()).To(Equal(0))
+			close(unitBelt)
+			wg.Wait()
+
+			// the second run learns about the old dealing unit only from a unit of another process
+			unitBelt = make(chan gomel.Unit)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				newCreator(cnf, send).CreateUnits(unitBelt, lastTiming, alerter)
+			}()
+
+			dag, _ := tests.NewTestDagFactoryWithEpochID(gomel.EpochID(0)).CreateDag(cnf.NProc)
+			parents := make([]gomel.Unit, nProc)
+			parents[0] = ownDealing
+			for pid := uint16(1); pid < nProc; pid++ {
+				pu := tests.NewPreunit(pid, gomel.EmptyCrown(nProc), make([]byte, 8), []byte{}, privateKeyStub{})
+				parents[pid] = tests.FromPreunit(pu, make([]gomel.Unit, nProc), dag)
+				unitBelt <- parents[pid]
+			}
+			pu := tests.NewPreunit(1, gomel.CrownFromParents(parents), make([]byte, 8), []byte{}, privateKeyStub{})
+			unitBelt <- tests.FromPreunit(pu, parents, dag)
+
+			createdUnit := <-unitRec
+			Expect(createdUnit.Creator()).To(Equal(uint16(0)))
+			Expect(createdUnit.Height()).To(Equal(1))
+			Expect(createdUnit.Parents()[0]).To(Equal(ownDealing))
+
+			close(unitBelt)
+			wg.Wait()
+			Expect(len(unitRec)).To(Equal(0))
+		})
+	})
 })
@@ -0,0 +1,93 @@
+package creator
+
+import (
+	"time"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+// dataPoll is how often the creator checks if enough data accumulated, when waiting for config.UnitMinData.
+const dataPoll = 10 * time.Millisecond
+
+// BatchingDataSource is a DataSource that knows how much data it has and can limit the size of the data it returns.
+// The creator waits for config.UnitMinData and respects config.UnitMaxData only with such a source. The data of other
+// sources cannot be split, so it is put in a unit whole, as long as it fits in config.MaxDataBytesPerUnit.
+type BatchingDataSource interface {
+	core.DataSource
+	// Pending returns the number of bytes of data waiting to be put in units.
+	Pending() int
+	// GetDataUpTo returns data of at most the given number of bytes for a unit of the given epoch.
+	GetDataUpTo(epoch gomel.EpochID, maxBytes int) core.Data
+}
+
+// pacer decides when the creator can build its next unit carrying data.
+// Dealing units and the finishing units of an epoch are never postponed.
+type pacer struct {
+	interval time.Duration
+	minData  int
+	wait     time.Duration
+	source   BatchingDataSource // nil if the data source cannot tell how much data it has
+	last     time.Time          // the creation of the last unit carrying data
+	since    time.Time          // the moment we started waiting for data, zero if we are not waiting
+}
+
+func newPacer(conf config.Config, ds core.DataSource) *pacer {
+	source, _ := ds.(BatchingDataSource)
+	return &pacer{
+		interval: conf.UnitInterval,
+		minData:  conf.UnitMinData,
+		wait:     conf.UnitDataWait,
+		source:   source,
+	}
+}
+
+// next returns the time until which building a unit should be postponed, a zero time if it can be built now.
+func (p *pacer) next(now time.Time) time.Time {
+	if earliest := p.last.Add(p.interval); now.Before(earliest) {
+		return earliest
+	}
+	if p.minData == 0 || p.source == nil {
+		return time.Time{}
+	}
+	if p.since.IsZero() {
+		p.since = now
+	}
+	deadline := p.since.Add(p.wait)
+	if p.source.Pending() >= p.minData || !now.Before(deadline) {
+		return time.Time{}
+	}
+	if poll := now.Add(dataPoll); poll.Before(deadline) {
+		return poll
+	}
+	return deadline
+}
+
+// built records that a unit carrying data has been built.
+func (p *pacer) built(now time.Time) {
+	p.last = now
+	p.since = time.Time{}
+}
+
+// unitData takes the data of a regular unit from the DataSource, respecting conf.UnitMaxData if the source is batching.
+// Data of other sources is dropped only if no unit could carry it.
+func (cr *Creator) unitData() core.Data {
+	if cr.ds == nil {
+		return core.Data{}
+	}
+	if cr.pacer.source != nil {
+		maxData := cr.conf.UnitMaxData
+		if maxData == 0 {
+			maxData = config.MaxDataBytesPerUnit
+		}
+		return cr.pacer.source.GetDataUpTo(cr.epoch, maxData)
+	}
+	data := cr.ds.GetData()
+	if len(data) > config.MaxDataBytesPerUnit {
+		cr.log.Error().Str("where", "creator.unitData").Int(lg.Size, len(data)).Msg(lg.DataTooBig)
+		return core.Data{}
+	}
+	return data
+}
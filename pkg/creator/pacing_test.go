@@ -0,0 +1,175 @@
+package creator_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/creator"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+// batchingStub pretends to have the given amount of pending data and returns as much of it as allowed.
+type batchingStub struct {
+	mx      sync.Mutex
+	pending int
+}
+
+func (bs *batchingStub) GetData() core.Data {
+	return bs.GetDataUpTo(0, config.MaxDataBytesPerUnit)
+}
+
+func (bs *batchingStub) Pending() int {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+	return bs.pending
+}
+
+func (bs *batchingStub) GetDataUpTo(_ gomel.EpochID, maxBytes int) core.Data {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+	size := bs.pending
+	if size > maxBytes {
+		size = maxBytes
+	}
+	bs.pending -= size
+	return make(core.Data, size)
+}
+
+func (bs *batchingStub) add(size int) {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+	bs.pending += size
+}
+
+// plainSource returns pieces of data of the given size, which cannot be split.
+type plainSource int
+
+func (ps plainSource) GetData() core.Data {
+	return make(core.Data, int(ps))
+}
+
+var _ = Describe("pacing", func() {
+	const nProc = uint16(4)
+	var (
+		cnf      config.Config
+		ds       *batchingStub
+		source   core.DataSource
+		unitRec  chan gomel.Unit
+		unitBelt chan gomel.Unit
+		wg       sync.WaitGroup
+		// levels[l][pid] is the unit of pid on level l, built on the units of other processes on the previous level
+		levels [][]gomel.Unit
+	)
+
+	BeforeEach(func() {
+		cnf = config.Empty()
+		cnf.NProc = nProc
+		cnf.NumberOfEpochs = 2
+		cnf.EpochLength = 10
+		cnf.LastLevel = 9
+		cnf.CanSkipLevel = true
+		cnf.PrivateKey = privateKeyStub{}
+		ds = &batchingStub{}
+		source = ds
+		unitRec = make(chan gomel.Unit, 10)
+		unitBelt = make(chan gomel.Unit, 10)
+
+		dag, _ := tests.NewTestDagFactory().CreateDag(nProc)
+		levels = nil
+		parents := make([]gomel.Unit, nProc)
+		for level := 0; level < 2; level++ {
+			units := make([]gomel.Unit, nProc)
+			for pid := uint16(1); pid < nProc; pid++ {
+				pu := tests.NewPreunit(pid, gomel.CrownFromParents(parents), make([]byte, 8), nil, privateKeyStub{})
+				units[pid] = tests.FromPreunit(pu, parents, dag)
+			}
+			levels = append(levels, units)
+			parents = units
+		}
+	})
+
+	start := func() {
+		epochProofBuilder := func(gomel.EpochID) creator.EpochProofBuilder { return newTestEpochProofBuilder() }
+		rsData := func(int, []gomel.Unit, gomel.EpochID) []byte { return nil }
+		cr := creator.New(cnf, source, func(u gomel.Unit) { unitRec <- u }, rsData, epochProofBuilder, zerolog.Logger{}.Level(zerolog.Disabled))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cr.CreateUnits(unitBelt, make(chan gomel.Unit), gomel.NopAlerter())
+		}()
+		Expect((<-unitRec).Level()).To(Equal(0))
+	}
+
+	feed := func(level int) {
+		for _, u := range levels[level][1:] {
+			unitBelt <- u
+		}
+	}
+
+	AfterEach(func() {
+		close(unitBelt)
+		wg.Wait()
+	})
+
+	It("should keep the minimal interval between units carrying data", func() {
+		cnf.UnitInterval = 100 * time.Millisecond
+		start()
+		feed(0)
+		var created gomel.Unit
+		Eventually(unitRec, time.Second).Should(Receive(&created))
+		Expect(created.Level()).To(Equal(1))
+		built := time.Now()
+
+		feed(1)
+		Eventually(unitRec, time.Second).Should(Receive(&created))
+		Expect(created.Level()).To(Equal(2))
+		Expect(time.Since(built)).To(BeNumerically(">=", 80*time.Millisecond))
+	})
+
+	It("should wait for enough data and take no more than allowed", func() {
+		cnf.UnitMinData = 100
+		cnf.UnitMaxData = 150
+		cnf.UnitDataWait = time.Minute
+		ds.add(50)
+		start()
+		feed(0)
+		Consistently(unitRec, 50*time.Millisecond).ShouldNot(Receive())
+
+		ds.add(150)
+		var created gomel.Unit
+		Eventually(unitRec, time.Second).Should(Receive(&created))
+		Expect(created.Level()).To(Equal(1))
+		Expect(created.Data()).To(HaveLen(150))
+		Expect(ds.Pending()).To(Equal(50))
+	})
+
+	It("should build a unit with less data after the deadline", func() {
+		cnf.UnitMinData = 100
+		cnf.UnitDataWait = 50 * time.Millisecond
+		ds.add(10)
+		start()
+		feed(0)
+		var created gomel.Unit
+		Eventually(unitRec, time.Second).Should(Receive(&created))
+		Expect(created.Level()).To(Equal(1))
+		Expect(created.Data()).To(HaveLen(10))
+	})
+
+	It("should put the data of a source that cannot split it in a unit whole", func() {
+		cnf.UnitMaxData = 150
+		source = plainSource(200)
+		start()
+		feed(0)
+		var created gomel.Unit
+		Eventually(unitRec, time.Second).Should(Receive(&created))
+		Expect(created.Level()).To(Equal(1))
+		Expect(created.Data()).To(HaveLen(200))
+	})
+})
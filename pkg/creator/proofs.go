@@ -2,6 +2,7 @@ package creator
 
 import (
 	"encoding/binary"
+	"errors"
 
 	"github.com/rs/zerolog"
 	"gitlab.com/alephledger/consensus-go/pkg/config"
@@ -66,6 +67,9 @@ func encodeShare(share *tss.Share, msg []byte) core.Data {
 
 // decodeShare reads signature share and the signed message from Data contained in some unit.
 func decodeShare(data core.Data) (*tss.Share, []byte, error) {
+	if len(data) < proofLength {
+		return nil, nil, errors.New("share data too short")
+	}
 	result := new(tss.Share)
 	err := result.Unmarshal(data[proofLength:])
 	if err != nil {
@@ -85,6 +89,9 @@ func encodeSignature(sig *tss.Signature, msg []byte) core.Data {
 
 // decodeSignature reads signature and the signed message from Data contained in some unit.
 func decodeSignature(data core.Data) (*tss.Signature, []byte, error) {
+	if len(data) < proofLength {
+		return nil, nil, errors.New("signature data too short")
+	}
 	result := new(tss.Signature)
 	err := result.Unmarshal(data[proofLength:])
 	if err != nil {
@@ -186,8 +193,12 @@ func (epi *epochProofImpl) TryBuilding(u gomel.Unit) core.Data {
 		epi.log.Error().Str("where", "creator.decodeShare").Msg(err.Error())
 		return nil
 	}
+	// only shares signing the end of our epoch can prove that the next one started
+	if _, _, epoch, _ := decodeProof(msg); epoch != epi.epoch {
+		return nil
+	}
 	if !epi.conf.WTKey.VerifyShare(share, msg) {
-		epi.log.Error().Str("where", "creator.verifyShare").Msg(err.Error())
+		epi.log.Error().Str("where", "creator.verifyShare").Msg("invalid share")
 		return nil
 	}
 	sig := epi.shares.Add(share, msg)
@@ -0,0 +1,237 @@
+package creator
+
+import (
+	"time"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// Candidates describe the units the creator can use as parents of its next unit.
+type Candidates struct {
+	Units  []gomel.Unit // the highest known unit of every process in the epoch, nil if there is none; must not be modified
+	Pid    uint16       // the process building the unit
+	Epoch  gomel.EpochID
+	Level  int    // the highest level of a unit that can be built on top of Units
+	Quorum uint16 // the number of units on a level needed to build a unit on the next one
+}
+
+// Highest returns a consistent copy of the candidates, for a unit on Level.
+func (c *Candidates) Highest() []gomel.Unit {
+	result := make([]gomel.Unit, len(c.Units))
+	copy(result, c.Units)
+	MakeConsistent(result)
+	return result
+}
+
+// Below returns consistent candidates with levels at most level-1, for a unit on the given level.
+func (c *Candidates) Below(level int) []gomel.Unit {
+	result := make([]gomel.Unit, len(c.Units))
+	for i, u := range c.Units {
+		for u != nil && u.Level() >= level {
+			u = gomel.Predecessor(u)
+		}
+		result[i] = u
+	}
+	MakeConsistent(result)
+	return result
+}
+
+// Own returns the last unit of the process building the next one.
+func (c *Candidates) Own() gomel.Unit {
+	return c.Units[c.Pid]
+}
+
+// ParentStrategy decides which candidates become the parents of the next unit of the creator, and when.
+// It is called under the mutex of the creator, only when a unit above our own last one can be built.
+// The returned parents have to be consistent, see MakeConsistent, and have to give the returned level.
+type ParentStrategy interface {
+	// Update is called for every unit of the current epoch the creator receives.
+	Update(gomel.Unit)
+	// Parents returns the parents and the level of the next unit. To wait for more candidates it returns nil parents
+	// and the time at which it should be asked again, a zero time meaning that it waits for new units only.
+	Parents(*Candidates) (parents []gomel.Unit, level int, retry time.Time)
+}
+
+// NewParentStrategy returns the strategy chosen in the config.
+func NewParentStrategy(conf config.Config) ParentStrategy {
+	switch conf.ParentStrategy {
+	case config.MaxLevelParents:
+		return MaxLevel()
+	case config.FixedLevelParents:
+		return FixedLevel()
+	case config.WaitParents:
+		return WaitForMore(conf.ParentExtra, conf.ParentWait, conf.Clock)
+	case config.LatencyParents:
+		return LatencyAware(conf.ParentWait, conf.Clock)
+	}
+	if conf.CanSkipLevel {
+		return MaxLevel()
+	}
+	return FixedLevel()
+}
+
+type maxLevel struct{}
+
+// MaxLevel returns a strategy building a unit on the highest possible level as soon as it can.
+// It skips levels when the creator falls behind.
+func MaxLevel() ParentStrategy {
+	return maxLevel{}
+}
+
+func (maxLevel) Update(gomel.Unit) {}
+
+func (maxLevel) Parents(c *Candidates) ([]gomel.Unit, int, time.Time) {
+	return c.Highest(), c.Level, time.Time{}
+}
+
+type fixedLevel struct{}
+
+// FixedLevel returns a strategy building a unit on every consecutive level, as required by the setup.
+func FixedLevel() ParentStrategy {
+	return fixedLevel{}
+}
+
+func (fixedLevel) Update(gomel.Unit) {}
+
+func (fixedLevel) Parents(c *Candidates) ([]gomel.Unit, int, time.Time) {
+	level := c.Own().Level() + 1
+	return c.Below(level), level, time.Time{}
+}
+
+// waiting remembers since when the creator could build a unit above its own last one.
+type waiting struct {
+	own   gomel.Unit
+	since time.Time
+}
+
+// start returns the time at which the creator became able to build a unit above its own last one.
+func (w *waiting) start(c *Candidates, now time.Time) time.Time {
+	if own := c.Own(); own != w.own {
+		w.own = own
+		w.since = now
+	}
+	return w.since
+}
+
+// atLeast counts the candidates on the given level or above it.
+func atLeast(c *Candidates, level int) int {
+	count := 0
+	for _, u := range c.Units {
+		if u != nil && u.Level() >= level {
+			count++
+		}
+	}
+	return count
+}
+
+type waitForMore struct {
+	waiting
+	extra   int
+	timeout time.Duration
+	clock   *gomel.Clock
+}
+
+// WaitForMore returns a strategy building on the highest possible level, but only once the level below it
+// has extra candidates above a quorum, or timeout after a unit could have been built.
+// More parents make units spread faster, at the cost of latency. The time is told by the given clock.
+func WaitForMore(extra int, timeout time.Duration, clock *gomel.Clock) ParentStrategy {
+	return &waitForMore{extra: extra, timeout: timeout, clock: clock}
+}
+
+func (s *waitForMore) Update(gomel.Unit) {}
+
+func (s *waitForMore) Parents(c *Candidates) ([]gomel.Unit, int, time.Time) {
+	now := s.clock.Now()
+	deadline := s.start(c, now).Add(s.timeout)
+	need := int(c.Quorum) + s.extra
+	if need > len(c.Units) {
+		need = len(c.Units)
+	}
+	if atLeast(c, c.Level-1) < need && now.Before(deadline) {
+		return nil, 0, deadline
+	}
+	return c.Highest(), c.Level, time.Time{}
+}
+
+// position is a level of an epoch.
+type position struct {
+	epoch gomel.EpochID
+	level int
+}
+
+// keepLevels is the number of recent levels for which LatencyAware remembers the arrival of the first unit.
+const keepLevels = 8
+
+type latencyAware struct {
+	waiting
+	maxWait time.Duration
+	clock   *gomel.Clock
+	first   map[position]time.Time   // the arrival of the first unit of every recent level
+	lag     map[uint16]time.Duration // for every process, the average delay of its units behind the first one of their level
+}
+
+// LatencyAware returns a strategy building on the highest possible level, but waiting a while for parents from processes
+// that are usually fast. It measures how much the units of every process lag behind the first unit of their level,
+// and waits for a missing parent until twice its usual lag has passed, at most maxWait after a unit could have been built.
+// Processes lagging by more than maxWait are never waited for. The time is told by the given clock.
+func LatencyAware(maxWait time.Duration, clock *gomel.Clock) ParentStrategy {
+	return &latencyAware{
+		maxWait: maxWait,
+		clock:   clock,
+		first:   make(map[position]time.Time),
+		lag:     make(map[uint16]time.Duration),
+	}
+}
+
+func (s *latencyAware) Update(u gomel.Unit) {
+	now := s.clock.Now()
+	pos := position{u.EpochID(), u.Level()}
+	first, ok := s.first[pos]
+	if !ok {
+		s.first[pos] = now
+		for p := range s.first {
+			if p.epoch < pos.epoch || p.level+keepLevels < pos.level {
+				delete(s.first, p)
+			}
+		}
+		first = now
+	}
+	delay := now.Sub(first)
+	if lag, known := s.lag[u.Creator()]; known {
+		// exponential moving average, with the weight of 1/4 for the new sample
+		s.lag[u.Creator()] = lag + (delay-lag)/4
+	} else {
+		s.lag[u.Creator()] = delay
+	}
+}
+
+func (s *latencyAware) Parents(c *Candidates) ([]gomel.Unit, int, time.Time) {
+	now := s.clock.Now()
+	deadline := s.start(c, now).Add(s.maxWait)
+	first, ok := s.first[position{c.Epoch, c.Level - 1}]
+	if !ok || !now.Before(deadline) {
+		return c.Highest(), c.Level, time.Time{}
+	}
+	var retry time.Time
+	for pid, u := range c.Units {
+		if uint16(pid) == c.Pid || (u != nil && u.Level() >= c.Level-1) {
+			continue
+		}
+		lag, known := s.lag[uint16(pid)]
+		if !known || lag > s.maxWait {
+			continue
+		}
+		expected := first.Add(2 * lag)
+		if expected.After(now) && (retry.IsZero() || expected.Before(retry)) {
+			retry = expected
+		}
+	}
+	if retry.IsZero() {
+		return c.Highest(), c.Level, time.Time{}
+	}
+	if retry.After(deadline) {
+		retry = deadline
+	}
+	return nil, 0, retry
+}
@@ -0,0 +1,181 @@
+package creator_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/creator"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+)
+
+// virtualClock is a clock that moves only when told to.
+type virtualClock struct {
+	now time.Time
+}
+
+func (vc *virtualClock) clock() *gomel.Clock {
+	return gomel.NewClock(func() time.Time { return vc.now }, time.After)
+}
+
+var _ = Describe("parent strategies", func() {
+	const nProc = uint16(4)
+	var (
+		// levels[l][pid] is the unit of pid on level l, every one of them built on all the units of the previous level
+		levels [][]gomel.Unit
+		vc     *virtualClock
+	)
+
+	BeforeEach(func() {
+		vc = &virtualClock{now: time.Unix(1000, 0)}
+		dag, _ := tests.NewTestDagFactory().CreateDag(nProc)
+		levels = nil
+		parents := make([]gomel.Unit, nProc)
+		for level := 0; level < 2; level++ {
+			units := make([]gomel.Unit, nProc)
+			for pid := uint16(0); pid < nProc; pid++ {
+				pu := tests.NewPreunit(pid, gomel.CrownFromParents(parents), make([]byte, 8), nil, privateKeyStub{})
+				units[pid] = tests.FromPreunit(pu, parents, dag)
+			}
+			levels = append(levels, units)
+			parents = units
+		}
+	})
+
+	// candidates returns candidates with the given units, the last one replaced with its predecessor when missing is true.
+	candidates := func(units []gomel.Unit, missing bool) *creator.Candidates {
+		result := make([]gomel.Unit, nProc)
+		copy(result, units)
+		if missing {
+			result[nProc-1] = gomel.Predecessor(result[nProc-1])
+		}
+		return &creator.Candidates{Units: result, Pid: 0, Level: 2, Quorum: gomel.MinimalQuorum(nProc)}
+	}
+
+	Describe("MaxLevel", func() {
+		It("should build right away on all the candidates", func() {
+			parents, level, retry := creator.MaxLevel().Parents(candidates(levels[1], true))
+			Expect(level).To(Equal(2))
+			Expect(retry.IsZero()).To(BeTrue())
+			Expect(parents).To(Equal([]gomel.Unit{levels[1][0], levels[1][1], levels[1][2], levels[0][3]}))
+		})
+	})
+
+	Describe("FixedLevel", func() {
+		It("should build on the level right above the own unit, using candidates below it", func() {
+			cands := candidates(levels[1], false)
+			cands.Units[0] = levels[0][0]
+			parents, level, retry := creator.FixedLevel().Parents(cands)
+			Expect(level).To(Equal(1))
+			Expect(retry.IsZero()).To(BeTrue())
+			Expect(parents).To(Equal(levels[0]))
+		})
+	})
+
+	Describe("WaitForMore", func() {
+		It("should wait for the extra candidates", func() {
+			strategy := creator.WaitForMore(1, time.Minute, vc.clock())
+			parents, _, retry := strategy.Parents(candidates(levels[1], true))
+			Expect(parents).To(BeNil())
+			Expect(retry).To(Equal(vc.now.Add(time.Minute)))
+
+			parents, level, retry := strategy.Parents(candidates(levels[1], false))
+			Expect(level).To(Equal(2))
+			Expect(retry.IsZero()).To(BeTrue())
+			Expect(parents).To(Equal(levels[1]))
+		})
+
+		It("should build without them after the timeout", func() {
+			strategy := creator.WaitForMore(1, 20*time.Millisecond, vc.clock())
+			parents, _, retry := strategy.Parents(candidates(levels[1], true))
+			Expect(parents).To(BeNil())
+			vc.now = retry
+
+			parents, level, _ := strategy.Parents(candidates(levels[1], true))
+			Expect(level).To(Equal(2))
+			Expect(parents).To(HaveLen(int(nProc)))
+		})
+	})
+
+	Describe("LatencyAware", func() {
+		// update feeds the strategy with the units of the first level, the last process lagging by the given delay,
+		// and then with the units of the second level without the last one.
+		update := func(strategy creator.ParentStrategy, delay time.Duration) {
+			for _, u := range levels[0][:nProc-1] {
+				strategy.Update(u)
+			}
+			vc.now = vc.now.Add(delay)
+			strategy.Update(levels[0][nProc-1])
+			for _, u := range levels[1][:nProc-1] {
+				strategy.Update(u)
+			}
+		}
+
+		It("should wait for a missing process that is usually fast", func() {
+			strategy := creator.LatencyAware(time.Minute, vc.clock())
+			update(strategy, 10*time.Millisecond)
+			parents, _, retry := strategy.Parents(candidates(levels[1], true))
+			Expect(parents).To(BeNil())
+			Expect(retry).To(BeTemporally(">", vc.now))
+			Expect(retry).To(BeTemporally("<", vc.now.Add(time.Second)))
+
+			vc.now = retry
+			parents, level, _ := strategy.Parents(candidates(levels[1], true))
+			Expect(level).To(Equal(2))
+			Expect(parents).To(HaveLen(int(nProc)))
+		})
+
+		It("should not wait for a process lagging by more than the maximal wait", func() {
+			strategy := creator.LatencyAware(10*time.Millisecond, vc.clock())
+			update(strategy, 30*time.Millisecond)
+			parents, level, retry := strategy.Parents(candidates(levels[1], true))
+			Expect(level).To(Equal(2))
+			Expect(retry.IsZero()).To(BeTrue())
+			Expect(parents).To(HaveLen(int(nProc)))
+		})
+
+		It("should not wait for a process it knows nothing about", func() {
+			strategy := creator.LatencyAware(time.Minute, vc.clock())
+			for _, u := range levels[1][:nProc-1] {
+				strategy.Update(u)
+			}
+			parents, _, _ := strategy.Parents(candidates(levels[1], true))
+			Expect(parents).NotTo(BeNil())
+		})
+	})
+
+	Describe("used by the creator", func() {
+		It("should make it build a unit once the strategy stops waiting", func() {
+			cnf := config.Empty()
+			cnf.NProc = nProc
+			cnf.NumberOfEpochs = 2
+			cnf.PrivateKey = privateKeyStub{}
+			unitRec := make(chan gomel.Unit, 2)
+			cr := newCreator(cnf, func(u gomel.Unit) { unitRec <- u })
+			cr.WithParentStrategy(creator.WaitForMore(1, 50*time.Millisecond, nil))
+
+			unitBelt := make(chan gomel.Unit, 2)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cr.CreateUnits(unitBelt, make(chan gomel.Unit), gomel.NopAlerter())
+			}()
+			Expect((<-unitRec).Level()).To(Equal(0))
+			// together with our own dealing unit it makes a quorum, but not the extra unit the strategy waits for
+			unitBelt <- levels[0][1]
+			unitBelt <- levels[0][2]
+			Consistently(unitRec, 30*time.Millisecond).ShouldNot(Receive())
+
+			var created gomel.Unit
+			Eventually(unitRec, time.Second).Should(Receive(&created))
+			Expect(created.Level()).To(Equal(1))
+			close(unitBelt)
+			wg.Wait()
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package signing
+
+import (
+	"crypto/rand"
+	"io"
+	"math/big"
+
+	cf "github.com/cloudflare/bn256"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+)
+
+// blsScheme signs units with BLS signatures on the bn256 curve.
+// Its signatures are longer and slower to check than ed25519 ones, but they can be aggregated.
+type blsScheme struct{}
+
+// batchBound limits the random coefficients of batch verification, a forged signature passes it with probability 2^-128.
+var batchBound = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// blsPublicKey implements PublicKey interface using a bn256 verification key.
+type blsPublicKey struct {
+	key *bn256.VerificationKey
+}
+
+// blsPrivateKey implements PrivateKey interface using a bn256 secret key.
+type blsPrivateKey struct {
+	key *bn256.SecretKey
+}
+
+// Verify checks if a given preunit has the correct signature using the public key from the receiver.
+func (pub *blsPublicKey) Verify(pu gomel.Preunit) bool {
+	sig, err := new(bn256.Signature).Unmarshal(pu.Signature())
+	if err != nil {
+		return false
+	}
+	return pub.key.Verify(sig, pu.Hash()[:])
+}
+
+// VerifyBatch checks the signatures of many preunits created by the owner of the key with a single pairing product.
+// For random coefficients r_i it checks that e(sum r_i*sig_i, g) = e(sum r_i*H(m_i), pub), which holds if all the signatures
+// are correct and fails otherwise with overwhelming probability. Signatures of a batch that fails are checked one by one.
+func (pub *blsPublicKey) VerifyBatch(pus []gomel.Preunit) []bool {
+	result := make([]bool, len(pus))
+	if len(pus) > 0 && pub.verifyAll(pus) {
+		for i := range result {
+			result[i] = true
+		}
+		return result
+	}
+	for i, pu := range pus {
+		result[i] = pub.Verify(pu)
+	}
+	return result
+}
+
+// verifyAll reports if the pairing product of the signatures of the given preunits equals one.
+func (pub *blsPublicKey) verifyAll(pus []gomel.Preunit) bool {
+	key := new(cf.G2)
+	if _, err := key.Unmarshal(pub.key.Marshal()); err != nil {
+		return false
+	}
+	var sigs, hashes *cf.G1
+	for _, pu := range pus {
+		sig := new(cf.G1)
+		if _, err := sig.Unmarshal(pu.Signature()); err != nil {
+			return false
+		}
+		r, err := rand.Int(rand.Reader, batchBound)
+		if err != nil {
+			return false
+		}
+		sig.ScalarMult(sig, r)
+		h := cf.HashG1(pu.Hash()[:], nil)
+		h.ScalarMult(h, r)
+		if sigs == nil {
+			sigs, hashes = sig, h
+			continue
+		}
+		sigs.Add(sigs, sig)
+		hashes.Add(hashes, h)
+	}
+	generator := new(cf.G2).ScalarBaseMult(big.NewInt(1))
+	return cf.PairingCheck([]*cf.G1{sigs, hashes.Neg(hashes)}, []*cf.G2{generator, key})
+}
+
+func (pub *blsPublicKey) Encode() string {
+	return tag(blsScheme{}.Name(), pub.key.Encode())
+}
+
+// Sign takes the hash of a given preunit and returns its signature produced using the private key from the receiver.
+func (priv *blsPrivateKey) Sign(h *gomel.Hash) gomel.Signature {
+	return priv.key.Sign(h[:]).Marshal()
+}
+
+func (priv *blsPrivateKey) Encode() string {
+	return tag(blsScheme{}.Name(), priv.key.Encode())
+}
+
+func (blsScheme) Name() string {
+	return "bls"
+}
+
+func (blsScheme) GenerateKeys(rand io.Reader) (gomel.PublicKey, gomel.PrivateKey, error) {
+	if rand == nil {
+		verKey, secKey, err := bn256.GenerateKeys()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &blsPublicKey{verKey}, &blsPrivateKey{secKey}, nil
+	}
+	// 31 bytes keep the secret below the order of the curve
+	buf := make([]byte, 31)
+	if _, err := io.ReadFull(rand, buf); err != nil {
+		return nil, nil, err
+	}
+	secret := new(big.Int).SetBytes(buf)
+	return &blsPublicKey{bn256.NewVerificationKey(secret)}, &blsPrivateKey{bn256.NewSecretKey(secret)}, nil
+}
+
+func (blsScheme) DecodePublicKey(enc string) (gomel.PublicKey, error) {
+	key, err := bn256.DecodeVerificationKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	return &blsPublicKey{key}, nil
+}
+
+func (blsScheme) DecodePrivateKey(enc string) (gomel.PrivateKey, error) {
+	key, err := bn256.DecodeSecretKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	return &blsPrivateKey{key}, nil
+}
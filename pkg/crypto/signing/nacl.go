@@ -1,9 +1,13 @@
-// Package signing implements unit signing using the NaCl library.
+// Package signing implements the signature schemes used for signing units.
+//
+// Schemes are kept in a registry and chosen by the keys in the committee file: an encoded key is tagged
+// with the name of its scheme, as in "bls:<key>", and untagged keys belong to the default ed25519 scheme.
 package signing
 
 import (
 	"encoding/base64"
 	"errors"
+	"io"
 
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	"golang.org/x/crypto/nacl/sign"
@@ -43,9 +47,15 @@ func (priv *privateKey) Encode() string {
 	return base64.StdEncoding.EncodeToString(priv.data[:])
 }
 
-// GenerateKeys produces a public and private key pair for signing units.
-func GenerateKeys() (gomel.PublicKey, gomel.PrivateKey, error) {
-	pubData, privData, err := sign.GenerateKey(nil)
+// ed25519Scheme signs units with ed25519 using the NaCl library. It is the default scheme.
+type ed25519Scheme struct{}
+
+func (ed25519Scheme) Name() string {
+	return "ed25519"
+}
+
+func (ed25519Scheme) GenerateKeys(rand io.Reader) (gomel.PublicKey, gomel.PrivateKey, error) {
+	pubData, privData, err := sign.GenerateKey(rand)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -56,8 +66,7 @@ func GenerateKeys() (gomel.PublicKey, gomel.PrivateKey, error) {
 	return pub, priv, nil
 }
 
-// DecodePublicKey decodes a public key encoded as a base64 string.
-func DecodePublicKey(enc string) (gomel.PublicKey, error) {
+func (ed25519Scheme) DecodePublicKey(enc string) (gomel.PublicKey, error) {
 	data, err := base64.StdEncoding.DecodeString(enc)
 	if err != nil {
 		return nil, err
@@ -70,8 +79,7 @@ func DecodePublicKey(enc string) (gomel.PublicKey, error) {
 	return &result, nil
 }
 
-// DecodePrivateKey decodes a private key encoded as a base64 string.
-func DecodePrivateKey(enc string) (gomel.PrivateKey, error) {
+func (ed25519Scheme) DecodePrivateKey(enc string) (gomel.PrivateKey, error) {
 	data, err := base64.StdEncoding.DecodeString(enc)
 	if err != nil {
 		return nil, err
@@ -1,6 +1,8 @@
 package signing_test
 
 import (
+	"math/rand"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -63,6 +65,16 @@ var _ = Describe("Signatures", func() {
 				Expect(err).To(HaveOccurred())
 			})
 		})
+		Describe("Generating keys from a seeded source", func() {
+			It("Should return the same keys for the same seed", func() {
+				pub1, priv1, err := GenerateKeysFrom(rand.New(rand.NewSource(7)))
+				Expect(err).NotTo(HaveOccurred())
+				pub2, priv2, err := GenerateKeysFrom(rand.New(rand.NewSource(7)))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pub2.Encode()).To(Equal(pub1.Encode()))
+				Expect(priv2.Encode()).To(Equal(priv1.Encode()))
+			})
+		})
 		Describe("Decoding public key as private key and vice versa", func() {
 			It("Should return an error", func() {
 				_, err := DecodePublicKey(priv.Encode())
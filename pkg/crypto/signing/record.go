@@ -0,0 +1,119 @@
+package signing
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// recordLength is the size of a persisted record: 4 bytes of epoch, 4 bytes of height and the hash.
+const recordLength = 8 + gomel.HashLength
+
+// Record remembers the epoch, height and hash of the last unit signed by a process, telling which units can be signed
+// without risking a fork of an already signed one. If a path is given, the record is persisted there,
+// so the protection survives restarts of the process. It is not thread-safe.
+type Record struct {
+	path    string
+	signed  bool
+	epoch   gomel.EpochID
+	height  int
+	hash    gomel.Hash
+	blocked bool // set when the persisted record could not be read; nothing can be signed safely then
+}
+
+// OpenRecord returns a record persisted in the given file, loading the record left there by previous runs.
+// An empty path results in a record kept only in memory. If the file cannot be read or is malformed,
+// the error is returned together with a record that allows signing nothing, since any unit could be a fork of the lost one.
+func OpenRecord(path string) (*Record, error) {
+	r := &Record{path: path}
+	if path == "" {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err == nil && len(data) != recordLength {
+		err = errors.New("malformed record of the last signed unit")
+	}
+	if err != nil {
+		r.blocked = true
+		return r, err
+	}
+	r.signed = true
+	r.epoch = gomel.EpochID(binary.LittleEndian.Uint32(data[:4]))
+	r.height = int(binary.LittleEndian.Uint32(data[4:8]))
+	copy(r.hash[:], data[8:])
+	return r, nil
+}
+
+// Allows checks if a unit with the given epoch and height can be signed without risking a fork.
+func (r *Record) Allows(epoch gomel.EpochID, height int) bool {
+	if r.blocked {
+		return false
+	}
+	return !r.signed || epoch > r.epoch || (epoch == r.epoch && height > r.height)
+}
+
+// Repeats checks if the given preunit is the last signed one, so it can be signed again.
+func (r *Record) Repeats(pu gomel.Preunit) bool {
+	return !r.blocked && r.signed && pu.EpochID() == r.epoch && pu.Height() == r.height && *pu.Hash() == r.hash
+}
+
+// Reached checks if a unit with the given epoch and height is at least as high as the last signed one.
+// Units of other epochs are never below it.
+func (r *Record) Reached(epoch gomel.EpochID, height int) bool {
+	if !r.signed || epoch != r.epoch {
+		return true
+	}
+	return height >= r.height
+}
+
+// Store remembers the given preunit as the last signed one. The record is written to a temporary
+// file which is then synced and moved in place of the old one, so a crash never leaves a partial record.
+func (r *Record) Store(pu gomel.Preunit) error {
+	if r.path != "" {
+		data := make([]byte, recordLength)
+		binary.LittleEndian.PutUint32(data[:4], uint32(pu.EpochID()))
+		binary.LittleEndian.PutUint32(data[4:8], uint32(pu.Height()))
+		copy(data[8:], pu.Hash()[:])
+		tmp := r.path + ".tmp"
+		file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(data); err == nil {
+			err = file.Sync()
+		}
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if err = os.Rename(tmp, r.path); err != nil {
+			return err
+		}
+	}
+	r.signed = true
+	r.epoch = pu.EpochID()
+	r.height = pu.Height()
+	r.hash = *pu.Hash()
+	return nil
+}
+
+// String describes the last signed unit.
+func (r *Record) String() string {
+	switch {
+	case r.blocked:
+		return "record of the last signed unit unreadable"
+	case !r.signed:
+		return "nothing signed"
+	default:
+		return "last signed epoch " + strconv.Itoa(int(r.epoch)) + " height " + strconv.Itoa(r.height)
+	}
+}
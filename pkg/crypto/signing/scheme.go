@@ -0,0 +1,137 @@
+package signing
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// DefaultScheme is the name of the scheme used for keys without a scheme tag.
+const DefaultScheme = "ed25519"
+
+// separator splits the scheme tag from the rest of an encoded key. It does not appear in base64.
+const separator = ":"
+
+// Scheme is a signature scheme that can be used for signing units.
+type Scheme interface {
+	// Name identifies the scheme in tags of encoded keys.
+	Name() string
+	// GenerateKeys produces a public and private key pair, using the given source of randomness or a secure one if it is nil.
+	GenerateKeys(rand io.Reader) (gomel.PublicKey, gomel.PrivateKey, error)
+	// DecodePublicKey decodes a public key of this scheme, with the scheme tag already removed.
+	DecodePublicKey(enc string) (gomel.PublicKey, error)
+	// DecodePrivateKey decodes a private key of this scheme, with the scheme tag already removed.
+	DecodePrivateKey(enc string) (gomel.PrivateKey, error)
+}
+
+var (
+	schemesMx sync.RWMutex
+	schemes   = make(map[string]Scheme)
+)
+
+func init() {
+	Register(ed25519Scheme{})
+	Register(blsScheme{})
+}
+
+// Register makes the scheme available for decoding keys tagged with its name. It panics if the name is already taken.
+func Register(s Scheme) {
+	schemesMx.Lock()
+	defer schemesMx.Unlock()
+	if strings.Contains(s.Name(), separator) {
+		panic("signing: invalid scheme name " + s.Name())
+	}
+	if _, ok := schemes[s.Name()]; ok {
+		panic("signing: scheme registered twice: " + s.Name())
+	}
+	schemes[s.Name()] = s
+}
+
+// Lookup returns the scheme with the given name.
+func Lookup(name string) (Scheme, error) {
+	schemesMx.RLock()
+	defer schemesMx.RUnlock()
+	s, ok := schemes[name]
+	if !ok {
+		return nil, errors.New("unknown signature scheme: " + name)
+	}
+	return s, nil
+}
+
+// Schemes returns the names of all the registered schemes, sorted.
+func Schemes() []string {
+	schemesMx.RLock()
+	defer schemesMx.RUnlock()
+	result := make([]string, 0, len(schemes))
+	for name := range schemes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SchemeOf returns the name of the scheme of an encoded key.
+func SchemeOf(enc string) string {
+	if i := strings.Index(enc, separator); i >= 0 {
+		return enc[:i]
+	}
+	return DefaultScheme
+}
+
+// Untag returns the name of the scheme of an encoded key and the key without the scheme tag.
+func Untag(enc string) (string, string) {
+	scheme := SchemeOf(enc)
+	return scheme, strings.TrimPrefix(enc, scheme+separator)
+}
+
+// tag prepends the name of the scheme to an encoded key. Keys of the default scheme are left untagged,
+// so that they stay readable by older versions.
+func tag(scheme, enc string) string {
+	if scheme == DefaultScheme {
+		return enc
+	}
+	return scheme + separator + enc
+}
+
+// split finds the scheme of an encoded key and returns it together with the untagged key.
+func split(enc string) (Scheme, string, error) {
+	name, rest := Untag(enc)
+	s, err := Lookup(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, rest, nil
+}
+
+// GenerateKeys produces a public and private key pair of the default scheme.
+func GenerateKeys() (gomel.PublicKey, gomel.PrivateKey, error) {
+	return GenerateKeysFrom(nil)
+}
+
+// GenerateKeysFrom produces a public and private key pair of the default scheme, using the given source of randomness.
+// Keys generated from a seeded source are not secret, but they allow reproducible tests and simulations.
+func GenerateKeysFrom(rand io.Reader) (gomel.PublicKey, gomel.PrivateKey, error) {
+	return ed25519Scheme{}.GenerateKeys(rand)
+}
+
+// DecodePublicKey decodes a public key of any registered scheme, as encoded by its Encode method.
+func DecodePublicKey(enc string) (gomel.PublicKey, error) {
+	s, rest, err := split(enc)
+	if err != nil {
+		return nil, err
+	}
+	return s.DecodePublicKey(rest)
+}
+
+// DecodePrivateKey decodes a private key of any registered scheme, as encoded by its Encode method.
+func DecodePrivateKey(enc string) (gomel.PrivateKey, error) {
+	s, rest, err := split(enc)
+	if err != nil {
+		return nil, err
+	}
+	return s.DecodePrivateKey(rest)
+}
@@ -0,0 +1,125 @@
+package signing_test
+
+import (
+	"math/rand"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+)
+
+var _ = Describe("Schemes", func() {
+
+	It("Should include ed25519 and bls", func() {
+		Expect(Schemes()).To(ContainElement("ed25519"))
+		Expect(Schemes()).To(ContainElement("bls"))
+		Expect(DefaultScheme).To(Equal("ed25519"))
+	})
+
+	It("Should not find an unknown scheme", func() {
+		_, err := Lookup("rot13")
+		Expect(err).To(HaveOccurred())
+		_, err = DecodePublicKey("rot13:abcd")
+		Expect(err).To(HaveOccurred())
+	})
+
+	for _, name := range []string{"ed25519", "bls"} {
+		name := name
+		Describe(name, func() {
+			var (
+				scheme Scheme
+				pub    gomel.PublicKey
+				priv   gomel.PrivateKey
+				pu     gomel.Preunit
+			)
+
+			BeforeEach(func() {
+				var err error
+				scheme, err = Lookup(name)
+				Expect(err).NotTo(HaveOccurred())
+				pub, priv, err = scheme.GenerateKeys(nil)
+				Expect(err).NotTo(HaveOccurred())
+				pu = tests.NewPreunit(0, gomel.EmptyCrown(10), []byte{1, 2, 3}, nil, priv)
+			})
+
+			It("Should verify a correct signature", func() {
+				Expect(pub.Verify(pu)).To(BeTrue())
+			})
+
+			It("Should reject a forged signature", func() {
+				pu.Signature()[0]++
+				Expect(pub.Verify(pu)).To(BeFalse())
+			})
+
+			It("Should reject a signature made with another key", func() {
+				other, _, err := scheme.GenerateKeys(nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(other.Verify(pu)).To(BeFalse())
+			})
+
+			It("Should tag the encoded keys with the scheme", func() {
+				Expect(SchemeOf(pub.Encode())).To(Equal(name))
+				Expect(SchemeOf(priv.Encode())).To(Equal(name))
+			})
+
+			It("Should decode the encoded keys", func() {
+				decodedPub, err := DecodePublicKey(pub.Encode())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decodedPub).To(Equal(pub))
+				decodedPriv, err := DecodePrivateKey(priv.Encode())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decodedPriv).To(Equal(priv))
+			})
+
+			It("Should decode the keys explicitly tagged with the scheme", func() {
+				tag, key := Untag(pub.Encode())
+				Expect(tag).To(Equal(name))
+				decoded, err := DecodePublicKey(name + ":" + key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decoded).To(Equal(pub))
+			})
+
+			It("Should generate the same keys from the same seed", func() {
+				pub1, priv1, err := scheme.GenerateKeys(rand.New(rand.NewSource(7)))
+				Expect(err).NotTo(HaveOccurred())
+				pub2, priv2, err := scheme.GenerateKeys(rand.New(rand.NewSource(7)))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pub2.Encode()).To(Equal(pub1.Encode()))
+				Expect(priv2.Encode()).To(Equal(priv1.Encode()))
+			})
+		})
+	}
+
+	Describe("bls batch verification", func() {
+		var (
+			bv  gomel.BatchVerifier
+			pus []gomel.Preunit
+		)
+
+		BeforeEach(func() {
+			scheme, err := Lookup("bls")
+			Expect(err).NotTo(HaveOccurred())
+			pub, priv, err := scheme.GenerateKeys(nil)
+			Expect(err).NotTo(HaveOccurred())
+			var ok bool
+			bv, ok = pub.(gomel.BatchVerifier)
+			Expect(ok).To(BeTrue())
+			pus = make([]gomel.Preunit, 8)
+			for i := range pus {
+				pus[i] = tests.NewPreunit(0, gomel.EmptyCrown(10), []byte{byte(i)}, nil, priv)
+			}
+		})
+
+		It("Should accept a batch of correct signatures", func() {
+			Expect(bv.VerifyBatch(pus)).To(Equal([]bool{true, true, true, true, true, true, true, true}))
+		})
+
+		It("Should find the forged signature in a batch", func() {
+			pus[5].Signature()[0]++
+			Expect(bv.VerifyBatch(pus)).To(Equal([]bool{true, true, true, true, true, false, true, true}))
+		})
+	})
+})
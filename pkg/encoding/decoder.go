@@ -13,23 +13,25 @@ import (
 
 type decoder struct {
 	io.Reader
+	version uint8
 }
 
 // newDecoder creates a new encoding.Decoder that is threadsafe.
 // It assumes the data encodes units in the following format:
 //  1. Creator id, 2 bytes.
-//  2. Signature, 64 bytes.
-//  3. Number of parents, 2 bytes.
-//  4. Parent heights, 4 bytes each.
-//  5. Control hash 32 bytes.
-//  6. Size of the unit data in bytes, 4 bytes.
-//  7. The unit data, as much as declared in 6.
-//  8. Size of the random source data in bytes, 4 bytes.
-//  9. The random source data, as much as declared in 8.
+//  2. Size of the signature in bytes, 2 bytes, only in the SizedSignatures version.
+//  3. The signature, as much as declared in 2, or 64 bytes in the FixedSignatures version.
+//  4. Number of parents, 2 bytes.
+//  5. Parent heights, 4 bytes each.
+//  6. Control hash 32 bytes.
+//  7. Size of the unit data in bytes, 4 bytes.
+//  8. The unit data, as much as declared in 7.
+//  9. Size of the random source data in bytes, 4 bytes.
+//  10. The random source data, as much as declared in 9.
 // All integer values are encoded as 16 or 32 bit unsigned ints.
 // It is guaranteed to read only as much data as needed.
-func newDecoder(r io.Reader) *decoder {
-	return &decoder{r}
+func newDecoder(r io.Reader, version uint8) *decoder {
+	return &decoder{r, version}
 }
 
 // decodeCrown reads encoded data from the io.Reader and tries to decode it as a crown.
@@ -112,7 +114,15 @@ func (d *decoder) decodePreunit() (gomel.Preunit, error) {
 		return nil, nil
 	}
 
-	signature := make([]byte, 64)
+	sigSize := fixedSignatureSize
+	if d.version != FixedSignatures {
+		_, err = io.ReadFull(d, uint32Buf[:2])
+		if err != nil {
+			return nil, err
+		}
+		sigSize = int(binary.LittleEndian.Uint16(uint32Buf[:2]))
+	}
+	signature := make([]byte, sigSize)
 	_, err = io.ReadFull(d, signature)
 	if err != nil {
 		return nil, err
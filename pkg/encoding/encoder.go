@@ -12,22 +12,24 @@ import (
 
 type encoder struct {
 	io.Writer
+	version uint8
 }
 
 // newEncoder creates a new encoding.Encoder that is thread-safe.
 // It encodes units in the following format:
 //  1. Creator id, 2 bytes.
-//  2. Signature, 64 bytes.
-//  3. Number of parents, 2 bytes.
-//  4. Parent heights, 4 bytes each.
-//  5. Control hash 32 bytes.
-//  6. Size of the unit data in bytes, 4 bytes.
-//  7. The unit data, as much as declared in 6.
-//  8. Size of the random source data in bytes, 4 bytes.
-//  9. The random source data, as much as declared in 8.
+//  2. Size of the signature in bytes, 2 bytes, only in the SizedSignatures version.
+//  3. The signature, as much as declared in 2, or 64 bytes in the FixedSignatures version.
+//  4. Number of parents, 2 bytes.
+//  5. Parent heights, 4 bytes each.
+//  6. Control hash 32 bytes.
+//  7. Size of the unit data in bytes, 4 bytes.
+//  8. The unit data, as much as declared in 7.
+//  9. Size of the random source data in bytes, 4 bytes.
+//  10. The random source data, as much as declared in 9.
 // All integer values are encoded as 16 or 32 bit unsigned ints.
-func newEncoder(w io.Writer) *encoder {
-	return &encoder{w}
+func newEncoder(w io.Writer, version uint8) *encoder {
+	return &encoder{w, version}
 }
 
 // encodeCrown encodes a crown and writes the encoded data to the io.Writer.
@@ -84,9 +86,22 @@ func (e *encoder) encodeUnit(unit gomel.Preunit) error {
 		_, err := e.Write(data)
 		return err
 	}
-	data := make([]byte, 8+64)
+	var data []byte
+	if e.version == FixedSignatures {
+		if len(unit.Signature()) > fixedSignatureSize {
+			return errors.New("signature too long for the first version of the encoding")
+		}
+		data = make([]byte, 8+fixedSignatureSize)
+		copy(data[8:], unit.Signature())
+	} else {
+		if len(unit.Signature()) > math.MaxUint16 {
+			return errors.New("signature too long")
+		}
+		data = make([]byte, 8+2, 8+2+len(unit.Signature()))
+		binary.LittleEndian.PutUint16(data[8:], uint16(len(unit.Signature())))
+		data = append(data, unit.Signature()...)
+	}
 	binary.LittleEndian.PutUint64(data[:8], gomel.UnitID(unit))
-	copy(data[8:8+64], unit.Signature())
 	_, err := e.Write(data)
 	if err != nil {
 		return err
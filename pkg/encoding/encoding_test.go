@@ -10,6 +10,7 @@ import (
 	. "gitlab.com/alephledger/consensus-go/pkg/encoding"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	"gitlab.com/alephledger/consensus-go/pkg/tests"
+	"gitlab.com/alephledger/consensus-go/pkg/unit"
 )
 
 var _ = Describe("Encoding/Decoding", func() {
@@ -26,9 +27,9 @@ var _ = Describe("Encoding/Decoding", func() {
 	Context("A dealing unit", func() {
 		It("should be encoded/decoded to a preunit representing the original unit", func() {
 			u := dag.UnitsOnLevel(0).Get(0)[0]
-			err := WriteUnit(u, network)
+			err := WriteUnit(u, network, LatestVersion)
 			Expect(err).NotTo(HaveOccurred())
-			pu, err := ReadPreunit(network)
+			pu, err := ReadPreunit(network, LatestVersion)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(pu.Creator()).To(Equal(u.Creator()))
 			Expect(gomel.SigEq(pu.Signature(), u.Signature())).To(BeTrue())
@@ -43,12 +44,52 @@ var _ = Describe("Encoding/Decoding", func() {
 			}
 		})
 	})
+	Context("A unit with a signature of unusual length", func() {
+		It("should be encoded/decoded with the whole signature", func() {
+			u := dag.UnitsOnLevel(0).Get(0)[0]
+			for _, length := range []int{0, 1, 96, 300} {
+				signature := make([]byte, length)
+				for i := range signature {
+					signature[i] = byte(i)
+				}
+				signed := unit.NewPreunit(gomel.UnitID(u), u.View(), u.Data(), u.RandomSourceData(), signature)
+				encoded, err := EncodeUnit(signed)
+				Expect(err).NotTo(HaveOccurred())
+				pu, err := DecodePreunit(encoded)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pu.Signature()).To(HaveLen(length))
+				Expect(gomel.SigEq(pu.Signature(), signature)).To(BeTrue())
+				Expect(pu.Hash()).To(Equal(u.Hash()))
+			}
+		})
+	})
+	Context("A unit encoded in the first version of the encoding", func() {
+		It("should be decoded with a signature of 64 bytes", func() {
+			u := dag.UnitsOnLevel(0).Get(0)[0]
+			signature := make([]byte, 64)
+			for i := range signature {
+				signature[i] = byte(i)
+			}
+			signed := unit.NewPreunit(gomel.UnitID(u), u.View(), u.Data(), u.RandomSourceData(), signature)
+			Expect(WriteUnit(signed, network, FixedSignatures)).To(Succeed())
+			pu, err := ReadPreunit(network, FixedSignatures)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gomel.SigEq(pu.Signature(), signature)).To(BeTrue())
+			Expect(pu.Hash()).To(Equal(u.Hash()))
+			Expect(network.Len()).To(BeZero())
+		})
+		It("should not fit a longer signature", func() {
+			u := dag.UnitsOnLevel(0).Get(0)[0]
+			signed := unit.NewPreunit(gomel.UnitID(u), u.View(), u.Data(), u.RandomSourceData(), make([]byte, 96))
+			Expect(WriteUnit(signed, network, FixedSignatures)).NotTo(Succeed())
+		})
+	})
 	Context("A non-dealing unit", func() {
 		It("should be encoded/decoded to a preunit representing the original unit", func() {
 			u := dag.MaximalUnitsPerProcess().Get(0)[0]
-			err := WriteUnit(u, network)
+			err := WriteUnit(u, network, LatestVersion)
 			Expect(err).NotTo(HaveOccurred())
-			pu, err := ReadPreunit(network)
+			pu, err := ReadPreunit(network, LatestVersion)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(pu.Creator()).To(Equal(u.Creator()))
 			Expect(gomel.SigEq(pu.Signature(), u.Signature())).To(BeTrue())
@@ -73,10 +114,10 @@ var _ = Describe("Encoding/Decoding", func() {
 				})
 				// sending to a buffer
 				var buf bytes.Buffer
-				err := WriteChunk(toSend, &buf)
+				err := WriteChunk(toSend, &buf, LatestVersion)
 				Expect(err).NotTo(HaveOccurred())
 				// receiving
-				pus, err := ReadChunk(&buf)
+				pus, err := ReadChunk(&buf, LatestVersion)
 				//checks
 				Expect(len(pus)).To(Equal(len(toSend)))
 				for _, pu := range pus {
@@ -95,10 +136,10 @@ var _ = Describe("Encoding/Decoding", func() {
 				}
 				// sending to a buffer
 				var buf bytes.Buffer
-				err := WriteChunk(toSend, &buf)
+				err := WriteChunk(toSend, &buf, LatestVersion)
 				Expect(err).NotTo(HaveOccurred())
 				// receiving
-				pus, err := ReadChunk(&buf)
+				pus, err := ReadChunk(&buf, LatestVersion)
 				// checks
 				Expect(len(pus)).To(Equal(len(toSend)))
 				for h, pu := range pus {
@@ -111,9 +152,9 @@ var _ = Describe("Encoding/Decoding", func() {
 		Context("on a unit with too much data", func() {
 			It("should return an error", func() {
 				nProc := 0
-				// creator, epochID, signature, nParents, parentsHeights, controlHash, data length
-				encoded := make([]byte, 2+8+64+(2+4*nProc+32)+4)
-				dataLenStartOffset := 2 + 8 + 64 + (2 + 4*nProc + 32)
+				// id, empty signature, nParents, parentsHeights, controlHash, data length
+				encoded := make([]byte, 8+2+(2+4*nProc+32)+4)
+				dataLenStartOffset := 8 + 2 + (2 + 4*nProc + 32)
 				binary.LittleEndian.PutUint32(encoded[dataLenStartOffset:], config.MaxDataBytesPerUnit+1)
 				_, err := DecodePreunit(encoded)
 				Expect(err).To(MatchError("maximal allowed data size in a preunit exceeded"))
@@ -122,9 +163,9 @@ var _ = Describe("Encoding/Decoding", func() {
 		Context("on a unit with too long random source data", func() {
 			It("should return an error", func() {
 				nProc := 0
-				// creator, epochID, signature, nParents, parentsHeights, controlHash, data length, random source data length
-				encoded := make([]byte, 2+8+64+2+4*nProc+32+4+4)
-				rsDataLenStartOffset := 2 + 8 + 64 + 2 + 4*nProc + 32 + 4
+				// id, empty signature, nParents, parentsHeights, controlHash, data length, random source data length
+				encoded := make([]byte, 8+2+2+4*nProc+32+4+4)
+				rsDataLenStartOffset := 8 + 2 + 2 + 4*nProc + 32 + 4
 				binary.LittleEndian.PutUint32(encoded[rsDataLenStartOffset:], config.MaxRandomSourceDataBytesPerUnit+1)
 				_, err := DecodePreunit(encoded)
 				Expect(err).To(MatchError("maximal allowed random source data size in a preunit exceeded"))
@@ -136,7 +177,7 @@ var _ = Describe("Encoding/Decoding", func() {
 			It("should return an error", func() {
 				encoded := make([]byte, 4)
 				binary.LittleEndian.PutUint32(encoded[:], config.MaxUnitsInChunk+1)
-				_, err := ReadChunk(bytes.NewBuffer(encoded))
+				_, err := ReadChunk(bytes.NewBuffer(encoded), LatestVersion)
 				Expect(err).To(MatchError("chunk contains too many units"))
 			})
 		})
@@ -7,10 +7,33 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 )
 
-// EncodeUnit encodes a unit to a slice of bytes.
+// Versions of the encoding of units. Protocols exchanging units with other processes agree on the version
+// in the handshake, see handshake.GreetWithVersion.
+const (
+	// FixedSignatures is the first version, with room only for signatures of exactly 64 bytes, like the ed25519 ones.
+	FixedSignatures uint8 = 1
+	// SizedSignatures precedes every signature with its size, so it can carry signatures of any scheme.
+	SizedSignatures uint8 = 2
+	// LatestVersion is the newest version of the encoding.
+	LatestVersion = SizedSignatures
+)
+
+// ForProtocol returns the version of the encoding of units used by the given version of a protocol exchanging units.
+// The first versions of the protocols send units with fixed size signatures, so they work only with ed25519 keys.
+func ForProtocol(version uint8) uint8 {
+	if version > 1 {
+		return SizedSignatures
+	}
+	return FixedSignatures
+}
+
+// fixedSignatureSize is the size of every signature in the FixedSignatures version.
+const fixedSignatureSize = 64
+
+// EncodeUnit encodes a unit to a slice of bytes, using the latest version of the encoding.
 func EncodeUnit(unit gomel.Preunit) ([]byte, error) {
 	var buf bytes.Buffer
-	encoder := newEncoder(&buf)
+	encoder := newEncoder(&buf, LatestVersion)
 	err := encoder.encodeUnit(unit)
 	if err != nil {
 		return nil, err
@@ -20,13 +43,13 @@ func EncodeUnit(unit gomel.Preunit) ([]byte, error) {
 
 // DecodePreunit checks decodes the given data into preunit. Complementary to EncodeUnit.
 func DecodePreunit(data []byte) (gomel.Preunit, error) {
-	decoder := newDecoder(bytes.NewReader(data))
+	decoder := newDecoder(bytes.NewReader(data), LatestVersion)
 	return decoder.decodePreunit()
 }
 
 // WriteDagInfos encodes a slice of DagInfos to writer.
 func WriteDagInfos(infos [2]*gomel.DagInfo, w io.Writer) error {
-	enc := newEncoder(w)
+	enc := newEncoder(w, LatestVersion)
 	for _, info := range infos {
 		err := enc.encodeDagInfo(info)
 		if err != nil {
@@ -39,7 +62,7 @@ func WriteDagInfos(infos [2]*gomel.DagInfo, w io.Writer) error {
 // ReadDagInfos decodes a list of DagInfo instances from the given stream.
 func ReadDagInfos(r io.Reader) ([2]*gomel.DagInfo, error) {
 	var infos [2]*gomel.DagInfo
-	dec := newDecoder(r)
+	dec := newDecoder(r, LatestVersion)
 	for i := range infos {
 		info, err := dec.decodeDagInfo()
 		if err != nil {
@@ -50,24 +73,24 @@ func ReadDagInfos(r io.Reader) ([2]*gomel.DagInfo, error) {
 	return infos, nil
 }
 
-// WriteUnit writes encoded unit to writer.
-func WriteUnit(unit gomel.Unit, w io.Writer) error {
-	return newEncoder(w).encodeUnit(unit)
+// WriteUnit writes encoded unit to writer, using the given version of the encoding.
+func WriteUnit(unit gomel.Preunit, w io.Writer, version uint8) error {
+	return newEncoder(w, version).encodeUnit(unit)
 }
 
-// ReadPreunit decodes a preunit from reader.
-func ReadPreunit(r io.Reader) (gomel.Preunit, error) {
-	return newDecoder(r).decodePreunit()
+// ReadPreunit decodes a preunit from reader, encoded in the given version of the encoding.
+func ReadPreunit(r io.Reader, version uint8) (gomel.Preunit, error) {
+	return newDecoder(r, version).decodePreunit()
 }
 
-// WriteChunk encodes units and writes them to writer.
-func WriteChunk(units []gomel.Unit, w io.Writer) error {
-	return newEncoder(w).encodeChunk(units)
+// WriteChunk encodes units and writes them to writer, using the given version of the encoding.
+func WriteChunk(units []gomel.Unit, w io.Writer, version uint8) error {
+	return newEncoder(w, version).encodeChunk(units)
 }
 
-// ReadChunk decodes slice of preunit antichains from reader.
-func ReadChunk(r io.Reader) ([]gomel.Preunit, error) {
-	return newDecoder(r).decodeChunk()
+// ReadChunk decodes slice of preunit antichains from reader, encoded in the given version of the encoding.
+func ReadChunk(r io.Reader, version uint8) ([]gomel.Preunit, error) {
+	return newDecoder(r, version).decodeChunk()
 }
 
 func computeLayer(u gomel.Unit, layers map[gomel.Unit]int) int {
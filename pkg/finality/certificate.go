@@ -0,0 +1,181 @@
+package finality
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+)
+
+// maxSignatureSize bounds the length of an encoded signature or bitmap of signers we are willing to read.
+const maxSignatureSize = 1 << 12
+
+// Certificate is a proof that the committee agreed on the preblocks of an epoch up to the given position.
+// It consists of the head of the chain of these preblocks, signed with the RMC keys of enough committee members
+// that at least one of them is honest. A final certificate additionally proves that its position is the last one of the epoch.
+// The signatures are aggregated into a single one and the signers are marked in a bitmap, so a certificate
+// takes a bit per committee member on top of a single signature.
+type Certificate struct {
+	stream.Position
+	Head      gomel.Hash
+	Final     bool
+	Signers   []byte           // bit pid%8 of byte pid/8 is set if the signature of pid is aggregated
+	Signature *bn256.Signature // sum of the signatures of all the signers
+}
+
+// NewCertificate aggregates the given signatures, made by members of a committee of the given size, into a certificate.
+func NewCertificate(pos stream.Position, head gomel.Hash, final bool, sigs map[uint16]*bn256.Signature, nProc uint16) *Certificate {
+	c := &Certificate{Position: pos, Head: head, Final: final, Signers: make([]byte, (int(nProc)+7)/8)}
+	for pid, sig := range sigs {
+		c.Signers[pid/8] |= 1 << (pid % 8)
+		if c.Signature == nil {
+			c.Signature = sig
+		} else {
+			c.Signature = bn256.AddSignatures(c.Signature, sig)
+		}
+	}
+	return c
+}
+
+// Signed checks if the signature of pid is aggregated in the certificate.
+func (c *Certificate) Signed(pid uint16) bool {
+	return int(pid)/8 < len(c.Signers) && c.Signers[pid/8]&(1<<(pid%8)) != 0
+}
+
+// Message returns the message signed by the committee for the given position and head of the chain.
+func Message(pos stream.Position, head *gomel.Hash, final bool) []byte {
+	var data bytes.Buffer
+	data.WriteString("finality")
+	writePosition(&data, pos)
+	data.Write(head[:])
+	writeFinal(&data, final)
+	return data.Bytes()
+}
+
+// Verify checks if the certificate is signed by enough members of the committee with the given verification keys.
+// The signature is checked against the sum of the keys of the signers. Like the multisignatures of RMC,
+// this relies on every key in the committee file being generated by its owner.
+func (c *Certificate) Verify(keys []*bn256.VerificationKey) bool {
+	if c.Signature == nil || len(c.Signers) != (len(keys)+7)/8 {
+		return false
+	}
+	// bits past the last member cannot be set
+	if rest := len(keys) % 8; rest != 0 && c.Signers[len(c.Signers)-1]>>uint(rest) != 0 {
+		return false
+	}
+	var aggregated *bn256.VerificationKey
+	signers := 0
+	for pid, key := range keys {
+		if !c.Signed(uint16(pid)) {
+			continue
+		}
+		signers++
+		if aggregated == nil {
+			aggregated = key
+		} else {
+			aggregated = bn256.AddVerificationKeys(aggregated, key)
+		}
+	}
+	if signers < int(gomel.MinimalTrusted(uint16(len(keys)))) {
+		return false
+	}
+	return aggregated.Verify(c.Signature, Message(c.Position, &c.Head, c.Final))
+}
+
+// WriteCertificate encodes the certificate to the writer.
+func WriteCertificate(c *Certificate, w io.Writer) error {
+	var data bytes.Buffer
+	writePosition(&data, c.Position)
+	data.Write(c.Head[:])
+	writeFinal(&data, c.Final)
+	writeBlob(&data, c.Signers)
+	writeBlob(&data, c.Signature.Marshal())
+	_, err := w.Write(data.Bytes())
+	return err
+}
+
+// ReadCertificate decodes a certificate written by WriteCertificate.
+// Returns io.EOF only if there was no data left at all.
+func ReadCertificate(r io.Reader) (*Certificate, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	c := &Certificate{Position: stream.Position{
+		Epoch: gomel.EpochID(binary.LittleEndian.Uint32(buf[:4])),
+		Level: int(binary.LittleEndian.Uint32(buf[4:])),
+	}}
+	if _, err := io.ReadFull(r, c.Head[:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	c.Final = buf[0] == 1
+	var err error
+	if c.Signers, err = readBlob(r); err != nil {
+		return nil, err
+	}
+	if c.Signature, err = readSignature(r); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadCertificates decodes all the certificates from the reader, skipping a partially written one at the end.
+func ReadCertificates(r io.Reader) ([]*Certificate, error) {
+	var result []*Certificate
+	for {
+		c, err := ReadCertificate(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, c)
+	}
+}
+
+func writeBlob(w io.Writer, data []byte) error {
+	buf := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+func writeFinal(data *bytes.Buffer, final bool) {
+	if final {
+		data.WriteByte(1)
+	} else {
+		data.WriteByte(0)
+	}
+}
+
+func readSignature(r io.Reader) (*bn256.Signature, error) {
+	data, err := readBlob(r)
+	if err != nil {
+		return nil, err
+	}
+	return new(bn256.Signature).Unmarshal(data)
+}
+
+func readBlob(r io.Reader) ([]byte, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	size := binary.LittleEndian.Uint32(buf)
+	if size > maxSignatureSize {
+		return nil, errors.New("signature too big")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
@@ -0,0 +1,86 @@
+package finality
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/crypto/sha3"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+// Chain is a hash chain over consecutive preblocks of an epoch.
+// Every epoch starts a new chain, so processes joining the committee in a later epoch can certify its preblocks too.
+type Chain struct {
+	pos  stream.Position
+	head gomel.Hash
+}
+
+// NewChain returns an empty chain.
+func NewChain() *Chain {
+	return &Chain{pos: stream.Position{Level: -1}}
+}
+
+// Append extends the chain with the preblock at the given position and returns the new head of the chain.
+// A preblock of a newer epoch starts the chain anew.
+func (c *Chain) Append(pos stream.Position, pb *core.Preblock) *gomel.Hash {
+	if pos.Epoch != c.pos.Epoch || c.pos.Level < 0 {
+		c.head = Genesis(pos.Epoch)
+	}
+	c.head = Link(&c.head, pos, pb)
+	c.pos = pos
+	head := c.head
+	return &head
+}
+
+// Genesis returns the head of the chain of the given epoch before any preblock was appended.
+func Genesis(epoch gomel.EpochID) gomel.Hash {
+	var result gomel.Hash
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(epoch))
+	sha3.ShakeSum128(result[:], append([]byte("genesis"), buf...))
+	return result
+}
+
+// Link returns the head of the chain with the given head after appending the preblock at the given position.
+func Link(prev *gomel.Hash, pos stream.Position, pb *core.Preblock) gomel.Hash {
+	var (
+		result gomel.Hash
+		data   bytes.Buffer
+	)
+	data.Write(prev[:])
+	writePosition(&data, pos)
+	data.Write(PreblockHash(pb)[:])
+	sha3.ShakeSum128(result[:], data.Bytes())
+	return result
+}
+
+// PreblockHash computes a hash of the data and the random bytes of the given preblock.
+func PreblockHash(pb *core.Preblock) *gomel.Hash {
+	var (
+		result gomel.Hash
+		data   bytes.Buffer
+	)
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(len(pb.RandomBytes)))
+	data.Write(buf)
+	data.Write(pb.RandomBytes)
+	binary.LittleEndian.PutUint32(buf, uint32(len(pb.Data)))
+	data.Write(buf)
+	for _, d := range pb.Data {
+		binary.LittleEndian.PutUint32(buf, uint32(len(d)))
+		data.Write(buf)
+		data.Write(d)
+	}
+	sha3.ShakeSum128(result[:], data.Bytes())
+	return &result
+}
+
+func writePosition(data *bytes.Buffer, pos stream.Position) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf[:4], uint32(pos.Epoch))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(pos.Level))
+	data.Write(buf)
+}
@@ -0,0 +1,13 @@
+package finality_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFinality(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Finality Suite")
+}
@@ -0,0 +1,225 @@
+package finality_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	. "gitlab.com/alephledger/consensus-go/pkg/finality"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+	"gitlab.com/alephledger/core-go/pkg/network"
+	ctests "gitlab.com/alephledger/core-go/pkg/tests"
+)
+
+func preblock(pos stream.Position) *core.Preblock {
+	return tests.Preblock(pos.Epoch, pos.Level)
+}
+
+// heads appends the preblocks of nEpochs epochs with nLevels levels each to a new chain and returns the consecutive heads.
+func heads(nEpochs, nLevels int) []*gomel.Hash {
+	chain := NewChain()
+	var result []*gomel.Hash
+	for e := 0; e < nEpochs; e++ {
+		for l := 0; l < nLevels; l++ {
+			pos := stream.Position{Epoch: gomel.EpochID(e), Level: l}
+			result = append(result, chain.Append(pos, preblock(pos)))
+		}
+	}
+	return result
+}
+
+var _ = Describe("Chain", func() {
+	It("should be deterministic", func() {
+		Expect(heads(2, 3)).To(Equal(heads(2, 3)))
+	})
+
+	It("should depend on every preblock", func() {
+		chain := NewChain()
+		pos := stream.Position{Level: 0}
+		other := chain.Append(pos, core.NewPreblock([]core.Data{core.Data("other")}, preblock(pos).RandomBytes))
+		Expect(*other).NotTo(Equal(*heads(1, 1)[0]))
+	})
+
+	It("should start anew in every epoch", func() {
+		chain := NewChain()
+		pos := stream.Position{Epoch: 1, Level: 0}
+		Expect(chain.Append(pos, preblock(pos))).To(Equal(heads(2, 3)[3]))
+	})
+})
+
+var _ = Describe("Certificate", func() {
+	var (
+		keys []*bn256.VerificationKey
+		cert *Certificate
+	)
+
+	BeforeEach(func() {
+		keys = make([]*bn256.VerificationKey, 4)
+		secrets := make([]*bn256.SecretKey, 4)
+		for pid := range keys {
+			keys[pid], secrets[pid], _ = bn256.GenerateKeys()
+		}
+		pos := stream.Position{Epoch: 1, Level: 2}
+		head := heads(2, 3)[5]
+		sigs := make(map[uint16]*bn256.Signature)
+		for pid, sk := range secrets[:gomel.MinimalTrusted(4)] {
+			sigs[uint16(pid)] = sk.Sign(Message(pos, head, true))
+		}
+		cert = NewCertificate(pos, *head, true, sigs, 4)
+	})
+
+	It("should verify", func() {
+		Expect(cert.Verify(keys)).To(BeTrue())
+	})
+
+	It("should not verify with a different head", func() {
+		cert.Head = *heads(2, 3)[4]
+		Expect(cert.Verify(keys)).To(BeFalse())
+	})
+
+	It("should not verify when not final", func() {
+		cert.Final = false
+		Expect(cert.Verify(keys)).To(BeFalse())
+	})
+
+	It("should not verify with too few signatures", func() {
+		cert.Signers[0] &^= 1
+		Expect(cert.Verify(keys)).To(BeFalse())
+	})
+
+	It("should not verify with a signer that did not sign", func() {
+		cert.Signers[0] |= 1 << 3
+		Expect(cert.Verify(keys)).To(BeFalse())
+	})
+
+	It("should not verify with signers outside the committee", func() {
+		cert.Signers[0] |= 1 << 4
+		Expect(cert.Verify(keys)).To(BeFalse())
+	})
+
+	It("should survive encoding", func() {
+		var buf bytes.Buffer
+		Expect(WriteCertificate(cert, &buf)).To(Succeed())
+		Expect(WriteCertificate(cert, &buf)).To(Succeed())
+		buf.Truncate(buf.Len() - 3)
+		certs, err := ReadCertificates(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(1))
+		Expect(certs[0].Position).To(Equal(cert.Position))
+		Expect(certs[0].Head).To(Equal(cert.Head))
+		Expect(certs[0].Final).To(BeTrue())
+		Expect(certs[0].Verify(keys)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Service", func() {
+	const (
+		nProc    = 4
+		nLevels  = 5
+		interval = 2
+		timeout  = time.Second
+	)
+
+	var (
+		netservs []network.Server
+		services []*Service
+		configs  []config.Config
+		certs    [][]*Certificate
+		mx       sync.Mutex
+	)
+
+	BeforeEach(func() {
+		netservs = ctests.NewNetwork(nProc, timeout)
+		configs = make([]config.Config, nProc)
+		for pid := range configs {
+			configs[pid] = config.Empty()
+			configs[pid].NProc = nProc
+			configs[pid].Pid = uint16(pid)
+			configs[pid].LastLevel = nLevels - 1
+			configs[pid].CertificateInterval = interval
+		}
+		tests.AddP2PKeys(configs...)
+		tests.AddRMCKeys(configs...)
+		certs = make([][]*Certificate, nProc)
+		services = make([]*Service, nProc)
+		for pid := range services {
+			pid := pid
+			services[pid] = NewService(configs[pid], netservs[pid], func(c *Certificate) {
+				mx.Lock()
+				defer mx.Unlock()
+				certs[pid] = append(certs[pid], c)
+			}, zerolog.Nop())
+			Expect(services[pid].Start()).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		for _, s := range services {
+			s.Stop()
+		}
+		ctests.CloseNetwork(netservs)
+	})
+
+	positions := func() []stream.Position {
+		mx.Lock()
+		defer mx.Unlock()
+		var result []stream.Position
+		for _, c := range certs[0] {
+			result = append(result, c.Position)
+		}
+		return result
+	}
+
+	It("should certify the due positions of every epoch", func() {
+		for pid, s := range services {
+			go func(pid int, s *Service) {
+				for e := 0; e < 2; e++ {
+					for l := 0; l < nLevels; l++ {
+						pos := stream.Position{Epoch: gomel.EpochID(e), Level: l}
+						s.Certify(pos, preblock(pos))
+						// a certificate makes the earlier positions obsolete, so we give every one of them a chance
+						time.Sleep(50 * time.Millisecond)
+					}
+				}
+			}(pid, s)
+		}
+		Eventually(positions, 5*time.Second).Should(ContainElement(stream.Position{Epoch: 1, Level: nLevels - 1}))
+		Expect(positions()).To(Equal([]stream.Position{
+			{Epoch: 0, Level: 1}, {Epoch: 0, Level: 3}, {Epoch: 0, Level: 4},
+			{Epoch: 1, Level: 1}, {Epoch: 1, Level: 3}, {Epoch: 1, Level: 4},
+		}))
+		hs := heads(2, nLevels)
+		mx.Lock()
+		defer mx.Unlock()
+		for _, c := range certs[0] {
+			Expect(c.Head).To(Equal(*hs[int(c.Epoch)*nLevels+c.Level]))
+			Expect(c.Final).To(Equal(c.Level == nLevels-1))
+			Expect(c.Verify(configs[0].RMCPublicKeys)).To(BeTrue())
+		}
+	})
+
+	It("should not certify preblocks the committee disagrees on", func() {
+		for pid, s := range services {
+			pos := stream.Position{Level: 0}
+			pb := preblock(pos)
+			if pid > 0 {
+				pb = core.NewPreblock([]core.Data{core.Data(fmt.Sprint("forged ", pid))}, pb.RandomBytes)
+			}
+			s.Certify(pos, pb)
+			pos = stream.Position{Level: 1}
+			s.Certify(pos, preblock(pos))
+		}
+		Consistently(positions, 500*time.Millisecond).Should(BeEmpty())
+	})
+})
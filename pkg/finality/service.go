@@ -0,0 +1,322 @@
+// Package finality implements certificates proving that the committee agreed on the produced preblocks.
+//
+// Every process keeps a hash chain over the consecutive preblocks of an epoch.
+// Every CertificateInterval levels, and at the last level of an epoch, it signs the head of that chain
+// with its RMC key and sends the signature to the other committee members. Signatures collected from enough processes
+// are aggregated into a Certificate that can be checked by anyone knowing the public committee file.
+package finality
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	gsync "sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/consensus-go/pkg/sync"
+	"gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
+	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+	"gitlab.com/alephledger/core-go/pkg/network"
+)
+
+const (
+	outPoolSize = 2
+	inPoolSize  = 2
+	// queueSize bounds the number of signatures waiting to be sent to a single process.
+	queueSize = 16
+	// maxPending bounds the number of positions for which we collect signatures at the same time.
+	maxPending = 64
+)
+
+// Output receives the certificates, in the order of their positions.
+type Output func(*Certificate)
+
+// message is a signature of the head of the chain at the given position made by the given process.
+type message struct {
+	pid  uint16
+	pos  stream.Position
+	head gomel.Hash
+	sig  *bn256.Signature
+}
+
+// round gathers the signatures for a single position.
+type round struct {
+	head    *gomel.Hash // our own head, nil until we reach this position
+	sigs    map[uint16]*bn256.Signature
+	pending []*message // signatures received before we knew our own head
+}
+
+// Service collects the signatures of the committee and combines them into certificates.
+type Service struct {
+	pid      uint16
+	nProc    uint16
+	interval int
+	epochLen int
+	secret   *bn256.SecretKey
+	verKeys  []*bn256.VerificationKey
+	chain    *Chain
+	netserv  network.Server
+	keys     *handshake.Keys
+	output   Output
+	requests []chan []byte
+	syncIds  []uint32
+	outPool  sync.WorkerPool
+	inPool   sync.WorkerPool
+	stopOut  chan struct{}
+	mx       gsync.Mutex
+	rounds   map[stream.Position]*round
+	last     *stream.Position // position of the most recent certificate
+	log      zerolog.Logger
+}
+
+// NewService returns a service certifying preblocks with conf.RMCPrivateKey.
+func NewService(conf config.Config, netserv network.Server, output Output, log zerolog.Logger) *Service {
+	requests := make([]chan []byte, conf.NProc)
+	for i := range requests {
+		requests[i] = make(chan []byte, queueSize)
+	}
+	s := &Service{
+		pid:      conf.Pid,
+		nProc:    conf.NProc,
+		interval: conf.CertificateInterval,
+		epochLen: conf.LastLevel + 1,
+		secret:   conf.RMCPrivateKey,
+		verKeys:  conf.RMCPublicKeys,
+		chain:    NewChain(),
+		netserv:  netserv,
+		keys:     handshake.NewKeys(conf),
+		output:   output,
+		requests: requests,
+		syncIds:  make([]uint32, conf.NProc),
+		stopOut:  make(chan struct{}),
+		rounds:   make(map[stream.Position]*round),
+		log:      log.With().Int(lg.Service, lg.FinalityService).Logger(),
+	}
+	s.outPool = sync.NewPerPidPool(conf.NProc, outPoolSize, s.out)
+	s.inPool = sync.NewPool(inPoolSize*int(conf.NProc), s.in)
+	return s
+}
+
+// Start starts the service.
+func (s *Service) Start() error {
+	s.outPool.Start()
+	s.inPool.Start()
+	s.log.Info().Msg(lg.ServiceStarted)
+	return nil
+}
+
+// Stop stops the service.
+func (s *Service) Stop() {
+	close(s.stopOut)
+	s.outPool.Stop()
+	s.inPool.Stop()
+	s.log.Info().Msg(lg.ServiceStopped)
+}
+
+// Certify appends the preblock at the given position to the chain.
+// If the position is due for a certificate, it signs the head of the chain and sends the signature to the committee.
+// It has to be called for every preblock, in order.
+func (s *Service) Certify(pos stream.Position, pb *core.Preblock) {
+	head := s.chain.Append(pos, pb)
+	if !s.due(pos) {
+		return
+	}
+	sig := s.secret.Sign(Message(pos, head, s.final(pos)))
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.last != nil && !s.last.Less(pos) {
+		return
+	}
+	r := s.round(pos)
+	if r == nil {
+		// make room for our own position, the signatures gathered so far came either for positions that this one supersedes,
+		// or from processes running far ahead of us
+		s.rounds = make(map[stream.Position]*round)
+		r = s.round(pos)
+	}
+	r.head = head
+	r.sigs[s.pid] = sig
+	s.broadcast(&message{s.pid, pos, *head, sig})
+	for _, m := range r.pending {
+		s.addSignature(r, m)
+	}
+	r.pending = nil
+	s.tryCombine(pos, r)
+}
+
+// due checks if the preblock at the given position should be certified.
+func (s *Service) due(pos stream.Position) bool {
+	return (pos.Level+1)%s.interval == 0 || s.final(pos)
+}
+
+// final checks if the given position is the last one of its epoch.
+func (s *Service) final(pos stream.Position) bool {
+	return pos.Level+1 == s.epochLen
+}
+
+// receive handles a signature sent by another process.
+func (s *Service) receive(m *message) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.last != nil && !s.last.Less(m.pos) {
+		return
+	}
+	r := s.round(m.pos)
+	if r == nil {
+		return
+	}
+	if _, ok := r.sigs[m.pid]; ok {
+		return
+	}
+	if r.head == nil {
+		for _, p := range r.pending {
+			if p.pid == m.pid {
+				return
+			}
+		}
+		r.pending = append(r.pending, m)
+		return
+	}
+	s.addSignature(r, m)
+	s.tryCombine(m.pos, r)
+}
+
+// round returns the round for the given position, creating it if there is room for one more.
+// This method must be called under mutex!
+func (s *Service) round(pos stream.Position) *round {
+	if r, ok := s.rounds[pos]; ok {
+		return r
+	}
+	if len(s.rounds) >= maxPending {
+		return nil
+	}
+	r := &round{sigs: make(map[uint16]*bn256.Signature)}
+	s.rounds[pos] = r
+	return r
+}
+
+// addSignature verifies the signature against our own head and adds it to the round.
+// This method must be called under mutex!
+func (s *Service) addSignature(r *round, m *message) {
+	if m.head != *r.head {
+		s.log.Warn().Uint16(lg.PID, m.pid).Uint32(lg.Epoch, uint32(m.pos.Epoch)).Int(lg.Level, m.pos.Level).Msg(lg.HeadMismatch)
+		return
+	}
+	if !s.verKeys[m.pid].Verify(m.sig, Message(m.pos, r.head, s.final(m.pos))) {
+		s.log.Error().Str("where", "finality.addSignature").Uint16(lg.PID, m.pid).Msg("invalid signature")
+		return
+	}
+	r.sigs[m.pid] = m.sig
+}
+
+// tryCombine produces a certificate if the round has enough signatures, and forgets all the rounds up to its position.
+// This method must be called under mutex!
+func (s *Service) tryCombine(pos stream.Position, r *round) {
+	if len(r.sigs) < int(gomel.MinimalTrusted(s.nProc)) {
+		return
+	}
+	c := NewCertificate(pos, *r.head, s.final(pos), r.sigs, s.nProc)
+	for p := range s.rounds {
+		if !pos.Less(p) {
+			delete(s.rounds, p)
+		}
+	}
+	s.last = &pos
+	s.log.Info().Uint32(lg.Epoch, uint32(pos.Epoch)).Int(lg.Level, pos.Level).Msg(lg.CertificateMade)
+	s.output(c)
+}
+
+// broadcast queues the message to be sent to all the other processes.
+// Messages to a process that does not keep up are dropped, certificates need only some of the signatures.
+func (s *Service) broadcast(m *message) {
+	var data bytes.Buffer
+	writePosition(&data, m.pos)
+	data.Write(m.head[:])
+	writeBlob(&data, m.sig.Marshal())
+	for _, i := range rand.Perm(int(s.nProc)) {
+		if i == int(s.pid) {
+			continue
+		}
+		select {
+		case s.requests[i] <- data.Bytes():
+		default:
+		}
+	}
+}
+
+func (s *Service) in() {
+	conn, err := s.netserv.Listen()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	pid, _, err := handshake.AcceptGreeting(conn, s.keys)
+	if err != nil {
+		s.log.Error().Str("where", "finality.in.greeting").Msg(err.Error())
+		return
+	}
+	m, err := readMessage(conn, pid)
+	if err != nil {
+		s.log.Error().Str("where", "finality.in.decode").Msg(err.Error())
+		return
+	}
+	s.receive(m)
+}
+
+func (s *Service) out(pid uint16) {
+	var data []byte
+	select {
+	case data = <-s.requests[pid]:
+	case <-s.stopOut:
+		return
+	}
+	conn, err := s.netserv.Dial(pid)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	sid := atomic.AddUint32(&s.syncIds[pid], 1) - 1
+	err = handshake.Greet(conn, s.keys, pid, sid)
+	if err != nil {
+		s.log.Error().Str("where", "finality.out.greeting").Msg(err.Error())
+		return
+	}
+	_, err = conn.Write(data)
+	if err != nil {
+		s.log.Error().Str("where", "finality.out.send").Msg(err.Error())
+		return
+	}
+	err = conn.Flush()
+	if err != nil {
+		s.log.Error().Str("where", "finality.out.flush").Msg(err.Error())
+	}
+}
+
+func readMessage(r io.Reader, pid uint16) (*message, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	m := &message{pid: pid, pos: stream.Position{
+		Epoch: gomel.EpochID(binary.LittleEndian.Uint32(buf[:4])),
+		Level: int(binary.LittleEndian.Uint32(buf[4:])),
+	}}
+	if _, err := io.ReadFull(r, m.head[:]); err != nil {
+		return nil, err
+	}
+	sig, err := readSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	m.sig = sig
+	return m, nil
+}
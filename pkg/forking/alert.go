@@ -19,6 +19,7 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/encoding"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
 	"gitlab.com/alephledger/core-go/pkg/network"
 	rmc "gitlab.com/alephledger/core-go/pkg/rmcbox"
 	"gitlab.com/alephledger/core-go/pkg/utils"
@@ -257,7 +258,7 @@ func (a *alertHandler) handleCommitmentRequest(conn network.Connection, log zero
 		log.Error().Str("where", "alertHandler.handleCommitmentRequest.Write").Msg(err.Error())
 		return
 	}
-	err = encoding.WriteUnit(nil, conn)
+	err = encoding.WriteUnit(nil, conn, encoding.LatestVersion)
 	if err != nil {
 		log.Error().Str("where", "alertHandler.handleCommitmentRequest.WriteUnit").Msg(err.Error())
 		return
@@ -671,6 +672,7 @@ func (a *alertHandler) NewFork(u, v gomel.Preunit) {
 
 	proof := newForkingProof(u, v, max)
 	a.raiseAlert(proof)
+	metrics.ForkAlerts.Inc()
 	a.notifyObservers(u, v)
 }
 
@@ -43,15 +43,15 @@ func (fp *forkingProof) marshal() []byte {
 func (fp *forkingProof) unmarshal(data []byte) (*forkingProof, error) {
 	reader := bytes.NewReader(data)
 	var err error
-	fp.pu, err = encoding.ReadPreunit(reader)
+	fp.pu, err = encoding.ReadPreunit(reader, encoding.LatestVersion)
 	if err != nil {
 		return nil, err
 	}
-	fp.pv, err = encoding.ReadPreunit(reader)
+	fp.pv, err = encoding.ReadPreunit(reader, encoding.LatestVersion)
 	if err != nil {
 		return nil, err
 	}
-	fp.pcommit, err = encoding.ReadPreunit(reader)
+	fp.pcommit, err = encoding.ReadPreunit(reader, encoding.LatestVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -72,8 +72,8 @@ func (fp *forkingProof) epochID() gomel.EpochID {
 func (fp *forkingProof) splitEncoding() ([]byte, []byte) {
 	encoded := fp.marshal()
 	reader := bytes.NewReader(encoded)
-	encoding.ReadPreunit(reader)
-	encoding.ReadPreunit(reader)
+	encoding.ReadPreunit(reader, encoding.LatestVersion)
+	encoding.ReadPreunit(reader, encoding.LatestVersion)
 	proofOnly := encoded[:len(encoded)-reader.Len()]
 	commitOnly := encoded[len(encoded)-reader.Len():]
 	return proofOnly, commitOnly
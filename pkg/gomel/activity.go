@@ -0,0 +1,83 @@
+package gomel
+
+import "sync"
+
+// Stage identifies a kind of work tracked by Activity.
+type Stage int
+
+const (
+	// Adding covers preunits waiting to be inserted into a dag and units waiting on the unit belt of the creator,
+	// as well as timers of the creator fired by a driver that runs processes in its own time (see Clock).
+	Adding Stage = iota
+	// Ordering covers the work of extenders and of the preblock builder.
+	Ordering
+	nStages
+)
+
+// Activity counts pieces of work handed over between the goroutines of processes.
+// It allows a driver feeding processes with units one by one (like a simulator) to wait until
+// everything caused by a unit is done, so the processes behave the same in every run.
+// All the methods of a nil *Activity do nothing, processes run without a driver do not pay for it.
+type Activity struct {
+	mx     sync.Mutex
+	cond   *sync.Cond
+	counts [nStages]int
+}
+
+// NewActivity returns an Activity with no work in progress.
+func NewActivity() *Activity {
+	a := &Activity{}
+	a.cond = sync.NewCond(&a.mx)
+	return a
+}
+
+// Add registers a piece of work of the given stage. It has to be called before the work is handed over.
+func (a *Activity) Add(s Stage) {
+	if a == nil {
+		return
+	}
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.counts[s]++
+}
+
+// Done marks n pieces of work of the given stage as finished, together with all the work they registered.
+// Finishing more work than was registered means a piece of work was marked finished twice, so it panics.
+func (a *Activity) Done(s Stage, n int) {
+	if a == nil || n == 0 {
+		return
+	}
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.counts[s] -= n
+	if a.counts[s] < 0 {
+		panic("more work done than registered")
+	}
+	if a.counts[s] == 0 {
+		a.cond.Broadcast()
+	}
+}
+
+// Wait blocks until there is no work of the given stages, or of any stage if none is given.
+func (a *Activity) Wait(stages ...Stage) {
+	if a == nil {
+		return
+	}
+	if len(stages) == 0 {
+		stages = []Stage{Adding, Ordering}
+	}
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	for !a.idle(stages) {
+		a.cond.Wait()
+	}
+}
+
+func (a *Activity) idle(stages []Stage) bool {
+	for _, s := range stages {
+		if a.counts[s] > 0 {
+			return false
+		}
+	}
+	return true
+}
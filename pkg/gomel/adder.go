@@ -4,6 +4,8 @@ package gomel
 type Adder interface {
 	// AddPreunits adds preunits received from the given process.
 	AddPreunits(uint16, ...Preunit) []error
+	// Backlog returns the number of preunits waiting for their parents and the number of parents that were never received.
+	Backlog() (waiting int, missing int)
 	// Close stops the Adder.
 	Close()
 }
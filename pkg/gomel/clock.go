@@ -0,0 +1,49 @@
+package gomel
+
+import "time"
+
+// Clock tells the time to the parts of a process that wait for something, like choosing parents or pacing units.
+// It allows a driver (like a simulator) to run processes in its own time instead of the real one.
+// All the methods of a nil *Clock use the real time. A driver tracking an Activity registers every timer it fires
+// as a piece of Adding work, which the creator finishes after it handles the timer.
+type Clock struct {
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+	stop  func(<-chan time.Time)
+}
+
+// NewClock returns a Clock telling the time with now and waiting with after, which has to work like time.After.
+// The optional stop is told about channels returned by after that nobody waits on anymore.
+func NewClock(now func() time.Time, after func(time.Duration) <-chan time.Time, stop func(<-chan time.Time)) *Clock {
+	return &Clock{now, after, stop}
+}
+
+// Now returns the current time.
+func (c *Clock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// After returns a channel on which the current time is sent after the given duration.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	if c == nil {
+		return time.After(d)
+	}
+	return c.after(d)
+}
+
+// Stop tells the clock that nobody waits on the given channel, returned by After, anymore.
+// It allows a driver to not fire abandoned timers. A nil channel is ignored.
+func (c *Clock) Stop(ch <-chan time.Time) {
+	if c == nil || c.stop == nil || ch == nil {
+		return
+	}
+	c.stop(ch)
+}
+
+// Until returns the duration until the given time.
+func (c *Clock) Until(t time.Time) time.Duration {
+	return t.Sub(c.Now())
+}
@@ -18,6 +18,8 @@ type Orderer interface {
 	// Delta returns all the units present in orderer that are above heights indicated by provided DagInfo.
 	// That includes also all units from newer epochs.
 	Delta([2]*DagInfo) []Unit
+	// Status returns a snapshot of the state of the orderer.
+	Status() *Status
 	// Start starts the orderer using provided RandomSourceFactory, Syncer, and Alerter.
 	Start(RandomSourceFactory, Syncer, Alerter)
 	Stop()
@@ -15,6 +15,14 @@ type PublicKey interface {
 	Encode() string
 }
 
+// BatchVerifier is implemented by public keys of signature schemes that can check many signatures at once
+// faster than one by one.
+type BatchVerifier interface {
+	// VerifyBatch checks the signatures of the given preunits, all created by the owner of the key,
+	// and reports which of them are correct.
+	VerifyBatch([]Preunit) []bool
+}
+
 // PrivateKey used for signing units.
 type PrivateKey interface {
 	// Sign computes and returns a signature of a preunit.
@@ -22,3 +30,10 @@ type PrivateKey interface {
 	// Encode encodes the private key in base 64.
 	Encode() string
 }
+
+// Signer signs units on behalf of their creator. Unlike a PrivateKey it may live outside of the process,
+// and it may refuse to sign, for example a unit that could be a fork of one it signed before.
+type Signer interface {
+	// SignUnit returns the signature of the preunit, whose own signature is ignored.
+	SignUnit(Preunit) (Signature, error)
+}
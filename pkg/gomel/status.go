@@ -0,0 +1,27 @@
+package gomel
+
+import "time"
+
+// Status is a snapshot of the state of a running process, meant for monitoring.
+type Status struct {
+	Pid        uint16
+	NProc      uint16
+	Epoch      *EpochID     // the current epoch, nil before the process starts
+	Previous   *EpochID     // the previous epoch, nil if there is none
+	MaxHeights []int        // heights of the maximal units of every process in the current epoch, -1 if there are none
+	LastTiming Unit         // the newest timing unit, nil before any is decided
+	Forkers    []uint16     // processes known to be forkers
+	Waiting    int          // preunits waiting in the adders for their parents
+	Missing    int          // units needed by the waiting preunits that were never received
+	Peers      []PeerStatus // health of syncing with other committee members
+	Failure    string       // why the process stopped taking part in the protocol, empty if it did not
+}
+
+// PeerStatus describes how syncing with a single committee member goes.
+type PeerStatus struct {
+	Pid         uint16
+	Successes   int
+	Failures    int       // sessions that did not complete, including failed attempts to connect
+	Consecutive int       // failures since the last successful session
+	LastSuccess time.Time // end of the last successful session, zero if there was none
+}
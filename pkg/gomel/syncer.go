@@ -6,8 +6,14 @@ type Syncer interface {
 	RequestGossip(uint16)
 	// RequestFetch send a request to the given committee member for units with given IDs.
 	RequestFetch(uint16, []uint64)
+	// RequestFetchByHash send a request to the given committee member for units with given hashes,
+	// together with their ancestors at most the given number of generations above.
+	// Units with the given IDs are requested instead if the committee member is unable to fetch units by hash.
+	RequestFetchByHash(uint16, []*Hash, int, []uint64)
 	// Multicast a unit.
 	Multicast(Unit)
+	// Peers reports the health of syncing with every committee member.
+	Peers() []PeerStatus
 	// Start syncer.
 	Start()
 	// Stop syncer.
@@ -0,0 +1,13 @@
+package light_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLight(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Light Suite")
+}
@@ -0,0 +1,138 @@
+// Package light implements verification of the ordered output of a committee for clients that do not run the protocol.
+//
+// A light client knows the public committee file. It receives preblocks with their positions and finality certificates
+// from any untrusted source, for example a stream.Log and a certificate file, and accepts a preblock only once a certificate,
+// signed with the RMC keys of the committee members, proves that the committee agreed on it and on all the preceding preblocks
+// of its epoch. Final certificates, issued at the last level of every epoch, link consecutive epochs.
+//
+// A client that also knows the weak threshold key of the committee, the public outcome of the setup phase (or a fixed
+// seeded key if the setup was skipped), can link consecutive epochs with epoch proofs instead: the signed dealing units
+// starting every epoch, which carry the threshold signature of the end of the previous one.
+package light
+
+import (
+	"strconv"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/creator"
+	"gitlab.com/alephledger/consensus-go/pkg/finality"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/core-go/pkg/crypto/tss"
+)
+
+// Verifier checks a sequence of preblocks against the certificates and epoch proofs of a committee.
+type Verifier struct {
+	committee *config.Committee
+	wtk       *tss.WeakThresholdKey // nil if epoch proofs cannot be verified
+	chain     *finality.Chain
+	last      *stream.Position
+	pending   []stream.Entry
+	heads     []gomel.Hash // heads of the chain after each of the pending entries
+	finalized map[gomel.EpochID]bool
+	proven    map[gomel.EpochID]bool
+}
+
+// New returns a verifier for the given committee. The threshold key of the committee is needed only to verify epoch proofs,
+// without it (nil) epochs are linked by final certificates alone.
+func New(committee *config.Committee, wtk *tss.WeakThresholdKey) *Verifier {
+	return &Verifier{
+		committee: committee,
+		wtk:       wtk,
+		chain:     finality.NewChain(),
+		finalized: make(map[gomel.EpochID]bool),
+		proven:    make(map[gomel.EpochID]bool),
+	}
+}
+
+// VerifyUnit checks if the preunit was created and signed by a member of the committee.
+func (v *Verifier) VerifyUnit(pu gomel.Preunit) error {
+	if int(pu.Creator()) >= len(v.committee.PublicKeys) {
+		return gomel.NewDataError("unit created by a process outside the committee: " + strconv.Itoa(int(pu.Creator())))
+	}
+	if !v.committee.PublicKeys[pu.Creator()].Verify(pu) {
+		return gomel.NewDataError("invalid unit signature")
+	}
+	return nil
+}
+
+// VerifyEpochProof checks if the preunit proves that its epoch started, that is if it is a dealing unit
+// signed by a member of the committee and carrying the threshold signature of the end of the previous epoch.
+// Such proofs are built by the creator at the end of every epoch. It requires the threshold key of the committee.
+func (v *Verifier) VerifyEpochProof(pu gomel.Preunit) error {
+	if v.wtk == nil {
+		return gomel.NewConfigError("verifying epoch proofs requires the threshold key of the committee")
+	}
+	if err := v.VerifyUnit(pu); err != nil {
+		return err
+	}
+	if !creator.EpochProof(pu, v.wtk) {
+		return gomel.NewDataError("invalid proof of the start of epoch " + strconv.Itoa(int(pu.EpochID())))
+	}
+	v.proven[pu.EpochID()] = true
+	return nil
+}
+
+// Add appends the preblock to the chain of the verifier. The preblocks have to come in order, without gaps.
+// A preblock is not trusted until a certificate covering it is passed to Confirm.
+// Preblocks of an epoch are accepted only after a final certificate of the previous epoch or a proof of the start of their own,
+// with the exception of the first epoch seen.
+func (v *Verifier) Add(entry stream.Entry) error {
+	if v.last != nil {
+		sameEpoch := entry.Epoch == v.last.Epoch && entry.Level == v.last.Level+1
+		if !sameEpoch && entry.Epoch != v.last.Epoch+1 {
+			return gomel.NewDataError("preblock out of order at epoch " + strconv.Itoa(int(entry.Epoch)) + " level " + strconv.Itoa(entry.Level))
+		}
+		if sameEpoch && v.finalized[entry.Epoch] {
+			return gomel.NewDataError("preblock after the end of epoch " + strconv.Itoa(int(entry.Epoch)))
+		}
+		if !sameEpoch && !v.finalized[v.last.Epoch] && !v.proven[entry.Epoch] {
+			return gomel.NewDataError("missing final certificate of epoch " + strconv.Itoa(int(v.last.Epoch)) + " or proof of the start of the next one")
+		}
+	}
+	head := v.chain.Append(entry.Position, entry.Preblock)
+	pos := entry.Position
+	v.last = &pos
+	v.pending = append(v.pending, entry)
+	v.heads = append(v.heads, *head)
+	return nil
+}
+
+// Confirm checks the certificate against the chain and returns the preblocks it proves final, in order.
+// These are the added preblocks of the epoch of the certificate, up to its position, that were not confirmed before.
+// Unconfirmed preblocks of older epochs are dropped. A final certificate ends its epoch, letting in the preblocks of the next one.
+func (v *Verifier) Confirm(c *finality.Certificate) ([]stream.Entry, error) {
+	if !c.Verify(v.committee.RMCVerificationKeys) {
+		return nil, gomel.NewDataError("invalid certificate signature")
+	}
+	for i, entry := range v.pending {
+		if entry.Position != c.Position {
+			continue
+		}
+		if v.heads[i] != c.Head {
+			return nil, gomel.NewDataError("certificate does not match the preblocks of epoch " + strconv.Itoa(int(c.Epoch)))
+		}
+		// preblocks of older epochs are covered only by certificates of their own epochs
+		start := i
+		for start > 0 && v.pending[start-1].Epoch == c.Epoch {
+			start--
+		}
+		result := v.pending[start : i+1]
+		v.pending = v.pending[i+1:]
+		v.heads = v.heads[i+1:]
+		if c.Final {
+			// anything added after the end of the epoch could not have been agreed on
+			v.finalized[c.Epoch] = true
+			v.pending, v.heads = nil, nil
+			pos := c.Position
+			v.last = &pos
+		}
+		return result, nil
+	}
+	return nil, gomel.NewDataError("certificate for a position without a pending preblock")
+}
+
+// Pending returns the number of added preblocks that are not yet confirmed.
+func (v *Verifier) Pending() int {
+	return len(v.pending)
+}
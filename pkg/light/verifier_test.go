@@ -0,0 +1,200 @@
+package light_test
+
+import (
+	"encoding/binary"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/finality"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	. "gitlab.com/alephledger/consensus-go/pkg/light"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+	"gitlab.com/alephledger/core-go/pkg/crypto/tss"
+)
+
+const nProc = 4
+
+func entry(epoch gomel.EpochID, level int) stream.Entry {
+	return stream.Entry{Position: stream.Position{Epoch: epoch, Level: level}, Preblock: tests.Preblock(epoch, level)}
+}
+
+// certify returns a certificate of the given entries of a single epoch, at the position of the last one,
+// signed by the first processes holding the given secret keys.
+func certify(secrets []*bn256.SecretKey, final bool, entries ...stream.Entry) *finality.Certificate {
+	chain := finality.NewChain()
+	var head *gomel.Hash
+	for _, e := range entries {
+		head = chain.Append(e.Position, e.Preblock)
+	}
+	pos := entries[len(entries)-1].Position
+	sigs := make(map[uint16]*bn256.Signature)
+	for pid, sk := range secrets[:gomel.MinimalTrusted(nProc)] {
+		sigs[uint16(pid)] = sk.Sign(finality.Message(pos, head, final))
+	}
+	return finality.NewCertificate(pos, *head, final, sigs, nProc)
+}
+
+var _ = Describe("Verifier", func() {
+	var (
+		secrets   []*bn256.SecretKey
+		committee *config.Committee
+		verifier  *Verifier
+	)
+
+	BeforeEach(func() {
+		secrets = make([]*bn256.SecretKey, nProc)
+		committee = &config.Committee{RMCVerificationKeys: make([]*bn256.VerificationKey, nProc)}
+		for pid := 0; pid < nProc; pid++ {
+			committee.RMCVerificationKeys[pid], secrets[pid], _ = bn256.GenerateKeys()
+		}
+		verifier = New(committee, nil)
+	})
+
+	It("should confirm certified preblocks", func() {
+		for l := 0; l < 3; l++ {
+			Expect(verifier.Add(entry(0, l))).To(Succeed())
+		}
+		confirmed, err := verifier.Confirm(certify(secrets, false, entry(0, 0), entry(0, 1)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(confirmed).To(Equal([]stream.Entry{entry(0, 0), entry(0, 1)}))
+		Expect(verifier.Pending()).To(Equal(1))
+	})
+
+	It("should reject a certificate of different preblocks", func() {
+		forged := entry(0, 0)
+		forged.Preblock = core.NewPreblock([]core.Data{core.Data("forged")}, forged.Preblock.RandomBytes)
+		Expect(verifier.Add(forged)).To(Succeed())
+		_, err := verifier.Confirm(certify(secrets, false, entry(0, 0)))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject a certificate signed with other keys", func() {
+		Expect(verifier.Add(entry(0, 0))).To(Succeed())
+		other := make([]*bn256.SecretKey, nProc)
+		for pid := range other {
+			_, other[pid], _ = bn256.GenerateKeys()
+		}
+		_, err := verifier.Confirm(certify(other, false, entry(0, 0)))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject preblocks with a gap", func() {
+		Expect(verifier.Add(entry(0, 0))).To(Succeed())
+		Expect(verifier.Add(entry(0, 2))).NotTo(Succeed())
+	})
+
+	Describe("crossing epochs", func() {
+		BeforeEach(func() {
+			Expect(verifier.Add(entry(0, 0))).To(Succeed())
+			Expect(verifier.Add(entry(0, 1))).To(Succeed())
+		})
+
+		It("should require a final certificate of the previous epoch", func() {
+			Expect(verifier.Add(entry(1, 0))).NotTo(Succeed())
+			_, err := verifier.Confirm(certify(secrets, true, entry(0, 0), entry(0, 1)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verifier.Add(entry(1, 0))).To(Succeed())
+			confirmed, err := verifier.Confirm(certify(secrets, false, entry(1, 0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(confirmed).To(Equal([]stream.Entry{entry(1, 0)}))
+		})
+
+		It("should not end the epoch with a certificate that is not final", func() {
+			_, err := verifier.Confirm(certify(secrets, false, entry(0, 0), entry(0, 1)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verifier.Add(entry(1, 0))).NotTo(Succeed())
+		})
+
+		It("should drop preblocks following the end of the epoch", func() {
+			Expect(verifier.Add(entry(0, 2))).To(Succeed())
+			confirmed, err := verifier.Confirm(certify(secrets, true, entry(0, 0), entry(0, 1)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(confirmed).To(Equal([]stream.Entry{entry(0, 0), entry(0, 1)}))
+			Expect(verifier.Pending()).To(Equal(0))
+			Expect(verifier.Add(entry(0, 2))).NotTo(Succeed())
+			Expect(verifier.Add(entry(1, 0))).To(Succeed())
+		})
+
+		It("should reject skipping an epoch", func() {
+			_, err := verifier.Confirm(certify(secrets, true, entry(0, 0), entry(0, 1)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verifier.Add(entry(2, 0))).NotTo(Succeed())
+		})
+	})
+
+	Describe("with the threshold key", func() {
+		var (
+			wtks  []*tss.WeakThresholdKey
+			privs []gomel.PrivateKey
+		)
+
+		// sign combines a threshold signature of the message from the shares of the first processes.
+		sign := func(msg []byte) *tss.Signature {
+			var shares []*tss.Share
+			for _, wtk := range wtks[:wtks[0].Threshold()] {
+				shares = append(shares, wtk.CreateShare(msg))
+			}
+			sig, ok := wtks[0].CombineShares(shares)
+			Expect(ok).To(BeTrue())
+			return sig
+		}
+
+		// epochProof returns a dealing unit of the given epoch carrying the signed end of the previous one.
+		epochProof := func(epoch gomel.EpochID, creator uint16) gomel.Preunit {
+			msg := make([]byte, 8+gomel.HashLength)
+			binary.LittleEndian.PutUint64(msg, gomel.ID(10, 1, epoch-1))
+			data := append(msg, sign(msg).Marshal()...)
+			return tests.NewPreunitFromEpoch(epoch, creator, gomel.EmptyCrown(nProc), data, nil, privs[creator])
+		}
+
+		BeforeEach(func() {
+			wtks = make([]*tss.WeakThresholdKey, nProc)
+			privs = make([]gomel.PrivateKey, nProc)
+			committee.PublicKeys = make([]gomel.PublicKey, nProc)
+			for pid := 0; pid < nProc; pid++ {
+				wtks[pid] = tss.SeededWTK(nProc, uint16(pid), 1729, nil)
+				committee.PublicKeys[pid], privs[pid], _ = signing.GenerateKeys()
+			}
+			verifier = New(committee, wtks[0])
+			Expect(verifier.Add(entry(0, 0))).To(Succeed())
+		})
+
+		It("should accept the next epoch after a proof of its start", func() {
+			Expect(verifier.Add(entry(1, 0))).NotTo(Succeed())
+			Expect(verifier.VerifyEpochProof(epochProof(1, 2))).To(Succeed())
+			Expect(verifier.Add(entry(1, 0))).To(Succeed())
+			confirmed, err := verifier.Confirm(certify(secrets, false, entry(1, 0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(confirmed).To(Equal([]stream.Entry{entry(1, 0)}))
+		})
+
+		It("should reject a proof signed by a process outside the committee", func() {
+			_, priv, _ := signing.GenerateKeys()
+			privs[2] = priv
+			Expect(verifier.VerifyEpochProof(epochProof(1, 2))).NotTo(Succeed())
+		})
+
+		It("should reject a proof of a wrong epoch", func() {
+			pu := epochProof(1, 2)
+			forged := tests.NewPreunitFromEpoch(2, 2, gomel.EmptyCrown(nProc), pu.Data(), nil, privs[2])
+			Expect(verifier.VerifyEpochProof(forged)).NotTo(Succeed())
+		})
+
+		It("should reject a proof signed with another threshold key", func() {
+			wtks = []*tss.WeakThresholdKey{tss.SeededWTK(nProc, 0, 7, nil), tss.SeededWTK(nProc, 1, 7, nil)}
+			Expect(verifier.VerifyEpochProof(epochProof(1, 2))).NotTo(Succeed())
+		})
+	})
+
+	It("should not verify epoch proofs without the threshold key", func() {
+		_, priv, _ := signing.GenerateKeys()
+		pu := tests.NewPreunitFromEpoch(1, 0, gomel.EmptyCrown(nProc), nil, nil, priv)
+		Expect(verifier.VerifyEpochProof(pu)).NotTo(Succeed())
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/config"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
 )
 
 // Extender is a type that implements an algorithm that extends order of units provided by an instance of a Dag to a linear order.
@@ -66,6 +67,7 @@ func (ext *Extender) NextRound() *TimingRound {
 		decision, decidedOn := decider.DecideUnitIsPopular(dagMaxLevel)
 		if decision == popular {
 			ext.log.Info().Int(lg.Height, decidedOn).Int(lg.Size, dagMaxLevel).Int(lg.Round, level).Msg(lg.NewTimingUnit)
+			metrics.DecisionLevels.Observe(float64(dagMaxLevel - uc.Level()))
 			ext.lastTUs = ext.lastTUs[1:]
 			ext.lastTUs = append(ext.lastTUs, ext.currentTU)
 			ext.currentTU = uc
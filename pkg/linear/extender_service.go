@@ -16,6 +16,7 @@ import (
 type ExtenderService struct {
 	ordering     *Extender
 	pid          uint16
+	activity     *gomel.Activity
 	output       chan<- []gomel.Unit
 	trigger      chan struct{}
 	finished     chan struct{}
@@ -31,6 +32,7 @@ func NewExtenderService(dag gomel.Dag, rs gomel.RandomSource, conf config.Config
 	ext := &ExtenderService{
 		ordering:     ordering,
 		pid:          conf.Pid,
+		activity:     conf.Activity,
 		output:       output,
 		trigger:      make(chan struct{}, 1),
 		finished:     make(chan struct{}),
@@ -49,14 +51,18 @@ func NewExtenderService(dag gomel.Dag, rs gomel.RandomSource, conf config.Config
 func (ext *ExtenderService) Close() {
 	close(ext.finished)
 	ext.wg.Wait()
+	ext.activity.Done(gomel.Ordering, len(ext.trigger))
 	ext.log.Info().Msg(lg.ServiceStopped)
 }
 
 // Notify ExtenderService to attempt choosing next timing units.
 func (ext *ExtenderService) Notify() {
+	ext.activity.Add(gomel.Ordering)
 	select {
 	case ext.trigger <- struct{}{}:
 	default:
+		// a notification is already pending
+		ext.activity.Done(gomel.Ordering, 1)
 	}
 }
 
@@ -69,9 +75,11 @@ func (ext *ExtenderService) timingUnitDecider() {
 		case <-ext.trigger:
 			round := ext.ordering.NextRound()
 			for round != nil {
+				ext.activity.Add(gomel.Ordering)
 				ext.timingRounds <- round
 				round = ext.ordering.NextRound()
 			}
+			ext.activity.Done(gomel.Ordering, 1)
 		case <-ext.finished:
 			close(ext.timingRounds)
 			return
@@ -86,7 +94,9 @@ func (ext *ExtenderService) roundSorter() {
 	defer ext.wg.Done()
 	for round := range ext.timingRounds {
 		units := round.OrderedUnits()
+		ext.activity.Add(gomel.Ordering)
 		ext.output <- units
+		ext.activity.Done(gomel.Ordering, 1)
 		for _, u := range units {
 			ext.log.Debug().Uint16(lg.Creator, u.Creator()).Int(lg.Height, u.Height()).Uint32(lg.Epoch, uint32(u.EpochID())).Msg(lg.UnitOrdered)
 			if u.Creator() == ext.pid {
@@ -27,6 +27,7 @@ const (
 	SendInfo              = "U"
 	GetUnits              = "V"
 	SendUnits             = "W"
+	UnitSigned            = "X"
 	PreblockProduced      = "Y"
 	// Rare events
 	NewEpoch              = "a"
@@ -45,6 +46,17 @@ const (
 	FutureLastTiming      = "n"
 	UnableToRetrieveEpoch = "o"
 	RequestOverload       = "p"
+	EpochReplayed         = "q"
+	RefusedToSign         = "r"
+	LeftCommittee         = "s"
+	CommitteeChanged      = "t"
+	SketchUndecoded       = "u"
+	HashFetchUnsupported  = "v"
+	CertificateMade       = "w"
+	HeadMismatch          = "x"
+	ForeignMulticast      = "y"
+	EncodingUnsupported   = "z"
+	DataTooBig            = "0"
 )
 
 // eventTypeDict maps short event names to human readable form.
@@ -72,6 +84,7 @@ var eventTypeDict = map[string]string{
 	SendInfo:              "sending dag info started",
 	GetUnits:              "receiving preunits started",
 	SendUnits:             "sending units started",
+	UnitSigned:            "signer signed a unit",
 	PreblockProduced:      "new preblock",
 
 	NewEpoch:              "new epoch",
@@ -90,6 +103,17 @@ var eventTypeDict = map[string]string{
 	FutureLastTiming:      "creator received timing unit from newer epoch that he's seen",
 	UnableToRetrieveEpoch: "unable to retrieve an epoch",
 	RequestOverload:       "sync server overloaded with requests",
+	EpochReplayed:         "units of an epoch replayed from the store",
+	RefusedToSign:         "creator refused to sign a unit that could be a fork of a unit signed earlier",
+	LeftCommittee:         "this process is not a member of the committee of the epoch",
+	CommitteeChanged:      "committee change agreed for the next epoch",
+	SketchUndecoded:       "gossip sketch too small to decode the difference, sending all units above heights",
+	HashFetchUnsupported:  "peer runs an old version of fetch, unable to request units by hash, requesting them by ID if possible",
+	CertificateMade:       "finality certificate created",
+	HeadMismatch:          "received a signature for a different head of the preblock chain",
+	ForeignMulticast:      "multicasted a unit of another process",
+	EncodingUnsupported:   "peer runs an old version of the protocol, unable to encode the unit for it",
+	DataTooBig:            "data from the data source too big for any unit, dropped",
 }
 
 // Field names.
@@ -100,6 +124,7 @@ const (
 	ID                = "D"
 	Epoch             = "E"
 	ControlHash       = "F"
+	Domain            = "G"
 	Height            = "H"
 	ISID              = "I"
 	WTKShareProviders = "J"
@@ -123,6 +148,7 @@ var fieldNameDict = map[string]string{
 	ID:                "ID",
 	Epoch:             "epoch",
 	ControlHash:       "hash",
+	Domain:            "domain",
 	Height:            "height",
 	ISID:              "inSID",
 	WTKShareProviders: "wtkSP",
@@ -150,6 +176,11 @@ const (
 	RMCService
 	AlertService
 	NetworkService
+	AdminService
+	FinalityService
+	MempoolService
+	SignerService
+	StreamService
 )
 
 // serviceTypeDict maps integer service types to human readable names.
@@ -164,6 +195,11 @@ var serviceTypeDict = map[int]string{
 	RMCService:      "RMC",
 	AlertService:    "ALERT",
 	NetworkService:  "NETWORK",
+	AdminService:    "ADMIN",
+	FinalityService: "FINALITY",
+	MempoolService:  "MEMPOOL",
+	SignerService:   "SIGNER",
+	StreamService:   "STREAM",
 }
 
 // Genesis was better with Phil Collins.
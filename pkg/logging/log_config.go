@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -18,6 +19,11 @@ import (
 
 var genesis time.Time
 
+var (
+	outputsMx sync.Mutex
+	outputs   = make(map[string]io.Writer) // outputs of the loggers created so far, by the name of the file
+)
+
 func init() {
 	// store the beginning of time
 	genesis = time.Now()
@@ -41,6 +47,7 @@ func init() {
 }
 
 // NewLogger creates a new zerolog logger based on the given configuration values.
+// Loggers created for the same file share its output, so services started next to a process can write to the log of that process.
 func NewLogger(conf config.Config) (zerolog.Logger, error) {
 	var filename string
 	if conf.LogHuman {
@@ -49,6 +56,12 @@ func NewLogger(conf config.Config) (zerolog.Logger, error) {
 		filename = conf.LogFile + ".json"
 	}
 
+	outputsMx.Lock()
+	defer outputsMx.Unlock()
+	if output, ok := outputs[filename]; ok {
+		return zerolog.New(output).With().Timestamp().Logger().Level(zerolog.Level(conf.LogLevel)), nil
+	}
+
 	var output io.Writer
 	output, err := os.Create(filename)
 	if err != nil {
@@ -67,6 +80,7 @@ func NewLogger(conf config.Config) (zerolog.Logger, error) {
 		})
 	}
 
+	outputs[filename] = output
 	log := zerolog.New(output).With().Timestamp().Logger().Level(zerolog.Level(conf.LogLevel))
 	log.Log().Str(Genesis, genesis.Format(time.RFC1123Z)).Msg(Genesis)
 
@@ -0,0 +1,259 @@
+// Package mempool implements a DataSource that batches transactions submitted by clients into the data of units.
+//
+// Transactions wait in a queue until the creator asks for data, then they are in flight until they show up in an ordered preblock.
+// The mempool has to be told about every ordered preblock. Transactions found in them are dropped, and duplicates
+// submitted later are rejected for a while. Units of an epoch that did not get ordered before the end of the epoch
+// never will be, so our transactions still in flight once a later epoch is ordered are put back at the front of the queue.
+package mempool
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/sha3"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+// keepEpochs is the number of epochs during which an ordered transaction is remembered to reject its duplicates.
+const keepEpochs = 2
+
+var (
+	// ErrEmpty is returned when submitting a transaction without any data.
+	ErrEmpty = errors.New("empty transaction")
+	// ErrTooBig is returned when submitting a transaction that does not fit in a single batch.
+	ErrTooBig = errors.New("transaction too big")
+	// ErrDuplicate is returned when submitting a transaction that is already in the mempool or was recently ordered.
+	ErrDuplicate = errors.New("duplicate transaction")
+	// ErrFull is returned when the waiting transactions already take all the space of the mempool.
+	ErrFull = errors.New("mempool full")
+)
+
+type tx struct {
+	data  []byte
+	hash  gomel.Hash
+	seq   uint64        // the order of submission
+	epoch gomel.EpochID // the epoch of the unit the transaction was put in
+}
+
+// Mempool keeps transactions until they get ordered.
+type Mempool struct {
+	maxBatch int
+	maxSize  int
+	mx       sync.Mutex
+	queue    []*tx
+	waiting  map[gomel.Hash]*tx
+	size     int // total size of the waiting transactions
+	inflight map[gomel.Hash]*tx
+	ordered  map[gomel.Hash]gomel.EpochID
+	seq      uint64
+	epoch    gomel.EpochID // the newest epoch seen in the ordered preblocks
+}
+
+// New returns a mempool producing batches of at most maxBatch bytes and keeping at most maxSize bytes of waiting transactions.
+func New(maxBatch, maxSize int) *Mempool {
+	return &Mempool{
+		maxBatch: maxBatch,
+		maxSize:  maxSize,
+		waiting:  make(map[gomel.Hash]*tx),
+		inflight: make(map[gomel.Hash]*tx),
+		ordered:  make(map[gomel.Hash]gomel.EpochID),
+	}
+}
+
+// Submit adds the transaction to the mempool.
+func (mp *Mempool) Submit(data []byte) error {
+	if len(data) == 0 {
+		return ErrEmpty
+	}
+	if 4+len(data) > mp.maxBatch {
+		return ErrTooBig
+	}
+	t := &tx{data: append([]byte(nil), data...), hash: Hash(data)}
+	mp.mx.Lock()
+	defer mp.mx.Unlock()
+	if mp.known(&t.hash) {
+		return ErrDuplicate
+	}
+	if mp.size+len(data) > mp.maxSize {
+		return ErrFull
+	}
+	t.seq = mp.seq
+	mp.seq++
+	mp.queue = append(mp.queue, t)
+	mp.waiting[t.hash] = t
+	mp.size += len(data)
+	return nil
+}
+
+// GetData returns a batch of the oldest waiting transactions, empty if there are none.
+// It does not know the epoch of the unit the batch is put in, so it assumes the epoch following the newest ordered one.
+func (mp *Mempool) GetData() core.Data {
+	mp.mx.Lock()
+	defer mp.mx.Unlock()
+	return mp.batch(mp.epoch+1, mp.maxBatch)
+}
+
+// GetDataUpTo returns a batch of the oldest waiting transactions for a unit of the given epoch,
+// of at most maxBytes bytes and at most the batch size of the mempool.
+func (mp *Mempool) GetDataUpTo(epoch gomel.EpochID, maxBytes int) core.Data {
+	if maxBytes > mp.maxBatch {
+		maxBytes = mp.maxBatch
+	}
+	mp.mx.Lock()
+	defer mp.mx.Unlock()
+	return mp.batch(epoch, maxBytes)
+}
+
+// batch takes the oldest waiting transactions of at most maxBytes bytes in total for a unit of the given epoch.
+// This method must be called under mutex!
+func (mp *Mempool) batch(epoch gomel.EpochID, maxBytes int) core.Data {
+	var batch [][]byte
+	size := 0
+	for len(mp.queue) > 0 {
+		t := mp.queue[0]
+		if mp.waiting[t.hash] != t {
+			// ordered in a unit of another process while waiting
+			mp.queue = mp.queue[1:]
+			continue
+		}
+		if size+4+len(t.data) > maxBytes {
+			break
+		}
+		mp.queue = mp.queue[1:]
+		delete(mp.waiting, t.hash)
+		mp.size -= len(t.data)
+		t.epoch = epoch
+		mp.inflight[t.hash] = t
+		batch = append(batch, t.data)
+		size += 4 + len(t.data)
+	}
+	return Encode(batch)
+}
+
+// Ordered removes the transactions contained in the preblock at the given position from the mempool.
+// It has to be called for every preblock produced by the process, in order.
+func (mp *Mempool) Ordered(pos stream.Position, pb *core.Preblock) {
+	mp.mx.Lock()
+	defer mp.mx.Unlock()
+	for _, data := range pb.Data {
+		// data of other processes may come from a different source, we do not care about it
+		txs, err := Decode(data)
+		if err != nil {
+			continue
+		}
+		for _, d := range txs {
+			h := Hash(d)
+			mp.ordered[h] = pos.Epoch
+			delete(mp.inflight, h)
+			if t, ok := mp.waiting[h]; ok {
+				delete(mp.waiting, h)
+				mp.size -= len(t.data)
+			}
+		}
+	}
+	if pos.Epoch > mp.epoch {
+		mp.epoch = pos.Epoch
+		mp.requeue()
+	}
+}
+
+// Pending returns the size of a batch with all the waiting transactions.
+func (mp *Mempool) Pending() int {
+	mp.mx.Lock()
+	defer mp.mx.Unlock()
+	return mp.size + 4*len(mp.waiting)
+}
+
+// Len returns the number of waiting and in-flight transactions.
+func (mp *Mempool) Len() int {
+	mp.mx.Lock()
+	defer mp.mx.Unlock()
+	return len(mp.waiting) + len(mp.inflight)
+}
+
+// requeue puts the in-flight transactions from units of epochs older than the newest ordered one back in the queue,
+// and forgets old ordered transactions.
+// This method must be called under mutex!
+func (mp *Mempool) requeue() {
+	var lost []*tx
+	for h, t := range mp.inflight {
+		if t.epoch < mp.epoch {
+			delete(mp.inflight, h)
+			lost = append(lost, t)
+		}
+	}
+	if len(lost) > 0 {
+		sort.Slice(lost, func(i, j int) bool { return lost[i].seq < lost[j].seq })
+		queue := make([]*tx, 0, len(lost)+len(mp.queue))
+		for _, t := range lost {
+			queue = append(queue, t)
+			mp.waiting[t.hash] = t
+			mp.size += len(t.data)
+		}
+		mp.queue = append(queue, mp.queue...)
+	}
+	for h, epoch := range mp.ordered {
+		if epoch+keepEpochs < mp.epoch {
+			delete(mp.ordered, h)
+		}
+	}
+}
+
+// known checks if a transaction with the given hash is waiting, in flight or was recently ordered.
+// This method must be called under mutex!
+func (mp *Mempool) known(h *gomel.Hash) bool {
+	if _, ok := mp.waiting[*h]; ok {
+		return true
+	}
+	if _, ok := mp.inflight[*h]; ok {
+		return true
+	}
+	_, ok := mp.ordered[*h]
+	return ok
+}
+
+// Hash returns the hash identifying the transaction.
+func Hash(data []byte) gomel.Hash {
+	var result gomel.Hash
+	sha3.ShakeSum128(result[:], data)
+	return result
+}
+
+// Encode puts the transactions in a single piece of data, each one preceded by its length.
+func Encode(txs [][]byte) core.Data {
+	size := 0
+	for _, t := range txs {
+		size += 4 + len(t)
+	}
+	result := make([]byte, 0, size)
+	buf := make([]byte, 4)
+	for _, t := range txs {
+		binary.LittleEndian.PutUint32(buf, uint32(len(t)))
+		result = append(result, buf...)
+		result = append(result, t...)
+	}
+	return core.Data(result)
+}
+
+// Decode splits the data produced by Encode into transactions.
+func Decode(data core.Data) ([][]byte, error) {
+	var result [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("malformed transaction batch")
+		}
+		n := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if n == 0 || uint64(n) > uint64(len(data)) {
+			return nil, errors.New("malformed transaction batch")
+		}
+		result = append(result, []byte(data[:n]))
+		data = data[n:]
+	}
+	return result, nil
+}
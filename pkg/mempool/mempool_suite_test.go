@@ -0,0 +1,13 @@
+package mempool_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMempool(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Mempool Suite")
+}
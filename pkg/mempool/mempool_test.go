@@ -0,0 +1,120 @@
+package mempool_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/creator"
+	. "gitlab.com/alephledger/consensus-go/pkg/mempool"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+func decode(data core.Data) []string {
+	txs, err := Decode(data)
+	Expect(err).NotTo(HaveOccurred())
+	var result []string
+	for _, t := range txs {
+		result = append(result, string(t))
+	}
+	return result
+}
+
+func preblock(txs ...string) *core.Preblock {
+	var batch [][]byte
+	for _, t := range txs {
+		batch = append(batch, []byte(t))
+	}
+	return core.NewPreblock([]core.Data{Encode(batch), core.Data("not a batch")}, nil)
+}
+
+var _ = Describe("Mempool", func() {
+	var mp *Mempool
+
+	BeforeEach(func() {
+		mp = New(20, 30)
+	})
+
+	It("should batch transactions in the order of submission up to the limit", func() {
+		Expect(mp.Submit([]byte("first"))).To(Succeed())
+		Expect(mp.Submit([]byte("second"))).To(Succeed())
+		Expect(mp.Submit([]byte("third"))).To(Succeed())
+		Expect(decode(mp.GetData())).To(Equal([]string{"first", "second"}))
+		Expect(decode(mp.GetData())).To(Equal([]string{"third"}))
+		Expect(mp.GetData()).To(BeEmpty())
+	})
+
+	It("should report the pending data and respect a smaller limit of a batch", func() {
+		var _ creator.BatchingDataSource = mp
+		Expect(mp.Pending()).To(Equal(0))
+		Expect(mp.Submit([]byte("first"))).To(Succeed())
+		Expect(mp.Submit([]byte("second"))).To(Succeed())
+		Expect(mp.Pending()).To(Equal(19))
+		Expect(mp.GetDataUpTo(0, 8)).To(BeEmpty())
+		Expect(decode(mp.GetDataUpTo(0, 12))).To(Equal([]string{"first"}))
+		Expect(mp.Pending()).To(Equal(10))
+		Expect(decode(mp.GetDataUpTo(0, 100))).To(Equal([]string{"second"}))
+		Expect(mp.Pending()).To(Equal(0))
+	})
+
+	It("should reject invalid and excessive transactions", func() {
+		Expect(mp.Submit(nil)).To(Equal(ErrEmpty))
+		Expect(mp.Submit(make([]byte, 17))).To(Equal(ErrTooBig))
+		Expect(mp.Submit(make([]byte, 16))).To(Succeed())
+		Expect(mp.Submit([]byte("0123456789abcd"))).To(Succeed())
+		Expect(mp.Submit([]byte("more"))).To(Equal(ErrFull))
+	})
+
+	It("should reject duplicates", func() {
+		Expect(mp.Submit([]byte("tx"))).To(Succeed())
+		Expect(mp.Submit([]byte("tx"))).To(Equal(ErrDuplicate))
+		mp.GetData()
+		Expect(mp.Submit([]byte("tx"))).To(Equal(ErrDuplicate))
+		mp.Ordered(stream.Position{}, preblock("tx"))
+		Expect(mp.Submit([]byte("tx"))).To(Equal(ErrDuplicate))
+	})
+
+	It("should drop transactions ordered in units of other processes", func() {
+		Expect(mp.Submit([]byte("mine"))).To(Succeed())
+		Expect(mp.Submit([]byte("theirs"))).To(Succeed())
+		mp.Ordered(stream.Position{}, preblock("theirs"))
+		Expect(decode(mp.GetData())).To(Equal([]string{"mine"}))
+		mp.Ordered(stream.Position{Level: 1}, preblock("mine"))
+		Expect(mp.Len()).To(BeZero())
+	})
+
+	It("should include again transactions from units that were not ordered", func() {
+		Expect(mp.Submit([]byte("lost"))).To(Succeed())
+		Expect(mp.Submit([]byte("ordered"))).To(Succeed())
+		Expect(decode(mp.GetData())).To(Equal([]string{"lost", "ordered"}))
+		Expect(mp.Submit([]byte("later"))).To(Succeed())
+		mp.Ordered(stream.Position{Level: 3}, preblock("ordered"))
+		mp.Ordered(stream.Position{Epoch: 1}, preblock())
+		Expect(decode(mp.GetData())).To(Equal([]string{"later"}))
+		Expect(mp.Submit([]byte("new"))).To(Succeed())
+		mp.Ordered(stream.Position{Epoch: 2}, preblock())
+		Expect(decode(mp.GetData())).To(Equal([]string{"lost", "new"}))
+	})
+
+	It("should include again transactions only after the epoch of their unit was ordered", func() {
+		Expect(mp.Submit([]byte("early"))).To(Succeed())
+		Expect(mp.Submit([]byte("ahead"))).To(Succeed())
+		Expect(decode(mp.GetDataUpTo(0, 10))).To(Equal([]string{"early"}))
+		Expect(decode(mp.GetDataUpTo(2, 10))).To(Equal([]string{"ahead"}))
+		mp.Ordered(stream.Position{Epoch: 1}, preblock())
+		Expect(decode(mp.GetData())).To(Equal([]string{"early"}))
+		mp.Ordered(stream.Position{Epoch: 1, Level: 1}, preblock("early"))
+		mp.Ordered(stream.Position{Epoch: 2}, preblock())
+		Expect(mp.GetData()).To(BeEmpty())
+		mp.Ordered(stream.Position{Epoch: 3}, preblock())
+		Expect(decode(mp.GetData())).To(Equal([]string{"ahead"}))
+	})
+
+	It("should forget ordered transactions after a few epochs", func() {
+		mp.Ordered(stream.Position{}, preblock("tx"))
+		mp.Ordered(stream.Position{Epoch: 2}, preblock())
+		Expect(mp.Submit([]byte("tx"))).To(Equal(ErrDuplicate))
+		mp.Ordered(stream.Position{Epoch: 3}, preblock())
+		Expect(mp.Submit([]byte("tx"))).To(Succeed())
+	})
+})
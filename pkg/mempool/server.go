@@ -0,0 +1,280 @@
+package mempool
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/network/socket"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+// writeTimeout bounds the time we wait for a client to read a response.
+const writeTimeout = 5 * time.Second
+
+// queueSize bounds the number of lines waiting to be written to a single client.
+// A client falling further behind is disconnected, so a stuck client cannot hold up the others.
+const queueSize = 1024
+
+// Server accepts transactions from local clients with a simple line protocol.
+//
+// A client sends one base64 encoded transaction per line. For every one of them it receives a line
+//
+//	ok <hash>             the transaction was accepted by the mempool
+//	error <hash> <reason> the transaction was rejected
+//
+// followed, for accepted ones, by a line
+//
+//	ordered <hash> <epoch> <level> <index>
+//
+// once the transaction appears in a preblock, where index is its position among all the transactions of that preblock.
+// Hashes are hex encoded, see Hash. Notifications come only while the connection stays open.
+type Server struct {
+	addr     string
+	mp       *Mempool
+	ln       net.Listener
+	mx       sync.Mutex
+	watchers map[gomel.Hash][]*client
+	clients  map[*client]bool
+	wg       sync.WaitGroup
+	log      zerolog.Logger
+}
+
+// client is a single connection of the server. Lines for the client are queued and written by a separate goroutine.
+type client struct {
+	conn   net.Conn
+	mx     sync.Mutex // keeps the order of the lines queued by different goroutines
+	out    chan string
+	closed bool
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{conn: conn, out: make(chan string, queueSize)}
+}
+
+func (c *client) send(format string, args ...interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.queue(format, args...)
+}
+
+// queue puts a line in the queue of the client, disconnecting the client if the queue is full.
+// This method must be called under the mutex of the client!
+func (c *client) queue(format string, args ...interface{}) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.out <- fmt.Sprintf(format, args...):
+	default:
+		c.conn.Close()
+	}
+}
+
+// close stops accepting lines for the client. The ones already queued are still written.
+func (c *client) close() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.out)
+	}
+}
+
+// writeAll writes the queued lines to the connection, and closes it once the queue is closed or a write fails.
+func (c *client) writeAll() {
+	defer c.conn.Close()
+	for line := range c.out {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintln(c.conn, line); err != nil {
+			return
+		}
+	}
+}
+
+// NewServer returns a server submitting transactions to the given mempool.
+// The address is either a TCP address or a path of a unix socket prefixed with socket.UnixPrefix.
+func NewServer(addr string, mp *Mempool, log zerolog.Logger) *Server {
+	return &Server{
+		addr:     addr,
+		mp:       mp,
+		watchers: make(map[gomel.Hash][]*client),
+		clients:  make(map[*client]bool),
+		log:      log.With().Int(lg.Service, lg.MempoolService).Logger(),
+	}
+}
+
+// Start listens on the address of the server and serves clients in the background.
+func (s *Server) Start() error {
+	ln, err := socket.Listen(s.addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	s.wg.Add(1)
+	go s.accept()
+	s.log.Info().Msg(lg.ServiceStarted)
+	return nil
+}
+
+// Stop closes the server together with all the connections.
+func (s *Server) Stop() {
+	s.ln.Close()
+	s.mx.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mx.Unlock()
+	s.wg.Wait()
+	s.log.Info().Msg(lg.ServiceStopped)
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Ordered passes the preblock to the mempool and notifies the clients waiting for its transactions.
+// It has to be called for every preblock produced by the process, in order.
+func (s *Server) Ordered(pos stream.Position, pb *core.Preblock) {
+	s.mp.Ordered(pos, pb)
+	for _, n := range s.notifications(pb) {
+		n.client.send("ordered %s %d %d %d", hex.EncodeToString(n.hash[:]), pos.Epoch, pos.Level, n.index)
+	}
+}
+
+// notification tells a client that a transaction was ordered at the given index of a preblock.
+type notification struct {
+	client *client
+	hash   gomel.Hash
+	index  int
+}
+
+// notifications collects the notifications about the transactions of the preblock and stops watching these transactions.
+func (s *Server) notifications(pb *core.Preblock) []notification {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if len(s.watchers) == 0 {
+		return nil
+	}
+	var result []notification
+	index := 0
+	for _, data := range pb.Data {
+		txs, err := Decode(data)
+		if err != nil {
+			continue
+		}
+		for _, t := range txs {
+			h := Hash(t)
+			for _, c := range s.watchers[h] {
+				result = append(result, notification{c, h, index})
+			}
+			delete(s.watchers, h)
+			index++
+		}
+	}
+	return result
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := newClient(conn)
+		s.mx.Lock()
+		s.clients[c] = true
+		s.mx.Unlock()
+		s.wg.Add(2)
+		go func() {
+			defer s.wg.Done()
+			c.writeAll()
+		}()
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c *client) {
+	defer s.wg.Done()
+	defer s.drop(c)
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(nil, base64.StdEncoding.EncodedLen(s.mp.maxBatch)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			c.send("error - malformed base64")
+			continue
+		}
+		h := Hash(data)
+		hash := hex.EncodeToString(h[:])
+		// we start watching before submitting, so that we do not miss the transaction if it gets ordered right away
+		s.mx.Lock()
+		s.watchers[h] = append(s.watchers[h], c)
+		s.mx.Unlock()
+		// the acknowledgement has to reach the client before the notification
+		c.mx.Lock()
+		err = s.mp.Submit(data)
+		if err == nil {
+			c.queue("ok %s", hash)
+		}
+		c.mx.Unlock()
+		if err != nil {
+			s.unwatch(h, c)
+			c.send("error %s %s", hash, err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		c.send("error - %s", err.Error())
+	}
+}
+
+// unwatch stops sending the notification about the given transaction to the client.
+func (s *Server) unwatch(h gomel.Hash, c *client) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.removeWatcher(h, c)
+}
+
+// drop closes the connection, once the queued lines are written, and forgets all the notifications the client was waiting for.
+func (s *Server) drop(c *client) {
+	c.close()
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.clients, c)
+	for h := range s.watchers {
+		s.removeWatcher(h, c)
+	}
+}
+
+// removeWatcher removes the client from the ones waiting for the given transaction.
+// This method must be called under mutex!
+func (s *Server) removeWatcher(h gomel.Hash, c *client) {
+	ws := s.watchers[h]
+	for i, w := range ws {
+		if w == c {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(s.watchers, h)
+	} else {
+		s.watchers[h] = ws
+	}
+}
@@ -0,0 +1,77 @@
+package mempool_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+
+	. "gitlab.com/alephledger/consensus-go/pkg/mempool"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		mp     *Mempool
+		server *Server
+		conn   net.Conn
+		lines  *bufio.Scanner
+	)
+
+	hash := func(tx string) string {
+		h := Hash([]byte(tx))
+		return hex.EncodeToString(h[:])
+	}
+
+	submit := func(tx string) {
+		fmt.Fprintln(conn, base64.StdEncoding.EncodeToString([]byte(tx)))
+	}
+
+	next := func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		Expect(lines.Scan()).To(BeTrue())
+		return lines.Text()
+	}
+
+	BeforeEach(func() {
+		mp = New(100, 1000)
+		server = NewServer("127.0.0.1:0", mp, zerolog.Nop())
+		Expect(server.Start()).To(Succeed())
+		var err error
+		conn, err = net.Dial("tcp", server.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		lines = bufio.NewScanner(conn)
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	It("should acknowledge transactions and notify when they are ordered", func() {
+		submit("first")
+		submit("second")
+		Expect(next()).To(Equal("ok " + hash("first")))
+		Expect(next()).To(Equal("ok " + hash("second")))
+		server.Ordered(stream.Position{Epoch: 1, Level: 2}, preblock("other", "second", "first"))
+		Expect(next()).To(Equal("ordered " + hash("second") + " 1 2 1"))
+		Expect(next()).To(Equal("ordered " + hash("first") + " 1 2 2"))
+		Expect(mp.Len()).To(BeZero())
+	})
+
+	It("should report rejected transactions", func() {
+		submit("tx")
+		Expect(next()).To(Equal("ok " + hash("tx")))
+		submit("tx")
+		Expect(next()).To(Equal("error " + hash("tx") + " " + ErrDuplicate.Error()))
+		fmt.Fprintln(conn, "not base64!")
+		Expect(next()).To(HavePrefix("error - "))
+	})
+})
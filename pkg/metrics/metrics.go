@@ -0,0 +1,147 @@
+// Package metrics collects statistics about the internals of the protocol and exposes them to Prometheus.
+//
+// The collectors are global, like the logger, so the components update them directly. They cost little
+// when nobody scrapes them. Serve Handler on some HTTP endpoint to make them available.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gomel"
+
+var (
+	// UnitsCreated counts units created by this process.
+	UnitsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "units_created_total",
+		Help:      "Number of units created by this process.",
+	})
+	// UnitsAdded counts units added to the dags, per creator.
+	UnitsAdded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "units_added_total",
+		Help:      "Number of units added to the dags, per creator.",
+	}, []string{"creator"})
+	// AdderWaiting is the number of preunits waiting in the adders.
+	AdderWaiting = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "adder_waiting",
+		Help:      "Number of preunits waiting in the adders to be added to the dags.",
+	})
+	// AdderMissing is the number of units the adders know of, but never received.
+	AdderMissing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "adder_missing",
+		Help:      "Number of units needed as parents by waiting preunits, but never received.",
+	})
+	// SyncDuration measures sync sessions, per protocol and direction.
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of sync sessions.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"protocol", "direction"})
+	// BytesSent counts bytes written to connections, per protocol.
+	BytesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sent_bytes_total",
+		Help:      "Number of bytes written to connections.",
+	}, []string{"protocol"})
+	// BytesReceived counts bytes read from connections, per protocol.
+	BytesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "received_bytes_total",
+		Help:      "Number of bytes read from connections.",
+	}, []string{"protocol"})
+	// DecisionLevels measures how many levels above a timing unit the dag has grown by the time the unit is decided.
+	DecisionLevels = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "timing_decision_levels",
+		Help:      "Difference between the maximal level of the dag and the level of a timing unit when the unit is decided.",
+		Buckets:   prometheus.LinearBuckets(1, 1, 12),
+	})
+	// Preblocks counts produced preblocks, its rate gives preblocks per second.
+	Preblocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "preblocks_total",
+		Help:      "Number of preblocks produced.",
+	})
+	// Epochs counts epochs entered by this process.
+	Epochs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "epochs_total",
+		Help:      "Number of epochs entered.",
+	})
+	// CurrentEpoch is the newest epoch entered by this process.
+	CurrentEpoch = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "epoch",
+		Help:      "The newest epoch entered.",
+	})
+	// ForkAlerts counts alerts raised by this process about forks it detected.
+	ForkAlerts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fork_alerts_total",
+		Help:      "Number of alerts raised about detected forks.",
+	})
+)
+
+// Registry holds all the collectors of this package, together with the standard Go and process collectors.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		UnitsCreated,
+		UnitsAdded,
+		AdderWaiting,
+		AdderMissing,
+		SyncDuration,
+		BytesSent,
+		BytesReceived,
+		DecisionLevels,
+		Preblocks,
+		Epochs,
+		CurrentEpoch,
+		ForkAlerts,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler returns an HTTP handler serving the metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve starts an HTTP server exposing the metrics under /metrics on the given address.
+// Returns the server, so it can be shut down, and the channel on which the error ending the server is sent.
+func Serve(addr string) (*http.Server, <-chan error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	return srv, errc
+}
+
+// UnitAdded records a unit of the given creator added to a dag.
+func UnitAdded(creator uint16) {
+	UnitsAdded.WithLabelValues(strconv.Itoa(int(creator))).Inc()
+}
+
+// TimeSync starts measuring a sync session of the given protocol and direction.
+// The returned function ends the measurement, it is meant to be deferred.
+func TimeSync(protocol, direction string) func() {
+	start := time.Now()
+	return func() {
+		SyncDuration.WithLabelValues(protocol, direction).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,86 @@
+package metrics_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
+	"gitlab.com/alephledger/consensus-go/pkg/network/mem"
+	"gitlab.com/alephledger/core-go/pkg/network"
+)
+
+var _ = Describe("Metrics", func() {
+
+	Describe("CountBytes", func() {
+
+		var (
+			servs []network.Server
+		)
+
+		BeforeEach(func() {
+			nw := mem.NewNetwork(mem.Faults{})
+			addresses := []string{"a", "b"}
+			servs = make([]network.Server, len(addresses))
+			for i, address := range addresses {
+				serv, err := nw.NewServer(address, addresses)
+				Expect(err).NotTo(HaveOccurred())
+				servs[i] = metrics.CountBytes(serv, "test")
+			}
+		})
+
+		AfterEach(func() {
+			for _, s := range servs {
+				s.Stop()
+			}
+		})
+
+		It("should count the bytes passing through connections", func() {
+			sent := testutil.ToFloat64(metrics.BytesSent.WithLabelValues("test"))
+			received := testutil.ToFloat64(metrics.BytesReceived.WithLabelValues("test"))
+
+			out, err := servs[0].Dial(1)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = out.Write([]byte("hello"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Flush()).To(Succeed())
+			Expect(out.Close()).To(Succeed())
+
+			in, err := servs[1].Listen()
+			Expect(err).NotTo(HaveOccurred())
+			data, err := ioutil.ReadAll(in)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("hello"))
+
+			Expect(testutil.ToFloat64(metrics.BytesSent.WithLabelValues("test"))).To(Equal(sent + 5))
+			Expect(testutil.ToFloat64(metrics.BytesReceived.WithLabelValues("test"))).To(Equal(received + 5))
+		})
+	})
+
+	Describe("TimeSync", func() {
+
+		It("should record a session", func() {
+			before := testutil.CollectAndCount(metrics.SyncDuration)
+			metrics.TimeSync("test", "in")()
+			Expect(testutil.CollectAndCount(metrics.SyncDuration)).To(Equal(before + 1))
+		})
+	})
+
+	Describe("Handler", func() {
+
+		It("should expose the metrics in the Prometheus format", func() {
+			metrics.UnitAdded(3)
+			metrics.Preblocks.Inc()
+			rec := httptest.NewRecorder()
+			metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			Expect(rec.Code).To(Equal(200))
+			body := rec.Body.String()
+			Expect(body).To(ContainSubstring(`gomel_units_added_total{creator="3"}`))
+			Expect(body).To(ContainSubstring("gomel_preblocks_total"))
+			Expect(body).To(ContainSubstring("go_goroutines"))
+		})
+	})
+})
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/alephledger/core-go/pkg/network"
+)
+
+type countingServer struct {
+	network.Server
+	sent, received prometheus.Counter
+}
+
+// CountBytes wraps the given network server, so that the bytes passing through its connections are counted
+// in BytesSent and BytesReceived under the given protocol.
+func CountBytes(netserv network.Server, protocol string) network.Server {
+	return &countingServer{
+		Server:   netserv,
+		sent:     BytesSent.WithLabelValues(protocol),
+		received: BytesReceived.WithLabelValues(protocol),
+	}
+}
+
+func (s *countingServer) Dial(pid uint16) (network.Connection, error) {
+	conn, err := s.Server.Dial(pid)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, s}, nil
+}
+
+func (s *countingServer) Listen() (network.Connection, error) {
+	conn, err := s.Server.Listen()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, s}, nil
+}
+
+type countingConn struct {
+	network.Connection
+	serv *countingServer
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Connection.Read(b)
+	c.serv.received.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Connection.Write(b)
+	c.serv.sent.Add(float64(n))
+	return n, err
+}
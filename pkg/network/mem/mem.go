@@ -0,0 +1,345 @@
+// Package mem implements network servers that connect goroutines of a single process instead of machines.
+//
+// Servers belong to a Network and find each other by their addresses, so a whole committee can run inside one process
+// without sockets or ports. A Network can inject faults into the connections: latency, reordering and drops.
+// The servers are selected in the config with the "mem" network type and then use the Default network.
+package mem
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"gitlab.com/alephledger/core-go/pkg/network"
+)
+
+// listenTimeout bounds the time Listen waits for an incoming connection, like a deadline on a socket would.
+const listenTimeout = time.Second
+
+var (
+	errClosed  = errors.New("connection closed")
+	errReset   = errors.New("connection reset")
+	errTimeout = errors.New("connection timed out")
+	errStopped = errors.New("server stopped")
+	errNoConn  = errors.New("no incoming connection")
+)
+
+// Faults describe the misbehaviour of a Network.
+// Every flushed chunk of data is delayed by Latency plus a random duration shorter than Jitter.
+// Data within a single connection is never reordered, but chunks sent over different connections can overtake each other.
+// With probability Drop a flushed chunk is lost, and the connection is reset for the receiving side.
+// Seed makes the choices of the Network repeatable.
+type Faults struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Drop    float64
+	Seed    int64
+}
+
+// Network is a set of in-memory servers that can dial each other by addresses.
+type Network struct {
+	mx      sync.Mutex
+	servers map[string]*server
+	faults  Faults
+	rand    *rand.Rand
+}
+
+// Default is the network used by servers selected in the config with the "mem" network type.
+var Default = NewNetwork(Faults{})
+
+// NewNetwork constructs an empty network injecting the given faults.
+func NewNetwork(faults Faults) *Network {
+	return &Network{
+		servers: make(map[string]*server),
+		faults:  faults,
+		rand:    rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+// SetFaults changes the faults injected into the connections established from now on.
+func (nw *Network) SetFaults(faults Faults) {
+	nw.mx.Lock()
+	defer nw.mx.Unlock()
+	nw.faults = faults
+	nw.rand = rand.New(rand.NewSource(faults.Seed))
+}
+
+// NewServer registers a server listening on localAddress and dialing the committee members at remoteAddresses.
+// The address is released when the server is stopped.
+func (nw *Network) NewServer(localAddress string, remoteAddresses []string) (network.Server, error) {
+	nw.mx.Lock()
+	defer nw.mx.Unlock()
+	if _, ok := nw.servers[localAddress]; ok {
+		return nil, errors.New("address already in use: " + localAddress)
+	}
+	s := &server{
+		nw:              nw,
+		localAddress:    localAddress,
+		remoteAddresses: remoteAddresses,
+		incoming:        make(chan *conn, len(remoteAddresses)),
+		quit:            make(chan struct{}),
+	}
+	nw.servers[localAddress] = s
+	return s, nil
+}
+
+// NewServer registers a server in the Default network.
+func NewServer(localAddress string, remoteAddresses []string) (network.Server, error) {
+	return Default.NewServer(localAddress, remoteAddresses)
+}
+
+// delay returns the delay of the next chunk and whether the chunk should be dropped.
+func (nw *Network) delay() (time.Duration, bool) {
+	nw.mx.Lock()
+	defer nw.mx.Unlock()
+	d := nw.faults.Latency
+	if nw.faults.Jitter > 0 {
+		d += time.Duration(nw.rand.Int63n(int64(nw.faults.Jitter)))
+	}
+	return d, nw.faults.Drop > 0 && nw.rand.Float64() < nw.faults.Drop
+}
+
+func (nw *Network) lookup(address string) *server {
+	nw.mx.Lock()
+	defer nw.mx.Unlock()
+	return nw.servers[address]
+}
+
+func (nw *Network) remove(s *server) {
+	nw.mx.Lock()
+	defer nw.mx.Unlock()
+	if nw.servers[s.localAddress] == s {
+		delete(nw.servers, s.localAddress)
+	}
+}
+
+type server struct {
+	nw              *Network
+	localAddress    string
+	remoteAddresses []string
+	incoming        chan *conn
+	quit            chan struct{}
+	stopOnce        sync.Once
+}
+
+func (s *server) Dial(pid uint16) (network.Connection, error) {
+	if int(pid) >= len(s.remoteAddresses) {
+		return nil, errors.New("unknown pid")
+	}
+	select {
+	case <-s.quit:
+		return nil, errStopped
+	default:
+	}
+	remote := s.nw.lookup(s.remoteAddresses[pid])
+	if remote == nil {
+		return nil, errors.New("connection refused: " + s.remoteAddresses[pid])
+	}
+	out, in := newPipe(), newPipe()
+	local := &conn{nw: s.nw, in: in, out: out, remote: addr(remote.localAddress)}
+	accepted := &conn{nw: s.nw, in: out, out: in, remote: addr(s.localAddress)}
+	select {
+	case remote.incoming <- accepted:
+		return local, nil
+	case <-remote.quit:
+		return nil, errors.New("connection refused: " + s.remoteAddresses[pid])
+	case <-s.quit:
+		return nil, errStopped
+	}
+}
+
+func (s *server) Listen() (network.Connection, error) {
+	timer := time.NewTimer(listenTimeout)
+	defer timer.Stop()
+	select {
+	case c := <-s.incoming:
+		return c, nil
+	case <-s.quit:
+		return nil, errStopped
+	case <-timer.C:
+		return nil, errNoConn
+	}
+}
+
+func (s *server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		s.nw.remove(s)
+	})
+}
+
+// addr is the address of an in-memory server.
+type addr string
+
+func (a addr) Network() string { return "mem" }
+func (a addr) String() string  { return string(a) }
+
+// chunk is a piece of data flushed at once, readable after the given time.
+// A chunk with reset set stands for data lost in the network.
+type chunk struct {
+	at    time.Time
+	data  []byte
+	reset bool
+}
+
+// pipe carries data in one direction of a connection.
+type pipe struct {
+	mx      sync.Mutex
+	changed chan struct{}
+	chunks  []chunk
+	buf     []byte
+	last    time.Time
+	closed  bool  // no more data will be sent
+	err     error // set when the reading side cannot read anymore
+}
+
+func newPipe() *pipe {
+	return &pipe{changed: make(chan struct{})}
+}
+
+// notify wakes up the readers waiting for a change. Must be called with the lock held.
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *pipe) send(data []byte, delay time.Duration, drop bool) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if p.closed {
+		return errClosed
+	}
+	at := time.Now().Add(delay)
+	if at.Before(p.last) {
+		at = p.last
+	}
+	p.last = at
+	if drop {
+		p.chunks = append(p.chunks, chunk{at: at, reset: true})
+		p.closed = true
+	} else {
+		p.chunks = append(p.chunks, chunk{at: at, data: data})
+	}
+	p.notify()
+	return nil
+}
+
+func (p *pipe) close() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.notify()
+	}
+}
+
+// fail makes all the further reads and writes return the given error.
+func (p *pipe) fail(err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.err == nil {
+		p.err = err
+		p.notify()
+	}
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	for {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if len(p.buf) > 0 {
+			n := copy(b, p.buf)
+			p.buf = p.buf[n:]
+			return n, nil
+		}
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if len(p.chunks) > 0 {
+			c := p.chunks[0]
+			if d := time.Until(c.at); d > 0 {
+				timer = time.NewTimer(d)
+				wait = timer.C
+			} else {
+				p.chunks = p.chunks[1:]
+				if c.reset {
+					p.err = errReset
+				} else {
+					p.buf = c.data
+				}
+				continue
+			}
+		} else if p.closed {
+			return 0, io.EOF
+		}
+		changed := p.changed
+		p.mx.Unlock()
+		select {
+		case <-changed:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		p.mx.Lock()
+	}
+}
+
+// conn is one end of a connection, reading from one pipe and writing to the other.
+type conn struct {
+	nw      *Network
+	in      *pipe
+	out     *pipe
+	pending []byte
+	remote  addr
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.out.mx.Lock()
+	err := c.out.err
+	c.out.mx.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	c.pending = append(c.pending, b...)
+	return len(b), nil
+}
+
+func (c *conn) Flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	delay, drop := c.nw.delay()
+	err := c.out.send(c.pending, delay, drop)
+	c.pending = nil
+	return err
+}
+
+func (c *conn) Close() error {
+	c.out.close()
+	c.in.fail(errClosed)
+	return nil
+}
+
+func (c *conn) TimeoutAfter(t time.Duration) {
+	time.AfterFunc(t, func() {
+		c.in.fail(errTimeout)
+		c.out.fail(errTimeout)
+	})
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
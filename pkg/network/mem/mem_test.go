@@ -0,0 +1,147 @@
+package mem_test
+
+import (
+	"io/ioutil"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/network/mem"
+	"gitlab.com/alephledger/core-go/pkg/network"
+)
+
+var _ = Describe("Network", func() {
+
+	var (
+		nw        *mem.Network
+		addresses []string
+		servs     []network.Server
+	)
+
+	BeforeEach(func() {
+		nw = mem.NewNetwork(mem.Faults{})
+		addresses = []string{"a", "b", "c"}
+		servs = make([]network.Server, len(addresses))
+		for i, address := range addresses {
+			var err error
+			servs[i], err = nw.NewServer(address, addresses)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	AfterEach(func() {
+		for _, s := range servs {
+			s.Stop()
+		}
+	})
+
+	send := func(from, to uint16, messages ...string) {
+		defer GinkgoRecover()
+		conn, err := servs[from].Dial(to)
+		Expect(err).NotTo(HaveOccurred())
+		for _, m := range messages {
+			_, err = conn.Write([]byte(m))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.Flush()).To(Succeed())
+		}
+		Expect(conn.Close()).To(Succeed())
+	}
+
+	receive := func(at uint16) (string, error) {
+		conn, err := servs[at].Listen()
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		data, err := ioutil.ReadAll(conn)
+		return string(data), err
+	}
+
+	It("should refuse registering an address twice", func() {
+		_, err := nw.NewServer("a", addresses)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should release the address of a stopped server", func() {
+		servs[0].Stop()
+		_, err := servs[1].Dial(0)
+		Expect(err).To(HaveOccurred())
+		servs[0], err = nw.NewServer("a", addresses)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should deliver the data in order", func() {
+		go send(1, 0, "one", "two", "three")
+		data, err := receive(0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(Equal("onetwothree"))
+	})
+
+	It("should pass answers back to the dialing side", func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			defer wg.Done()
+			conn, err := servs[2].Listen()
+			Expect(err).NotTo(HaveOccurred())
+			buf := make([]byte, 4)
+			_, err = conn.Read(buf)
+			Expect(err).NotTo(HaveOccurred())
+			conn.Write([]byte("pong"))
+			Expect(conn.Flush()).To(Succeed())
+			conn.Close()
+		}()
+		conn, err := servs[0].Dial(2)
+		Expect(err).NotTo(HaveOccurred())
+		conn.Write([]byte("ping"))
+		Expect(conn.Flush()).To(Succeed())
+		data, err := ioutil.ReadAll(conn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("pong"))
+		Expect(conn.RemoteAddr().String()).To(Equal("c"))
+		wg.Wait()
+	})
+
+	It("should stop listening when there are no connections", func() {
+		_, err := servs[0].Listen()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should time out reads", func() {
+		conn, err := servs[0].Dial(1)
+		Expect(err).NotTo(HaveOccurred())
+		conn.TimeoutAfter(10 * time.Millisecond)
+		_, err = conn.Read(make([]byte, 1))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with latency", func() {
+		BeforeEach(func() {
+			nw.SetFaults(mem.Faults{Latency: 50 * time.Millisecond, Jitter: 50 * time.Millisecond})
+		})
+
+		It("should delay the data but keep it in order", func() {
+			start := time.Now()
+			go send(1, 0, "one", "two", "three")
+			data, err := receive(0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal("onetwothree"))
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+	})
+
+	Context("with drops", func() {
+		BeforeEach(func() {
+			nw.SetFaults(mem.Faults{Drop: 1})
+		})
+
+		It("should reset the connection", func() {
+			go send(1, 0, "lost")
+			_, err := receive(0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
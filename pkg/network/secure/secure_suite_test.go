@@ -0,0 +1,13 @@
+package secure_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSecure(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Secure Suite")
+}
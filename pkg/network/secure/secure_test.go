@@ -0,0 +1,141 @@
+package secure_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/network/mem"
+	"gitlab.com/alephledger/consensus-go/pkg/network/secure"
+	"gitlab.com/alephledger/core-go/pkg/crypto/encrypt"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+	"gitlab.com/alephledger/core-go/pkg/network"
+)
+
+var _ = Describe("Server", func() {
+
+	var (
+		addresses []string
+		plain     []network.Server
+		servs     []network.Server
+	)
+
+	newKeys := func(n int) [][]encrypt.SymmetricKey {
+		pks := make([]*p2p.PublicKey, n)
+		sks := make([]*p2p.SecretKey, n)
+		for i := range pks {
+			pks[i], sks[i], _ = p2p.GenerateKeys()
+		}
+		keys := make([][]encrypt.SymmetricKey, n)
+		for i := range keys {
+			var err error
+			keys[i], err = p2p.Keys(sks[i], pks, uint16(i))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		return keys
+	}
+
+	BeforeEach(func() {
+		nw := mem.NewNetwork(mem.Faults{})
+		addresses = []string{"a", "b", "c"}
+		plain = make([]network.Server, len(addresses))
+		servs = make([]network.Server, len(addresses))
+		keys := newKeys(len(addresses))
+		for i, address := range addresses {
+			var err error
+			plain[i], err = nw.NewServer(address, addresses)
+			Expect(err).NotTo(HaveOccurred())
+			servs[i] = secure.NewServer(plain[i], uint16(i), keys[i])
+		}
+	})
+
+	AfterEach(func() {
+		for _, s := range servs {
+			s.Stop()
+		}
+	})
+
+	send := func(from, to uint16, data []byte) {
+		defer GinkgoRecover()
+		conn, err := servs[from].Dial(to)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Write(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Flush()).To(Succeed())
+		Expect(conn.Close()).To(Succeed())
+	}
+
+	It("should deliver the data", func() {
+		go send(1, 0, []byte("secret data"))
+		conn, err := servs[0].Listen()
+		Expect(err).NotTo(HaveOccurred())
+		data, err := ioutil.ReadAll(conn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("secret data"))
+	})
+
+	It("should deliver data longer than a single frame", func() {
+		long := bytes.Repeat([]byte("0123456789"), 20000)
+		go send(1, 0, long)
+		conn, err := servs[0].Listen()
+		Expect(err).NotTo(HaveOccurred())
+		data, err := ioutil.ReadAll(conn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(Equal(long))
+	})
+
+	It("should pass answers back to the dialing side", func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			defer wg.Done()
+			conn, err := servs[2].Listen()
+			Expect(err).NotTo(HaveOccurred())
+			buf := make([]byte, 4)
+			_, err = conn.Read(buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf)).To(Equal("ping"))
+			conn.Write([]byte("pong"))
+			Expect(conn.Flush()).To(Succeed())
+			conn.Close()
+		}()
+		conn, err := servs[0].Dial(2)
+		Expect(err).NotTo(HaveOccurred())
+		conn.Write([]byte("ping"))
+		Expect(conn.Flush()).To(Succeed())
+		data, err := ioutil.ReadAll(conn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("pong"))
+		wg.Wait()
+	})
+
+	It("should not send the data in plaintext", func() {
+		go send(1, 0, []byte("secret data"))
+		conn, err := plain[0].Listen()
+		Expect(err).NotTo(HaveOccurred())
+		data, err := ioutil.ReadAll(conn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).NotTo(BeEmpty())
+		Expect(string(data)).NotTo(ContainSubstring("secret"))
+	})
+
+	It("should reject connections from an impostor", func() {
+		impostor := secure.NewServer(plain[1], 1, newKeys(len(addresses))[1])
+		go func() {
+			defer GinkgoRecover()
+			conn, err := impostor.Dial(0)
+			Expect(err).NotTo(HaveOccurred())
+			conn.Write([]byte("secret data"))
+			Expect(conn.Flush()).To(Succeed())
+			conn.Close()
+		}()
+		conn, err := servs[0].Listen()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ioutil.ReadAll(conn)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,31 @@
+// Package socket lets the servers of local services, like the preblock stream, the mempool and the signer,
+// and their clients use either a TCP address or a path of a unix socket.
+package socket
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// UnixPrefix marks an address as a path of a unix socket, addresses without it are TCP ones.
+const UnixPrefix = "unix:"
+
+// Split splits the given address into the network and the address for net.Dial and net.Listen.
+func Split(addr string) (string, string) {
+	if strings.HasPrefix(addr, UnixPrefix) {
+		return "unix", strings.TrimPrefix(addr, UnixPrefix)
+	}
+	return "tcp", addr
+}
+
+// Listen listens on the given address.
+func Listen(addr string) (net.Listener, error) {
+	return net.Listen(Split(addr))
+}
+
+// Dial connects to the given address, giving up after the timeout if it is positive.
+func Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	network, address := Split(addr)
+	return net.DialTimeout(network, address, timeout)
+}
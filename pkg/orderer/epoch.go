@@ -9,47 +9,80 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	"gitlab.com/alephledger/consensus-go/pkg/linear"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
+	"gitlab.com/alephledger/consensus-go/pkg/store"
 )
 
 // epoch is a wrapper around a triple (adder, dag, extender) that is processing units from a particular epoch.
 // Units/Preunits can be added to the epoch by directly accessing methods of adder.
 // extender produces timing rounds on the provided output channel.
 type epoch struct {
-	id       gomel.EpochID
-	adder    gomel.Adder
-	dag      gomel.Dag
-	extender *linear.ExtenderService
-	rs       gomel.RandomSource
-	more     chan bool
-	log      zerolog.Logger
+	id        gomel.EpochID
+	conf      config.Config
+	rsf       gomel.RandomSourceFactory
+	adder     gomel.Adder
+	dag       gomel.Dag
+	extender  *linear.ExtenderService
+	rs        gomel.RandomSource
+	more      chan bool
+	replaying bool // set while units are replayed from the store, they are neither stored again nor put on the unit belt
+	log       zerolog.Logger
 }
 
-func newEpoch(id gomel.EpochID, conf config.Config, syncer gomel.Syncer, rsf gomel.RandomSourceFactory, alert gomel.Alerter, unitBelt chan<- gomel.Unit, output chan<- []gomel.Unit, log zerolog.Logger) *epoch {
+func newEpoch(id gomel.EpochID, conf config.Config, syncer gomel.Syncer, rsf gomel.RandomSourceFactory, alert gomel.Alerter, st *store.Store, unitBelt chan<- gomel.Unit, output chan<- []gomel.Unit, log zerolog.Logger) *epoch {
 	log = log.With().Uint32(lg.Epoch, uint32(id)).Logger()
 	dg := dag.New(conf, id)
 	adr := adder.New(dg, conf, syncer, alert, log)
 	rs := rsf.NewRandomSource(dg)
 	ext := linear.NewExtenderService(dg, rs, conf, output, log)
+	ep := &epoch{
+		id:       id,
+		conf:     conf,
+		rsf:      rsf,
+		adder:    adr,
+		dag:      dg,
+		extender: ext,
+		rs:       rs,
+		more:     make(chan bool),
+		log:      log,
+	}
 
+	if st != nil {
+		dg.AfterInsert(func(u gomel.Unit) {
+			if ep.replaying {
+				return
+			}
+			if err := st.Append(u); err != nil {
+				log.Error().Str("where", "orderer.store.Append").Msg(err.Error())
+			}
+		})
+	}
 	dg.AfterInsert(func(_ gomel.Unit) { ext.Notify() })
+	dg.AfterInsert(func(u gomel.Unit) { metrics.UnitAdded(u.Creator()) })
 	dg.AfterInsert(func(u gomel.Unit) {
+		if ep.replaying {
+			return
+		}
 		log.Debug().Uint16(lg.Creator, u.Creator()).Uint32(lg.Epoch, uint32(u.EpochID())).Int(lg.Height, u.Height()).Int(lg.Level, u.Level()).Msg(lg.SendingUnitToCreator)
 		if u.Creator() != conf.Pid { // don't put our own units on the unit belt, creator already knows about them.
 			log.Debug().Uint16(lg.Creator, u.Creator()).Int(lg.Height, u.Height()).Int(lg.Level, u.Level()).Msg(lg.SendingUnitToCreator)
+			conf.Activity.Add(gomel.Adding)
 			unitBelt <- u
 		}
 	})
 
 	log.Log().Msg(lg.NewEpoch)
-	return &epoch{
-		id:       id,
-		adder:    adr,
-		dag:      dg,
-		extender: ext,
-		rs:       rs,
-		more:     make(chan bool),
-		log:      log,
-	}
+	return ep
+}
+
+// replay inserts into the dag all the units of this epoch that were persisted in the given store.
+// It has to be called before the epoch starts receiving units from other sources.
+func (ep *epoch) replay(st *store.Store) error {
+	ep.replaying = true
+	defer func() { ep.replaying = false }()
+	err := st.Replay(ep.dag)
+	ep.log.Log().Int(lg.Size, len(ep.allUnits())).Msg(lg.EpochReplayed)
+	return err
 }
 
 // Close stops all the workers inside this epoch.
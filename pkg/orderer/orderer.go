@@ -12,9 +12,15 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/creator"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
+	"gitlab.com/alephledger/consensus-go/pkg/store"
 	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/utils"
 )
 
+// lastTimingBuffer is the capacity of the channel passing last timing units to the creator, used when the number of epochs is unbounded.
+const lastTimingBuffer = 4
+
 type orderer struct {
 	conf         config.Config
 	syncer       gomel.Syncer
@@ -23,43 +29,88 @@ type orderer struct {
 	toPreblock   gomel.PreblockMaker
 	ds           core.DataSource
 	creator      *creator.Creator
+	store        *store.Store
 	current      *epoch
 	previous     *epoch
 	unitBelt     chan gomel.Unit // Note: units on the unit belt does not have to appear in topological order
 	lastTiming   chan gomel.Unit // used to pass the last timing unit of the epoch to creator
 	orderedUnits chan []gomel.Unit
+	lastTU       gomel.Unit // the timing unit of the newest preblock, guarded by mx
+	failure      error      // why we stopped taking part in the protocol, guarded by mx
 	mx           sync.RWMutex
 	wg           sync.WaitGroup
 	ticker       *time.Ticker
 	log          zerolog.Logger
+	// committee changes, see NewReconfigurable
+	services     *Services
+	confs        map[gomel.EpochID]config.Config // configs of epochs with known committees, nil for committees we are not a member of
+	tracker      *changeTracker
+	netConf      config.Config // config of the committee the syncer and the alerter work for
+	forkObserver utils.ObserverManager
+	svcMx        sync.RWMutex
+	switchMx     sync.Mutex
+	stopped      bool
 }
 
 // New constructs a new orderer instance using provided config, data source, preblock maker, and logger.
-func New(conf config.Config, ds core.DataSource, toPreblock gomel.PreblockMaker, log zerolog.Logger) gomel.Orderer {
-	return &orderer{
+// If conf.UnitStoreDir is set, all the units added to the orderer are persisted there,
+// and units stored by a previous run are recovered when the orderer starts. An error is returned if the store cannot be opened.
+func New(conf config.Config, ds core.DataSource, toPreblock gomel.PreblockMaker, log zerolog.Logger) (gomel.Orderer, error) {
+	timingBuffer := conf.NumberOfEpochs
+	if timingBuffer == 0 {
+		timingBuffer = lastTimingBuffer
+	}
+	ord := &orderer{
 		conf:         conf,
 		toPreblock:   toPreblock,
 		ds:           ds,
 		unitBelt:     make(chan gomel.Unit, conf.EpochLength*int(conf.NProc)),
-		lastTiming:   make(chan gomel.Unit, conf.NumberOfEpochs),
+		lastTiming:   make(chan gomel.Unit, timingBuffer),
 		orderedUnits: make(chan []gomel.Unit, conf.EpochLength),
 		log:          log.With().Int(lg.Service, lg.OrderService).Logger(),
 	}
+	if conf.UnitStoreDir != "" {
+		st, err := store.New(conf.UnitStoreDir)
+		if err != nil {
+			return nil, err
+		}
+		ord.store = st
+	}
+	return ord, nil
 }
 
 func (ord *orderer) Start(rsf gomel.RandomSourceFactory, syncer gomel.Syncer, alerter gomel.Alerter) {
 	ord.rsf = rsf
+	ord.svcMx.Lock()
 	ord.syncer = syncer
 	ord.alerter = alerter
+	ord.netConf = ord.conf
+	ord.svcMx.Unlock()
+
+	ord.recover()
+	if ord.current == nil {
+		ord.newEpoch(ord.conf.FirstEpoch)
+	}
 
 	send := func(u gomel.Unit) {
 		ord.insert(u)
-		ord.syncer.Multicast(u)
+		ord.sync().Multicast(u)
+	}
+	// proofs that an epoch has finished are built and verified by the committee of that epoch
+	epochProofBuilder := func(epoch gomel.EpochID) creator.EpochProofBuilder {
+		conf, _ := ord.confFor(epoch)
+		if conf == nil {
+			conf = ord.conf
+		}
+		return creator.NewProofBuilder(conf, ord.log)(epoch)
+	}
+	ord.creator = creator.NewForEpoch(ord.conf, ord.ds, send, ord.rsData, epochProofBuilder, ord.current.id, ord.log.With().Int(lg.Service, lg.CreatorService).Logger())
+	if ord.services != nil {
+		ord.creator.WithCommittees(ord.confFor)
+	}
+	if ord.store != nil {
+		ord.creator.Recover(ord.current.dag)
 	}
-	epochProofBuilder := creator.NewProofBuilder(ord.conf, ord.log)
-	ord.creator = creator.New(ord.conf, ord.ds, send, ord.rsData, epochProofBuilder, ord.log.With().Int(lg.Service, lg.CreatorService).Logger())
-
-	ord.newEpoch(gomel.EpochID(0))
 
 	syncer.Start()
 	alerter.Start()
@@ -83,11 +134,12 @@ func (ord *orderer) Start(rsf gomel.RandomSourceFactory, syncer gomel.Syncer, al
 	go func() {
 		for range ord.ticker.C {
 			// choose pid randomly amongst other NProc-1 committee members
-			pidToCall := uint16(rand.Intn(int(ord.conf.NProc - 1)))
-			if pidToCall >= ord.conf.Pid {
+			conf := ord.network()
+			pidToCall := uint16(rand.Intn(int(conf.NProc - 1)))
+			if pidToCall >= conf.Pid {
 				pidToCall++
 			}
-			ord.syncer.RequestGossip(pidToCall)
+			ord.sync().RequestGossip(pidToCall)
 		}
 	}()
 
@@ -95,14 +147,22 @@ func (ord *orderer) Start(rsf gomel.RandomSourceFactory, syncer gomel.Syncer, al
 }
 
 func (ord *orderer) Stop() {
-	ord.alerter.Stop()
-	ord.syncer.Stop()
+	ord.switchMx.Lock()
+	ord.stopped = true
+	ord.alert().Stop()
+	ord.sync().Stop()
+	ord.switchMx.Unlock()
 	if ord.previous != nil {
 		ord.previous.Close()
 	}
 	if ord.current != nil {
 		ord.current.Close()
 	}
+	if ord.store != nil {
+		if err := ord.store.Close(); err != nil {
+			ord.log.Error().Str("where", "orderer.Stop.store").Msg(err.Error())
+		}
+	}
 	close(ord.orderedUnits)
 	close(ord.unitBelt)
 	ord.ticker.Stop()
@@ -114,24 +174,50 @@ func (ord *orderer) Stop() {
 // Since Extenders in multiple epochs can supply ordered rounds simultaneously, handleTimingRounds needs to ensure that
 // Preblocks are produced in ascending order with respect to epochs. For the last ordered round
 // of the epoch, the timing unit defining it is sent to the creator (to produce signature shares.)
+// Epochs of the past are released from memory as new ones appear, so this can go on forever if conf.NumberOfEpochs is 0.
 func (ord *orderer) handleTimingRounds() {
 	defer close(ord.lastTiming)
 	current := gomel.EpochID(0)
 	for round := range ord.orderedUnits {
 		timingUnit := round[len(round)-1]
 		epoch := timingUnit.EpochID()
+		if epoch >= current && timingUnit.Level() <= ord.conf.LastLevel {
+			ord.observeChanges(epoch, round)
+		}
 		if timingUnit.Level() == ord.conf.LastLevel {
-			ord.lastTiming <- timingUnit
+			ord.settle(epoch)
+			ord.sendLastTiming(timingUnit)
 			ord.finishEpoch(epoch)
-			if int(epoch) == ord.conf.NumberOfEpochs-1 {
+			if config.IsLastEpoch(ord.conf, epoch) {
 				ord.ticker.Stop()
 			}
 		}
 		if epoch >= current && timingUnit.Level() <= ord.conf.LastLevel {
 			ord.toPreblock(round)
+			metrics.Preblocks.Inc()
+			ord.mx.Lock()
+			ord.lastTU = timingUnit
+			ord.mx.Unlock()
 			ord.log.Info().Int(lg.Level, timingUnit.Level()).Uint32(lg.Epoch, uint32(epoch)).Msg(lg.PreblockProduced)
 		}
 		current = epoch
+		ord.conf.Activity.Done(gomel.Ordering, 1)
+	}
+}
+
+// sendLastTiming puts the given timing unit on the lastTiming channel. If the channel is full, timing units
+// of the oldest epochs are discarded, as the creator is only interested in the epoch it is currently working on.
+func (ord *orderer) sendLastTiming(timingUnit gomel.Unit) {
+	for {
+		select {
+		case ord.lastTiming <- timingUnit:
+			return
+		default:
+			select {
+			case <-ord.lastTiming:
+			default:
+			}
+		}
 	}
 }
 
@@ -256,16 +342,59 @@ func (ord *orderer) Delta(info [2]*gomel.DagInfo) []gomel.Unit {
 	return result
 }
 
+// Status returns a snapshot of the state of the orderer, describing the dag of the current epoch.
+func (ord *orderer) Status() *gomel.Status {
+	status := &gomel.Status{Pid: ord.conf.Pid, NProc: ord.conf.NProc}
+	ord.mx.RLock()
+	current, previous := ord.current, ord.previous
+	status.LastTiming = ord.lastTU
+	if ord.failure != nil {
+		status.Failure = ord.failure.Error()
+	}
+	ord.mx.RUnlock()
+	if current != nil {
+		id := current.id
+		status.Epoch = &id
+		status.Pid, status.NProc = current.conf.Pid, current.conf.NProc
+		status.MaxHeights = gomel.MaxView(current.dag).Heights
+		status.Waiting, status.Missing = current.adder.Backlog()
+	}
+	if previous != nil {
+		id := previous.id
+		status.Previous = &id
+		waiting, missing := previous.adder.Backlog()
+		status.Waiting += waiting
+		status.Missing += missing
+	}
+	if alerter, conf := ord.alert(), ord.network(); alerter != nil && conf != nil {
+		for pid := uint16(0); pid < conf.NProc; pid++ {
+			if alerter.IsForker(pid) {
+				status.Forkers = append(status.Forkers, pid)
+			}
+		}
+	}
+	if syncer := ord.sync(); syncer != nil {
+		status.Peers = syncer.Peers()
+	}
+	return status
+}
+
 // retrieveEpoch returns an epoch for the given preunit. If the preunit comes from a future epoch,
 // it is checked for new epoch proof. If failed, requests gossip with source of the preunit.
 func (ord *orderer) retrieveEpoch(pu gomel.Preunit, source uint16) *epoch {
 	epochID := pu.EpochID()
 	epoch, fromFuture := ord.getEpoch(epochID)
 	if fromFuture {
-		if creator.EpochProof(pu, ord.conf.WTKey) {
+		if !ord.mayEnter(epochID) {
+			// we will receive the preunit again once we are ready for its epoch
+			return nil
+		}
+		// the proof is verified under the threshold key of the committee of the previous epoch
+		prev, _ := ord.confFor(epochID - 1)
+		if prev != nil && creator.EpochProof(pu, prev.WTKey) {
 			epoch = ord.newEpoch(epochID)
 		} else {
-			ord.syncer.RequestGossip(source)
+			ord.sync().RequestGossip(source)
 		}
 	}
 	return epoch
@@ -282,33 +411,90 @@ func (ord *orderer) getEpoch(epoch gomel.EpochID) (*epoch, bool) {
 	if epoch == ord.current.id {
 		return ord.current, false
 	}
-	if epoch == ord.previous.id {
+	if ord.previous != nil && epoch == ord.previous.id {
 		return ord.previous, false
 	}
 	return nil, false
 }
 
 // newEpoch creates and returns a new epoch object with the given EpochID. If such epoch already exists, returns it.
+// Returns nil if the committee of the epoch is not known or we are not its member.
+// Entering an epoch with a new committee switches the syncer and the alerter to that committee.
 func (ord *orderer) newEpoch(epoch gomel.EpochID) *epoch {
+	conf, known := ord.confFor(epoch)
+	if !known || conf == nil {
+		return nil
+	}
 	ord.mx.Lock()
 	defer ord.mx.Unlock()
 	if ord.current == nil || epoch > ord.current.id {
+		rsf := ord.rsf
+		changed := false
+		if ord.current != nil {
+			rsf = ord.current.rsf
+			if conf != ord.current.conf {
+				rsf = ord.services.RandomSource(conf)
+				changed = true
+			}
+		}
 		if ord.previous != nil {
 			ord.previous.Close()
 		}
 		ord.previous = ord.current
-		ord.current = newEpoch(epoch, ord.conf, ord.syncer, ord.rsf, ord.alerter, ord.unitBelt, ord.orderedUnits, ord.log)
+		ord.current = newEpoch(epoch, conf, syncerProxy{ord}, rsf, alerterProxy{ord}, ord.store, ord.unitBelt, ord.orderedUnits, ord.log)
+		metrics.Epochs.Inc()
+		metrics.CurrentEpoch.Set(float64(epoch))
+		if changed {
+			ord.wg.Add(1)
+			go ord.switchServices(conf)
+		}
+		if ord.store != nil && epoch > 0 {
+			ord.store.Prune(epoch - 1)
+		}
 		return ord.current
 	}
 	if epoch == ord.current.id {
 		return ord.current
 	}
-	if epoch == ord.previous.id {
+	if ord.previous != nil && epoch == ord.previous.id {
 		return ord.previous
 	}
 	return nil
 }
 
+// recover replays units persisted in the store for the two most recent stored epochs, skipping the ones before conf.FirstEpoch.
+// The config we were started with describes the committee of these epochs only: a process restarted after a committee change
+// is started with a config returned by config.Join for the latest change, and the epochs of the old committee are not replayed.
+// It is called before any other source of units is running.
+func (ord *orderer) recover() {
+	if ord.store == nil {
+		return
+	}
+	var epochs []gomel.EpochID
+	for _, id := range ord.store.Epochs() {
+		if id >= ord.conf.FirstEpoch {
+			epochs = append(epochs, id)
+		}
+	}
+	if len(epochs) > 2 {
+		epochs = epochs[len(epochs)-2:]
+	}
+	for _, id := range epochs {
+		if ord.services != nil {
+			ord.mx.Lock()
+			ord.confs[id] = ord.conf
+			ord.mx.Unlock()
+		}
+		ep := ord.newEpoch(id)
+		if ep == nil {
+			continue
+		}
+		if err := ep.replay(ord.store); err != nil {
+			ord.log.Error().Str("where", "orderer.recover").Msg(err.Error())
+		}
+	}
+}
+
 // finishEpoch marks the chosen epoch as not interested in accepting new units.
 func (ord *orderer) finishEpoch(epoch gomel.EpochID) {
 	ep, _ := ord.getEpoch(epoch)
@@ -320,7 +506,8 @@ func (ord *orderer) finishEpoch(epoch gomel.EpochID) {
 // insert puts the provided unit directly into the corresponding epoch. If such epoch does not exist, creates it.
 // All correctness checks (epoch proof, adder, dag checks) are skipped. This method is meant for our own units only.
 func (ord *orderer) insert(unit gomel.Unit) {
-	if unit.Creator() != ord.conf.Pid {
+	conf, _ := ord.confFor(unit.EpochID())
+	if conf == nil || unit.Creator() != conf.Pid {
 		ord.log.Warn().Uint16(lg.Creator, unit.Creator()).Msg(lg.InvalidCreator)
 		return
 	}
@@ -340,15 +527,18 @@ func (ord *orderer) insert(unit gomel.Unit) {
 func (ord *orderer) rsData(level int, parents []gomel.Unit, epoch gomel.EpochID) []byte {
 	var result []byte
 	var err error
-	if level == 0 {
-		result, err = ord.rsf.DealingData(epoch)
-	} else {
-		ep, _ := ord.getEpoch(epoch)
-		if ep != nil {
-			result, err = ep.rs.DataToInclude(parents, level)
-		} else {
-			err = gomel.NewDataError("unknown epoch")
-		}
+	ep, newer := ord.getEpoch(epoch)
+	if newer && level == 0 {
+		// our dealing unit is the first unit of the epoch we have seen
+		ep = ord.newEpoch(epoch)
+	}
+	switch {
+	case ep == nil:
+		err = gomel.NewDataError("unknown epoch")
+	case level == 0:
+		result, err = ep.rsf.DealingData(epoch)
+	default:
+		result, err = ep.rs.DataToInclude(parents, level)
 	}
 	if err != nil {
 		ord.log.Error().Str("where", "orderer.rsData").Msg(err.Error())
@@ -0,0 +1,285 @@
+package orderer
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/network"
+	"gitlab.com/alephledger/core-go/pkg/utils"
+)
+
+// Services construct the parts of the orderer that depend on the committee. When the committee changes
+// between epochs, they are used to obtain a random source factory for the new committee and to replace the syncer and the alerter.
+type Services struct {
+	RandomSource func(config.Config) gomel.RandomSourceFactory
+	Network      func(config.Config) (gomel.Syncer, gomel.Alerter, error)
+}
+
+// NewReconfigurable constructs an orderer that applies committee changes agreed in preblocks.
+// A change proposed (see config.NewCommitteeChange) by a quorum of the committee in units ordered in epoch N
+// takes effect in epoch N+1. Such an orderer never skips epochs, as it has to know the committee of every epoch it enters.
+// After a change, the previous epoch is no longer synchronized with other processes.
+// The handover of an agreed change is written to conf.HandoverFile, members added by the change start
+// with a config returned for it by config.Join.
+func NewReconfigurable(conf config.Config, ds core.DataSource, toPreblock gomel.PreblockMaker, services Services, log zerolog.Logger) (gomel.Orderer, error) {
+	o, err := New(conf, ds, toPreblock, log)
+	if err != nil {
+		return nil, err
+	}
+	ord := o.(*orderer)
+	ord.services = &services
+	ord.confs = map[gomel.EpochID]config.Config{conf.FirstEpoch: conf}
+	return ord, nil
+}
+
+// confFor returns the config of the given epoch, and whether it is known already.
+// A nil config means that we are not a member of the committee of that epoch.
+func (ord *orderer) confFor(epoch gomel.EpochID) (config.Config, bool) {
+	if ord.services == nil {
+		return ord.conf, true
+	}
+	ord.mx.RLock()
+	defer ord.mx.RUnlock()
+	conf, ok := ord.confs[epoch]
+	return conf, ok
+}
+
+// mayEnter checks if the orderer can enter the given future epoch on request of other processes.
+// With committee changes, that has to be the next epoch with a known committee that we have been working with already.
+// Epochs with a new committee are entered only when we create our own dealing unit, as that requires changing the syncer.
+func (ord *orderer) mayEnter(epoch gomel.EpochID) bool {
+	if ord.services == nil {
+		return true
+	}
+	conf, known := ord.confFor(epoch)
+	ord.mx.RLock()
+	defer ord.mx.RUnlock()
+	return known && ord.current != nil && epoch == ord.current.id+1 && conf == ord.current.conf
+}
+
+// observeChanges looks for proposals of committee changes in the given ordered round.
+func (ord *orderer) observeChanges(epoch gomel.EpochID, round []gomel.Unit) {
+	if ord.services == nil {
+		return
+	}
+	conf, _ := ord.confFor(epoch)
+	if conf == nil {
+		return
+	}
+	if ord.tracker == nil || ord.tracker.epoch != epoch {
+		ord.tracker = newChangeTracker(epoch, conf)
+	}
+	for _, u := range round {
+		ord.tracker.observe(u)
+	}
+}
+
+// settle fixes the config of the epoch following the given one, that has just been ordered.
+// If we belong to the agreed committee but cannot use our part of its key, we leave the protocol
+// as if we were removed, and the failure is reported in the status of the orderer.
+func (ord *orderer) settle(epoch gomel.EpochID) {
+	if ord.services == nil {
+		return
+	}
+	ord.mx.Lock()
+	defer ord.mx.Unlock()
+	if _, ok := ord.confs[epoch+1]; ok {
+		return
+	}
+	conf := ord.confs[epoch]
+	next := conf
+	if ord.tracker != nil && ord.tracker.epoch == epoch && ord.tracker.agreed != nil {
+		var err error
+		next, err = config.Reconfigure(conf, ord.tracker.agreed, ord.tracker.dealt)
+		if err != nil {
+			// the new committee is valid, but we are unable to use our part of its key
+			ord.failure = errors.New("unable to join the committee of epoch " + strconv.Itoa(int(epoch+1)) + ": " + err.Error())
+			ord.log.Error().Str("where", "orderer.settle.Reconfigure").Uint32(lg.Epoch, uint32(epoch+1)).Msg(err.Error())
+			next = nil
+		} else {
+			ord.log.Log().Uint32(lg.Epoch, uint32(epoch+1)).Msg(lg.CommitteeChanged)
+		}
+		if conf.HandoverFile != "" {
+			h := &config.Handover{Epoch: epoch + 1, Committee: config.CommitteeOf(conf), Change: ord.tracker.agreed, Keys: ord.tracker.dealt}
+			if err := writeHandover(conf.HandoverFile, h); err != nil {
+				ord.log.Error().Str("where", "orderer.settle.writeHandover").Msg(err.Error())
+			}
+		}
+	}
+	ord.confs[epoch+1] = next
+	delete(ord.confs, epoch-1)
+}
+
+// writeHandover writes the handover to a temporary file which is then moved in place of the given one,
+// so a process joining the committee never reads a partial handover.
+func writeHandover(path string, h *config.Handover) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = config.StoreHandover(file, h)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// switchServices replaces the syncer and the alerter with ones working for the committee described by the given config.
+func (ord *orderer) switchServices(conf config.Config) {
+	defer ord.wg.Done()
+	ord.switchMx.Lock()
+	defer ord.switchMx.Unlock()
+	if ord.stopped {
+		return
+	}
+	ord.sync().Stop()
+	ord.alert().Stop()
+	syncer, alerter, err := ord.services.Network(conf)
+	if err != nil {
+		ord.log.Error().Str("where", "orderer.switchServices").Msg(err.Error())
+		return
+	}
+	ord.svcMx.Lock()
+	ord.syncer = syncer
+	ord.alerter = alerter
+	ord.netConf = conf
+	ord.svcMx.Unlock()
+	syncer.Start()
+	alerter.Start()
+	if ord.forkObserver != nil {
+		ord.forkObserver.RemoveObserver()
+	}
+	if ord.creator != nil {
+		ord.forkObserver = ord.creator.Watch(alerter)
+	}
+}
+
+// sync returns the syncer working for the current committee.
+func (ord *orderer) sync() gomel.Syncer {
+	ord.svcMx.RLock()
+	defer ord.svcMx.RUnlock()
+	return ord.syncer
+}
+
+// alert returns the alerter working for the current committee.
+func (ord *orderer) alert() gomel.Alerter {
+	ord.svcMx.RLock()
+	defer ord.svcMx.RUnlock()
+	return ord.alerter
+}
+
+// network returns the config of the committee served by the current syncer and alerter.
+func (ord *orderer) network() config.Config {
+	ord.svcMx.RLock()
+	defer ord.svcMx.RUnlock()
+	return ord.netConf
+}
+
+// changeTracker counts proposals of committee changes in units ordered in a single epoch.
+// A change is agreed when a quorum of the committee has proposed it. The key of the new committee combines the keys
+// of the first MinimalTrusted proposers in the linear order, so all the processes agree on it, and no single dealer knows its secret.
+type changeTracker struct {
+	epoch    gomel.EpochID
+	conf     config.Config
+	quorum   uint16
+	trusted  uint16
+	proposed map[string]map[uint16]bool
+	first    map[string]*config.CommitteeChange
+	keys     map[string][]config.DealtKey
+	agreed   *config.CommitteeChange
+	dealt    []config.DealtKey
+}
+
+func newChangeTracker(epoch gomel.EpochID, conf config.Config) *changeTracker {
+	return &changeTracker{
+		epoch:    epoch,
+		conf:     conf,
+		quorum:   gomel.MinimalQuorum(conf.NProc),
+		trusted:  gomel.MinimalTrusted(conf.NProc),
+		proposed: make(map[string]map[uint16]bool),
+		first:    make(map[string]*config.CommitteeChange),
+		keys:     make(map[string][]config.DealtKey),
+	}
+}
+
+// observe takes into account a proposal contained in the given ordered unit, if there is one.
+func (ct *changeTracker) observe(u gomel.Unit) {
+	if ct.agreed != nil || !config.IsCommitteeChange(u.Data()) {
+		return
+	}
+	ch, err := config.DecodeCommitteeChange(u.Data())
+	if err != nil {
+		return
+	}
+	if _, err = config.ApplyChange(config.CommitteeOf(ct.conf), ch); err != nil {
+		return
+	}
+	key := string(ch.Proposal())
+	if ct.proposed[key] == nil {
+		ct.proposed[key] = make(map[uint16]bool)
+		ct.first[key] = ch
+	}
+	if ct.proposed[key][u.Creator()] {
+		return
+	}
+	ct.proposed[key][u.Creator()] = true
+	if uint16(len(ct.keys[key])) < ct.trusted {
+		ct.keys[key] = append(ct.keys[key], config.DealtKey{Dealer: u.Creator(), Key: ch.Key})
+	}
+	if uint16(len(ct.proposed[key])) >= ct.quorum {
+		ct.agreed = ct.first[key]
+		ct.dealt = ct.keys[key]
+	}
+}
+
+// syncerProxy passes requests of epochs to the syncer working for the current committee.
+type syncerProxy struct {
+	ord *orderer
+}
+
+func (sp syncerProxy) RequestGossip(pid uint16)              { sp.ord.sync().RequestGossip(pid) }
+func (sp syncerProxy) RequestFetch(pid uint16, ids []uint64) { sp.ord.sync().RequestFetch(pid, ids) }
+func (sp syncerProxy) Multicast(u gomel.Unit)                { sp.ord.sync().Multicast(u) }
+func (sp syncerProxy) Peers() []gomel.PeerStatus             { return sp.ord.sync().Peers() }
+func (sp syncerProxy) Start()                                {}
+func (sp syncerProxy) Stop()                                 {}
+
+func (sp syncerProxy) RequestFetchByHash(pid uint16, hashes []*gomel.Hash, depth int, fallback []uint64) {
+	sp.ord.sync().RequestFetchByHash(pid, hashes, depth, fallback)
+}
+
+// alerterProxy passes requests of epochs to the alerter working for the current committee.
+type alerterProxy struct {
+	ord *orderer
+}
+
+func (ap alerterProxy) NewFork(u, v gomel.Preunit)             { ap.ord.alert().NewFork(u, v) }
+func (ap alerterProxy) HandleIncoming(conn network.Connection) { ap.ord.alert().HandleIncoming(conn) }
+func (ap alerterProxy) IsForker(pid uint16) bool               { return ap.ord.alert().IsForker(pid) }
+func (ap alerterProxy) Lock(pid uint16)                        { ap.ord.alert().Lock(pid) }
+func (ap alerterProxy) Unlock(pid uint16)                      { ap.ord.alert().Unlock(pid) }
+func (ap alerterProxy) Start()                                 {}
+func (ap alerterProxy) Stop()                                  {}
+func (ap alerterProxy) Disambiguate(us []gomel.Unit, pu gomel.Preunit) (gomel.Unit, error) {
+	return ap.ord.alert().Disambiguate(us, pu)
+}
+func (ap alerterProxy) RequestCommitment(pu gomel.Preunit, pid uint16) error {
+	return ap.ord.alert().RequestCommitment(pu, pid)
+}
+func (ap alerterProxy) ResolveMissingCommitment(err error, pu gomel.Preunit, pid uint16) error {
+	return ap.ord.alert().ResolveMissingCommitment(err, pu, pid)
+}
+func (ap alerterProxy) AddForkObserver(observer func(gomel.Preunit, gomel.Preunit)) utils.ObserverManager {
+	return ap.ord.alert().AddForkObserver(observer)
+}
@@ -3,19 +3,29 @@ package run
 
 import (
 	"errors"
+	"os"
+	"strings"
 
 	"github.com/rs/zerolog"
 
+	"gitlab.com/alephledger/consensus-go/pkg/admin"
 	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/finality"
 	"gitlab.com/alephledger/consensus-go/pkg/forking"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	"gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
+	"gitlab.com/alephledger/consensus-go/pkg/network/mem"
+	"gitlab.com/alephledger/consensus-go/pkg/network/secure"
 	"gitlab.com/alephledger/consensus-go/pkg/orderer"
 	"gitlab.com/alephledger/consensus-go/pkg/random/beacon"
 	"gitlab.com/alephledger/consensus-go/pkg/random/coin"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
 	"gitlab.com/alephledger/consensus-go/pkg/sync/syncer"
 	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
 	"gitlab.com/alephledger/core-go/pkg/crypto/tss"
+	"gitlab.com/alephledger/core-go/pkg/network"
 	"gitlab.com/alephledger/core-go/pkg/network/tcp"
 )
 
@@ -24,13 +34,28 @@ import (
 // Process initializes two orderers and a channel between them used to pass the result of the setup phase.
 // Returns two functions that can be used to, respectively, start and stop the whole system.
 // The provided preblock sink gets closed after Process produces the last preblock.
+// If conf.NumberOfEpochs is 0, Process produces preblocks until it is stopped and the sink is never closed.
+// If conf.UnitStoreDir is set, a restarted Process orders the stored units again and pushes their preblocks into the sink,
+// including the ones it pushed before the restart. The sink has no way of telling them apart, so a consumer
+// that must not see a preblock twice should use Stream, which skips the preblocks already present in its log.
 func Process(setupConf, conf config.Config, ds core.DataSource, ps core.PreblockSink) (start func(), stop func(), err error) {
+	return process(setupConf, conf, ds, toSink(ps))
+}
+
+// Stream is a counterpart of Process that appends preblocks to the given log instead of pushing them into a sink,
+// so that many consumers can subscribe to them and resume from any position still present in the log.
+// The log is marked as finished after Stream produces the last preblock. Closing it is left to the caller, after stopping the process.
+func Stream(setupConf, conf config.Config, ds core.DataSource, pl *stream.Log) (start func(), stop func(), err error) {
+	return process(setupConf, conf, ds, toLog(pl))
+}
+
+func process(setupConf, conf config.Config, ds core.DataSource, out output) (start func(), stop func(), err error) {
 	wtkchan := make(chan *tss.WeakThresholdKey, 1)
 	startSetup, stopSetup, setupErr := setup(setupConf, wtkchan)
 	if setupErr != nil {
 		return nil, nil, errors.New("an error occurred while initializing setup: " + setupErr.Error())
 	}
-	startConsensus, stopConsensus, consensusErr := consensus(conf, wtkchan, ds, ps)
+	startConsensus, stopConsensus, consensusErr := consensus(conf, wtkchan, ds, out)
 	if consensusErr != nil {
 		return nil, nil, errors.New("an error occurred while initializing consensus: " + consensusErr.Error())
 	}
@@ -49,46 +74,154 @@ func Process(setupConf, conf config.Config, ds core.DataSource, ps core.Preblock
 // Instead, a fixed seeded WeakThresholdKey is used for the main consensus.
 // NoBeacon should be used for testing purposes only! Returns start and stop functions.
 func NoBeacon(conf config.Config, ds core.DataSource, ps core.PreblockSink) (func(), func(), error) {
+	return noBeacon(conf, ds, toSink(ps))
+}
+
+// NoBeaconStream is a counterpart of NoBeacon that appends preblocks to the given log, see Stream.
+// NoBeaconStream should be used for testing purposes only! Returns start and stop functions.
+func NoBeaconStream(conf config.Config, ds core.DataSource, pl *stream.Log) (func(), func(), error) {
+	return noBeacon(conf, ds, toLog(pl))
+}
+
+func noBeacon(conf config.Config, ds core.DataSource, out output) (func(), func(), error) {
+	return withKey(conf, tss.SeededWTK(conf.NProc, conf.Pid, 2137, nil), ds, out)
+}
+
+// Join is a counterpart of Process for a process joining the committee after a committee change.
+// It does not perform the setup phase, the WeakThresholdKey handed over to the new committee is taken from conf,
+// see config.Join. Consensus starts in conf.FirstEpoch. Returns start and stop functions.
+func Join(conf config.Config, ds core.DataSource, ps core.PreblockSink) (func(), func(), error) {
+	return withKey(conf, conf.WTKey, ds, toSink(ps))
+}
+
+// JoinStream is a counterpart of Join that appends preblocks to the given log, see Stream.
+func JoinStream(conf config.Config, ds core.DataSource, pl *stream.Log) (func(), func(), error) {
+	return withKey(conf, conf.WTKey, ds, toLog(pl))
+}
+
+// withKey runs the main consensus with the given WeakThresholdKey.
+func withKey(conf config.Config, wtkey *tss.WeakThresholdKey, ds core.DataSource, out output) (func(), func(), error) {
+	if wtkey == nil {
+		return nil, nil, errors.New("no WeakThresholdKey in the config")
+	}
 	wtkchan := make(chan *tss.WeakThresholdKey, 1)
-	wtkchan <- tss.SeededWTK(conf.NProc, conf.Pid, 2137, nil)
-	start, stop, err := consensus(conf, wtkchan, ds, ps)
-	if err != nil {
-		return nil, nil, err
+	wtkchan <- wtkey
+	return consensus(conf, wtkchan, ds, out)
+}
+
+// output receives preblocks together with the timing units of the rounds they were made of.
+// The last flag is set for the last preblock of the last epoch.
+type output func(pb *core.Preblock, timingUnit gomel.Unit, last bool) error
+
+// toSink returns an output pushing preblocks into the given sink, and closing it after the last one.
+func toSink(ps core.PreblockSink) output {
+	return func(pb *core.Preblock, _ gomel.Unit, last bool) error {
+		ps <- pb
+		if last {
+			// we have just sent the last preblock of the last epoch, it's safe to quit
+			close(ps)
+		}
+		return nil
 	}
-	return start, stop, nil
 }
 
-func consensus(conf config.Config, wtkchan chan *tss.WeakThresholdKey, ds core.DataSource, ps core.PreblockSink) (func(), func(), error) {
+// toLog returns an output appending preblocks to the given log, and finishing it after the last one.
+func toLog(pl *stream.Log) output {
+	return func(pb *core.Preblock, timingUnit gomel.Unit, last bool) error {
+		err := pl.Append(stream.Position{Epoch: timingUnit.EpochID(), Level: timingUnit.Level()}, pb)
+		if last {
+			pl.Finish()
+		}
+		return err
+	}
+}
+
+func consensus(conf config.Config, wtkchan chan *tss.WeakThresholdKey, ds core.DataSource, out output) (func(), func(), error) {
 	log, err := logging.NewLogger(conf)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// the finality service is started together with the orderer, before the first preblock is produced
+	var (
+		fin      *finality.Service
+		certNet  network.Server
+		certFile *os.File
+	)
+	if conf.CertificateInterval > 0 {
+		certNet, err = netServer(conf, conf.CertNetType, conf.CertAddresses, "cert", log)
+		if err != nil {
+			return nil, nil, err
+		}
+		certFile, err = os.OpenFile(conf.CertificateFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			certNet.Stop()
+			return nil, nil, err
+		}
+	}
+
 	makePreblock := func(units []gomel.Unit) {
-		ps <- gomel.ToPreblock(units)
 		timingUnit := units[len(units)-1]
-		if timingUnit.Level() == conf.LastLevel && timingUnit.EpochID() == gomel.EpochID(conf.NumberOfEpochs-1) {
-			// we have just sent the last preblock of the last epoch, it's safe to quit
-			close(ps)
+		last := timingUnit.Level() == conf.LastLevel && config.IsLastEpoch(conf, timingUnit.EpochID())
+		pb := gomel.ToPreblock(units)
+		if fin != nil {
+			fin.Certify(stream.Position{Epoch: timingUnit.EpochID(), Level: timingUnit.Level()}, pb)
+		}
+		if err := out(pb, timingUnit, last); err != nil {
+			log.Error().Str("where", "run.consensus.output").Msg(err.Error())
 		}
 	}
 
-	ord := orderer.New(conf, ds, makePreblock, log)
-	syn, err := syncer.New(conf, ord, log, false)
-	if err != nil {
-		return nil, nil, err
+	var ord gomel.Orderer
+	services := orderer.Services{
+		RandomSource: func(c config.Config) gomel.RandomSourceFactory {
+			return coin.NewFactory(c.Pid, c.WTKey)
+		},
+		Network: func(c config.Config) (gomel.Syncer, gomel.Alerter, error) {
+			syn, err := syncer.New(c, ord, log, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			netserv, err := netServer(c, c.RMCNetType, c.RMCAddresses, "alert", log)
+			if err != nil {
+				return nil, nil, err
+			}
+			alrt, err := forking.NewAlerter(c, ord, netserv, log)
+			if err != nil {
+				return nil, nil, err
+			}
+			return syn, serverAlerter{alrt, netserv}, nil
+		},
+	}
+	if conf.CommitteeChanges {
+		ord, err = orderer.NewReconfigurable(conf, ds, makePreblock, services, log)
+	} else {
+		ord, err = orderer.New(conf, ds, makePreblock, log)
 	}
-	netserv, err := tcp.NewServer(conf.RMCAddresses[conf.Pid], conf.RMCAddresses, log)
 	if err != nil {
+		if certNet != nil {
+			certNet.Stop()
+			certFile.Close()
+		}
 		return nil, nil, err
 	}
-	alrt, err := forking.NewAlerter(conf, ord, netserv, log)
+	syn, alrt, err := services.Network(conf)
 	if err != nil {
 		return nil, nil, err
 	}
+	var adm *admin.Server
+	if conf.AdminAddress != "" {
+		adm = admin.NewServer(conf.AdminAddress, ord, log)
+	}
 
 	started := make(chan struct{})
 	start := func() {
+		if adm != nil {
+			if err := adm.Start(); err != nil {
+				log.Error().Str("where", "run.consensus.admin").Msg(err.Error())
+				adm = nil
+			}
+		}
 		go func() {
 			defer func() { started <- struct{}{} }()
 			wtkey, ok := <-wtkchan
@@ -100,17 +233,70 @@ func consensus(conf config.Config, wtkchan chan *tss.WeakThresholdKey, ds core.D
 			logWTK(log, wtkey)
 
 			conf.WTKey = wtkey
-			ord.Start(coin.NewFactory(conf.Pid, wtkey), syn, alrt)
+			if certNet != nil {
+				fin = finality.NewService(conf, certNet, func(c *finality.Certificate) {
+					if err := finality.WriteCertificate(c, certFile); err != nil {
+						log.Error().Str("where", "run.consensus.certificate").Msg(err.Error())
+					}
+				}, log)
+				fin.Start()
+			}
+			ord.Start(services.RandomSource(conf), syn, alrt)
 		}()
 	}
 	stop := func() {
 		<-started
-		netserv.Stop()
+		if adm != nil {
+			adm.Stop()
+		}
 		ord.Stop()
+		if fin != nil {
+			fin.Stop()
+		}
+		if certNet != nil {
+			certNet.Stop()
+			certFile.Close()
+		}
 	}
 	return start, stop, nil
 }
 
+// netServer returns a network server of the given type, "mem" or "tcp" possibly prefixed with "secure+",
+// for a service not handled by the syncer, like the alerter. The name is used to count the bytes it transfers.
+func netServer(conf config.Config, netType string, addresses []string, name string, log zerolog.Logger) (network.Server, error) {
+	var netserv network.Server
+	var err error
+	plainType := strings.TrimPrefix(netType, secure.Prefix)
+	if plainType == "mem" {
+		netserv, err = mem.NewServer(addresses[conf.Pid], addresses)
+	} else {
+		netserv, err = tcp.NewServer(addresses[conf.Pid], addresses, log)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plainType != netType {
+		keys, err := p2p.Keys(conf.P2PSecretKey, conf.P2PPublicKeys, conf.Pid)
+		if err != nil {
+			netserv.Stop()
+			return nil, err
+		}
+		netserv = secure.NewServer(netserv, conf.Pid, keys)
+	}
+	return metrics.CountBytes(netserv, name), nil
+}
+
+// serverAlerter is an alerter that owns the network server it uses and stops it together with itself.
+type serverAlerter struct {
+	gomel.Alerter
+	netserv network.Server
+}
+
+func (sa serverAlerter) Stop() {
+	sa.netserv.Stop()
+	sa.Alerter.Stop()
+}
+
 func setup(conf config.Config, wtkchan chan *tss.WeakThresholdKey) (func(), func(), error) {
 	log, err := logging.NewLogger(conf)
 	if err != nil {
@@ -131,7 +317,10 @@ func setup(conf config.Config, wtkchan chan *tss.WeakThresholdKey) (func(), func
 		panic("Setup phase: wrong level")
 	}
 
-	ord := orderer.New(conf, nil, extractHead, log)
+	ord, err := orderer.New(conf, nil, extractHead, log)
+	if err != nil {
+		return nil, nil, err
+	}
 	syn, err := syncer.New(conf, ord, log, true)
 	if err != nil {
 		return nil, nil, err
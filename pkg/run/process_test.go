@@ -0,0 +1,411 @@
+package run_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/finality"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	. "gitlab.com/alephledger/consensus-go/pkg/run"
+	"gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+	"gitlab.com/alephledger/core-go/pkg/tests"
+)
+
+// memCommittee generates keys for a committee of the given size communicating through the in-memory network.
+func memCommittee(nProc int, name string) ([]*config.Member, *config.Committee) {
+	members := make([]*config.Member, nProc)
+	committee := &config.Committee{
+		PublicKeys:          make([]gomel.PublicKey, nProc),
+		RMCVerificationKeys: make([]*bn256.VerificationKey, nProc),
+		P2PPublicKeys:       make([]*p2p.PublicKey, nProc),
+		SetupAddresses:      make(map[string][]string),
+		Addresses:           make(map[string][]string),
+	}
+	for pid := 0; pid < nProc; pid++ {
+		m := &config.Member{Pid: uint16(pid)}
+		committee.PublicKeys[pid], m.PrivateKey, _ = signing.GenerateKeys()
+		committee.RMCVerificationKeys[pid], m.RMCSecretKey, _ = bn256.GenerateKeys()
+		committee.P2PPublicKeys[pid], m.P2PSecretKey, _ = p2p.GenerateKeys()
+		members[pid] = m
+		for _, syncType := range []string{"rmc", "gossip", "fetch", "mcast", "cert"} {
+			address := fmt.Sprintf("%s/%d/%s", name, pid, syncType)
+			committee.Addresses[syncType] = append(committee.Addresses[syncType], address)
+		}
+	}
+	return members, committee
+}
+
+var _ = Describe("NoBeacon", func() {
+
+	var (
+		logDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		logDir, err = ioutil.TempDir("", "run")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(logDir)
+	})
+
+	Context("on the in-memory network", func() {
+		It("should produce the same preblocks in every process", func() {
+			const nProc = 4
+			members, committee := memCommittee(nProc, "nobeacon")
+			preblocks := make([][]*core.Preblock, nProc)
+			var wg sync.WaitGroup
+			for pid := 0; pid < nProc; pid++ {
+				conf := config.New(members[pid], committee)
+				conf.RMCNetType = "mem"
+				conf.GossipNetType = "mem"
+				conf.FetchNetType = "mem"
+				conf.MCastNetType = "mem"
+				conf.LogFile = filepath.Join(logDir, fmt.Sprint(pid))
+				conf.NumberOfEpochs = 2
+				conf.EpochLength = 5
+				conf.LastLevel = conf.EpochLength + conf.OrderStartLevel - 1
+				Expect(config.Valid(conf)).To(Succeed())
+
+				ps := make(chan *core.Preblock)
+				start, stop, err := NoBeacon(conf, tests.RandomDataSource(10), ps)
+				Expect(err).NotTo(HaveOccurred())
+				wg.Add(1)
+				go func(pid int) {
+					defer wg.Done()
+					for pb := range ps {
+						preblocks[pid] = append(preblocks[pid], pb)
+					}
+				}(pid)
+				defer stop()
+				start()
+			}
+			wg.Wait()
+			Expect(preblocks[0]).To(HaveLen(2 * 5))
+			for pid := 1; pid < nProc; pid++ {
+				Expect(preblocks[pid]).To(Equal(preblocks[0]))
+			}
+		})
+
+		It("should output the preblocks of the replayed units again after a restart", func() {
+			const nProc = 4
+			members, committee := memCommittee(nProc, "restart")
+			memConf := func(pid int) config.Config {
+				conf := config.New(members[pid], committee)
+				conf.RMCNetType = "mem"
+				conf.GossipNetType = "mem"
+				conf.FetchNetType = "mem"
+				conf.MCastNetType = "mem"
+				conf.LogFile = filepath.Join(logDir, fmt.Sprint(pid))
+				conf.NumberOfEpochs = 2
+				conf.EpochLength = 5
+				conf.LastLevel = conf.EpochLength + conf.OrderStartLevel - 1
+				return conf
+			}
+			preblocks := make([][]*core.Preblock, nProc)
+			var wg sync.WaitGroup
+			for pid := 1; pid < nProc; pid++ {
+				conf := memConf(pid)
+				Expect(config.Valid(conf)).To(Succeed())
+				ps := make(chan *core.Preblock)
+				start, stop, err := NoBeacon(conf, tests.RandomDataSource(10), ps)
+				Expect(err).NotTo(HaveOccurred())
+				wg.Add(1)
+				go func(pid int) {
+					defer wg.Done()
+					for pb := range ps {
+						preblocks[pid] = append(preblocks[pid], pb)
+					}
+				}(pid)
+				defer stop()
+				start()
+			}
+
+			conf := memConf(0)
+			conf.UnitStoreDir = filepath.Join(logDir, "units")
+			Expect(config.Valid(conf)).To(Succeed())
+			// the sink of the first run is large enough to never block the process while it is stopped
+			before := make(chan *core.Preblock, 2*5)
+			start, stop, err := NoBeacon(conf, tests.RandomDataSource(10), before)
+			Expect(err).NotTo(HaveOccurred())
+			start()
+			var first []*core.Preblock
+			for len(first) < 3 {
+				first = append(first, <-before)
+			}
+			stop()
+
+			ps := make(chan *core.Preblock)
+			start, stop, err = NoBeacon(conf, tests.RandomDataSource(10), ps)
+			Expect(err).NotTo(HaveOccurred())
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for pb := range ps {
+					preblocks[0] = append(preblocks[0], pb)
+				}
+			}()
+			defer stop()
+			start()
+			wg.Wait()
+
+			Expect(preblocks[1]).To(HaveLen(2 * 5))
+			Expect(first).To(Equal(preblocks[1][:3]))
+			// the replayed units are ordered anew, so the preblocks output before the restart are output again
+			Expect(preblocks[0]).To(Equal(preblocks[1]))
+			for pid := 2; pid < nProc; pid++ {
+				Expect(preblocks[pid]).To(Equal(preblocks[1]))
+			}
+		})
+
+		It("should certify the preblocks of every epoch", func() {
+			const nProc = 4
+			members, committee := memCommittee(nProc, "certificates")
+			var wg sync.WaitGroup
+			for pid := 0; pid < nProc; pid++ {
+				conf := config.New(members[pid], committee)
+				conf.RMCNetType = "mem"
+				conf.GossipNetType = "mem"
+				conf.FetchNetType = "mem"
+				conf.MCastNetType = "mem"
+				conf.CertNetType = "mem"
+				conf.LogFile = filepath.Join(logDir, fmt.Sprint(pid))
+				conf.CertificateFile = filepath.Join(logDir, fmt.Sprint(pid, ".certs"))
+				conf.CertificateInterval = 2
+				conf.NumberOfEpochs = 2
+				conf.EpochLength = 5
+				conf.LastLevel = conf.EpochLength + conf.OrderStartLevel - 1
+				Expect(config.Valid(conf)).To(Succeed())
+
+				ps := make(chan *core.Preblock)
+				start, stop, err := NoBeacon(conf, tests.RandomDataSource(10), ps)
+				Expect(err).NotTo(HaveOccurred())
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range ps {
+					}
+				}()
+				defer stop()
+				start()
+			}
+			wg.Wait()
+			last := func() stream.Position {
+				file, err := os.Open(filepath.Join(logDir, "0.certs"))
+				Expect(err).NotTo(HaveOccurred())
+				defer file.Close()
+				certs, err := finality.ReadCertificates(file)
+				Expect(err).NotTo(HaveOccurred())
+				if len(certs) == 0 {
+					return stream.Position{}
+				}
+				for _, c := range certs {
+					Expect(c.Verify(committee.RMCVerificationKeys)).To(BeTrue())
+				}
+				return certs[len(certs)-1].Position
+			}
+			Eventually(last, 5*time.Second).Should(Equal(stream.Position{Epoch: 1, Level: 4}))
+		})
+	})
+})
+
+var _ = Describe("NoBeaconStream", func() {
+
+	var (
+		dir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "stream")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("on the in-memory network", func() {
+		It("should let many consumers of every process read the same preblocks", func() {
+			const nProc = 4
+			members, committee := memCommittee(nProc, "nobeaconstream")
+			preblocks := make([][][]*core.Preblock, nProc)
+			var wg sync.WaitGroup
+			for pid := 0; pid < nProc; pid++ {
+				conf := config.New(members[pid], committee)
+				conf.RMCNetType = "mem"
+				conf.GossipNetType = "mem"
+				conf.FetchNetType = "mem"
+				conf.MCastNetType = "mem"
+				conf.LogFile = filepath.Join(dir, fmt.Sprint(pid))
+				conf.NumberOfEpochs = 2
+				conf.EpochLength = 5
+				conf.LastLevel = conf.EpochLength + conf.OrderStartLevel - 1
+				Expect(config.Valid(conf)).To(Succeed())
+
+				pl, err := stream.Open(filepath.Join(dir, fmt.Sprint(pid, ".preblocks")), 0)
+				Expect(err).NotTo(HaveOccurred())
+				defer pl.Close()
+				start, stop, err := NoBeaconStream(conf, tests.RandomDataSource(10), pl)
+				Expect(err).NotTo(HaveOccurred())
+				preblocks[pid] = make([][]*core.Preblock, 2)
+				for i := range preblocks[pid] {
+					s, err := pl.Subscribe(stream.Position{})
+					Expect(err).NotTo(HaveOccurred())
+					wg.Add(1)
+					go func(pid, i int) {
+						defer wg.Done()
+						for entry := range s.Entries() {
+							preblocks[pid][i] = append(preblocks[pid][i], entry.Preblock)
+						}
+					}(pid, i)
+				}
+				defer stop()
+				start()
+			}
+			wg.Wait()
+			Expect(preblocks[0][0]).To(HaveLen(2 * 5))
+			for pid := 0; pid < nProc; pid++ {
+				for i := range preblocks[pid] {
+					Expect(preblocks[pid][i]).To(Equal(preblocks[0][0]))
+				}
+			}
+		})
+	})
+})
+
+var _ = Describe("Join", func() {
+
+	var (
+		dir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "join")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("on the in-memory network", func() {
+		It("should let members added by a committee change replace a removed one", func() {
+			const nProc = 4
+			members, committee := memCommittee(nProc, "reconfig")
+			added, addedCommittee := memCommittee(2, "reconfig-added")
+			handoverFile := filepath.Join(dir, "handover")
+			memConf := func(conf config.Config, name string) config.Config {
+				conf.RMCNetType = "mem"
+				conf.GossipNetType = "mem"
+				conf.FetchNetType = "mem"
+				conf.MCastNetType = "mem"
+				conf.LogFile = filepath.Join(dir, name)
+				conf.CommitteeChanges = true
+				conf.NumberOfEpochs = 3
+				conf.EpochLength = 5
+				conf.LastLevel = conf.EpochLength + conf.OrderStartLevel - 1
+				return conf
+			}
+
+			preblocks := make([][]*core.Preblock, nProc+2)
+			var removedMx sync.Mutex
+			var wg sync.WaitGroup
+			for pid := 0; pid < nProc; pid++ {
+				conf := memConf(config.New(members[pid], committee), fmt.Sprint(pid))
+				change, err := config.NewCommitteeChange(conf, []uint16{nProc - 1}, addedCommittee)
+				Expect(err).NotTo(HaveOccurred())
+				conf.Proposal = change
+				if pid == 0 {
+					conf.HandoverFile = handoverFile
+				}
+				Expect(config.Valid(conf)).To(Succeed())
+
+				ps := make(chan *core.Preblock)
+				start, stop, err := NoBeacon(conf, tests.RandomDataSource(10), ps)
+				Expect(err).NotTo(HaveOccurred())
+				if pid == nProc-1 {
+					// the removed member never produces its last preblock, so its sink is never closed
+					go func() {
+						for pb := range ps {
+							removedMx.Lock()
+							preblocks[nProc-1] = append(preblocks[nProc-1], pb)
+							removedMx.Unlock()
+						}
+					}()
+				} else {
+					wg.Add(1)
+					go func(pid int) {
+						defer wg.Done()
+						for pb := range ps {
+							preblocks[pid] = append(preblocks[pid], pb)
+						}
+					}(pid)
+				}
+				defer stop()
+				start()
+			}
+
+			Eventually(func() bool {
+				_, err := os.Stat(handoverFile)
+				return err == nil
+			}, 10*time.Second).Should(BeTrue())
+			file, err := os.Open(handoverFile)
+			Expect(err).NotTo(HaveOccurred())
+			h, err := config.LoadHandover(file)
+			file.Close()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(h.Epoch).To(Equal(gomel.EpochID(1)))
+			newCommittee, err := config.ApplyChange(h.Committee, h.Change)
+			Expect(err).NotTo(HaveOccurred())
+
+			for i, m := range added {
+				m.Pid = uint16(nProc - 1 + i)
+				conf, err := config.Join(memConf(config.New(m, newCommittee), fmt.Sprint("added", i)), h)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(conf.FirstEpoch).To(Equal(gomel.EpochID(1)))
+				Expect(config.Valid(conf)).To(Succeed())
+
+				ps := make(chan *core.Preblock)
+				start, stop, err := Join(conf, tests.RandomDataSource(10), ps)
+				Expect(err).NotTo(HaveOccurred())
+				wg.Add(1)
+				go func(pid int) {
+					defer wg.Done()
+					for pb := range ps {
+						preblocks[pid] = append(preblocks[pid], pb)
+					}
+				}(nProc + i)
+				defer stop()
+				start()
+			}
+			wg.Wait()
+
+			Expect(preblocks[0]).To(HaveLen(3 * 5))
+			for pid := 1; pid < nProc-1; pid++ {
+				Expect(preblocks[pid]).To(Equal(preblocks[0]))
+			}
+			for pid := nProc; pid < nProc+2; pid++ {
+				Expect(preblocks[pid]).To(Equal(preblocks[0][5:]))
+			}
+			removedMx.Lock()
+			defer removedMx.Unlock()
+			Expect(preblocks[nProc-1]).To(Equal(preblocks[0][:5]))
+		})
+	})
+})
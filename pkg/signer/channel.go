@@ -0,0 +1,157 @@
+package signer
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+)
+
+const (
+	nonceSize = 32
+	macSize   = sha256.Size
+)
+
+// labels tie the proofs and the keys of a session to their purpose, so one cannot be reflected as another.
+var (
+	secretLabel   = []byte("gomel signer secret")
+	serverLabel   = []byte("gomel signer server")
+	clientLabel   = []byte("gomel signer client")
+	requestLabel  = []byte("gomel signer requests")
+	responseLabel = []byte("gomel signer responses")
+)
+
+// NewSecret derives the secret authenticating a Server and its Remotes from the P2P secret key of the committee member,
+// present in both the private key file of the signer and the one of the gomel process.
+func NewSecret(key *p2p.SecretKey) []byte {
+	return mac([]byte(key.Encode()), secretLabel)
+}
+
+// channel carries the messages of a Remote and a Server over a connection.
+// Both sides prove the knowledge of the secret in a challenge-response exchange: the client sends a nonce,
+// the server replies with its own nonce and a MAC of both, and the client answers with its MAC of both.
+// Every following message is followed by a MAC under a key of the session in its direction, covering also the number
+// of the message, so messages cannot be injected, altered, reordered or replayed by anyone without the secret.
+type channel struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	sendKey  []byte
+	recvKey  []byte
+	sent     uint64
+	received uint64
+}
+
+// dialChannel performs the exchange on the client side of the given connection.
+func dialChannel(conn net.Conn, secret []byte) (*channel, error) {
+	reader := bufio.NewReader(conn)
+	nonces := make([]byte, 2*nonceSize)
+	if _, err := rand.Read(nonces[:nonceSize]); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(nonces[:nonceSize]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(reader, nonces[nonceSize:]); err != nil {
+		return nil, err
+	}
+	proof := make([]byte, macSize)
+	if _, err := io.ReadFull(reader, proof); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(proof, mac(secret, serverLabel, nonces)) {
+		return nil, errors.New("signer failed to authenticate")
+	}
+	if _, err := conn.Write(mac(secret, clientLabel, nonces)); err != nil {
+		return nil, err
+	}
+	return &channel{
+		conn:    conn,
+		reader:  reader,
+		sendKey: mac(secret, requestLabel, nonces),
+		recvKey: mac(secret, responseLabel, nonces),
+	}, nil
+}
+
+// acceptChannel performs the exchange on the server side of the given connection.
+func acceptChannel(conn net.Conn, secret []byte) (*channel, error) {
+	reader := bufio.NewReader(conn)
+	nonces := make([]byte, 2*nonceSize)
+	if _, err := io.ReadFull(reader, nonces[:nonceSize]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonces[nonceSize:]); err != nil {
+		return nil, err
+	}
+	reply := append(append([]byte{}, nonces[nonceSize:]...), mac(secret, serverLabel, nonces)...)
+	if _, err := conn.Write(reply); err != nil {
+		return nil, err
+	}
+	proof := make([]byte, macSize)
+	if _, err := io.ReadFull(reader, proof); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(proof, mac(secret, clientLabel, nonces)) {
+		return nil, errors.New("client failed to authenticate")
+	}
+	return &channel{
+		conn:    conn,
+		reader:  reader,
+		sendKey: mac(secret, responseLabel, nonces),
+		recvKey: mac(secret, requestLabel, nonces),
+	}, nil
+}
+
+// send writes the message preceded by its length (4 bytes) and followed by its MAC.
+func (c *channel) send(msg []byte) error {
+	buf := make([]byte, 4, 4+len(msg)+macSize)
+	binary.LittleEndian.PutUint32(buf, uint32(len(msg)))
+	buf = append(buf, msg...)
+	buf = append(buf, c.tag(c.sendKey, c.sent, msg)...)
+	c.sent++
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// receive reads a message of at most maxSize bytes and checks its MAC.
+func (c *channel) receive(maxSize uint32) ([]byte, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, buf); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(buf)
+	if size > maxSize {
+		return nil, errors.New("message of the signer too big")
+	}
+	msg := make([]byte, size+macSize)
+	if _, err := io.ReadFull(c.reader, msg); err != nil {
+		return nil, err
+	}
+	msg, proof := msg[:size], msg[size:]
+	if !hmac.Equal(proof, c.tag(c.recvKey, c.received, msg)) {
+		return nil, errors.New("message of the signer failed to authenticate")
+	}
+	c.received++
+	return msg, nil
+}
+
+// tag computes the MAC of the message with the given number.
+func (c *channel) tag(key []byte, number uint64, msg []byte) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, number)
+	return mac(key, buf, msg)
+}
+
+// mac computes a HMAC of the concatenation of the given parts.
+func mac(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,54 @@
+// Package signer implements signing units outside of the consensus process.
+//
+// A Local signer holds the private key and refuses to sign two different units at the same epoch and height,
+// or any unit below the last one it signed. A Server exposes local signers over a socket, one for every domain
+// (the setup and the consensus build separate dags with the same key), and a Remote is the gomel.Signer used
+// by the consensus process to talk to it. This way the private key never has to be present on the consensus host.
+// The Server and its Remotes authenticate each other and every message with a secret derived from the P2P secret key of the member.
+package signer
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// Local signs units with a private key, refusing to sign anything that could be a fork of a unit signed before.
+type Local struct {
+	pid    uint16
+	key    gomel.PrivateKey
+	mx     sync.Mutex
+	record *signing.Record
+}
+
+// NewLocal returns a signer of the units of the given process, persisting the record of the last signed unit in the given file
+// and loading the record left there by previous runs. An empty path results in a signer that remembers its record only in memory.
+// If the record cannot be loaded, the error is returned together with a signer that refuses to sign anything.
+func NewLocal(pid uint16, key gomel.PrivateKey, path string) (*Local, error) {
+	record, err := signing.OpenRecord(path)
+	return &Local{pid: pid, key: key, record: record}, err
+}
+
+// SignUnit signs the preunit if it is not a fork of a unit signed earlier.
+// The last signed unit can be signed again, so that a request can be repeated after its response got lost.
+func (l *Local) SignUnit(pu gomel.Preunit) (gomel.Signature, error) {
+	if pu.Creator() != l.pid {
+		return nil, errors.New("refused to sign a unit of process " + strconv.Itoa(int(pu.Creator())))
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.record.Repeats(pu) {
+		return l.key.Sign(pu.Hash()), nil
+	}
+	if !l.record.Allows(pu.EpochID(), pu.Height()) {
+		return nil, errors.New("refused to sign a possible fork at epoch " + strconv.Itoa(int(pu.EpochID())) + " height " + strconv.Itoa(pu.Height()) +
+			", " + l.record.String())
+	}
+	if err := l.record.Store(pu); err != nil {
+		return nil, err
+	}
+	return l.key.Sign(pu.Hash()), nil
+}
@@ -0,0 +1,82 @@
+package signer
+
+import (
+	"errors"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/encoding"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+const (
+	statusSigned  byte = 0
+	statusRefused byte = 1
+	// maxRequestSize bounds the size of a request: the domain and an encoded unit.
+	maxRequestSize = 1 + 255 + config.MaxDataBytesPerUnit + config.MaxRandomSourceDataBytesPerUnit + 1<<20
+	// maxResponseSize bounds the size of a response: the status and a signature or a reason of a refusal.
+	maxResponseSize = 1 + 1<<16
+)
+
+// Requests and responses are sent as messages of a channel, authenticated with the secret shared by the server and its clients.
+// A request consists of the domain preceded by its length (1 byte), and the encoded unit without a signature.
+// The signer decodes the unit and computes its hash by itself.
+// A response consists of the status (1 byte), and either the signature or the reason of a refusal.
+
+func encodeRequest(domain string, pu gomel.Preunit) ([]byte, error) {
+	if len(domain) > 255 {
+		return nil, errors.New("domain name too long")
+	}
+	data, err := encoding.EncodeUnit(pu)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 1+len(domain)+len(data))
+	buf = append(buf, byte(len(domain)))
+	buf = append(buf, domain...)
+	return append(buf, data...), nil
+}
+
+// decodeRequest returns the domain and the encoded unit of a request.
+func decodeRequest(msg []byte) (string, []byte, error) {
+	if len(msg) == 0 || len(msg) < 1+int(msg[0]) {
+		return "", nil, errors.New("malformed request")
+	}
+	return string(msg[1 : 1+msg[0]]), msg[1+msg[0]:], nil
+}
+
+// decodePreunit decodes a preunit, treating an inconsistent one as malformed data.
+func decodePreunit(data []byte) (pu gomel.Preunit, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pu, err = nil, errors.New("malformed unit")
+		}
+	}()
+	pu, err = encoding.DecodePreunit(data)
+	if err == nil && pu == nil {
+		err = errors.New("missing unit")
+	}
+	return pu, err
+}
+
+func encodeResponse(signature gomel.Signature, refusal error) []byte {
+	status, payload := statusSigned, []byte(signature)
+	if refusal != nil {
+		status, payload = statusRefused, []byte(refusal.Error())
+	}
+	return append([]byte{status}, payload...)
+}
+
+// decodeResponse returns the signature, the refusal of the signer, or an error if the response is malformed.
+func decodeResponse(msg []byte) (gomel.Signature, error, error) {
+	if len(msg) == 0 {
+		return nil, nil, errors.New("malformed response of the signer")
+	}
+	switch msg[0] {
+	case statusSigned:
+		return gomel.Signature(msg[1:]), nil, nil
+	case statusRefused:
+		return nil, errors.New(string(msg[1:])), nil
+	default:
+		return nil, nil, errors.New("malformed response of the signer")
+	}
+}
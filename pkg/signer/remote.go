@@ -0,0 +1,83 @@
+package signer
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/network/socket"
+)
+
+// Remote delegates signing units to a Server, keeping a single connection that is reopened after a failure.
+type Remote struct {
+	addr    string
+	domain  string
+	secret  []byte
+	timeout time.Duration
+	mx      sync.Mutex
+	conn    net.Conn
+	ch      *channel
+}
+
+// NewRemote returns a signer asking the server at the given address to sign units with its signer for the given domain.
+// The secret authenticates the server and the client to each other, see NewSecret.
+// The timeout bounds the time of a single request, including connecting to the server.
+func NewRemote(addr, domain string, secret []byte, timeout time.Duration) *Remote {
+	return &Remote{addr: addr, domain: domain, secret: secret, timeout: timeout}
+}
+
+// SignUnit sends the preunit to the server and returns the signature made there.
+func (r *Remote) SignUnit(pu gomel.Preunit) (gomel.Signature, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	request, err := encodeRequest(r.domain, pu)
+	if err != nil {
+		return nil, err
+	}
+	if r.conn == nil {
+		conn, err := socket.Dial(r.addr, r.timeout)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(r.timeout))
+		ch, err := dialChannel(conn, r.secret)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		r.conn, r.ch = conn, ch
+	}
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+	if err := r.ch.send(request); err != nil {
+		r.close()
+		return nil, err
+	}
+	response, err := r.ch.receive(maxResponseSize)
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	signature, refusal, err := decodeResponse(response)
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	return signature, refusal
+}
+
+// Close closes the connection to the server.
+func (r *Remote) Close() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.conn != nil {
+		r.close()
+	}
+}
+
+// close drops the connection, so that it is reopened by the next request.
+// This method must be called under mutex!
+func (r *Remote) close() {
+	r.conn.Close()
+	r.conn, r.ch = nil, nil
+}
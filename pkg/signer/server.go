@@ -0,0 +1,144 @@
+package signer
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/network/socket"
+)
+
+// Domains of the signers used by the gomel binary, the setup and the consensus sign units of separate dags.
+const (
+	SetupDomain     = "setup"
+	ConsensusDomain = "consensus"
+)
+
+// Server serves signing requests of Remote signers, using a separate signer for every domain.
+// Only clients knowing the secret of the member (see NewSecret) are served.
+type Server struct {
+	addr    string
+	signers map[string]gomel.Signer
+	secret  []byte
+	ln      net.Listener
+	mx      sync.Mutex
+	conns   map[net.Conn]bool
+	wg      sync.WaitGroup
+	log     zerolog.Logger
+}
+
+// NewServer returns a server listening on the given address, either a TCP address or a path of a unix socket prefixed with socket.UnixPrefix.
+// The secret authenticates the clients and the server to each other.
+func NewServer(addr string, signers map[string]gomel.Signer, secret []byte, log zerolog.Logger) *Server {
+	return &Server{
+		addr:    addr,
+		signers: signers,
+		secret:  secret,
+		conns:   make(map[net.Conn]bool),
+		log:     log.With().Int(lg.Service, lg.SignerService).Logger(),
+	}
+}
+
+// Start listens on the address of the server and serves requests in the background.
+func (s *Server) Start() error {
+	ln, err := socket.Listen(s.addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	s.wg.Add(1)
+	go s.accept()
+	s.log.Info().Msg(lg.ServiceStarted)
+	return nil
+}
+
+// Stop closes the server together with all the connections.
+func (s *Server) Stop() {
+	s.ln.Close()
+	s.mx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mx.Unlock()
+	s.wg.Wait()
+	s.log.Info().Msg(lg.ServiceStopped)
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mx.Lock()
+		s.conns[conn] = true
+		s.mx.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mx.Lock()
+		delete(s.conns, conn)
+		s.mx.Unlock()
+	}()
+	ch, err := acceptChannel(conn, s.secret)
+	if err != nil {
+		s.log.Error().Str("where", "signer.serve.authenticate").Msg(err.Error())
+		return
+	}
+	for {
+		msg, err := ch.receive(maxRequestSize)
+		if err != nil {
+			if err != io.EOF {
+				s.log.Error().Str("where", "signer.serve.read").Msg(err.Error())
+			}
+			return
+		}
+		domain, data, err := decodeRequest(msg)
+		if err != nil {
+			s.log.Error().Str("where", "signer.serve.decode").Msg(err.Error())
+			return
+		}
+		signer, ok := s.signers[domain]
+		if !ok {
+			err = errors.New("unknown domain " + domain)
+		}
+		var pu gomel.Preunit
+		if err == nil {
+			pu, err = decodePreunit(data)
+		}
+		if err != nil {
+			s.log.Error().Str("where", "signer.serve").Str(lg.Domain, domain).Msg(err.Error())
+			if err := ch.send(encodeResponse(nil, err)); err != nil {
+				return
+			}
+			continue
+		}
+		signature, err := signer.SignUnit(pu)
+		if err != nil {
+			s.log.Warn().Str(lg.Domain, domain).Uint32(lg.Epoch, uint32(pu.EpochID())).Int(lg.Height, pu.Height()).Msg(err.Error())
+		} else {
+			s.log.Info().Str(lg.Domain, domain).Uint32(lg.Epoch, uint32(pu.EpochID())).Int(lg.Height, pu.Height()).Msg(lg.UnitSigned)
+		}
+		if err := ch.send(encodeResponse(signature, err)); err != nil {
+			s.log.Error().Str("where", "signer.serve.respond").Msg(err.Error())
+			return
+		}
+	}
+}
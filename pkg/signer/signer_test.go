@@ -0,0 +1,212 @@
+package signer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/network/socket"
+	. "gitlab.com/alephledger/consensus-go/pkg/signer"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+	"gitlab.com/alephledger/consensus-go/pkg/unit"
+	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+)
+
+var _ = Describe("Signer", func() {
+	const nProc = 4
+
+	var (
+		pub    gomel.PublicKey
+		priv   gomel.PrivateKey
+		secret []byte
+		dir    string
+	)
+
+	// preunit returns a unit of the given process at the given epoch and height.
+	preunit := func(creator uint16, epoch gomel.EpochID, height int, data byte) gomel.Preunit {
+		heights := []int{-1, -1, -1, -1}
+		heights[creator] = height - 1
+		return tests.NewPreunitFromEpoch(epoch, creator, gomel.NewCrown(heights, &gomel.Hash{}), core.Data{data}, nil, nil)
+	}
+
+	withSignature := func(pu gomel.Preunit, signature gomel.Signature) gomel.Preunit {
+		return unit.NewPreunit(gomel.UnitID(pu), pu.View(), pu.Data(), pu.RandomSourceData(), signature)
+	}
+
+	BeforeEach(func() {
+		pub, priv, _ = signing.GenerateKeys()
+		_, p2pKey, err := p2p.GenerateKeys()
+		Expect(err).NotTo(HaveOccurred())
+		secret = NewSecret(p2pKey)
+		dir, err = ioutil.TempDir("", "signer")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Local", func() {
+		var local *Local
+
+		BeforeEach(func() {
+			var err error
+			local, err = NewLocal(1, priv, filepath.Join(dir, "consensus.signed"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should sign consecutive units", func() {
+			for epoch := gomel.EpochID(0); epoch < 2; epoch++ {
+				for height := 0; height < 3; height++ {
+					pu := preunit(1, epoch, height, 0)
+					signature, err := local.SignUnit(pu)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(pub.Verify(withSignature(pu, signature))).To(BeTrue())
+				}
+			}
+		})
+
+		It("should sign the last unit again", func() {
+			pu := preunit(1, 0, 0, 0)
+			_, err := local.SignUnit(pu)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = local.SignUnit(pu)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should refuse to sign a fork", func() {
+			_, err := local.SignUnit(preunit(1, 0, 2, 0))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = local.SignUnit(preunit(1, 0, 2, 1))
+			Expect(err).To(HaveOccurred())
+			_, err = local.SignUnit(preunit(1, 0, 1, 0))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should refuse to sign a unit of an older epoch", func() {
+			_, err := local.SignUnit(preunit(1, 1, 0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = local.SignUnit(preunit(1, 0, 5, 0))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should refuse to sign a unit of another process", func() {
+			_, err := local.SignUnit(preunit(2, 0, 0, 0))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should remember the last signed unit after a restart", func() {
+			_, err := local.SignUnit(preunit(1, 0, 3, 0))
+			Expect(err).NotTo(HaveOccurred())
+			restarted, err := NewLocal(1, priv, filepath.Join(dir, "consensus.signed"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = restarted.SignUnit(preunit(1, 0, 3, 1))
+			Expect(err).To(HaveOccurred())
+			_, err = restarted.SignUnit(preunit(1, 0, 3, 0))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = restarted.SignUnit(preunit(1, 0, 4, 0))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should refuse to sign anything with a malformed record", func() {
+			path := filepath.Join(dir, "consensus.signed")
+			Expect(ioutil.WriteFile(path, []byte("malformed"), 0644)).To(Succeed())
+			broken, err := NewLocal(1, priv, path)
+			Expect(err).To(HaveOccurred())
+			_, err = broken.SignUnit(preunit(1, 5, 0, 0))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Remote", func() {
+		var (
+			server *Server
+			remote *Remote
+			addr   string
+		)
+
+		start := func() {
+			setup, err := NewLocal(1, priv, "")
+			Expect(err).NotTo(HaveOccurred())
+			consensus, err := NewLocal(1, priv, filepath.Join(dir, "consensus.signed"))
+			Expect(err).NotTo(HaveOccurred())
+			server = NewServer(addr, map[string]gomel.Signer{SetupDomain: setup, ConsensusDomain: consensus}, secret, zerolog.Nop())
+			Expect(server.Start()).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			addr = socket.UnixPrefix + filepath.Join(dir, "signer.sock")
+			start()
+			remote = NewRemote(addr, ConsensusDomain, secret, time.Second)
+		})
+
+		AfterEach(func() {
+			remote.Close()
+			server.Stop()
+		})
+
+		It("should create units signed by the server", func() {
+			u, err := unit.NewSigned(1, 0, make([]gomel.Unit, nProc), 0, core.Data{1, 2, 3}, nil, remote)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pub.Verify(u)).To(BeTrue())
+		})
+
+		It("should pass the refusals of the server", func() {
+			_, err := remote.SignUnit(preunit(1, 0, 2, 0))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = remote.SignUnit(preunit(1, 0, 2, 1))
+			Expect(err).To(HaveOccurred())
+			// the connection is still usable
+			_, err = remote.SignUnit(preunit(1, 0, 3, 0))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should keep the domains apart", func() {
+			_, err := remote.SignUnit(preunit(1, 0, 2, 0))
+			Expect(err).NotTo(HaveOccurred())
+			setup := NewRemote(addr, SetupDomain, secret, time.Second)
+			defer setup.Close()
+			_, err = setup.SignUnit(preunit(1, 0, 0, 1))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should refuse an unknown domain", func() {
+			other := NewRemote(addr, "other", secret, time.Second)
+			defer other.Close()
+			_, err := other.SignUnit(preunit(1, 0, 0, 0))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should refuse a client with another secret", func() {
+			_, p2pKey, err := p2p.GenerateKeys()
+			Expect(err).NotTo(HaveOccurred())
+			impostor := NewRemote(addr, ConsensusDomain, NewSecret(p2pKey), time.Second)
+			defer impostor.Close()
+			_, err = impostor.SignUnit(preunit(1, 0, 0, 0))
+			Expect(err).To(HaveOccurred())
+			// nothing was signed, so the real client can sign the unit
+			_, err = remote.SignUnit(preunit(1, 0, 0, 1))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reconnect after the server restarts", func() {
+			_, err := remote.SignUnit(preunit(1, 0, 0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			server.Stop()
+			_, err = remote.SignUnit(preunit(1, 0, 1, 0))
+			Expect(err).To(HaveOccurred())
+			start()
+			_, err = remote.SignUnit(preunit(1, 0, 1, 0))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
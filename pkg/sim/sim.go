@@ -0,0 +1,529 @@
+// Package sim runs the whole protocol for a committee of virtual processes in a reproducible way.
+//
+// Every virtual process is a real orderer, with its own creator, adders, dags and extenders.
+// Instead of syncers, the simulator passes units between the processes. Messages wait in a queue ordered by a virtual clock,
+// which also tells the time to the processes and fires the timers of their creators (see gomel.Clock), and units are added to a process one at a time, only when their parents are already there. After every unit
+// the simulator waits until all the processes have dealt with it (see gomel.Activity), so no decision depends on
+// the timing of goroutines. Delays, losses and gossip partners are drawn from a generator seeded with Options.Seed,
+// as are the keys and the data of the processes. Hence a run, including all the units created, is determined by its seed.
+package sim
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/crypto/signing"
+	"gitlab.com/alephledger/consensus-go/pkg/encoding"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/orderer"
+	"gitlab.com/alephledger/consensus-go/pkg/random/coin"
+	"gitlab.com/alephledger/consensus-go/pkg/sync/fetch"
+	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+	"gitlab.com/alephledger/core-go/pkg/crypto/tss"
+)
+
+// Options describe the committee and the network of a simulation. Durations are measured in virtual time.
+type Options struct {
+	NProc          uint16
+	Epochs         int
+	EpochLength    int
+	Seed           int64
+	Latency        time.Duration // minimal delay of a message
+	Jitter         time.Duration // maximal random delay added to Latency
+	Drop           float64       // probability of losing a multicast unit on its way to a single process
+	GossipInterval time.Duration // time between gossips started by a process, 0 disables gossip
+	Deadline       time.Duration // time after which the run is considered stalled, 0 means no limit
+}
+
+// Event records a unit added to a process by the simulator.
+type Event struct {
+	Time time.Duration
+	From uint16
+	To   uint16
+	Unit gomel.Hash
+}
+
+// Result describes a finished simulation.
+type Result struct {
+	Preblocks [][]*core.Preblock // preblocks produced by every process
+	Trace     []Event            // units in the order they were added to the processes
+	Time      time.Duration      // virtual time at which the last process produced its last preblock
+}
+
+// Check verifies that all the processes produced the same preblocks.
+func (r *Result) Check() error {
+	for pid := 1; pid < len(r.Preblocks); pid++ {
+		if len(r.Preblocks[pid]) != len(r.Preblocks[0]) {
+			return fmt.Errorf("process %d produced %d preblocks, while process 0 produced %d", pid, len(r.Preblocks[pid]), len(r.Preblocks[0]))
+		}
+		for i, pb := range r.Preblocks[pid] {
+			if !samePreblocks(pb, r.Preblocks[0][i]) {
+				return fmt.Errorf("preblock %d of process %d differs from the one of process 0", i, pid)
+			}
+		}
+	}
+	return nil
+}
+
+func samePreblocks(pb1, pb2 *core.Preblock) bool {
+	if len(pb1.Data) != len(pb2.Data) || !bytes.Equal(pb1.RandomBytes, pb2.RandomBytes) {
+		return false
+	}
+	for i := range pb1.Data {
+		if !bytes.Equal(pb1.Data[i], pb2.Data[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	delivery = iota
+	gossipRequest
+	gossipReply
+	fetchRequest
+	fetchByHashRequest
+	timer
+)
+
+// message is an entry of the simulator queue. Messages are processed in the order of time, then of scheduling.
+type message struct {
+	at     time.Duration
+	seq    uint64
+	kind   int
+	from   uint16
+	to     uint16
+	units  [][]byte
+	info   [2]*gomel.DagInfo
+	ids    []uint64
+	hashes []*gomel.Hash
+	depth  int
+	fire   chan time.Time
+}
+
+type queue []*message
+
+func (q queue) Len() int { return len(q) }
+func (q queue) Less(i, j int) bool {
+	return q[i].at < q[j].at || (q[i].at == q[j].at && q[i].seq < q[j].seq)
+}
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(*message)) }
+func (q *queue) Pop() interface{} {
+	old := *q
+	m := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return m
+}
+
+// received is a preunit waiting in the inbox of a process until its parents are there.
+type received struct {
+	pu     gomel.Preunit
+	source uint16
+}
+
+type process struct {
+	pid       uint16
+	ord       gomel.Orderer
+	inbox     []received
+	epoch     gomel.EpochID // the newest epoch in which the process created a unit
+	sent      int           // the number of units created by the process
+	dealt     chan struct{} // closed when the process sends its first unit
+	preblocks []*core.Preblock
+	mx        sync.Mutex // guards preblocks
+}
+
+type simulation struct {
+	opts     Options
+	conf     []config.Config
+	procs    []*process
+	activity *gomel.Activity
+	mx       sync.Mutex // guards the fields below, which are also used by the orderers through their syncers
+	rand     *rand.Rand
+	now      time.Duration
+	seq      uint64
+	queue    queue
+	timers   map[<-chan time.Time]bool // timers of the creators that are still waited on
+	result   *Result
+}
+
+// start is the moment of the real time at which the virtual time of every simulation begins.
+var start = time.Unix(0, 0)
+
+// Run simulates the committee described by the given options until every process produces all its preblocks.
+// Returns an error if it is not possible to finish, which indicates a liveness problem.
+func Run(opts Options) (*Result, error) {
+	if opts.NProc < 4 {
+		return nil, errors.New("the committee needs at least 4 processes")
+	}
+	if opts.Epochs < 1 || opts.EpochLength < 1 {
+		return nil, errors.New("the number of epochs and their length have to be positive")
+	}
+	if opts.Drop > 0 && opts.GossipInterval == 0 {
+		return nil, errors.New("lost units can be recovered only with gossip")
+	}
+	sim := &simulation{
+		opts:     opts,
+		procs:    make([]*process, opts.NProc),
+		activity: gomel.NewActivity(),
+		rand:     rand.New(rand.NewSource(opts.Seed)),
+		timers:   make(map[<-chan time.Time]bool),
+		result:   &Result{Preblocks: make([][]*core.Preblock, opts.NProc)},
+	}
+	sim.configure()
+	for pid := range sim.procs {
+		sim.procs[pid] = &process{pid: uint16(pid), dealt: make(chan struct{})}
+	}
+	for pid, p := range sim.procs {
+		p := p
+		conf := sim.conf[pid]
+		toPreblock := func(round []gomel.Unit) {
+			p.mx.Lock()
+			defer p.mx.Unlock()
+			p.preblocks = append(p.preblocks, gomel.ToPreblock(round))
+		}
+		ds := &dataSource{rand.New(rand.NewSource(opts.Seed + int64(pid) + 1))}
+		ord, err := orderer.New(conf, ds, toPreblock, zerolog.Nop())
+		if err != nil {
+			return nil, err
+		}
+		p.ord = ord
+	}
+	// processes start by creating their dealing units, one after another to keep the order of messages fixed
+	for pid, p := range sim.procs {
+		p.ord.Start(coin.NewFactory(uint16(pid), sim.conf[pid].WTKey), &syncer{sim, uint16(pid)}, gomel.NopAlerter())
+		sim.waitForDealing(p)
+		if opts.GossipInterval > 0 {
+			sim.mx.Lock()
+			sim.schedule(&message{at: sim.delay(), kind: gossipRequest, from: uint16(pid)})
+			sim.mx.Unlock()
+		}
+	}
+	defer func() {
+		for _, p := range sim.procs {
+			p.ord.Stop()
+		}
+	}()
+	err := sim.loop()
+	for pid, p := range sim.procs {
+		p.mx.Lock()
+		sim.result.Preblocks[pid] = p.preblocks
+		p.mx.Unlock()
+	}
+	return sim.result, err
+}
+
+// loop handles messages from the queue until all the processes are finished.
+func (sim *simulation) loop() error {
+	for !sim.finished() {
+		if sim.opts.Deadline > 0 && sim.now > sim.opts.Deadline {
+			return fmt.Errorf("simulation stalled, some processes did not finish before %v", sim.opts.Deadline)
+		}
+		sim.mx.Lock()
+		if sim.queue.Len() == 0 {
+			sim.mx.Unlock()
+			return errors.New("simulation stalled, no messages left")
+		}
+		m := heap.Pop(&sim.queue).(*message)
+		sim.now = m.at
+		sim.mx.Unlock()
+		sim.handle(m)
+	}
+	return nil
+}
+
+// configure prepares configs of all the processes, with keys generated from the seed.
+func (sim *simulation) configure() {
+	nProc := sim.opts.NProc
+	keys := rand.New(rand.NewSource(sim.opts.Seed))
+	members := make([]*config.Member, nProc)
+	committee := &config.Committee{
+		PublicKeys:          make([]gomel.PublicKey, nProc),
+		RMCVerificationKeys: make([]*bn256.VerificationKey, nProc),
+		P2PPublicKeys:       make([]*p2p.PublicKey, nProc),
+		SetupAddresses:      make(map[string][]string),
+		Addresses:           make(map[string][]string),
+	}
+	for pid := uint16(0); pid < nProc; pid++ {
+		members[pid] = &config.Member{Pid: pid}
+		committee.PublicKeys[pid], members[pid].PrivateKey, _ = signing.GenerateKeysFrom(keys)
+	}
+	sim.conf = make([]config.Config, nProc)
+	for pid := uint16(0); pid < nProc; pid++ {
+		conf := config.New(members[pid], committee)
+		conf.NumberOfEpochs = sim.opts.Epochs
+		conf.EpochLength = sim.opts.EpochLength
+		conf.LastLevel = conf.EpochLength + conf.OrderStartLevel - 1
+		// gossip and fetches are scheduled by the simulator, the timers of the orderers should never fire
+		conf.GossipInterval = 24 * time.Hour
+		conf.FetchInterval = 24 * time.Hour
+		conf.WTKey = tss.SeededWTK(nProc, pid, sim.opts.Seed, nil)
+		conf.Activity = sim.activity
+		conf.Clock = gomel.NewClock(sim.clockNow, sim.clockAfter(pid), sim.clockStop)
+		sim.conf[pid] = conf
+	}
+}
+
+// waitForDealing blocks until the freshly started process has sent its dealing unit and is idle.
+func (sim *simulation) waitForDealing(p *process) {
+	<-p.dealt
+	sim.activity.Wait()
+}
+
+// clockNow tells the virtual time to the processes.
+func (sim *simulation) clockNow() time.Time {
+	sim.mx.Lock()
+	defer sim.mx.Unlock()
+	return start.Add(sim.now)
+}
+
+// clockAfter returns the function scheduling the timers of the given process in the virtual time.
+func (sim *simulation) clockAfter(pid uint16) func(time.Duration) <-chan time.Time {
+	return func(d time.Duration) <-chan time.Time {
+		sim.mx.Lock()
+		defer sim.mx.Unlock()
+		if d < 0 {
+			d = 0
+		}
+		m := &message{at: sim.now + d, kind: timer, to: pid, fire: make(chan time.Time, 1)}
+		sim.timers[m.fire] = true
+		sim.schedule(m)
+		return m.fire
+	}
+}
+
+// clockStop forgets the timer, so it is not fired.
+func (sim *simulation) clockStop(ch <-chan time.Time) {
+	sim.mx.Lock()
+	defer sim.mx.Unlock()
+	delete(sim.timers, ch)
+}
+
+// finished checks if all the processes produced all their preblocks, and records the time when that happens.
+func (sim *simulation) finished() bool {
+	expected := sim.opts.Epochs * sim.opts.EpochLength
+	for _, p := range sim.procs {
+		p.mx.Lock()
+		n := len(p.preblocks)
+		p.mx.Unlock()
+		if n < expected {
+			return false
+		}
+	}
+	sim.result.Time = sim.now
+	return true
+}
+
+// handle processes a message taken from the queue.
+func (sim *simulation) handle(m *message) {
+	p := sim.procs[m.to]
+	switch m.kind {
+	case delivery:
+		for _, data := range m.units {
+			pu, err := encoding.DecodePreunit(data)
+			if err != nil {
+				continue
+			}
+			p.inbox = append(p.inbox, received{pu, m.from})
+		}
+		sim.release(p)
+	case gossipRequest:
+		sim.mx.Lock()
+		peer := uint16(sim.rand.Intn(int(sim.opts.NProc) - 1))
+		if peer >= m.from {
+			peer++
+		}
+		sim.mx.Unlock()
+		sim.requestGossip(m.from, peer)
+		sim.mx.Lock()
+		sim.schedule(&message{at: sim.now + sim.opts.GossipInterval, kind: gossipRequest, from: m.from})
+		sim.mx.Unlock()
+	case gossipReply:
+		sim.send(m.to, m.from, p.ord.Delta(m.info))
+	case fetchRequest:
+		sim.send(m.to, m.from, p.ord.UnitsByID(m.ids...))
+	case fetchByHashRequest:
+		sim.send(m.to, m.from, fetch.UnitsByHash(p.ord, m.hashes, m.depth, m.info))
+	case timer:
+		sim.mx.Lock()
+		waited := sim.timers[m.fire]
+		delete(sim.timers, m.fire)
+		sim.mx.Unlock()
+		if waited {
+			sim.activity.Add(gomel.Adding)
+			m.fire <- start.Add(m.at)
+			sim.activity.Wait()
+		}
+	}
+}
+
+// release adds to the process all the units from its inbox that can be added, one by one.
+func (sim *simulation) release(p *process) {
+	for {
+		i := 0
+		for i < len(p.inbox) && !sim.ready(p, p.inbox[i].pu) {
+			i++
+		}
+		if i == len(p.inbox) {
+			return
+		}
+		r := p.inbox[i]
+		p.inbox = append(p.inbox[:i], p.inbox[i+1:]...)
+		if sim.stale(p, r.pu) {
+			continue
+		}
+		p.ord.AddPreunits(r.source, r.pu)
+		sim.activity.Wait()
+		sim.result.Trace = append(sim.result.Trace, Event{Time: sim.now, From: r.source, To: p.pid, Unit: *r.pu.Hash()})
+	}
+}
+
+// stale checks if the preunit is already in the process, or is from an epoch the process has forgotten.
+func (sim *simulation) stale(p *process, pu gomel.Preunit) bool {
+	if p.ord.UnitsByHash(pu.Hash())[0] != nil {
+		return true
+	}
+	sim.mx.Lock()
+	defer sim.mx.Unlock()
+	return pu.EpochID()+1 < p.epoch
+}
+
+// ready checks if the preunit can be added to the process, that is all its parents are there,
+// or it should be removed from the inbox.
+func (sim *simulation) ready(p *process, pu gomel.Preunit) bool {
+	if sim.stale(p, pu) {
+		return true
+	}
+	var ids []uint64
+	for creator, height := range pu.View().Heights {
+		if height >= 0 {
+			ids = append(ids, gomel.ID(height, uint16(creator), pu.EpochID()))
+		}
+	}
+	return len(p.ord.UnitsByID(ids...)) >= len(ids)
+}
+
+// requestGossip makes the process from exchange units with the process to.
+func (sim *simulation) requestGossip(from, to uint16) {
+	info := sim.procs[from].ord.GetInfo()
+	sim.mx.Lock()
+	defer sim.mx.Unlock()
+	sim.schedule(&message{at: sim.now + sim.delay(), kind: gossipReply, from: from, to: to, info: info})
+}
+
+// send schedules a delivery of the given units.
+func (sim *simulation) send(from, to uint16, units []gomel.Unit) {
+	if len(units) == 0 {
+		return
+	}
+	m := &message{kind: delivery, from: from, to: to}
+	for _, u := range units {
+		data, err := encoding.EncodeUnit(u)
+		if err != nil {
+			continue
+		}
+		m.units = append(m.units, data)
+	}
+	sim.mx.Lock()
+	defer sim.mx.Unlock()
+	m.at = sim.now + sim.delay()
+	sim.schedule(m)
+}
+
+// multicast schedules deliveries of the given unit of the process from to all other processes. Must be called with the lock held.
+func (sim *simulation) multicast(from uint16, u gomel.Unit) {
+	data, err := encoding.EncodeUnit(u)
+	if err != nil {
+		return
+	}
+	p := sim.procs[from]
+	p.sent++
+	if p.sent == 1 {
+		close(p.dealt)
+	}
+	if u.EpochID() > p.epoch {
+		p.epoch = u.EpochID()
+	}
+	for to := uint16(0); to < sim.opts.NProc; to++ {
+		if to == from {
+			continue
+		}
+		if sim.opts.Drop > 0 && sim.rand.Float64() < sim.opts.Drop {
+			continue
+		}
+		sim.schedule(&message{at: sim.now + sim.delay(), kind: delivery, from: from, to: to, units: [][]byte{data}})
+	}
+}
+
+// delay draws the delay of a message. Must be called with the lock held.
+func (sim *simulation) delay() time.Duration {
+	d := sim.opts.Latency
+	if sim.opts.Jitter > 0 {
+		d += time.Duration(sim.rand.Int63n(int64(sim.opts.Jitter)))
+	}
+	return d
+}
+
+// schedule puts the message in the queue. Must be called with the lock held.
+func (sim *simulation) schedule(m *message) {
+	m.seq = sim.seq
+	sim.seq++
+	heap.Push(&sim.queue, m)
+}
+
+// syncer passes the units of a process to the simulator.
+type syncer struct {
+	sim *simulation
+	pid uint16
+}
+
+func (s *syncer) Multicast(u gomel.Unit) {
+	s.sim.mx.Lock()
+	defer s.sim.mx.Unlock()
+	s.sim.multicast(s.pid, u)
+}
+
+func (s *syncer) RequestGossip(pid uint16) {
+	s.sim.requestGossip(s.pid, pid)
+}
+
+func (s *syncer) RequestFetch(pid uint16, ids []uint64) {
+	s.sim.mx.Lock()
+	defer s.sim.mx.Unlock()
+	s.sim.schedule(&message{at: s.sim.now + s.sim.delay(), kind: fetchRequest, from: s.pid, to: pid, ids: ids})
+}
+
+// RequestFetchByHash never falls back to fetching by IDs, as every simulated process can fetch units by hash.
+func (s *syncer) RequestFetchByHash(pid uint16, hashes []*gomel.Hash, depth int, _ []uint64) {
+	info := s.sim.procs[s.pid].ord.GetInfo()
+	s.sim.mx.Lock()
+	defer s.sim.mx.Unlock()
+	s.sim.schedule(&message{at: s.sim.now + s.sim.delay(), kind: fetchByHashRequest, from: s.pid, to: pid, hashes: hashes, depth: depth, info: info})
+}
+
+// Peers reports nothing, as the simulator delivers all the units it does not drop on purpose.
+func (s *syncer) Peers() []gomel.PeerStatus { return nil }
+
+func (s *syncer) Start() {}
+func (s *syncer) Stop()  {}
+
+// dataSource provides pseudorandom data for the units of a process.
+type dataSource struct {
+	rand *rand.Rand
+}
+
+func (ds *dataSource) GetData() core.Data {
+	data := make([]byte, 32)
+	ds.rand.Read(data)
+	return data
+}
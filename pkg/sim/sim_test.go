@@ -0,0 +1,69 @@
+package sim_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "gitlab.com/alephledger/consensus-go/pkg/sim"
+)
+
+var _ = Describe("Simulation", func() {
+
+	var (
+		opts Options
+	)
+
+	BeforeEach(func() {
+		opts = Options{
+			NProc:       4,
+			Epochs:      2,
+			EpochLength: 5,
+			Seed:        2137,
+			Latency:     10 * time.Millisecond,
+			Jitter:      50 * time.Millisecond,
+			Deadline:    time.Hour,
+		}
+	})
+
+	It("should produce the same preblocks in every process", func() {
+		result, err := Run(opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Preblocks[0]).To(HaveLen(opts.Epochs * opts.EpochLength))
+		Expect(result.Check()).To(Succeed())
+	})
+
+	It("should be reproducible from the seed", func() {
+		first, err := Run(opts)
+		Expect(err).NotTo(HaveOccurred())
+		second, err := Run(opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Trace).To(Equal(first.Trace))
+		Expect(second.Preblocks).To(Equal(first.Preblocks))
+		Expect(second.Time).To(Equal(first.Time))
+	})
+
+	It("should depend on the seed", func() {
+		first, err := Run(opts)
+		Expect(err).NotTo(HaveOccurred())
+		opts.Seed++
+		second, err := Run(opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Trace).NotTo(Equal(first.Trace))
+	})
+
+	It("should recover lost units with gossip", func() {
+		opts.Drop = 0.2
+		opts.GossipInterval = 100 * time.Millisecond
+		result, err := Run(opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Check()).To(Succeed())
+	})
+
+	It("should refuse to lose units without gossip", func() {
+		opts.Drop = 0.2
+		_, err := Run(opts)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,293 @@
+// Package store implements a write-ahead log of units inserted into dags.
+//
+// Every unit inserted into a dag is appended to a file dedicated to the epoch of that unit, together with hashes of its parents.
+// After a crash, the content of such a file can be replayed into a fresh dag, recreating exactly the same structure of units.
+//
+// Every file starts with a header: an encoded empty unit, which is never stored otherwise, followed by the version of the encoding
+// of units used in the file. Files without the header were written before the encoding was versioned, in its first version.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/alephledger/consensus-go/pkg/encoding"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+const suffix = ".units"
+
+// headerSize is the size of the header of a file: an encoded empty unit followed by the version of the encoding of units.
+const headerSize = 8 + 1
+
+// Store keeps units of recent epochs on disk, one file per epoch.
+// Each record consists of an encoded unit followed by hashes of its parents (ZeroHash for a missing parent).
+type Store struct {
+	dir   string
+	files map[gomel.EpochID]*epochFile
+	mx    sync.Mutex
+}
+
+type epochFile struct {
+	file    *os.File
+	writer  *bufio.Writer
+	version uint8
+}
+
+// New opens a unit store kept in the given directory. The directory is created if needed.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:   dir,
+		files: make(map[gomel.EpochID]*epochFile),
+	}, nil
+}
+
+// Epochs returns IDs of all the epochs that have some units stored, in ascending order.
+func (s *Store) Epochs() []gomel.EpochID {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+	var result []gomel.EpochID
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, suffix), 10, 32)
+		if err != nil {
+			continue
+		}
+		result = append(result, gomel.EpochID(id))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Append writes the given unit to the file of its epoch.
+// Data is handed to the operating system before Append returns, so it survives a crash of the process.
+func (s *Store) Append(u gomel.Unit) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	ef, err := s.open(u.EpochID())
+	if err != nil {
+		return err
+	}
+	if err = encoding.WriteUnit(u, ef.writer, ef.version); err != nil {
+		return err
+	}
+	for _, p := range u.Parents() {
+		h := &gomel.ZeroHash
+		if p != nil {
+			h = p.Hash()
+		}
+		if _, err = ef.writer.Write(h[:]); err != nil {
+			return err
+		}
+	}
+	return ef.writer.Flush()
+}
+
+// Replay reads all the units stored for the epoch of the given dag and inserts them directly into that dag.
+// No checks are performed, units are assumed to be correct as they were checked before being stored.
+// A partially written record at the end of the file (a result of a crash) is discarded.
+// Any other malformed record results in an error, leaving the file intact.
+func (s *Store) Replay(dag gomel.Dag) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if _, ok := s.files[dag.EpochID()]; ok {
+		return errors.New("cannot replay an epoch that is currently being written")
+	}
+	file, err := os.OpenFile(s.path(dag.EpochID()), os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	version, valid, err := readHeader(file)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		// the header was not written completely, so there are no units
+		return file.Truncate(0)
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		return err
+	}
+	reader := &countingReader{r: bufio.NewReader(file), n: valid}
+	for {
+		pu, parents, err := readRecord(reader, version)
+		if err == io.EOF && reader.n == valid {
+			return nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the last record was not written completely, cut it off
+			return file.Truncate(valid)
+		}
+		if err != nil {
+			return err
+		}
+		units := dag.GetUnits(parents)
+		for i, h := range parents {
+			if h != nil && units[i] == nil {
+				return errors.New("stored unit has a parent that is not present in the store")
+			}
+		}
+		dag.Insert(dag.BuildUnit(pu, units))
+		valid = reader.n
+	}
+}
+
+// Prune removes from disk all the epochs older than the given one.
+func (s *Store) Prune(epoch gomel.EpochID) {
+	for _, id := range s.Epochs() {
+		if id >= epoch {
+			break
+		}
+		s.mx.Lock()
+		if ef, ok := s.files[id]; ok {
+			ef.writer.Flush()
+			ef.file.Close()
+			delete(s.files, id)
+		}
+		os.Remove(s.path(id))
+		s.mx.Unlock()
+	}
+}
+
+// Close flushes and closes all the open files.
+func (s *Store) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var result error
+	for id, ef := range s.files {
+		if err := ef.writer.Flush(); err != nil && result == nil {
+			result = err
+		}
+		if err := ef.file.Close(); err != nil && result == nil {
+			result = err
+		}
+		delete(s.files, id)
+	}
+	return result
+}
+
+// open returns the file for the given epoch, opening it in append mode if needed.
+// This method must be called under mutex!
+func (s *Store) open(epoch gomel.EpochID) (*epochFile, error) {
+	if ef, ok := s.files[epoch]; ok {
+		return ef, nil
+	}
+	file, err := os.OpenFile(s.path(epoch), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	version, _, err := readHeader(file)
+	if err == nil && version == 0 {
+		version, err = writeHeader(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ef := &epochFile{file: file, writer: bufio.NewWriter(file), version: version}
+	s.files[epoch] = ef
+	return ef, nil
+}
+
+func (s *Store) path(epoch gomel.EpochID) string {
+	return filepath.Join(s.dir, strconv.FormatUint(uint64(epoch), 10)+suffix)
+}
+
+// readHeader returns the version of the encoding of units used in the given file, together with the size of its header.
+// Files without the header use the first version of the encoding. The version is zero for a file that is empty
+// or contains only a part of the header, as a result of a crash.
+func readHeader(file *os.File) (uint8, int64, error) {
+	header := make([]byte, headerSize)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	for i := 0; i < n && i < headerSize-1; i++ {
+		if header[i] != 0xFF {
+			return encoding.FixedSignatures, 0, nil
+		}
+	}
+	if n < headerSize {
+		return 0, 0, nil
+	}
+	version := header[headerSize-1]
+	if version == 0 || version > encoding.LatestVersion {
+		return 0, 0, errors.New("unknown version of the encoding of units")
+	}
+	return version, headerSize, nil
+}
+
+// writeHeader replaces the content of the given file with a header of the latest version of the encoding of units.
+func writeHeader(file *os.File) (uint8, error) {
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	if err := encoding.WriteUnit(nil, &buf, encoding.LatestVersion); err != nil {
+		return 0, err
+	}
+	buf.WriteByte(encoding.LatestVersion)
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return encoding.LatestVersion, nil
+}
+
+// readRecord reads a single preunit together with hashes of its parents.
+// Returns io.EOF only if there was no data left at all.
+func readRecord(r io.Reader, version uint8) (gomel.Preunit, []*gomel.Hash, error) {
+	pu, err := encoding.ReadPreunit(r, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pu == nil {
+		return nil, nil, errors.New("empty record")
+	}
+	parents := make([]*gomel.Hash, len(pu.View().Heights))
+	for i := range parents {
+		h := &gomel.Hash{}
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		if *h != gomel.ZeroHash {
+			parents[i] = h
+		}
+	}
+	return pu, parents, nil
+}
+
+// countingReader counts the number of bytes read so far.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
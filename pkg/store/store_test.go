@@ -0,0 +1,177 @@
+package store_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/dag"
+	"gitlab.com/alephledger/consensus-go/pkg/encoding"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	. "gitlab.com/alephledger/consensus-go/pkg/store"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+)
+
+type storingFactory struct {
+	st *Store
+}
+
+func (sf storingFactory) CreateDag(nProc uint16) (gomel.Dag, gomel.Adder) {
+	cnf := config.Empty()
+	cnf.NProc = nProc
+	dg := dag.New(cnf, gomel.EpochID(0))
+	dg.AfterInsert(func(u gomel.Unit) {
+		Expect(sf.st.Append(u)).To(Succeed())
+	})
+	return dg, tests.NewAdder(dg)
+}
+
+func hashes(dg gomel.Dag) map[gomel.Hash]bool {
+	result := make(map[gomel.Hash]bool)
+	for _, u := range dg.UnitsAbove(nil) {
+		result[*u.Hash()] = true
+	}
+	return result
+}
+
+// headerSize is the size of the header of a file written in a versioned encoding.
+const headerSize = 9
+
+// unversion rewrites the given file as it was written before the encoding of units was versioned.
+// It returns the offset of the last record and the unit stored there.
+func unversion(path string, dg gomel.Dag) (int64, gomel.Unit) {
+	data, err := ioutil.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(data[headerSize-1]).To(Equal(encoding.LatestVersion))
+	r := bytes.NewReader(data[headerSize:])
+	var buf bytes.Buffer
+	var last int64
+	var u gomel.Unit
+	for r.Len() > 0 {
+		pu, err := encoding.ReadPreunit(r, encoding.LatestVersion)
+		Expect(err).NotTo(HaveOccurred())
+		parents := make([]byte, len(pu.View().Heights)*len(gomel.ZeroHash))
+		_, err = io.ReadFull(r, parents)
+		Expect(err).NotTo(HaveOccurred())
+		last = int64(buf.Len())
+		u = dg.GetUnits([]*gomel.Hash{pu.Hash()})[0]
+		Expect(u).NotTo(BeNil())
+		Expect(encoding.WriteUnit(u, &buf, encoding.FixedSignatures)).To(Succeed())
+		buf.Write(parents)
+	}
+	Expect(ioutil.WriteFile(path, buf.Bytes(), 0644)).To(Succeed())
+	return last, u
+}
+
+var _ = Describe("Store", func() {
+	var (
+		dir      string
+		st       *Store
+		original gomel.Dag
+		fresh    gomel.Dag
+		err      error
+	)
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "store")
+		Expect(err).NotTo(HaveOccurred())
+		st, err = New(dir)
+		Expect(err).NotTo(HaveOccurred())
+		original, _, err = tests.CreateDagFromTestFile("../testdata/dags/10/random_100u.txt", storingFactory{st})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(st.Close()).To(Succeed())
+		cnf := config.Empty()
+		cnf.NProc = original.NProc()
+		fresh = dag.New(cnf, gomel.EpochID(0))
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+	Describe("replaying a stored dag", func() {
+		It("should recreate the same set of units", func() {
+			st, err = New(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st.Epochs()).To(Equal([]gomel.EpochID{0}))
+			Expect(st.Replay(fresh)).To(Succeed())
+			Expect(hashes(fresh)).To(Equal(hashes(original)))
+			Expect(gomel.MaxView(fresh)).To(Equal(gomel.MaxView(original)))
+		})
+	})
+	Describe("replaying a store with a truncated last record", func() {
+		It("should recreate all but the last unit", func() {
+			path := filepath.Join(dir, "0.units")
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Truncate(path, info.Size()-10)).To(Succeed())
+			st, err = New(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st.Replay(fresh)).To(Succeed())
+			Expect(len(fresh.UnitsAbove(nil))).To(Equal(len(original.UnitsAbove(nil)) - 1))
+		})
+	})
+	Describe("replaying a store with a corrupted record in the middle", func() {
+		It("should return an error and keep the file", func() {
+			path := filepath.Join(dir, "0.units")
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+			Expect(err).NotTo(HaveOccurred())
+			// an ID consisting of ones only marks an empty unit, which is never stored after the header
+			_, err = file.WriteAt([]byte{255, 255, 255, 255, 255, 255, 255, 255}, headerSize)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+			st, err = New(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st.Replay(fresh)).NotTo(Succeed())
+			after, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(after.Size()).To(Equal(info.Size()))
+		})
+	})
+	Describe("replaying a store written before the encoding was versioned", func() {
+		It("should recreate the same set of units", func() {
+			unversion(filepath.Join(dir, "0.units"), original)
+			st, err = New(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st.Replay(fresh)).To(Succeed())
+			Expect(hashes(fresh)).To(Equal(hashes(original)))
+		})
+		It("should keep appending in the old encoding", func() {
+			path := filepath.Join(dir, "0.units")
+			last, u := unversion(path, original)
+			Expect(os.Truncate(path, last)).To(Succeed())
+			st, err = New(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st.Append(u)).To(Succeed())
+			Expect(st.Close()).To(Succeed())
+			Expect(st.Replay(fresh)).To(Succeed())
+			Expect(hashes(fresh)).To(Equal(hashes(original)))
+		})
+	})
+	Describe("replaying a store with a truncated header", func() {
+		It("should recreate nothing and start the file anew", func() {
+			path := filepath.Join(dir, "0.units")
+			Expect(os.Truncate(path, headerSize-1)).To(Succeed())
+			st, err = New(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st.Replay(fresh)).To(Succeed())
+			Expect(fresh.UnitsAbove(nil)).To(BeEmpty())
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(BeZero())
+		})
+	})
+	Describe("pruning", func() {
+		It("should remove older epochs", func() {
+			st, err = New(dir)
+			Expect(err).NotTo(HaveOccurred())
+			st.Prune(gomel.EpochID(1))
+			Expect(st.Epochs()).To(BeEmpty())
+		})
+	})
+})
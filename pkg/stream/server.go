@@ -0,0 +1,246 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/network/socket"
+)
+
+// endOfStream is sent in place of an epoch to mark the end of a subscription.
+const endOfStream = math.MaxUint32
+
+// maxErrorSize bounds the length of the reason of the end of a subscription we are willing to receive.
+const maxErrorSize = 1 << 16
+
+// Server lets consumers running in other processes subscribe to a log.
+//
+// A client sends the position to subscribe from: the epoch and the level, 4 bytes each. In response it receives
+// the preblocks of the subscription, each one as its epoch, 4 bytes, followed by a record as it is stored on disk.
+// When the subscription ends, the client receives 4 bytes with all the bits set, followed by the length of the reason, 4 bytes,
+// and the reason itself, empty if the log was finished. Clients resume after a restart by subscribing again, see Dial.
+type Server struct {
+	addr    string
+	pl      *Log
+	ln      net.Listener
+	mx      sync.Mutex
+	clients map[net.Conn]bool
+	wg      sync.WaitGroup
+	log     zerolog.Logger
+}
+
+// NewServer returns a server of subscriptions to the given log.
+// The address is either a TCP address or a path of a unix socket prefixed with socket.UnixPrefix.
+func NewServer(addr string, pl *Log, log zerolog.Logger) *Server {
+	return &Server{
+		addr:    addr,
+		pl:      pl,
+		clients: make(map[net.Conn]bool),
+		log:     log.With().Int(lg.Service, lg.StreamService).Logger(),
+	}
+}
+
+// Start listens on the address of the server and serves clients in the background.
+func (s *Server) Start() error {
+	ln, err := socket.Listen(s.addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	s.wg.Add(1)
+	go s.accept()
+	s.log.Info().Msg(lg.ServiceStarted)
+	return nil
+}
+
+// Stop closes the server together with all the connections.
+func (s *Server) Stop() {
+	s.ln.Close()
+	s.mx.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.mx.Unlock()
+	s.wg.Wait()
+	s.log.Info().Msg(lg.ServiceStopped)
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mx.Lock()
+		s.clients[conn] = true
+		s.mx.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mx.Lock()
+		delete(s.clients, conn)
+		s.mx.Unlock()
+	}()
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		s.log.Error().Str("where", "stream.serve.receiveFrom").Msg(err.Error())
+		return
+	}
+	from := Position{Epoch: gomel.EpochID(binary.LittleEndian.Uint32(buf)), Level: int(binary.LittleEndian.Uint32(buf[4:]))}
+	w := bufio.NewWriter(conn)
+	sub, err := s.pl.Subscribe(from)
+	if err != nil {
+		writeEnd(w, err)
+		return
+	}
+	defer sub.Cancel()
+	// a client closing the connection cancels its subscription
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		sub.Cancel()
+	}()
+	for entry := range sub.Entries() {
+		binary.LittleEndian.PutUint32(buf, uint32(entry.Epoch))
+		if _, err := w.Write(buf[:4]); err != nil {
+			return
+		}
+		if _, err := w.Write(encodeRecord(entry.Level, entry.Preblock)); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+	writeEnd(w, sub.Err())
+}
+
+// writeEnd sends the marker of the end of a subscription, together with the reason.
+func writeEnd(w *bufio.Writer, reason error) {
+	var msg string
+	if reason != nil {
+		msg = reason.Error()
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, endOfStream)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(msg)))
+	w.Write(buf)
+	w.WriteString(msg)
+	w.Flush()
+}
+
+// Dial subscribes to a log served by a Server on the given address, starting from the given position.
+// The subscription works as the one returned by Log.Subscribe. It also ends if the connection breaks,
+// in which case Err reports why, and the consumer can subscribe again from the position following the last one it received.
+func Dial(addr string, from Position) (*Subscription, error) {
+	conn, err := socket.Dial(addr, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, uint32(from.Epoch))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(from.Level))
+	if _, err := conn.Write(buf); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &Subscription{
+		from:    from,
+		entries: make(chan Entry),
+		cancel:  make(chan struct{}),
+	}
+	go s.receive(conn)
+	return s, nil
+}
+
+// receive delivers the preblocks sent by a Server over the given connection.
+func (s *Subscription) receive(conn net.Conn) {
+	defer close(s.entries)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.cancel:
+		case <-done:
+		}
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	buf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			s.fail(err)
+			return
+		}
+		epoch := binary.LittleEndian.Uint32(buf)
+		if epoch == endOfStream {
+			s.err = readEnd(reader)
+			return
+		}
+		entry, err := readRecord(reader, gomel.EpochID(epoch))
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		select {
+		case s.entries <- *entry:
+		case <-s.cancel:
+			return
+		}
+	}
+}
+
+// fail records the error that broke the subscription, unless it was cancelled.
+func (s *Subscription) fail(err error) {
+	select {
+	case <-s.cancel:
+	default:
+		s.err = err
+	}
+}
+
+// readEnd reads the reason of the end of a subscription, mapping it to the errors of this package when possible.
+func readEnd(r io.Reader) error {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	size := binary.LittleEndian.Uint32(buf)
+	if size == 0 {
+		return nil
+	}
+	if size > maxErrorSize {
+		return errors.New("malformed end of a subscription")
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return err
+	}
+	for _, known := range []error{ErrPruned, ErrClosed} {
+		if string(msg) == known.Error() {
+			return known
+		}
+	}
+	return errors.New(string(msg))
+}
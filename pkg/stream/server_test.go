@@ -0,0 +1,94 @@
+package stream_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/network/socket"
+	. "gitlab.com/alephledger/consensus-go/pkg/stream"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		dir    string
+		pl     *Log
+		server *Server
+		addr   string
+		err    error
+	)
+
+	appendAll := func(ps []Position) {
+		for _, pos := range ps {
+			Expect(pl.Append(pos, preblock(pos))).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "stream")
+		Expect(err).NotTo(HaveOccurred())
+		pl, err = Open(filepath.Join(dir, "log"), 2)
+		Expect(err).NotTo(HaveOccurred())
+		addr = socket.UnixPrefix + filepath.Join(dir, "stream.sock")
+		server = NewServer(addr, pl, zerolog.Nop())
+		Expect(server.Start()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Stop()
+		pl.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should deliver the preblocks to a subscriber in another process", func() {
+		appendAll(positions(1, 3))
+		s, err := Dial(addr, Position{})
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			appendAll(positions(2, 3)[3:])
+			pl.Finish()
+		}()
+		entries := collect(s)
+		Expect(positionsOf(entries)).To(Equal(positions(2, 3)))
+		for _, e := range entries {
+			Expect(e.Preblock).To(Equal(preblock(e.Position)))
+		}
+		Expect(s.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should let a subscriber resume after reconnecting", func() {
+		appendAll(positions(2, 3))
+		s, err := Dial(addr, Position{})
+		Expect(err).NotTo(HaveOccurred())
+		first := <-s.Entries()
+		s.Cancel()
+		collect(s)
+		Expect(s.Err()).NotTo(HaveOccurred())
+		pl.Finish()
+		s, err = Dial(addr, Position{Epoch: first.Epoch, Level: first.Level + 1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(positionsOf(collect(s))).To(Equal(positions(2, 3)[1:]))
+	})
+
+	It("should report a pruned position", func() {
+		appendAll(positions(4, 1))
+		s, err := Dial(addr, Position{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(collect(s)).To(BeEmpty())
+		Expect(s.Err()).To(Equal(ErrPruned))
+	})
+
+	It("should end subscriptions with an error when stopped", func() {
+		s, err := Dial(addr, Position{})
+		Expect(err).NotTo(HaveOccurred())
+		server.Stop()
+		Expect(collect(s)).To(BeEmpty())
+		Expect(s.Err()).To(HaveOccurred())
+		Expect(server.Start()).To(Succeed())
+	})
+})
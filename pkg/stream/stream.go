@@ -0,0 +1,371 @@
+// Package stream implements a log of preblocks that many consumers can subscribe to.
+//
+// Every preblock produced by a process is appended to a file dedicated to its epoch, together with its position:
+// the epoch and the level of the timing unit that produced it. Only a bounded number of the most recent epochs is kept on disk.
+// A subscriber receives all the preblocks starting from a given position, first the ones read from disk, then the new ones
+// as soon as they are appended. Hence a consumer can restart independently of the process and of other consumers,
+// and resume where it stopped, as long as that position is still in the log.
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+const suffix = ".preblocks"
+
+// maxRecordSize bounds the size of a single record, anything bigger is considered corrupted.
+const maxRecordSize = 1 << 30
+
+var (
+	// ErrPruned is returned when subscribing from a position that was already removed from the log.
+	ErrPruned = errors.New("requested position was pruned from the preblock log")
+	// ErrClosed is returned when the log was closed while a subscription was still active.
+	ErrClosed = errors.New("preblock log closed")
+)
+
+// Position identifies a preblock by the epoch and the level of the timing unit that produced it.
+type Position struct {
+	Epoch gomel.EpochID
+	Level int
+}
+
+// Less checks if p comes before q in the log.
+func (p Position) Less(q Position) bool {
+	return p.Epoch < q.Epoch || (p.Epoch == q.Epoch && p.Level < q.Level)
+}
+
+// Entry is a preblock together with its position in the log.
+type Entry struct {
+	Position
+	Preblock *core.Preblock
+}
+
+// Log keeps preblocks of recent epochs on disk, one file per epoch.
+// Each record consists of its length followed by the level of the preblock, its random bytes and its data.
+type Log struct {
+	dir      string
+	keep     int
+	mx       sync.Mutex
+	epochs   []gomel.EpochID
+	sizes    map[gomel.EpochID]int64
+	file     *os.File
+	last     *Position
+	pruned   gomel.EpochID // all the epochs older than this one were removed
+	appended chan struct{}
+	finished bool
+	closed   bool
+}
+
+// Open opens a preblock log kept in the given directory, creating the directory if needed.
+// The log keeps preblocks of at most keep most recent epochs, 0 means all of them.
+// A partially written record at the end of the log (a result of a crash) is discarded.
+func Open(dir string, keep int) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &Log{
+		dir:      dir,
+		keep:     keep,
+		sizes:    make(map[gomel.EpochID]int64),
+		appended: make(chan struct{}),
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, suffix), 10, 32)
+		if err != nil {
+			continue
+		}
+		l.epochs = append(l.epochs, gomel.EpochID(id))
+	}
+	sort.Slice(l.epochs, func(i, j int) bool { return l.epochs[i] < l.epochs[j] })
+	if len(l.epochs) > 0 {
+		l.pruned = l.epochs[0]
+	}
+	for _, epoch := range l.epochs {
+		if err := l.recover(epoch); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// recover finds the size of the complete records of the given epoch, cutting off the incomplete one, and the last position.
+func (l *Log) recover(epoch gomel.EpochID) error {
+	file, err := os.OpenFile(l.path(epoch), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := &countingReader{r: bufio.NewReader(file)}
+	for {
+		entry, err := readRecord(reader, epoch)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return file.Truncate(l.sizes[epoch])
+		}
+		l.sizes[epoch] = reader.n
+		l.last = &entry.Position
+	}
+}
+
+// Last returns the position of the most recent preblock in the log, or nil if the log is empty.
+func (l *Log) Last() *Position {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.last == nil {
+		return nil
+	}
+	last := *l.last
+	return &last
+}
+
+// Append writes the given preblock at the given position to the log, and passes it to the subscribers.
+// Positions must come in ascending order, preblocks at positions that are already present in the log are ignored,
+// so the preblocks reproduced after a restart of the process are not duplicated.
+// Data is handed to the operating system before Append returns, so it survives a crash of the process.
+func (l *Log) Append(pos Position, pb *core.Preblock) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.last != nil && !l.last.Less(pos) {
+		return nil
+	}
+	if len(l.epochs) == 0 || l.epochs[len(l.epochs)-1] != pos.Epoch || l.file == nil {
+		if err := l.openEpoch(pos.Epoch); err != nil {
+			return err
+		}
+	}
+	record := encodeRecord(pos.Level, pb)
+	if _, err := l.file.Write(record); err != nil {
+		return err
+	}
+	l.sizes[pos.Epoch] += int64(len(record))
+	l.last = &pos
+	l.notify()
+	return nil
+}
+
+// Finish marks the log as complete, subscriptions end after receiving the last preblock.
+func (l *Log) Finish() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.finished = true
+	l.notify()
+}
+
+// Close closes the log, ending all the subscriptions with ErrClosed.
+func (l *Log) Close() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	l.notify()
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
+
+// Subscribe returns a subscription delivering, in order, all the preblocks at the given position and the following ones.
+// To resume after processing a preblock at (epoch, level), subscribe from (epoch, level+1).
+// Returns ErrPruned if the log no longer has some of the requested preblocks.
+func (l *Log) Subscribe(from Position) (*Subscription, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if from.Epoch < l.pruned {
+		return nil, ErrPruned
+	}
+	s := &Subscription{
+		log:     l,
+		from:    from,
+		entries: make(chan Entry),
+		cancel:  make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// openEpoch starts a file for the given epoch and removes the files of the epochs that should no longer be kept.
+// This method must be called under mutex!
+func (l *Log) openEpoch(epoch gomel.EpochID) error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	file, err := os.OpenFile(l.path(epoch), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	if len(l.epochs) == 0 || l.epochs[len(l.epochs)-1] != epoch {
+		l.epochs = append(l.epochs, epoch)
+	}
+	for l.keep > 0 && len(l.epochs) > l.keep {
+		os.Remove(l.path(l.epochs[0]))
+		delete(l.sizes, l.epochs[0])
+		l.pruned = l.epochs[0] + 1
+		l.epochs = l.epochs[1:]
+	}
+	return nil
+}
+
+// notify wakes up all the subscriptions waiting for new preblocks.
+// This method must be called under mutex!
+func (l *Log) notify() {
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// snapshot describes the state of the log as seen by a subscription reading the given epoch.
+type snapshot struct {
+	epoch    gomel.EpochID // the first epoch in the log not older than the requested one
+	size     int64         // the size of complete records of that epoch
+	found    bool          // false if there is no such epoch yet
+	last     bool          // true if that epoch is the newest one in the log
+	pruned   bool          // true if the requested epoch was removed from the log
+	finished bool
+	closed   bool
+	changed  <-chan struct{} // closed on the next change of the log
+}
+
+func (l *Log) snapshot(epoch gomel.EpochID) snapshot {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	snap := snapshot{
+		pruned:   epoch < l.pruned,
+		finished: l.finished,
+		closed:   l.closed,
+		changed:  l.appended,
+	}
+	for i, e := range l.epochs {
+		if e >= epoch {
+			snap.epoch, snap.size, snap.found, snap.last = e, l.sizes[e], true, i == len(l.epochs)-1
+			break
+		}
+	}
+	return snap
+}
+
+func (l *Log) path(epoch gomel.EpochID) string {
+	return filepath.Join(l.dir, strconv.FormatUint(uint64(epoch), 10)+suffix)
+}
+
+func encodeRecord(level int, pb *core.Preblock) []byte {
+	size := 4 + 4 + 4 + len(pb.RandomBytes) + 4
+	for _, d := range pb.Data {
+		size += 4 + len(d)
+	}
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(size-4))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(level))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(pb.RandomBytes)))
+	off := 12 + copy(buf[12:], pb.RandomBytes)
+	binary.LittleEndian.PutUint32(buf[off:], uint32(len(pb.Data)))
+	off += 4
+	for _, d := range pb.Data {
+		binary.LittleEndian.PutUint32(buf[off:], uint32(len(d)))
+		off += 4 + copy(buf[off+4:], d)
+	}
+	return buf
+}
+
+// readRecord reads a single preblock of the given epoch.
+// Returns io.EOF only if there was no data left at all.
+func readRecord(r io.Reader, epoch gomel.EpochID) (*Entry, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(buf)
+	if size < 12 || size > maxRecordSize {
+		return nil, errors.New("malformed preblock record")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	// every length below is checked against the remaining part of the record, so a corrupted one cannot cause a panic
+	rest := data
+	take := func(n uint32) ([]byte, bool) {
+		if uint64(n) > uint64(len(rest)) {
+			return nil, false
+		}
+		result := rest[:n]
+		rest = rest[n:]
+		return result, true
+	}
+	uint32At := func() (uint32, bool) {
+		b, ok := take(4)
+		if !ok {
+			return 0, false
+		}
+		return binary.LittleEndian.Uint32(b), true
+	}
+	level, _ := uint32At()
+	nRandom, _ := uint32At()
+	randomBytes, ok := take(nRandom)
+	if !ok {
+		return nil, errors.New("malformed preblock record")
+	}
+	nData, ok := uint32At()
+	if !ok || uint64(nData)*4 > uint64(len(rest)) {
+		return nil, errors.New("malformed preblock record")
+	}
+	pbData := make([]core.Data, nData)
+	for i := range pbData {
+		n, ok := uint32At()
+		if !ok {
+			return nil, errors.New("malformed preblock record")
+		}
+		d, ok := take(n)
+		if !ok {
+			return nil, errors.New("malformed preblock record")
+		}
+		pbData[i] = core.Data(d)
+	}
+	return &Entry{
+		Position: Position{Epoch: epoch, Level: int(level)},
+		Preblock: core.NewPreblock(pbData, randomBytes),
+	}, nil
+}
+
+// countingReader counts the number of bytes read so far.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,212 @@
+package stream_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	. "gitlab.com/alephledger/consensus-go/pkg/stream"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+func preblock(pos Position) *core.Preblock {
+	return tests.Preblock(pos.Epoch, pos.Level)
+}
+
+// positions returns the positions of nEpochs epochs with nLevels preblocks each.
+func positions(nEpochs, nLevels int) []Position {
+	var result []Position
+	for e := 0; e < nEpochs; e++ {
+		for l := 0; l < nLevels; l++ {
+			result = append(result, Position{Epoch: gomel.EpochID(e), Level: l})
+		}
+	}
+	return result
+}
+
+// collect reads all the entries of the subscription until it ends.
+func collect(s *Subscription) []Entry {
+	var result []Entry
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case entry, ok := <-s.Entries():
+			if !ok {
+				return result
+			}
+			result = append(result, entry)
+		case <-timeout:
+			Fail("subscription did not end")
+			return result
+		}
+	}
+}
+
+func positionsOf(entries []Entry) []Position {
+	result := make([]Position, len(entries))
+	for i, e := range entries {
+		result[i] = e.Position
+	}
+	return result
+}
+
+var _ = Describe("Log", func() {
+	var (
+		dir string
+		pl  *Log
+		err error
+	)
+
+	appendAll := func(ps []Position) {
+		for _, pos := range ps {
+			Expect(pl.Append(pos, preblock(pos))).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "stream")
+		Expect(err).NotTo(HaveOccurred())
+		pl, err = Open(dir, 0)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		pl.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("subscribing to a finished log", func() {
+		BeforeEach(func() {
+			appendAll(positions(3, 4))
+			pl.Finish()
+		})
+
+		It("should deliver all the preblocks in order", func() {
+			s, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			entries := collect(s)
+			Expect(positionsOf(entries)).To(Equal(positions(3, 4)))
+			for _, e := range entries {
+				Expect(e.Preblock).To(Equal(preblock(e.Position)))
+			}
+			Expect(s.Err()).NotTo(HaveOccurred())
+		})
+
+		It("should resume from the given position", func() {
+			s, err := pl.Subscribe(Position{Epoch: 1, Level: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(positionsOf(collect(s))).To(Equal(positions(3, 4)[6:]))
+		})
+
+		It("should serve many subscribers independently", func() {
+			s1, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			s2, err := pl.Subscribe(Position{Epoch: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(positionsOf(collect(s2))).To(Equal(positions(3, 4)[8:]))
+			Expect(positionsOf(collect(s1))).To(Equal(positions(3, 4)))
+		})
+	})
+
+	Describe("subscribing to a growing log", func() {
+		It("should deliver the preblocks appended later", func() {
+			appendAll(positions(1, 2))
+			s, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			go func() {
+				defer GinkgoRecover()
+				appendAll(positions(3, 2)[2:])
+				pl.Finish()
+			}()
+			Expect(positionsOf(collect(s))).To(Equal(positions(3, 2)))
+		})
+
+		It("should end when cancelled", func() {
+			s, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			s.Cancel()
+			Expect(collect(s)).To(BeEmpty())
+			Expect(s.Err()).NotTo(HaveOccurred())
+		})
+
+		It("should end with an error when the log is closed", func() {
+			s, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pl.Close()).To(Succeed())
+			Expect(collect(s)).To(BeEmpty())
+			Expect(s.Err()).To(Equal(ErrClosed))
+		})
+	})
+
+	Describe("appending a preblock again", func() {
+		It("should ignore it", func() {
+			appendAll(positions(2, 2))
+			appendAll(positions(1, 2))
+			pl.Finish()
+			s, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(positionsOf(collect(s))).To(Equal(positions(2, 2)))
+		})
+	})
+
+	Describe("reopening the log", func() {
+		BeforeEach(func() {
+			appendAll(positions(2, 3))
+			Expect(pl.Close()).To(Succeed())
+		})
+
+		It("should keep all the preblocks", func() {
+			pl, err = Open(dir, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*pl.Last()).To(Equal(Position{Epoch: 1, Level: 2}))
+			pl.Finish()
+			s, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(positionsOf(collect(s))).To(Equal(positions(2, 3)))
+		})
+
+		It("should discard a truncated last record", func() {
+			path := filepath.Join(dir, "1.preblocks")
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Truncate(path, info.Size()-5)).To(Succeed())
+			pl, err = Open(dir, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*pl.Last()).To(Equal(Position{Epoch: 1, Level: 1}))
+			appendAll(positions(2, 3)[5:])
+			pl.Finish()
+			s, err := pl.Subscribe(Position{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(positionsOf(collect(s))).To(Equal(positions(2, 3)))
+		})
+	})
+
+	Describe("bounded to two epochs", func() {
+		BeforeEach(func() {
+			pl.Close()
+			pl, err = Open(dir, 2)
+			Expect(err).NotTo(HaveOccurred())
+			appendAll(positions(4, 2))
+			pl.Finish()
+		})
+
+		It("should keep only the most recent epochs", func() {
+			s, err := pl.Subscribe(Position{Epoch: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(positionsOf(collect(s))).To(Equal(positions(4, 2)[4:]))
+			_, err = os.Stat(filepath.Join(dir, "1.preblocks"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should refuse to resume from a pruned position", func() {
+			_, err := pl.Subscribe(Position{Epoch: 1, Level: 1})
+			Expect(err).To(Equal(ErrPruned))
+		})
+	})
+})
@@ -0,0 +1,139 @@
+package stream
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// Subscription delivers the preblocks of a log to a single consumer, either in the process of the log, or in another one (see Dial).
+// A consumer that falls behind does not slow down the log nor other consumers, it just reads older preblocks from disk.
+type Subscription struct {
+	log     *Log
+	from    Position
+	entries chan Entry
+	cancel  chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// Entries returns the channel of preblocks, in the order of their positions.
+// It gets closed when the subscription ends.
+func (s *Subscription) Entries() <-chan Entry {
+	return s.entries
+}
+
+// Cancel ends the subscription.
+func (s *Subscription) Cancel() {
+	s.once.Do(func() { close(s.cancel) })
+}
+
+// Err returns the reason why the subscription ended, nil if the log was finished or the subscription was cancelled.
+// It should be called only after the channel of entries gets closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// run reads the log epoch by epoch, waiting for new preblocks when it reaches the end of the log.
+func (s *Subscription) run() {
+	defer close(s.entries)
+	epoch := s.from.Epoch
+	var file *os.File
+	var offset int64
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for {
+		snap := s.log.snapshot(epoch)
+		if snap.closed {
+			s.err = ErrClosed
+			return
+		}
+		if file == nil {
+			if snap.pruned {
+				s.err = ErrPruned
+				return
+			}
+			if !snap.found {
+				if snap.finished || !s.wait(snap.changed) {
+					return
+				}
+				continue
+			}
+			epoch = snap.epoch
+			var err error
+			file, err = os.Open(s.log.path(epoch))
+			if err != nil {
+				s.err = err
+				return
+			}
+			offset = 0
+		}
+
+		size := snap.size
+		if !snap.found || snap.epoch != epoch {
+			// our epoch was pruned while we were reading it, it is complete, so we read it to the end
+			size = math.MaxInt64
+		}
+		n, ok := s.read(file, epoch, offset, size)
+		if !ok {
+			return
+		}
+		if n > 0 {
+			offset += n
+			continue
+		}
+
+		if snap.found && snap.epoch == epoch && snap.last {
+			if snap.finished || !s.wait(snap.changed) {
+				return
+			}
+			continue
+		}
+		// this epoch is complete, move to the next one
+		file.Close()
+		file = nil
+		epoch++
+	}
+}
+
+// read delivers the preblocks of the given epoch stored in the file between offset and size.
+// It returns the number of bytes read and false if the subscription should end.
+func (s *Subscription) read(file *os.File, epoch gomel.EpochID, offset, size int64) (int64, bool) {
+	reader := &countingReader{r: bufio.NewReader(io.NewSectionReader(file, offset, size-offset))}
+	for {
+		read := reader.n
+		entry, err := readRecord(reader, epoch)
+		if err == io.EOF {
+			return read, true
+		}
+		if err != nil {
+			s.err = err
+			return read, false
+		}
+		if entry.Position.Less(s.from) {
+			continue
+		}
+		select {
+		case s.entries <- *entry:
+		case <-s.cancel:
+			return reader.n, false
+		}
+	}
+}
+
+// wait blocks until the log changes. It returns false if the subscription was cancelled in the meantime.
+func (s *Subscription) wait(changed <-chan struct{}) bool {
+	select {
+	case <-changed:
+		return true
+	case <-s.cancel:
+		return false
+	}
+}
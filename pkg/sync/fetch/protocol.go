@@ -1,8 +1,12 @@
 package fetch
 
 import (
+	"io"
+
 	"gitlab.com/alephledger/consensus-go/pkg/encoding"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
 	"gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
 )
 
@@ -12,7 +16,7 @@ func (p *server) In() {
 		return
 	}
 	defer conn.Close()
-	pid, sid, err := handshake.AcceptGreeting(conn)
+	pid, sid, version, err := handshake.AcceptGreetingWithVersion(conn, p.keys, p.version)
 	if err != nil {
 		p.log.Error().Str("where", "fetch.in.greeting").Msg(err.Error())
 		return
@@ -21,20 +25,41 @@ func (p *server) In() {
 		p.log.Warn().Uint16(lg.PID, pid).Msg("Called by a stranger")
 		return
 	}
+	session := p.health.Begin(pid)
+	defer session.End()
 	log := p.log.With().Uint16(lg.PID, pid).Uint32(lg.ISID, sid).Logger()
 	log.Info().Msg(lg.SyncStarted)
-	unitIDs, err := receiveRequests(conn)
-	if err != nil {
-		log.Error().Str("where", "fetch.in.receiveRequests").Msg(err.Error())
-		return
+	defer metrics.TimeSync("fetch", "in")()
+	kind := []byte{byID}
+	if version > 1 {
+		_, err = io.ReadFull(conn, kind)
+		if err != nil {
+			log.Error().Str("where", "fetch.in.receiveKind").Msg(err.Error())
+			return
+		}
 	}
-	units := p.orderer.UnitsByID(unitIDs...)
-	if err != nil {
-		log.Error().Str("where", "fetch.in.getUnits").Msg(err.Error())
+	var units []gomel.Unit
+	switch kind[0] {
+	case byID:
+		unitIDs, err := receiveRequests(conn)
+		if err != nil {
+			log.Error().Str("where", "fetch.in.receiveRequests").Msg(err.Error())
+			return
+		}
+		units = p.orderer.UnitsByID(unitIDs...)
+	case byHash:
+		hashes, depth, known, err := receiveHashRequests(conn)
+		if err != nil {
+			log.Error().Str("where", "fetch.in.receiveHashRequests").Msg(err.Error())
+			return
+		}
+		units = UnitsByHash(p.orderer, hashes, depth, known)
+	default:
+		log.Error().Str("where", "fetch.in.receiveKind").Msg("unknown kind of request")
 		return
 	}
 	log.Debug().Int(lg.Sent, len(units)).Msg(lg.SendUnits)
-	err = encoding.WriteChunk(units, conn)
+	err = encoding.WriteChunk(units, conn, encoding.ForProtocol(version))
 	if err != nil {
 		log.Error().Str("where", "fetch.in.sendUnits").Msg(err.Error())
 		return
@@ -44,6 +69,7 @@ func (p *server) In() {
 		log.Error().Str("where", "fetch.in.flush").Msg(err.Error())
 		return
 	}
+	session.Succeeded()
 	log.Info().Int(lg.Sent, len(units)).Msg(lg.SyncCompleted)
 }
 
@@ -55,6 +81,8 @@ func (p *server) Out() {
 		return
 	}
 	remotePid := r.Pid
+	session := p.health.Begin(remotePid)
+	defer session.End()
 	conn, err := p.netserv.Dial(remotePid)
 	if err != nil {
 		return
@@ -64,19 +92,49 @@ func (p *server) Out() {
 	p.syncIds[remotePid]++
 	log := p.log.With().Uint16(lg.PID, remotePid).Uint32(lg.OSID, sid).Logger()
 	log.Info().Msg(lg.SyncStarted)
+	defer metrics.TimeSync("fetch", "out")()
 
-	err = handshake.Greet(conn, p.pid, sid)
+	version, err := handshake.GreetWithVersion(conn, p.keys, remotePid, sid, p.version)
 	if err != nil {
 		log.Error().Str("where", "fetch.out.greeting").Msg(err.Error())
 		return
 	}
-	err = sendRequests(conn, r.UnitIDs)
-	if err != nil {
-		log.Error().Str("where", "fetch.out.sendRequests").Msg(err.Error())
-		return
+	hashes := r.Hashes
+	if hashes != nil && version < 2 {
+		if r.UnitIDs == nil {
+			log.Warn().Msg(lg.HashFetchUnsupported)
+			return
+		}
+		log.Debug().Msg(lg.HashFetchUnsupported)
+		hashes = nil
+	}
+	if hashes != nil {
+		_, err = conn.Write([]byte{byHash})
+		if err != nil {
+			log.Error().Str("where", "fetch.out.sendKind").Msg(err.Error())
+			return
+		}
+		err = sendHashRequests(conn, hashes, r.Depth, p.orderer.GetInfo())
+		if err != nil {
+			log.Error().Str("where", "fetch.out.sendHashRequests").Msg(err.Error())
+			return
+		}
+	} else {
+		if version > 1 {
+			_, err = conn.Write([]byte{byID})
+			if err != nil {
+				log.Error().Str("where", "fetch.out.sendKind").Msg(err.Error())
+				return
+			}
+		}
+		err = sendRequests(conn, r.UnitIDs)
+		if err != nil {
+			log.Error().Str("where", "fetch.out.sendRequests").Msg(err.Error())
+			return
+		}
 	}
 	log.Debug().Msg(lg.GetUnits)
-	units, err := encoding.ReadChunk(conn)
+	units, err := encoding.ReadChunk(conn, encoding.ForProtocol(version))
 	nReceived := len(units)
 	if err != nil {
 		log.Error().Str("where", "fetch.out.receivePreunits").Msg(err.Error())
@@ -84,5 +142,6 @@ func (p *server) Out() {
 	}
 	errs := p.orderer.AddPreunits(remotePid, units...)
 	lg.AddingErrors(errs, len(units), log)
+	session.Succeeded()
 	log.Info().Int(lg.Recv, nReceived).Msg(lg.SyncCompleted)
 }
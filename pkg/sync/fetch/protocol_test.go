@@ -54,6 +54,10 @@ func (ua *unitsAdder) UnitsByID(ids ...uint64) []gomel.Unit {
 	return result
 }
 
+func (ua *unitsAdder) UnitsByHash(hashes ...*gomel.Hash) []gomel.Unit {
+	return ua.dag.GetUnits(hashes)
+}
+
 // missingParents returns a slice of unit IDs that are parents of preunit above maxUnits.
 func missingParents(preunit gomel.Preunit, maxUnits gomel.SlottedUnits) []uint64 {
 	unitIDs := []uint64{}
@@ -87,11 +91,14 @@ var _ = Describe("Protocol", func() {
 		serv1    core.Service
 		serv2    core.Service
 		request  sync.Fetch
+		byHash   sync.FetchByHash
+		health1  *sync.Health
 		tserv1   testServer
 		tserv2   testServer
 		netservs []network.Server
 		pu       gomel.Preunit
 		missing  []uint64
+		version2 int
 	)
 
 	const (
@@ -100,6 +107,7 @@ var _ = Describe("Protocol", func() {
 
 	BeforeEach(func() {
 		netservs = ctests.NewNetwork(10, timeout)
+		version2 = 0
 	})
 
 	JustBeforeEach(func() {
@@ -107,15 +115,18 @@ var _ = Describe("Protocol", func() {
 		config1.NProc = 2
 		config1.Pid = 0
 		config1.Timeout = timeout
-		if adder1 == nil {
-			panic("adder1 is nil")
-		}
-		serv1, request = NewServer(config1, adder1, netservs[0], zerolog.Nop())
 		config2 := config.Empty()
 		config2.NProc = 2
 		config2.Pid = 1
 		config2.Timeout = timeout
-		serv2, _ = NewServer(config2, adder2, netservs[1], zerolog.Nop())
+		config2.FetchVersion = version2
+		tests.AddP2PKeys(config1, config2)
+		if adder1 == nil {
+			panic("adder1 is nil")
+		}
+		health1 = sync.NewHealth(config1.NProc)
+		serv1, request, byHash = NewServer(config1, adder1, netservs[0], health1, zerolog.Nop())
+		serv2, _, _ = NewServer(config2, adder2, netservs[1], sync.NewHealth(config2.NProc), zerolog.Nop())
 		tserv1 = serv1.(testServer)
 		tserv2 = serv2.(testServer)
 	})
@@ -149,6 +160,63 @@ var _ = Describe("Protocol", func() {
 				tserv1.Out()
 				Expect(adder1.attemptedAdd).To(HaveLen(len(missing)))
 			})
+
+			It("should record a successful session with the peer", func() {
+				request(pu.Creator(), missing)
+				go tserv2.In()
+				tserv1.Out()
+				peers := health1.Peers()
+				Expect(peers[1].Successes).To(Equal(1))
+				Expect(peers[1].Failures).To(Equal(0))
+				Expect(peers[1].LastSuccess.IsZero()).To(BeFalse())
+				Expect(peers[0].Successes).To(Equal(0))
+			})
+
+			It("should receive only the requested unit when asking by hash", func() {
+				byHash(pu.Creator(), []*gomel.Hash{pu.Hash()}, 0, nil)
+				go tserv2.In()
+				tserv1.Out()
+				Expect(adder1.attemptedAdd).To(HaveLen(1))
+				Expect(*adder1.attemptedAdd[0].Hash()).To(Equal(*pu.Hash()))
+			})
+
+			It("should receive the parents of the requested unit when asking by hash", func() {
+				byHash(pu.Creator(), []*gomel.Hash{pu.Hash()}, 1, nil)
+				go tserv2.In()
+				tserv1.Out()
+				expected := []gomel.Hash{*pu.Hash()}
+				for _, parent := range dag2.GetUnit(pu.Hash()).Parents() {
+					if parent != nil {
+						expected = append(expected, *parent.Hash())
+					}
+				}
+				received := []gomel.Hash{}
+				for _, u := range adder1.attemptedAdd {
+					received = append(received, *u.Hash())
+				}
+				Expect(received).To(ConsistOf(expected))
+			})
+
+			Context("when the peer can only fetch units by ID", func() {
+
+				BeforeEach(func() {
+					version2 = 1
+				})
+
+				It("should request the fallback IDs instead of the hashes", func() {
+					byHash(pu.Creator(), []*gomel.Hash{pu.Hash()}, 1, missing)
+					go tserv2.In()
+					tserv1.Out()
+					Expect(adder1.attemptedAdd).To(HaveLen(len(missing)))
+				})
+
+				It("should request nothing without fallback IDs", func() {
+					byHash(pu.Creator(), []*gomel.Hash{pu.Hash()}, 1, nil)
+					go tserv2.In()
+					tserv1.Out()
+					Expect(adder1.attemptedAdd).To(BeEmpty())
+				})
+			})
 		})
 
 	})
@@ -6,13 +6,28 @@ import (
 	"io"
 
 	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/encoding"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	"gitlab.com/alephledger/core-go/pkg/network"
 )
 
+// Kinds of requests, sent as the first byte of the second version of the protocol.
+const (
+	byID byte = iota
+	byHash
+)
+
+// MaxDepth bounds the number of generations of ancestors that can be requested together with units.
+const MaxDepth = 8
+
 // request is a query for fetch server to perform a sync with the given process and request particular units.
+// Units are requested either by their IDs, or by their hashes together with their ancestors up to the given depth.
+// In the latter case UnitIDs are requested instead if the process runs the first version of the protocol.
 type request struct {
 	Pid     uint16
 	UnitIDs []uint64
+	Hashes  []*gomel.Hash
+	Depth   int
 }
 
 func sendRequests(conn network.Connection, unitIDs []uint64) error {
@@ -55,3 +70,108 @@ func receiveRequests(conn network.Connection) ([]uint64, error) {
 	}
 	return result, nil
 }
+
+// sendHashRequests sends the hashes of requested units, the depth of their ancestry to send back,
+// and the heights of our maximal units, below which we have all the units except forks.
+func sendHashRequests(conn network.Connection, hashes []*gomel.Hash, depth int, known [2]*gomel.DagInfo) error {
+	if len(hashes) > config.MaxUnitsInChunk {
+		hashes = hashes[:config.MaxUnitsInChunk]
+	}
+	if depth > MaxDepth {
+		depth = MaxDepth
+	}
+	buf := make([]byte, 5, 5+len(hashes)*len(gomel.ZeroHash))
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(hashes)))
+	buf[4] = byte(depth)
+	for _, h := range hashes {
+		buf = append(buf, h[:]...)
+	}
+	_, err := conn.Write(buf)
+	if err != nil {
+		return err
+	}
+	err = encoding.WriteDagInfos(known, conn)
+	if err != nil {
+		return err
+	}
+	return conn.Flush()
+}
+
+func receiveHashRequests(conn network.Connection) ([]*gomel.Hash, int, [2]*gomel.DagInfo, error) {
+	var known [2]*gomel.DagInfo
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(conn, buf)
+	if err != nil {
+		return nil, 0, known, err
+	}
+	nReqs := binary.LittleEndian.Uint32(buf[:4])
+	if nReqs > config.MaxUnitsInChunk {
+		return nil, 0, known, errors.New("requests too big")
+	}
+	depth := int(buf[4])
+	if depth > MaxDepth {
+		return nil, 0, known, errors.New("requested depth too big")
+	}
+	result := make([]*gomel.Hash, nReqs)
+	for i := range result {
+		result[i] = &gomel.Hash{}
+		_, err := io.ReadFull(conn, result[i][:])
+		if err != nil {
+			return nil, 0, known, err
+		}
+	}
+	known, err = encoding.ReadDagInfos(conn)
+	if err != nil {
+		return nil, 0, known, err
+	}
+	return result, depth, known, nil
+}
+
+// UnitsByHash returns the units with the given hashes present in the orderer, together with their ancestors
+// at most depth generations above them. Parents of the requested units are always included, as these are
+// the ones the other party failed to recognize. Further ancestors below the heights in known are omitted, unless they have forks,
+// so the other party, having all the units up to these heights, still gets the ones it may lack.
+// The result contains no duplicates and at most config.MaxUnitsInChunk units.
+func UnitsByHash(orderer gomel.Orderer, hashes []*gomel.Hash, depth int, known [2]*gomel.DagInfo) []gomel.Unit {
+	seen := make(map[gomel.Hash]bool)
+	var result []gomel.Unit
+	add := func(u gomel.Unit) bool {
+		if u == nil || seen[*u.Hash()] || len(result) >= config.MaxUnitsInChunk {
+			return false
+		}
+		seen[*u.Hash()] = true
+		result = append(result, u)
+		return true
+	}
+	generation := make([]gomel.Unit, 0, len(hashes))
+	for _, u := range orderer.UnitsByHash(hashes...) {
+		if add(u) {
+			generation = append(generation, u)
+		}
+	}
+	for level := 1; level <= depth && len(generation) > 0; level++ {
+		var next []gomel.Unit
+		for _, u := range generation {
+			for _, parent := range u.Parents() {
+				if parent == nil || (level > 1 && isKnown(orderer, parent, known)) {
+					continue
+				}
+				if add(parent) {
+					next = append(next, parent)
+				}
+			}
+		}
+		generation = next
+	}
+	return result
+}
+
+// isKnown checks if the unit is below the given heights, and there are no forks of it.
+func isKnown(orderer gomel.Orderer, u gomel.Unit, known [2]*gomel.DagInfo) bool {
+	for _, info := range known {
+		if info != nil && info.Epoch == u.EpochID() && int(u.Creator()) < len(info.Heights) && u.Height() <= info.Heights[u.Creator()] {
+			return len(orderer.UnitsByID(gomel.UnitID(u))) == 1
+		}
+	}
+	return false
+}
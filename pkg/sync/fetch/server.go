@@ -2,6 +2,8 @@
 //
 // This protocol cannot be used for general syncing, because usually we don't know the hashes of units we would like to receive in advance.
 // It is only useful as a fallback mechanism.
+// There are two versions of the protocol, negotiated in the handshake: the first one requests units by their IDs only,
+// the second one can also request units by their hashes, together with their ancestors up to a given depth.
 package fetch
 
 import (
@@ -11,14 +13,20 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
 	"gitlab.com/alephledger/consensus-go/pkg/sync"
+	"gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
 	"gitlab.com/alephledger/core-go/pkg/core"
 	"gitlab.com/alephledger/core-go/pkg/network"
 )
 
+// latestVersion is the newest version of the protocol, the one able to fetch units by their hashes.
+const latestVersion = 2
+
 type server struct {
-	pid      uint16
 	orderer  gomel.Orderer
 	netserv  network.Server
+	keys     *handshake.Keys
+	version  uint8
+	health   *sync.Health
 	requests chan *request
 	syncIds  []uint32
 	outPool  sync.WorkerPool
@@ -27,20 +35,28 @@ type server struct {
 	log      zerolog.Logger
 }
 
-// NewServer runs a pool of nOut workers for outgoing part and nIn for incoming part of the given protocol
-func NewServer(conf config.Config, orderer gomel.Orderer, netserv network.Server, log zerolog.Logger) (core.Service, sync.Fetch) {
+// NewServer runs a pool of nOut workers for outgoing part and nIn for incoming part of the given protocol.
+// Outcomes of the sessions are recorded in the given health.
+// It returns functions requesting units by their IDs and by their hashes, the latter falling back to the given IDs
+// when the peer runs the first version of the protocol.
+func NewServer(conf config.Config, orderer gomel.Orderer, netserv network.Server, health *sync.Health, log zerolog.Logger) (core.Service, sync.Fetch, sync.FetchByHash) {
 	s := &server{
-		pid:      conf.Pid,
 		orderer:  orderer,
 		netserv:  netserv,
+		keys:     handshake.NewKeys(conf),
+		version:  latestVersion,
+		health:   health,
 		requests: make(chan *request, conf.NProc),
 		syncIds:  make([]uint32, conf.NProc),
 		stopOut:  make(chan struct{}),
 		log:      log,
 	}
+	if conf.FetchVersion > 0 && conf.FetchVersion < latestVersion {
+		s.version = uint8(conf.FetchVersion)
+	}
 	s.inPool = sync.NewPool(conf.FetchWorkers[0], s.In)
 	s.outPool = sync.NewPool(conf.FetchWorkers[1], s.Out)
-	return s, s.trigger
+	return s, s.trigger, s.triggerByHash
 }
 
 func (s *server) Start() error {
@@ -57,7 +73,15 @@ func (s *server) Stop() {
 
 func (s *server) trigger(pid uint16, ids []uint64) {
 	select {
-	case s.requests <- &request{pid, ids}:
+	case s.requests <- &request{Pid: pid, UnitIDs: ids}:
+	default:
+		s.log.Warn().Msg(lg.RequestOverload)
+	}
+}
+
+func (s *server) triggerByHash(pid uint16, hashes []*gomel.Hash, depth int, fallback []uint64) {
+	select {
+	case s.requests <- &request{Pid: pid, Hashes: hashes, Depth: depth, UnitIDs: fallback}:
 	default:
 		s.log.Warn().Msg(lg.RequestOverload)
 	}
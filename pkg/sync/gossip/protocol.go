@@ -1,22 +1,17 @@
 package gossip
 
 import (
+	"github.com/rs/zerolog"
+
 	"gitlab.com/alephledger/consensus-go/pkg/encoding"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
+	"gitlab.com/alephledger/consensus-go/pkg/sync"
 	"gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
+	"gitlab.com/alephledger/core-go/pkg/network"
 )
 
-// in handles the incoming connection using info from the dag.
-// This version uses simple 2-exchange protocol: receive and send heights and send and receive units.
-//
-// The precise flow of this protocol follows:
-/*		1. Receive a consistent snapshot of the other parties maximal units as a list of heights.
-		2. Compute a similar info for our dag.
-		3. Send this info.
-		4. Compute and send units that are predecessors of our info and successors of the received.
-		5. Receive units complying with the above restrictions.
-		6. Add the received units to the dag.
-*/
+// In handles an incoming connection, running the version of the protocol negotiated in the handshake.
 func (p *server) In() {
 	conn, err := p.netserv.Listen()
 	if err != nil {
@@ -25,7 +20,7 @@ func (p *server) In() {
 	defer conn.Close()
 
 	// receive a handshake
-	pid, sid, err := handshake.AcceptGreeting(conn)
+	pid, sid, version, err := handshake.AcceptGreetingWithVersion(conn, p.keys, p.version)
 	if err != nil {
 		p.log.Error().Str("where", "gossip.in.greeting").Msg(err.Error())
 		return
@@ -41,10 +36,33 @@ func (p *server) In() {
 		return
 	}
 	defer func() { p.tokens[pid] <- struct{}{} }()
+	session := p.health.Begin(pid)
+	defer session.End()
 
 	log := p.log.With().Uint16(lg.PID, pid).Uint32(lg.ISID, sid).Logger()
 	log.Info().Msg(lg.SyncStarted)
+	defer metrics.TimeSync("gossip", "in")()
 
+	if version == 1 {
+		p.inHeights(conn, pid, version, session, log)
+	} else {
+		p.inSketch(conn, pid, version, session, log)
+	}
+}
+
+// inHeights handles the incoming connection using info from the dag.
+// This is the first version of the protocol, using simple 2-exchange protocol: receive and send heights and send and receive units.
+// Units are sent in the encoding of this version of the protocol, see encoding.ForProtocol.
+//
+// The precise flow of this protocol follows:
+/*		1. Receive a consistent snapshot of the other parties maximal units as a list of heights.
+		2. Compute a similar info for our dag.
+		3. Send this info.
+		4. Compute and send units that are predecessors of our info and successors of the received.
+		5. Receive units complying with the above restrictions.
+		6. Add the received units to the dag.
+*/
+func (p *server) inHeights(conn network.Connection, pid uint16, version uint8, session *sync.Session, log zerolog.Logger) {
 	// 1. receive dag info
 	log.Debug().Msg(lg.GetInfo)
 	theirDagInfo, err := encoding.ReadDagInfos(conn)
@@ -66,7 +84,7 @@ func (p *server) In() {
 	// 4. send units
 	units := p.orderer.Delta(theirDagInfo)
 	log.Debug().Int(lg.Sent, len(units)).Msg(lg.SendUnits)
-	err = encoding.WriteChunk(units, conn)
+	err = encoding.WriteChunk(units, conn, encoding.ForProtocol(version))
 	if err != nil {
 		log.Error().Str("where", "gossip.in.sendUnits").Msg(err.Error())
 		return
@@ -80,7 +98,7 @@ func (p *server) In() {
 
 	// 5. receive units
 	log.Debug().Msg(lg.GetUnits)
-	theirPreunitsReceived, err := encoding.ReadChunk(conn)
+	theirPreunitsReceived, err := encoding.ReadChunk(conn, encoding.ForProtocol(version))
 	if err != nil {
 		log.Error().Str("where", "gossip.in.getPreunits").Msg(err.Error())
 		return
@@ -89,21 +107,11 @@ func (p *server) In() {
 	// 6. add units
 	errs := p.orderer.AddPreunits(pid, theirPreunitsReceived...)
 	lg.AddingErrors(errs, len(theirPreunitsReceived), log)
+	session.Succeeded()
 	log.Info().Int(lg.Recv, len(theirPreunitsReceived)).Int(lg.Sent, len(units)).Msg(lg.SyncCompleted)
 }
 
-// out handles the outgoing connection using info from the dag.
-// This version uses 2-exchange simple protocol: send and receive heights and receive and send units.
-//
-// The precise flow of this protocol follows:
-/*
-    1. Get a consistent snapshot of our maximal units and convert it to a list of heights.
-	2. Send this info.
-	3. Receive a similar info created by the other party.
-	4. Receive units, that are predecessors of the received info and successors of ours.
-	5. Compute and send units complying with the above restrictions.
-    6. Add the received units to the dag.
-*/
+// Out handles an outgoing connection, running the version of the protocol negotiated in the handshake.
 func (p *server) Out() {
 	var remotePid uint16
 	select {
@@ -118,6 +126,8 @@ func (p *server) Out() {
 		return
 	}
 	defer func() { p.tokens[remotePid] <- struct{}{} }()
+	session := p.health.Begin(remotePid)
+	defer session.End()
 
 	conn, err := p.netserv.Dial(remotePid)
 	if err != nil {
@@ -130,13 +140,34 @@ func (p *server) Out() {
 	p.syncIds[remotePid]++
 	log := p.log.With().Uint16(lg.PID, remotePid).Uint32(lg.OSID, sid).Logger()
 	log.Info().Msg(lg.SyncStarted)
+	defer metrics.TimeSync("gossip", "out")()
 
-	err = handshake.Greet(conn, p.pid, sid)
+	version, err := handshake.GreetWithVersion(conn, p.keys, remotePid, sid, p.version)
 	if err != nil {
 		log.Error().Str("where", "gossip.out.greeting").Msg(err.Error())
 		return
 	}
+	if version == 1 {
+		p.outHeights(conn, remotePid, version, session, log)
+	} else {
+		p.outSketch(conn, remotePid, version, session, log)
+	}
+}
 
+// outHeights handles the outgoing connection using info from the dag.
+// This is the first version of the protocol, using 2-exchange simple protocol: send and receive heights and receive and send units.
+// Units are sent in the first version of their encoding, see inHeights.
+//
+// The precise flow of this protocol follows:
+/*
+    1. Get a consistent snapshot of our maximal units and convert it to a list of heights.
+	2. Send this info.
+	3. Receive a similar info created by the other party.
+	4. Receive units, that are predecessors of the received info and successors of ours.
+	5. Compute and send units complying with the above restrictions.
+    6. Add the received units to the dag.
+*/
+func (p *server) outHeights(conn network.Connection, remotePid uint16, version uint8, session *sync.Session, log zerolog.Logger) {
 	// 2. send dag info
 	dagInfo := p.orderer.GetInfo()
 	log.Debug().Msg(lg.SendInfo)
@@ -144,7 +175,7 @@ func (p *server) Out() {
 		log.Error().Str("where", "gossip.out.sendDagInfo").Msg(err.Error())
 		return
 	}
-	err = conn.Flush()
+	err := conn.Flush()
 	if err != nil {
 		log.Error().Str("where", "gossip.out.flush").Msg(err.Error())
 		return
@@ -161,7 +192,7 @@ func (p *server) Out() {
 
 	// 4. receive units
 	log.Debug().Msg(lg.GetUnits)
-	theirPreunitsReceived, err := encoding.ReadChunk(conn)
+	theirPreunitsReceived, err := encoding.ReadChunk(conn, encoding.ForProtocol(version))
 	if err != nil {
 		log.Error().Str("where", "gossip.out.getPreunits").Msg(err.Error())
 		return
@@ -170,7 +201,7 @@ func (p *server) Out() {
 	// 5. send units
 	units := p.orderer.Delta(theirDagInfo)
 	log.Debug().Int(lg.Sent, len(units)).Msg(lg.SendUnits)
-	err = encoding.WriteChunk(units, conn)
+	err = encoding.WriteChunk(units, conn, encoding.ForProtocol(version))
 	if err != nil {
 		log.Error().Str("where", "gossip.out.sendUnits").Msg(err.Error())
 		return
@@ -184,5 +215,6 @@ func (p *server) Out() {
 	// 6. add units to dag
 	errs := p.orderer.AddPreunits(remotePid, theirPreunitsReceived...)
 	lg.AddingErrors(errs, len(theirPreunitsReceived), log)
+	session.Succeeded()
 	log.Info().Int(lg.Recv, len(theirPreunitsReceived)).Int(lg.Sent, len(units)).Msg(lg.SyncCompleted)
 }
@@ -14,6 +14,7 @@ import (
 
 	"gitlab.com/alephledger/consensus-go/pkg/config"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/consensus-go/pkg/sync"
 	. "gitlab.com/alephledger/consensus-go/pkg/sync/gossip"
 	"gitlab.com/alephledger/consensus-go/pkg/tests"
 	"gitlab.com/alephledger/core-go/pkg/core"
@@ -62,6 +63,30 @@ func (ua *unitsAdder) Delta(info [2]*gomel.DagInfo) []gomel.Unit {
 	return collectUnits(ua.dag)
 }
 
+// dagOrderer answers with the actual views of its dag and counts the units it receives.
+type dagOrderer struct {
+	gomel.Orderer
+	gomel.Adder
+	dag      gomel.Dag
+	received int
+}
+
+func (do *dagOrderer) AddPreunits(source uint16, units ...gomel.Preunit) []error {
+	do.received += len(units)
+	return do.Adder.AddPreunits(source, units...)
+}
+
+func (do *dagOrderer) GetInfo() [2]*gomel.DagInfo {
+	return [2]*gomel.DagInfo{nil, gomel.MaxView(do.dag)}
+}
+
+func (do *dagOrderer) Delta(info [2]*gomel.DagInfo) []gomel.Unit {
+	if info[1] == nil {
+		return nil
+	}
+	return do.dag.UnitsAbove(info[1].Heights)
+}
+
 type testNetworkServer struct {
 	network.Server
 	connectivity []bool
@@ -119,13 +144,17 @@ var _ = Describe("Protocol", func() {
 			servs = make([]core.Service, size)
 			tservs = make([]testServer, size)
 			req = make([]func(uint16), size)
+			configs := make([]config.Config, size)
 			for i := 0; i < size; i++ {
-				config := config.Empty()
-				config.NProc = uint16(size)
-				config.Pid = uint16(i)
-				config.Timeout = connectionTimeout
-				config.GossipWorkers[0], config.GossipWorkers[1] = 1, 1
-				servs[i], req[i] = NewServer(config, adders[i], netservs[i], zerolog.Nop())
+				configs[i] = config.Empty()
+				configs[i].NProc = uint16(size)
+				configs[i].Pid = uint16(i)
+				configs[i].Timeout = connectionTimeout
+				configs[i].GossipWorkers[0], configs[i].GossipWorkers[1] = 1, 1
+			}
+			tests.AddP2PKeys(configs...)
+			for i := 0; i < size; i++ {
+				servs[i], req[i] = NewServer(configs[i], adders[i], netservs[i], sync.NewHealth(configs[i].NProc), zerolog.Nop())
 				tservs[i] = servs[i].(testServer)
 			}
 		}
@@ -237,4 +266,96 @@ var _ = Describe("Protocol", func() {
 		})
 
 	})
+
+	Describe("between two processes", func() {
+
+		var orderers []*dagOrderer
+
+		hashes := func(dag gomel.Dag) []gomel.Hash {
+			var result []gomel.Hash
+			for _, u := range collectUnits(dag) {
+				result = append(result, *u.Hash())
+			}
+			return result
+		}
+
+		init := func(versions ...int) {
+			netservs = ctests.NewNetwork(2, time.Second)
+			orderers = nil
+			tservs = nil
+			req = nil
+			configs := make([]config.Config, 2)
+			for i := range configs {
+				configs[i] = config.Empty()
+				configs[i].NProc = 4
+				configs[i].Pid = uint16(i)
+				configs[i].Timeout = time.Second
+				configs[i].GossipWorkers[0], configs[i].GossipWorkers[1] = 1, 1
+				configs[i].GossipVersion = versions[i]
+			}
+			tests.AddP2PKeys(configs...)
+			for i := range configs {
+				orderers = append(orderers, &dagOrderer{Orderer: tests.NewOrderer(), Adder: tests.NewAdder(dags[i]), dag: dags[i]})
+				serv, request := NewServer(configs[i], orderers[i], netservs[i], sync.NewHealth(configs[i].NProc), zerolog.Nop())
+				tservs = append(tservs, serv.(testServer))
+				req = append(req, request)
+			}
+		}
+
+		gossip := func() {
+			var done snc.WaitGroup
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				tservs[1].In()
+			}()
+			req[0](1)
+			tservs[0].Out()
+			done.Wait()
+		}
+
+		Context("when they know different forks", func() {
+
+			BeforeEach(func() {
+				for _, file := range []string{"exchange_with_fork_local_view1.txt", "exchange_with_fork_local_view2.txt"} {
+					dag, _, err := tests.CreateDagFromTestFile("../../testdata/dags/4/"+file, tests.NewTestDagFactory())
+					Expect(err).NotTo(HaveOccurred())
+					dags = append(dags, dag)
+				}
+			})
+
+			It("should send only the missing units, including forks", func() {
+				init(0, 0)
+				gossip()
+				Expect(hashes(dags[0])).To(HaveLen(5))
+				Expect(hashes(dags[1])).To(Equal(hashes(dags[0])))
+				Expect(orderers[0].received).To(Equal(2))
+				Expect(orderers[1].received).To(Equal(2))
+			})
+		})
+
+		Context("when one of them knows nothing", func() {
+
+			BeforeEach(func() {
+				for _, file := range []string{"regular.txt", "empty.txt"} {
+					dag, _, err := tests.CreateDagFromTestFile("../../testdata/dags/4/"+file, tests.NewTestDagFactory())
+					Expect(err).NotTo(HaveOccurred())
+					dags = append(dags, dag)
+				}
+			})
+
+			It("should send all the units", func() {
+				init(0, 0)
+				gossip()
+				Expect(hashes(dags[1])).To(Equal(hashes(dags[0])))
+				Expect(orderers[0].received).To(Equal(0))
+			})
+
+			It("should fall back to the first version of the protocol if the other side doesn't know the second", func() {
+				init(0, 1)
+				gossip()
+				Expect(hashes(dags[1])).To(Equal(hashes(dags[0])))
+			})
+		})
+	})
 })
@@ -1,6 +1,8 @@
 // Package gossip implements a protocol for synchronizing dags through gossiping.
 //
 // This protocol should always succeed with adding units received from honest peers, so it needs no fallback.
+// There are two versions of the protocol, negotiated in the handshake: the first one sends all the units above the heights
+// of the peer's maximal units, the second one reconciles the sets of units with sketches to avoid sending units the peer already has.
 package gossip
 
 import (
@@ -9,15 +11,21 @@ import (
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
 	"gitlab.com/alephledger/consensus-go/pkg/sync"
+	"gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
 	"gitlab.com/alephledger/core-go/pkg/core"
 	"gitlab.com/alephledger/core-go/pkg/network"
 )
 
+// latestVersion is the newest version of the protocol, see inSketch.
+const latestVersion = 2
+
 type server struct {
 	nProc    uint16
-	pid      uint16
 	orderer  gomel.Orderer
 	netserv  network.Server
+	keys     *handshake.Keys
+	version  uint8
+	health   *sync.Health
 	requests chan uint16
 	syncIds  []uint32
 	tokens   []chan struct{}
@@ -28,18 +36,24 @@ type server struct {
 }
 
 // NewServer runs a pool of nOut workers for the outgoing part and nIn for the incoming part of the gossip protocol.
-func NewServer(conf config.Config, orderer gomel.Orderer, netserv network.Server, log zerolog.Logger) (core.Service, sync.Gossip) {
+// Outcomes of the sessions are recorded in the given health.
+func NewServer(conf config.Config, orderer gomel.Orderer, netserv network.Server, health *sync.Health, log zerolog.Logger) (core.Service, sync.Gossip) {
 	s := &server{
 		nProc:    conf.NProc,
-		pid:      conf.Pid,
 		orderer:  orderer,
 		netserv:  netserv,
+		keys:     handshake.NewKeys(conf),
+		version:  latestVersion,
+		health:   health,
 		requests: make(chan uint16, conf.NProc),
 		syncIds:  make([]uint32, conf.NProc),
 		tokens:   make([]chan struct{}, conf.NProc),
 		stopOut:  make(chan struct{}),
 		log:      log,
 	}
+	if conf.GossipVersion > 0 && conf.GossipVersion < latestVersion {
+		s.version = uint8(conf.GossipVersion)
+	}
 	for i := range s.tokens {
 		s.tokens[i] = make(chan struct{}, 1)
 		s.tokens[i] <- struct{}{}
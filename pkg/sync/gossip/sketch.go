@@ -0,0 +1,319 @@
+package gossip
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/rs/zerolog"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/encoding"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/sync"
+	"gitlab.com/alephledger/consensus-go/pkg/sync/sketch"
+	"gitlab.com/alephledger/core-go/pkg/network"
+)
+
+// everything is sent instead of requests when the sketch could not be decoded.
+const everything = ^uint32(0)
+
+// maxSketchSize bounds the length of an encoded sketch we are willing to receive.
+const maxSketchSize = 1 << 24
+
+// windowSlack is the number of heights below the lower of the maximal units of both parties that are still reconciled.
+// Units there are usually known to both parties, so they cost nothing, unless some of them are forks the other party hasn't seen.
+const windowSlack = 2
+
+// inSketch handles the incoming connection using info from the dag.
+// This is the second version of the protocol. Heights are exchanged only to find the units both parties might have,
+// the sets of these units are reconciled with a sketch, so no unit is sent to a party that already has it.
+// Units are sent with sized signatures, so they can be signed with any scheme.
+//
+// The precise flow of this protocol follows:
+/*		1. Receive a consistent snapshot of the other parties maximal units as a list of heights.
+		2. Compute a similar info for our dag, and the window: the lower of the two heights for every creator, lowered by windowSlack.
+		3. Send our info and a sketch of our units above the window.
+		4. Receive the keys of units the other party lacks and the units we lack, according to the sketch.
+		5. Send the requested units, or all the units above the received info if the sketch could not be decoded.
+		6. Add the received units to the dag.
+*/
+func (p *server) inSketch(conn network.Connection, pid uint16, version uint8, session *sync.Session, log zerolog.Logger) {
+	// 1. receive dag info
+	log.Debug().Msg(lg.GetInfo)
+	theirDagInfo, err := encoding.ReadDagInfos(conn)
+	if err != nil {
+		log.Error().Str("where", "gossip.in.getDagInfo").Msg(err.Error())
+		return
+	}
+
+	// 2. compute dag info and window
+	dagInfo := p.orderer.GetInfo()
+	win, difference, ok := window(dagInfo, theirDagInfo)
+
+	// 3. send dag info and sketch
+	log.Debug().Msg(lg.SendInfo)
+	if err := encoding.WriteDagInfos(dagInfo, conn); err != nil {
+		log.Error().Str("where", "gossip.in.sendDagInfo").Msg(err.Error())
+		return
+	}
+	var ours map[sketch.Key]gomel.Unit
+	var sk *sketch.Sketch
+	if ok {
+		ours = byKeys(p.orderer.Delta(win))
+		sk = sketch.New(difference)
+		for k := range ours {
+			sk.Insert(k)
+		}
+	}
+	if err := writeSketch(sk, conn); err != nil {
+		log.Error().Str("where", "gossip.in.sendSketch").Msg(err.Error())
+		return
+	}
+	if err := conn.Flush(); err != nil {
+		log.Error().Str("where", "gossip.in.flush").Msg(err.Error())
+		return
+	}
+
+	// 4. receive requests and units
+	requests, all, err := readRequests(conn)
+	if err != nil {
+		log.Error().Str("where", "gossip.in.getRequests").Msg(err.Error())
+		return
+	}
+	log.Debug().Msg(lg.GetUnits)
+	theirPreunitsReceived, err := encoding.ReadChunk(conn, encoding.ForProtocol(version))
+	if err != nil {
+		log.Error().Str("where", "gossip.in.getPreunits").Msg(err.Error())
+		return
+	}
+
+	// 5. send units
+	var units []gomel.Unit
+	if all {
+		units = p.orderer.Delta(theirDagInfo)
+	} else {
+		for _, k := range requests {
+			if u, ok := ours[k]; ok {
+				units = append(units, u)
+			}
+		}
+	}
+	log.Debug().Int(lg.Sent, len(units)).Msg(lg.SendUnits)
+	if err := encoding.WriteChunk(units, conn, encoding.ForProtocol(version)); err != nil {
+		log.Error().Str("where", "gossip.in.sendUnits").Msg(err.Error())
+		return
+	}
+	if err := conn.Flush(); err != nil {
+		log.Error().Str("where", "gossip.in.flush2").Msg(err.Error())
+		return
+	}
+
+	// 6. add units
+	errs := p.orderer.AddPreunits(pid, theirPreunitsReceived...)
+	lg.AddingErrors(errs, len(theirPreunitsReceived), log)
+	session.Succeeded()
+	log.Info().Int(lg.Recv, len(theirPreunitsReceived)).Int(lg.Sent, len(units)).Msg(lg.SyncCompleted)
+}
+
+// outSketch handles the outgoing connection using info from the dag.
+// This is the second version of the protocol, see inSketch.
+//
+// The precise flow of this protocol follows:
+/*
+	1. Get a consistent snapshot of our maximal units and convert it to a list of heights.
+	2. Send this info.
+	3. Receive a similar info created by the other party and a sketch of its units above the window.
+	4. Remove our units above the window from the sketch and decode the difference.
+	5. Send the keys of units we lack and the units the other party lacks, or ask for everything above our info
+	   and send all units above its info if the sketch could not be decoded.
+	6. Receive units and add them to the dag.
+*/
+func (p *server) outSketch(conn network.Connection, remotePid uint16, version uint8, session *sync.Session, log zerolog.Logger) {
+	// 1. get dag info
+	dagInfo := p.orderer.GetInfo()
+
+	// 2. send dag info
+	log.Debug().Msg(lg.SendInfo)
+	if err := encoding.WriteDagInfos(dagInfo, conn); err != nil {
+		log.Error().Str("where", "gossip.out.sendDagInfo").Msg(err.Error())
+		return
+	}
+	if err := conn.Flush(); err != nil {
+		log.Error().Str("where", "gossip.out.flush").Msg(err.Error())
+		return
+	}
+
+	// 3. receive dag info and sketch
+	log.Debug().Msg(lg.GetInfo)
+	theirDagInfo, err := encoding.ReadDagInfos(conn)
+	if err != nil {
+		// errors here happen when the remote side rejects our gossip attempt, hence they are not "true" errors
+		log.Debug().Str("where", "gossip.out.getDagInfo").Msg(err.Error())
+		return
+	}
+	sk, err := readSketch(conn)
+	if err != nil {
+		log.Error().Str("where", "gossip.out.getSketch").Msg(err.Error())
+		return
+	}
+
+	// 4. decode the difference
+	var requests []sketch.Key
+	var units []gomel.Unit
+	all := true
+	if win, _, ok := window(dagInfo, theirDagInfo); ok && sk != nil {
+		ours := byKeys(p.orderer.Delta(win))
+		for k := range ours {
+			sk.Remove(k)
+		}
+		theirs, missing, decoded := sk.Decode()
+		if decoded {
+			all = false
+			requests = theirs
+			for _, k := range missing {
+				if u, ok := ours[k]; ok {
+					units = append(units, u)
+				}
+			}
+		}
+	}
+	if all {
+		log.Debug().Msg(lg.SketchUndecoded)
+		units = p.orderer.Delta(theirDagInfo)
+	}
+
+	// 5. send requests and units
+	if err := writeRequests(requests, all, conn); err != nil {
+		log.Error().Str("where", "gossip.out.sendRequests").Msg(err.Error())
+		return
+	}
+	log.Debug().Int(lg.Sent, len(units)).Msg(lg.SendUnits)
+	if err := encoding.WriteChunk(units, conn, encoding.ForProtocol(version)); err != nil {
+		log.Error().Str("where", "gossip.out.sendUnits").Msg(err.Error())
+		return
+	}
+	if err := conn.Flush(); err != nil {
+		log.Error().Str("where", "gossip.out.flush2").Msg(err.Error())
+		return
+	}
+
+	// 6. receive and add units
+	log.Debug().Msg(lg.GetUnits)
+	theirPreunitsReceived, err := encoding.ReadChunk(conn, encoding.ForProtocol(version))
+	if err != nil {
+		log.Error().Str("where", "gossip.out.getPreunits").Msg(err.Error())
+		return
+	}
+	errs := p.orderer.AddPreunits(remotePid, theirPreunitsReceived...)
+	lg.AddingErrors(errs, len(theirPreunitsReceived), log)
+	session.Succeeded()
+	log.Info().Int(lg.Recv, len(theirPreunitsReceived)).Int(lg.Sent, len(units)).Msg(lg.SyncCompleted)
+}
+
+// window returns dag infos with the lower of the two heights for every creator, lowered by windowSlack.
+// All the units below them should be known to both parties.
+// It also estimates the number of units above the window that only one of the parties has.
+// It returns false if the parties work on different epochs.
+func window(ours, theirs [2]*gomel.DagInfo) (result [2]*gomel.DagInfo, difference int, ok bool) {
+	for i := range result {
+		if ours[i] == nil || theirs[i] == nil {
+			if ours[i] != theirs[i] {
+				return result, 0, false
+			}
+			continue
+		}
+		if ours[i].Epoch != theirs[i].Epoch || len(ours[i].Heights) != len(theirs[i].Heights) {
+			return result, 0, false
+		}
+		heights := make([]int, len(ours[i].Heights))
+		for pid, h := range ours[i].Heights {
+			low, high := h, theirs[i].Heights[pid]
+			if low > high {
+				low, high = high, low
+			}
+			difference += high - low
+			heights[pid] = low - windowSlack
+			if heights[pid] < -1 {
+				heights[pid] = -1
+			}
+		}
+		result[i] = &gomel.DagInfo{Epoch: ours[i].Epoch, Heights: heights}
+	}
+	return result, difference, true
+}
+
+func byKeys(units []gomel.Unit) map[sketch.Key]gomel.Unit {
+	result := make(map[sketch.Key]gomel.Unit, len(units))
+	for _, u := range units {
+		result[sketch.KeyOf(u.Hash())] = u
+	}
+	return result
+}
+
+// writeSketch sends the encoded sketch preceded by its length, nil is sent as an empty sketch.
+func writeSketch(sk *sketch.Sketch, w io.Writer) error {
+	var data []byte
+	if sk != nil {
+		data = sk.Marshal()
+	}
+	buf := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+func readSketch(r io.Reader) (*sketch.Sketch, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(buf)
+	if size == 0 {
+		return nil, nil
+	}
+	if size > maxSketchSize {
+		return nil, errors.New("sketch too big")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return sketch.Unmarshal(data)
+}
+
+func writeRequests(keys []sketch.Key, all bool, w io.Writer) error {
+	buf := make([]byte, 4+sketch.KeySize*len(keys))
+	if all {
+		binary.LittleEndian.PutUint32(buf, everything)
+	} else {
+		binary.LittleEndian.PutUint32(buf, uint32(len(keys)))
+	}
+	for i, k := range keys {
+		copy(buf[4+sketch.KeySize*i:], k[:])
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func readRequests(r io.Reader) ([]sketch.Key, bool, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, false, err
+	}
+	nReqs := binary.LittleEndian.Uint32(buf)
+	if nReqs == everything {
+		return nil, true, nil
+	}
+	if nReqs > config.MaxUnitsInChunk {
+		return nil, false, errors.New("requests too big")
+	}
+	result := make([]sketch.Key, nReqs)
+	for i := range result {
+		if _, err := io.ReadFull(r, result[i][:]); err != nil {
+			return nil, false, err
+		}
+	}
+	return result, false, nil
+}
@@ -1,32 +1,172 @@
 // Package handshake implements protocols for identifying the peer.
 //
 // These protocols are used before some proper sync protocols, to figure out who we are talking to.
-// This is currently not cryptographically secure, but as long as we don't punish peers,
-// which send some wrong information this shouldn't impact anything negatively.
+// The identity of the dialing process is authenticated with a challenge-response exchange
+// using the secret it shares with the listening process (see p2p.NewSharedSecret).
+// The listening process proves its identity in the same way, so both sides know who they are talking to.
+// The session id chosen by the dialing process is part of the authenticated transcript,
+// so it cannot be altered by anyone in between.
+// The same holds for the version of the protocol, which is negotiated during the handshake: both sides propose the newest version they speak
+// and the older of the two is used.
+//
+// The exchange takes a round trip, so it requires connections that can be written to in both directions.
+// Protocols sending messages in one direction only, which can run over one-way connections, seal every message instead, see Seal.
 package handshake
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"io"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+)
+
+const (
+	nonceSize = 32
+	macSize   = sha256.Size
+	helloSize = 7 + nonceSize
+	replySize = nonceSize + 1 + macSize
+)
+
+// labels distinguish the proofs of the dialing and the listening process, so one cannot be reflected as the other.
+var (
+	greetLabel  = []byte("gomel handshake greet")
+	acceptLabel = []byte("gomel handshake accept")
 )
 
-// Greet sends a greeting to the given conn.
-func Greet(w io.Writer, pid uint16, sid uint32) error {
-	var data [6]byte
-	binary.LittleEndian.PutUint16(data[0:], pid)
-	binary.LittleEndian.PutUint32(data[2:], sid)
-	_, err := w.Write(data[:])
+// Keys are the secrets shared by a process with all the committee members.
+type Keys struct {
+	pid     uint16
+	secrets [][]byte
+}
+
+// NewKeys computes the secrets shared by the process described by the given config with all the committee members.
+func NewKeys(conf config.Config) *Keys {
+	secrets := make([][]byte, len(conf.P2PPublicKeys))
+	for i, pk := range conf.P2PPublicKeys {
+		secret := p2p.NewSharedSecret(conf.P2PSecretKey, pk)
+		secrets[i] = secret.Marshal()
+	}
+	return &Keys{conf.Pid, secrets}
+}
+
+// Conn is a connection the handshake is performed on.
+type Conn interface {
+	io.ReadWriter
+	Flush() error
+}
+
+// Greet introduces us to the process pid, listening on the other end of the given conn, as the initiator of the session sid.
+// It returns an error if the other process fails to prove that it is pid.
+func Greet(conn Conn, keys *Keys, pid uint16, sid uint32) error {
+	_, err := GreetWithVersion(conn, keys, pid, sid, 1)
 	return err
 }
 
 // AcceptGreeting accepts a greeting and returns the information it learned from it.
-func AcceptGreeting(r io.Reader) (pid uint16, sid uint32, err error) {
-	var data [6]byte
-	_, err = io.ReadFull(r, data[:])
-	if err != nil {
+// It returns an error if the greeting process fails to prove that it is pid.
+func AcceptGreeting(conn Conn, keys *Keys) (pid uint16, sid uint32, err error) {
+	pid, sid, _, err = AcceptGreetingWithVersion(conn, keys, 1)
+	return
+}
+
+// GreetWithVersion works like Greet, proposing the given version of the protocol. It returns the version agreed on.
+func GreetWithVersion(conn Conn, keys *Keys, pid uint16, sid uint32, version uint8) (uint8, error) {
+	if int(pid) >= len(keys.secrets) {
+		return 0, errors.New("greeting an unknown process")
+	}
+	var hello [helloSize]byte
+	binary.LittleEndian.PutUint16(hello[0:], keys.pid)
+	binary.LittleEndian.PutUint32(hello[2:], sid)
+	hello[6] = version
+	if _, err := rand.Read(hello[7:]); err != nil {
+		return 0, err
+	}
+	if _, err := conn.Write(hello[:]); err != nil {
+		return 0, err
+	}
+	if err := conn.Flush(); err != nil {
+		return 0, err
+	}
+
+	var reply [replySize]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return 0, err
+	}
+	transcript := newTranscript(hello, pid, reply[:nonceSize+1])
+	if !hmac.Equal(reply[nonceSize+1:], keys.mac(pid, acceptLabel, transcript)) {
+		return 0, errors.New("peer failed to authenticate")
+	}
+	agreed := reply[nonceSize]
+	if agreed == 0 || agreed > version {
+		return 0, errors.New("peer chose an unknown version")
+	}
+	if _, err := conn.Write(keys.mac(pid, greetLabel, transcript)); err != nil {
+		return 0, err
+	}
+	return agreed, conn.Flush()
+}
+
+// AcceptGreetingWithVersion works like AcceptGreeting, proposing the given version of the protocol. It also returns the version agreed on.
+func AcceptGreetingWithVersion(conn Conn, keys *Keys, version uint8) (pid uint16, sid uint32, agreed uint8, err error) {
+	var hello [helloSize]byte
+	if _, err = io.ReadFull(conn, hello[:]); err != nil {
+		return
+	}
+	pid = binary.LittleEndian.Uint16(hello[0:])
+	sid = binary.LittleEndian.Uint32(hello[2:])
+	if int(pid) >= len(keys.secrets) || pid == keys.pid {
+		err = errors.New("greeted by an unknown process")
+		return
+	}
+	agreed = version
+	if hello[6] < agreed {
+		agreed = hello[6]
+	}
+	if agreed == 0 {
+		err = errors.New("greeted with an unknown version")
+		return
+	}
+
+	var reply [nonceSize + 1]byte
+	if _, err = rand.Read(reply[:nonceSize]); err != nil {
 		return
 	}
-	pid = binary.LittleEndian.Uint16(data[0:])
-	sid = binary.LittleEndian.Uint32(data[2:])
+	reply[nonceSize] = agreed
+	transcript := newTranscript(hello, keys.pid, reply[:])
+	if _, err = conn.Write(append(reply[:], keys.mac(pid, acceptLabel, transcript)...)); err != nil {
+		return
+	}
+	if err = conn.Flush(); err != nil {
+		return
+	}
+
+	var proof [macSize]byte
+	if _, err = io.ReadFull(conn, proof[:]); err != nil {
+		return
+	}
+	if !hmac.Equal(proof[:], keys.mac(pid, greetLabel, transcript)) {
+		err = errors.New("peer failed to authenticate")
+	}
 	return
 }
+
+// newTranscript concatenates everything that was said in the handshake together with the pid of the listening process.
+func newTranscript(hello [helloSize]byte, listener uint16, reply []byte) []byte {
+	transcript := make([]byte, 0, helloSize+2+len(reply))
+	transcript = append(transcript, hello[:]...)
+	transcript = append(transcript, byte(listener), byte(listener>>8))
+	return append(transcript, reply...)
+}
+
+// mac computes a proof of knowledge of the secret shared with pid, tied to the given label and transcript.
+func (k *Keys) mac(pid uint16, label, transcript []byte) []byte {
+	h := hmac.New(sha256.New, k.secrets[pid])
+	h.Write(label)
+	h.Write(transcript)
+	return h.Sum(nil)
+}
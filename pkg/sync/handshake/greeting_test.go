@@ -7,7 +7,9 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"gitlab.com/alephledger/consensus-go/pkg/config"
 	. "gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
 	"gitlab.com/alephledger/core-go/pkg/network"
 	ctests "gitlab.com/alephledger/core-go/pkg/tests"
 )
@@ -16,6 +18,7 @@ var _ = Describe("Greeting", func() {
 
 	var (
 		servs []network.Server
+		keys  []*Keys
 	)
 
 	const (
@@ -24,6 +27,17 @@ var _ = Describe("Greeting", func() {
 
 	BeforeEach(func() {
 		servs = ctests.NewNetwork(2, timeout)
+		confs := []config.Config{config.Empty(), config.Empty()}
+		for i, cnf := range confs {
+			cnf.Pid = uint16(i)
+			cnf.NProc = 2
+		}
+		tests.AddP2PKeys(confs...)
+		keys = []*Keys{NewKeys(confs[0]), NewKeys(confs[1])}
+	})
+
+	AfterEach(func() {
+		ctests.CloseNetwork(servs)
 	})
 
 	Context("correctly", func() {
@@ -32,19 +46,84 @@ var _ = Describe("Greeting", func() {
 			var wg sync.WaitGroup
 			wg.Add(2)
 			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
 				conn, err := servs[1].Dial(0)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(Greet(conn, 1, 2)).To(Succeed())
-				wg.Done()
+				defer conn.Close()
+				Expect(Greet(conn, keys[1], 0, 2)).To(Succeed())
 			}()
 			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
 				conn, err := servs[0].Listen()
 				Expect(err).NotTo(HaveOccurred())
-				pid, sid, err := AcceptGreeting(conn)
+				defer conn.Close()
+				pid, sid, err := AcceptGreeting(conn, keys[0])
 				Expect(err).NotTo(HaveOccurred())
 				Expect(pid).To(BeNumerically("==", 1))
 				Expect(sid).To(BeNumerically("==", 2))
-				wg.Done()
+			}()
+			wg.Wait()
+		})
+
+		It("should agree on the older of the proposed versions", func() {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				conn, err := servs[1].Dial(0)
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+				version, err := GreetWithVersion(conn, keys[1], 0, 2, 3)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version).To(BeNumerically("==", 2))
+			}()
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				conn, err := servs[0].Listen()
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+				_, _, version, err := AcceptGreetingWithVersion(conn, keys[0], 2)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version).To(BeNumerically("==", 2))
+			}()
+			wg.Wait()
+		})
+
+	})
+
+	Context("by an impostor", func() {
+
+		BeforeEach(func() {
+			impostor := config.Empty()
+			impostor.Pid = 1
+			impostor.NProc = 2
+			tests.AddP2PKeys(config.Empty(), impostor)
+			keys[1] = NewKeys(impostor)
+		})
+
+		It("should reject the greeting", func() {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				conn, err := servs[1].Dial(0)
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+				Expect(Greet(conn, keys[1], 0, 2)).NotTo(Succeed())
+			}()
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				conn, err := servs[0].Listen()
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+				_, _, err = AcceptGreeting(conn, keys[0])
+				Expect(err).To(HaveOccurred())
 			}()
 			wg.Wait()
 		})
@@ -0,0 +1,140 @@
+package handshake
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// Messages sent over one-way connections, like the ones of the udp network, cannot be preceded by a greeting,
+// so they are sealed instead. A sealed message starts with the pid of the sender, the session id, the version of the protocol,
+// the time it was sealed, a random nonce and the size of the payload, followed by a MAC over all of them, the pid of
+// the receiver and the payload, computed with the secret shared by both processes. The receiver opens only messages sealed
+// recently, and remembers their nonces (see Window), so a sealed message cannot be replayed.
+const sealHeaderSize = 2 + 4 + 1 + 8 + nonceSize + 4
+
+var sealLabel = []byte("gomel sealed message")
+
+// Seal writes the payload for the process pid, authenticated as sent in the session sid using the given version of the protocol.
+func Seal(w io.Writer, keys *Keys, pid uint16, sid uint32, version uint8, now time.Time, payload []byte) error {
+	if int(pid) >= len(keys.secrets) {
+		return errors.New("sealing for an unknown process")
+	}
+	var header [sealHeaderSize]byte
+	binary.LittleEndian.PutUint16(header[0:], keys.pid)
+	binary.LittleEndian.PutUint32(header[2:], sid)
+	header[6] = version
+	binary.LittleEndian.PutUint64(header[7:], uint64(now.UnixNano()))
+	if _, err := rand.Read(header[15 : 15+nonceSize]); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(header[15+nonceSize:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(keys.mac(pid, sealLabel, sealTranscript(header, pid, payload))); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Open reads a message written by Seal and returns its sender, session id, version and payload, which is at most maxSize bytes.
+// It returns an error if the message was not sealed for us by the sender, or it is not fresh according to the given window.
+func Open(r io.Reader, keys *Keys, window *Window, maxSize int) (pid uint16, sid uint32, version uint8, payload []byte, err error) {
+	var header [sealHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	pid = binary.LittleEndian.Uint16(header[0:])
+	sid = binary.LittleEndian.Uint32(header[2:])
+	version = header[6]
+	if int(pid) >= len(keys.secrets) || pid == keys.pid {
+		err = errors.New("message sealed by an unknown process")
+		return
+	}
+	size := binary.LittleEndian.Uint32(header[15+nonceSize:])
+	if uint64(size) > uint64(maxSize) {
+		err = errors.New("sealed message too big")
+		return
+	}
+	var mac [macSize]byte
+	if _, err = io.ReadFull(r, mac[:]); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if !hmac.Equal(mac[:], keys.mac(pid, sealLabel, sealTranscript(header, keys.pid, payload))) {
+		err = errors.New("peer failed to authenticate")
+		return
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], header[15:])
+	sealed := time.Unix(0, int64(binary.LittleEndian.Uint64(header[7:])))
+	if !window.fresh(pid, sealed, nonce) {
+		err = errors.New("stale or replayed message")
+	}
+	return
+}
+
+// sealTranscript concatenates the header of a sealed message, the pid of its receiver and the payload.
+func sealTranscript(header [sealHeaderSize]byte, receiver uint16, payload []byte) []byte {
+	transcript := make([]byte, 0, sealHeaderSize+2+len(payload))
+	transcript = append(transcript, header[:]...)
+	transcript = append(transcript, byte(receiver), byte(receiver>>8))
+	return append(transcript, payload...)
+}
+
+// Window tells which sealed messages are fresh. Messages sealed more than maxAge before or after the current time
+// are stale, so the clocks of processes cannot drift apart by more than that. The nonces of the remaining ones
+// are remembered until they become stale, so every message is opened at most once.
+type Window struct {
+	mx     sync.Mutex
+	maxAge time.Duration
+	clock  *gomel.Clock
+	seen   []map[[nonceSize]byte]time.Time
+	pruned time.Time
+}
+
+// NewWindow returns a window for messages from a committee of the given size, telling the time with the given clock.
+func NewWindow(nProc uint16, maxAge time.Duration, clock *gomel.Clock) *Window {
+	seen := make([]map[[nonceSize]byte]time.Time, nProc)
+	for i := range seen {
+		seen[i] = make(map[[nonceSize]byte]time.Time)
+	}
+	return &Window{maxAge: maxAge, clock: clock, seen: seen}
+}
+
+// fresh checks if the message with the given nonce, sealed by pid at the given time, is fresh and records it as opened.
+func (w *Window) fresh(pid uint16, sealed time.Time, nonce [nonceSize]byte) bool {
+	now := w.clock.Now()
+	oldest := now.Add(-w.maxAge)
+	if int(pid) >= len(w.seen) || sealed.Before(oldest) || sealed.After(now.Add(w.maxAge)) {
+		return false
+	}
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if _, ok := w.seen[pid][nonce]; ok {
+		return false
+	}
+	if now.Sub(w.pruned) > w.maxAge {
+		for _, seen := range w.seen {
+			for n, t := range seen {
+				if t.Before(oldest) {
+					delete(seen, n)
+				}
+			}
+		}
+		w.pruned = now
+	}
+	w.seen[pid][nonce] = sealed
+	return true
+}
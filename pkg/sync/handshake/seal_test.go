@@ -0,0 +1,86 @@
+package handshake_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	. "gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
+	"gitlab.com/alephledger/consensus-go/pkg/tests"
+)
+
+var _ = Describe("Seal", func() {
+
+	var (
+		keys   []*Keys
+		window *Window
+		now    time.Time
+		buf    *bytes.Buffer
+	)
+
+	const (
+		maxAge  = time.Minute
+		maxSize = 1 << 10
+	)
+
+	BeforeEach(func() {
+		confs := []config.Config{config.Empty(), config.Empty()}
+		for i, cnf := range confs {
+			cnf.Pid = uint16(i)
+			cnf.NProc = 2
+		}
+		tests.AddP2PKeys(confs...)
+		keys = []*Keys{NewKeys(confs[0]), NewKeys(confs[1])}
+		now = time.Now()
+		clock := gomel.NewClock(func() time.Time { return now }, time.After, nil)
+		window = NewWindow(2, maxAge, clock)
+		buf = &bytes.Buffer{}
+	})
+
+	It("should open a sealed message once", func() {
+		Expect(Seal(buf, keys[1], 0, 7, 2, now, []byte("unit"))).To(Succeed())
+		sealed := buf.Bytes()
+		pid, sid, version, payload, err := Open(bytes.NewReader(sealed), keys[0], window, maxSize)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pid).To(BeNumerically("==", 1))
+		Expect(sid).To(BeNumerically("==", 7))
+		Expect(version).To(BeNumerically("==", 2))
+		Expect(payload).To(Equal([]byte("unit")))
+		_, _, _, _, err = Open(bytes.NewReader(sealed), keys[0], window, maxSize)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject stale messages", func() {
+		Expect(Seal(buf, keys[1], 0, 7, 2, now.Add(-2*maxAge), []byte("unit"))).To(Succeed())
+		_, _, _, _, err := Open(buf, keys[0], window, maxSize)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject altered messages", func() {
+		Expect(Seal(buf, keys[1], 0, 7, 2, now, []byte("unit"))).To(Succeed())
+		sealed := buf.Bytes()
+		sealed[len(sealed)-1] ^= 1
+		_, _, _, _, err := Open(bytes.NewReader(sealed), keys[0], window, maxSize)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject messages that are too big", func() {
+		Expect(Seal(buf, keys[1], 0, 7, 2, now, make([]byte, maxSize+1))).To(Succeed())
+		_, _, _, _, err := Open(buf, keys[0], window, maxSize)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject messages sealed by an impostor", func() {
+		impostor := config.Empty()
+		impostor.Pid = 1
+		impostor.NProc = 2
+		tests.AddP2PKeys(config.Empty(), impostor)
+		Expect(Seal(buf, NewKeys(impostor), 0, 7, 2, now, []byte("unit"))).To(Succeed())
+		_, _, _, _, err := Open(buf, keys[0], window, maxSize)
+		Expect(err).To(HaveOccurred())
+	})
+})
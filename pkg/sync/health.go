@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+// Health keeps track of the outcomes of sync sessions with every committee member.
+type Health struct {
+	mx    sync.Mutex
+	peers []gomel.PeerStatus
+}
+
+// NewHealth constructs a Health for a committee of the given size.
+func NewHealth(nProc uint16) *Health {
+	peers := make([]gomel.PeerStatus, nProc)
+	for pid := range peers {
+		peers[pid].Pid = uint16(pid)
+	}
+	return &Health{peers: peers}
+}
+
+// Session is a single sync session with a committee member.
+type Session struct {
+	health *Health
+	pid    uint16
+	ok     bool
+}
+
+// Begin starts recording a session with the given committee member. The session has to be ended with End.
+func (h *Health) Begin(pid uint16) *Session {
+	return &Session{health: h, pid: pid}
+}
+
+// Succeeded marks the session as completed.
+func (s *Session) Succeeded() {
+	s.ok = true
+}
+
+// End records the outcome of the session, a failure unless Succeeded was called.
+func (s *Session) End() {
+	s.health.mx.Lock()
+	defer s.health.mx.Unlock()
+	peer := &s.health.peers[s.pid]
+	if s.ok {
+		peer.Successes++
+		peer.Consecutive = 0
+		peer.LastSuccess = time.Now()
+	} else {
+		peer.Failures++
+		peer.Consecutive++
+	}
+}
+
+// Peers returns the status of syncing with every committee member.
+func (h *Health) Peers() []gomel.PeerStatus {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	result := make([]gomel.PeerStatus, len(h.peers))
+	copy(result, h.peers)
+	return result
+}
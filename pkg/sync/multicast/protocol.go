@@ -1,8 +1,12 @@
 package multicast
 
 import (
+	"bytes"
+	"sync/atomic"
+
 	"gitlab.com/alephledger/consensus-go/pkg/encoding"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
 )
 
 func (s *server) In() {
@@ -12,12 +16,25 @@ func (s *server) In() {
 	}
 	defer conn.Close()
 
-	preunit, err := encoding.ReadPreunit(conn)
+	pid, _, version, data, err := handshake.Open(conn, s.keys, s.window, maxUnitSize)
+	if err != nil {
+		s.log.Error().Str("where", "multicast.in.open").Msg(err.Error())
+		return
+	}
+	if version == 0 || version > latestVersion {
+		s.log.Warn().Uint16(lg.PID, pid).Msg(lg.EncodingUnsupported)
+		return
+	}
+	preunit, err := encoding.ReadPreunit(bytes.NewReader(data), encoding.ForProtocol(version))
 	if err != nil {
 		s.log.Error().Str("where", "multicast.in.decode").Msg(err.Error())
 		return
 	}
-	lg.AddingErrors(s.orderer.AddPreunits(preunit.Creator(), preunit), 1, s.log)
+	if preunit.Creator() != pid {
+		s.log.Warn().Uint16(lg.PID, pid).Uint16(lg.Creator, preunit.Creator()).Msg(lg.ForeignMulticast)
+		return
+	}
+	lg.AddingErrors(s.orderer.AddPreunits(pid, preunit), 1, s.log)
 }
 
 func (s *server) Out(pid uint16) {
@@ -32,7 +49,8 @@ func (s *server) Out(pid uint16) {
 		return
 	}
 	defer conn.Close()
-	_, err = conn.Write(r.encUnit)
+	sid := atomic.AddUint32(&s.syncIds[pid], 1) - 1
+	err = handshake.Seal(conn, s.keys, pid, sid, latestVersion, s.clock.Now(), r.encUnit)
 	if err != nil {
 		s.log.Error().Str("where", "multicast.out.sendUnit").Msg(err.Error())
 		return
@@ -71,12 +71,16 @@ var _ = Describe("Protocol", func() {
 		for _, dag := range dags {
 			adders = append(adders, &unitsAdder{Orderer: tests.NewOrderer(), Adder: tests.NewAdder(dag)})
 		}
+		configs := make([]config.Config, 4)
 		for i := 0; i < 4; i++ {
-			config := config.Empty()
-			config.NProc = 4
-			config.Pid = uint16(i)
-			config.Timeout = timeout
-			serv, mltcst := NewServer(config, adders[i], netservs[i], zerolog.Nop())
+			configs[i] = config.Empty()
+			configs[i].NProc = 4
+			configs[i].Pid = uint16(i)
+			configs[i].Timeout = timeout
+		}
+		tests.AddP2PKeys(configs...)
+		for i := 0; i < 4; i++ {
+			serv, mltcst := NewServer(configs[i], adders[i], netservs[i], zerolog.Nop())
 			servs = append(servs, serv)
 			tservs = append(tservs, serv.(testServer))
 			if multicast == nil {
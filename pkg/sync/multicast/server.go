@@ -2,16 +2,23 @@
 //
 // It also accepts units multicasted by other processes.
 // We might not be able to insert some of these units into our dag if we don't have their parents, so a fallback mechanism is needed.
+// Units are sent one way, so the protocol works over one-way networks like udp. Instead of greeting the receiver,
+// the sender seals every unit (see handshake.Seal), together with the version of the protocol it uses.
+// There are two versions of the protocol: the first one sends units with fixed size signatures,
+// which fit only ed25519 keys, the second one precedes every signature with its size. We send units in the second one.
 package multicast
 
 import (
+	"bytes"
 	"math/rand"
+	"time"
 
 	"github.com/rs/zerolog"
 	"gitlab.com/alephledger/consensus-go/pkg/config"
 	"gitlab.com/alephledger/consensus-go/pkg/encoding"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	"gitlab.com/alephledger/consensus-go/pkg/sync"
+	"gitlab.com/alephledger/consensus-go/pkg/sync/handshake"
 	"gitlab.com/alephledger/core-go/pkg/core"
 	"gitlab.com/alephledger/core-go/pkg/network"
 )
@@ -21,6 +28,15 @@ const (
 	inPoolSize  = 4
 )
 
+// latestVersion is the newest version of the protocol, the one sending units with sized signatures.
+const latestVersion = 2
+
+// maxUnitSize bounds the size of an encoded unit, leaving plenty of room for the crown and the signature.
+const maxUnitSize = config.MaxDataBytesPerUnit + config.MaxRandomSourceDataBytesPerUnit + 1<<20
+
+// sealAge is how long a sealed unit can be opened, it has to exceed the drift between clocks of processes and the delivery time.
+const sealAge = time.Minute
+
 // request represents a request to send the encoded unit to the committee member indicated by pid.
 type request struct {
 	encUnit []byte
@@ -32,7 +48,11 @@ type server struct {
 	nProc    uint16
 	orderer  gomel.Orderer
 	netserv  network.Server
+	keys     *handshake.Keys
+	window   *handshake.Window
+	clock    *gomel.Clock
 	requests []chan *request
+	syncIds  []uint32
 	outPool  sync.WorkerPool
 	inPool   sync.WorkerPool
 	stopOut  chan struct{}
@@ -51,7 +71,11 @@ func NewServer(conf config.Config, orderer gomel.Orderer, netserv network.Server
 		nProc:    nProc,
 		orderer:  orderer,
 		netserv:  netserv,
+		keys:     handshake.NewKeys(conf),
+		window:   handshake.NewWindow(nProc, sealAge, conf.Clock),
+		clock:    conf.Clock,
 		requests: requests,
+		syncIds:  make([]uint32, nProc),
 		stopOut:  make(chan struct{}),
 		log:      log,
 	}
@@ -76,7 +100,8 @@ func (s *server) send(unit gomel.Unit) {
 	if unit.Creator() != s.pid {
 		panic("Attempting to multicast unit that we didn't create")
 	}
-	encUnit, err := encoding.EncodeUnit(unit)
+	var buf bytes.Buffer
+	err := encoding.WriteUnit(unit, &buf, encoding.ForProtocol(latestVersion))
 	if err != nil {
 		s.log.Error().Str("where", "multicastServer.Send.EncodeUnit").Msg(err.Error())
 		return
@@ -85,6 +110,6 @@ func (s *server) send(unit gomel.Unit) {
 		if i == int(s.pid) {
 			continue
 		}
-		s.requests[i] <- &request{encUnit, unit.Height()}
+		s.requests[i] <- &request{buf.Bytes(), unit.Height()}
 	}
 }
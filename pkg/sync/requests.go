@@ -8,5 +8,10 @@ type Gossip func(uint16)
 // Fetch is a function that contacts the given PID and requests units with given IDs.
 type Fetch func(uint16, []uint64)
 
+// FetchByHash is a function that contacts the given PID and requests units with given hashes,
+// together with their ancestors the given number of generations above, or units with the given IDs
+// if the PID is unable to fetch units by hash.
+type FetchByHash func(uint16, []*gomel.Hash, int, []uint64)
+
 // Multicast is a function that sends the given unit to all committee members.
 type Multicast func(gomel.Unit)
@@ -0,0 +1,176 @@
+// Package sketch implements invertible Bloom lookup tables of sets of unit hashes.
+//
+// Two processes can find the symmetric difference of their sets with a sketch
+// of a size proportional to the expected difference, not to the sets themselves:
+// one of them sends a sketch of its set, the other removes from it all the keys of its own set and decodes the result.
+// Units are identified by their whole hashes, so even forks crafted to share a prefix of the hash are told apart.
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+)
+
+const (
+	// nHashes is the number of cells every key is stored in, one in each third of the table.
+	nHashes = 3
+	// minSize is the size of the smallest sketch, small differences are decoded reliably only in a table that is not too small.
+	minSize = 3 * 16
+	// KeySize is the size of an encoded key in bytes.
+	KeySize  = len(gomel.ZeroHash)
+	cellSize = 4 + KeySize + 8
+)
+
+// Key identifies a unit in a sketch.
+type Key gomel.Hash
+
+// KeyOf returns the key of a unit with the given hash.
+func KeyOf(h *gomel.Hash) Key {
+	return Key(*h)
+}
+
+// xor sets k to the bitwise xor of k and other.
+func (k *Key) xor(other Key) {
+	for i := range k {
+		k[i] ^= other[i]
+	}
+}
+
+type cell struct {
+	count    int32
+	keySum   Key
+	checkSum uint64
+}
+
+// Sketch is an invertible Bloom lookup table of keys.
+type Sketch struct {
+	cells []cell
+}
+
+// New returns an empty sketch able to decode differences of roughly the given size.
+func New(difference int) *Sketch {
+	size := 2*difference + minSize
+	size += (nHashes - size%nHashes) % nHashes
+	return &Sketch{make([]cell, size)}
+}
+
+// Insert adds the given key to the sketch.
+func (s *Sketch) Insert(k Key) {
+	s.update(k, 1)
+}
+
+// Remove takes the given key out of the sketch, even if it was never inserted.
+func (s *Sketch) Remove(k Key) {
+	s.update(k, -1)
+}
+
+// Decode lists the keys that were inserted and not removed, and the ones that were removed but never inserted.
+// It returns false if there were too many of them to decode. The sketch is emptied in the process anyway.
+func (s *Sketch) Decode() (inserted, removed []Key, ok bool) {
+	pure := make([]int, 0, len(s.cells))
+	for i := range s.cells {
+		if s.isPure(i) {
+			pure = append(pure, i)
+		}
+	}
+	for len(pure) > 0 {
+		i := pure[len(pure)-1]
+		pure = pure[:len(pure)-1]
+		if !s.isPure(i) {
+			continue
+		}
+		k, count := s.cells[i].keySum, s.cells[i].count
+		if count > 0 {
+			inserted = append(inserted, k)
+		} else {
+			removed = append(removed, k)
+		}
+		for _, j := range s.indices(k) {
+			s.cells[j].count -= count
+			s.cells[j].keySum.xor(k)
+			s.cells[j].checkSum ^= check(k)
+			if s.isPure(j) {
+				pure = append(pure, j)
+			}
+		}
+	}
+	for _, c := range s.cells {
+		if c.count != 0 || c.keySum != (Key{}) || c.checkSum != 0 {
+			return inserted, removed, false
+		}
+	}
+	return inserted, removed, true
+}
+
+// Marshal encodes the sketch.
+func (s *Sketch) Marshal() []byte {
+	data := make([]byte, cellSize*len(s.cells))
+	for i, c := range s.cells {
+		b := data[cellSize*i:]
+		binary.LittleEndian.PutUint32(b[0:], uint32(c.count))
+		copy(b[4:], c.keySum[:])
+		binary.LittleEndian.PutUint64(b[4+KeySize:], c.checkSum)
+	}
+	return data
+}
+
+// Unmarshal decodes a sketch encoded with Marshal.
+func Unmarshal(data []byte) (*Sketch, error) {
+	if len(data) == 0 || len(data)%cellSize != 0 || (len(data)/cellSize)%nHashes != 0 {
+		return nil, errors.New("malformed sketch")
+	}
+	s := &Sketch{make([]cell, len(data)/cellSize)}
+	for i := range s.cells {
+		b := data[cellSize*i:]
+		s.cells[i].count = int32(binary.LittleEndian.Uint32(b[0:]))
+		copy(s.cells[i].keySum[:], b[4:4+KeySize])
+		s.cells[i].checkSum = binary.LittleEndian.Uint64(b[4+KeySize:])
+	}
+	return s, nil
+}
+
+func (s *Sketch) update(k Key, count int32) {
+	for _, i := range s.indices(k) {
+		s.cells[i].count += count
+		s.cells[i].keySum.xor(k)
+		s.cells[i].checkSum ^= check(k)
+	}
+}
+
+// isPure checks if the cell holds exactly one key, either inserted or removed.
+func (s *Sketch) isPure(i int) bool {
+	c := s.cells[i]
+	return (c.count == 1 || c.count == -1) && c.checkSum == check(c.keySum)
+}
+
+func (s *Sketch) indices(k Key) [nHashes]int {
+	var result [nHashes]int
+	part := len(s.cells) / nHashes
+	seed := binary.LittleEndian.Uint64(k[:8])
+	for i := range result {
+		result[i] = i*part + int(mix(seed+uint64(i)+1)%uint64(part))
+	}
+	return result
+}
+
+// check is a checksum of a key, it tells cells holding a single key from cells holding many.
+// It depends on the whole key, so keys differing anywhere have unrelated checksums.
+func check(k Key) uint64 {
+	x := uint64(0x5bd1e9955bd1e995)
+	for i := 0; i < KeySize; i += 8 {
+		x = mix(x ^ binary.LittleEndian.Uint64(k[i:]))
+	}
+	return x
+}
+
+// mix is the finalizer of splitmix64.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
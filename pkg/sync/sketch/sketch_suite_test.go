@@ -0,0 +1,13 @@
+package sketch_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSketch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sketch Suite")
+}
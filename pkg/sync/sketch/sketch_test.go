@@ -0,0 +1,112 @@
+package sketch_test
+
+import (
+	"math/rand"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "gitlab.com/alephledger/consensus-go/pkg/sync/sketch"
+)
+
+var _ = Describe("Sketch", func() {
+
+	var (
+		common, ours, theirs []Key
+		sk                   *Sketch
+	)
+
+	randomKeys := func(n int) []Key {
+		keys := make([]Key, n)
+		for i := range keys {
+			rand.Read(keys[i][:])
+		}
+		return keys
+	}
+
+	// build a sketch of common and ours, and remove common and theirs from it.
+	reconcile := func(difference int) {
+		sk = New(difference)
+		for _, k := range append(append([]Key{}, common...), ours...) {
+			sk.Insert(k)
+		}
+		for _, k := range append(append([]Key{}, common...), theirs...) {
+			sk.Remove(k)
+		}
+	}
+
+	BeforeEach(func() {
+		common = randomKeys(1000)
+	})
+
+	Context("when the sets are equal", func() {
+		It("should decode an empty difference", func() {
+			reconcile(0)
+			inserted, removed, ok := sk.Decode()
+			Expect(ok).To(BeTrue())
+			Expect(inserted).To(BeEmpty())
+			Expect(removed).To(BeEmpty())
+		})
+	})
+
+	Context("when the sets differ a little", func() {
+		BeforeEach(func() {
+			ours = randomKeys(20)
+			theirs = randomKeys(30)
+		})
+
+		It("should decode the difference", func() {
+			reconcile(50)
+			inserted, removed, ok := sk.Decode()
+			Expect(ok).To(BeTrue())
+			Expect(inserted).To(ConsistOf(ours))
+			Expect(removed).To(ConsistOf(theirs))
+		})
+
+		It("should decode the difference after sending the sketch", func() {
+			reconcile(50)
+			received, err := Unmarshal(sk.Marshal())
+			Expect(err).NotTo(HaveOccurred())
+			inserted, removed, ok := received.Decode()
+			Expect(ok).To(BeTrue())
+			Expect(inserted).To(ConsistOf(ours))
+			Expect(removed).To(ConsistOf(theirs))
+		})
+	})
+
+	Context("when the sets differ much more than expected", func() {
+		BeforeEach(func() {
+			ours = randomKeys(500)
+			theirs = randomKeys(500)
+		})
+
+		It("should report failure", func() {
+			reconcile(10)
+			_, _, ok := sk.Decode()
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("when the sets differ in keys sharing a long prefix", func() {
+		BeforeEach(func() {
+			ours = randomKeys(1)
+			theirs = []Key{ours[0]}
+			theirs[0][len(theirs[0])-1] ^= 1
+		})
+
+		It("should tell them apart", func() {
+			reconcile(2)
+			inserted, removed, ok := sk.Decode()
+			Expect(ok).To(BeTrue())
+			Expect(inserted).To(ConsistOf(ours))
+			Expect(removed).To(ConsistOf(theirs))
+		})
+	})
+
+	It("should refuse malformed data", func() {
+		_, err := Unmarshal(make([]byte, 7))
+		Expect(err).To(HaveOccurred())
+		_, err = Unmarshal(nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,18 +1,22 @@
 package syncer
 
 import (
-	"time"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"gitlab.com/alephledger/consensus-go/pkg/config"
 	"gitlab.com/alephledger/consensus-go/pkg/gomel"
 	lg "gitlab.com/alephledger/consensus-go/pkg/logging"
+	"gitlab.com/alephledger/consensus-go/pkg/metrics"
+	"gitlab.com/alephledger/consensus-go/pkg/network/mem"
+	"gitlab.com/alephledger/consensus-go/pkg/network/secure"
 	"gitlab.com/alephledger/consensus-go/pkg/sync"
 	"gitlab.com/alephledger/consensus-go/pkg/sync/fetch"
 	"gitlab.com/alephledger/consensus-go/pkg/sync/gossip"
 	"gitlab.com/alephledger/consensus-go/pkg/sync/multicast"
 	"gitlab.com/alephledger/consensus-go/pkg/sync/rmc"
 	"gitlab.com/alephledger/core-go/pkg/core"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
 	"gitlab.com/alephledger/core-go/pkg/network"
 	"gitlab.com/alephledger/core-go/pkg/network/persistent"
 	"gitlab.com/alephledger/core-go/pkg/network/tcp"
@@ -22,47 +26,54 @@ import (
 type syncer struct {
 	gossip      sync.Gossip
 	fetch       sync.Fetch
+	fetchByHash sync.FetchByHash
 	mcast       sync.Multicast
+	health      *sync.Health
 	servers     []core.Service
 	subservices []core.Service
 }
 
 // New creates a new syncer that uses provided config, ordered and logger.
 func New(conf config.Config, orderer gomel.Orderer, log zerolog.Logger, setup bool) (gomel.Syncer, error) {
-	s := &syncer{}
+	s := &syncer{health: sync.NewHealth(conf.NProc)}
 
 	// init fetch
 	var netserv network.Server
 	var err error
-	netserv, s.subservices, err = getNetServ(conf.FetchNetType, conf.Pid, conf.FetchAddresses, s.subservices, conf.Timeout, log)
+	netserv, s.subservices, err = getNetServ(conf.FetchNetType, conf, conf.FetchAddresses, s.subservices, log)
 	if err != nil {
 		return nil, err
 	}
-	serv, ftrigger := fetch.NewServer(conf, orderer, netserv, log.With().Int(lg.Service, lg.FetchService).Logger())
+	netserv = metrics.CountBytes(netserv, "fetch")
+	serv, ftrigger, htrigger := fetch.NewServer(conf, orderer, netserv, s.health, log.With().Int(lg.Service, lg.FetchService).Logger())
 	s.servers = append(s.servers, serv)
 	s.fetch = ftrigger
+	s.fetchByHash = htrigger
 	// init gossip
-	netserv, s.subservices, err = getNetServ(conf.GossipNetType, conf.Pid, conf.GossipAddresses, s.subservices, conf.Timeout, log)
+	netserv, s.subservices, err = getNetServ(conf.GossipNetType, conf, conf.GossipAddresses, s.subservices, log)
 	if err != nil {
 		return nil, err
 	}
-	serv, gtrigger := gossip.NewServer(conf, orderer, netserv, log.With().Int(lg.Service, lg.GossipService).Logger())
+	netserv = metrics.CountBytes(netserv, "gossip")
+	serv, gtrigger := gossip.NewServer(conf, orderer, netserv, s.health, log.With().Int(lg.Service, lg.GossipService).Logger())
 	s.servers = append(s.servers, serv)
 	s.gossip = gtrigger
 	if setup {
 		// init rmc
-		netserv, s.subservices, err = getNetServ(conf.RMCNetType, conf.Pid, conf.RMCAddresses, s.subservices, conf.Timeout, log)
+		netserv, s.subservices, err = getNetServ(conf.RMCNetType, conf, conf.RMCAddresses, s.subservices, log)
 		if err != nil {
 			return nil, err
 		}
+		netserv = metrics.CountBytes(netserv, "rmc")
 		serv, s.mcast = rmc.NewServer(conf, orderer, netserv, log.With().Int(lg.Service, lg.RMCService).Logger())
 		s.servers = append(s.servers, serv)
 	} else {
 		// init mcast
-		netserv, s.subservices, err = getNetServ(conf.MCastNetType, conf.Pid, conf.MCastAddresses, s.subservices, conf.Timeout, log)
+		netserv, s.subservices, err = getNetServ(conf.MCastNetType, conf, conf.MCastAddresses, s.subservices, log)
 		if err != nil {
 			return nil, err
 		}
+		netserv = metrics.CountBytes(netserv, "mcast")
 		serv, s.mcast = multicast.NewServer(conf, orderer, netserv, log.With().Int(lg.Service, lg.MCService).Logger())
 		s.servers = append(s.servers, serv)
 	}
@@ -72,6 +83,11 @@ func New(conf config.Config, orderer gomel.Orderer, log zerolog.Logger, setup bo
 func (s *syncer) Multicast(u gomel.Unit)                { s.mcast(u) }
 func (s *syncer) RequestFetch(pid uint16, ids []uint64) { s.fetch(pid, ids) }
 func (s *syncer) RequestGossip(pid uint16)              { s.gossip(pid) }
+func (s *syncer) Peers() []gomel.PeerStatus             { return s.health.Peers() }
+
+func (s *syncer) RequestFetchByHash(pid uint16, hashes []*gomel.Hash, depth int, fallback []uint64) {
+	s.fetchByHash(pid, hashes, depth, fallback)
+}
 
 func (s *syncer) Start() {
 	for _, service := range s.subservices {
@@ -108,7 +124,20 @@ func (ns *networkService) Stop() {
 }
 
 // Return network.Server of the type indicated by "net". If needed, append a corresponding service to the given slice. Defaults to "tcp".
-func getNetServ(net string, pid uint16, addresses []string, services []core.Service, timeout time.Duration, log zerolog.Logger) (network.Server, []core.Service, error) {
+// Types with the secure.Prefix return a server of the remaining type wrapped in the secure transport.
+func getNetServ(net string, conf config.Config, addresses []string, services []core.Service, log zerolog.Logger) (network.Server, []core.Service, error) {
+	pid, timeout := conf.Pid, conf.Timeout
+	if strings.HasPrefix(net, secure.Prefix) {
+		netserv, services, err := getNetServ(strings.TrimPrefix(net, secure.Prefix), conf, addresses, services, log)
+		if err != nil {
+			return nil, services, err
+		}
+		keys, err := p2p.Keys(conf.P2PSecretKey, conf.P2PPublicKeys, pid)
+		if err != nil {
+			return nil, services, err
+		}
+		return secure.NewServer(netserv, pid, keys), services, nil
+	}
 	switch net {
 	case "udp":
 		netLogger := log.With().Int(lg.Service, lg.NetworkService).Logger()
@@ -119,6 +148,16 @@ func getNetServ(net string, pid uint16, addresses []string, services []core.Serv
 		netService := newNetworkService(netserv)
 		services = append(services, netService)
 
+		return netserv, services, nil
+	case "mem":
+		netserv, err := mem.NewServer(addresses[pid], addresses)
+		if err != nil {
+			return nil, services, err
+		}
+		netserv = network.NewTimeoutConnectionServer(netserv, timeout)
+		netService := newNetworkService(netserv)
+		services = append(services, netService)
+
 		return netserv, services, nil
 	case "pers":
 		netLogger := log.With().Int(lg.Service, lg.NetworkService).Logger()
@@ -13,6 +13,8 @@ func NewAdder(dag gomel.Dag) gomel.Adder {
 
 func (ad *adder) Close() {}
 
+func (ad *adder) Backlog() (int, int) { return 0, 0 }
+
 func (ad *adder) AddPreunits(source uint16, pus ...gomel.Preunit) []error {
 	var result []error
 	getErrors := func() []error {
@@ -0,0 +1,35 @@
+package tests
+
+import (
+	"gitlab.com/alephledger/consensus-go/pkg/config"
+	"gitlab.com/alephledger/core-go/pkg/crypto/bn256"
+	"gitlab.com/alephledger/core-go/pkg/crypto/p2p"
+)
+
+// AddP2PKeys generates p2p keys for a committee and puts them in the given configs, one for every member.
+// It is useful when testing protocols that authenticate peers.
+func AddP2PKeys(confs ...config.Config) {
+	pks := make([]*p2p.PublicKey, len(confs))
+	sks := make([]*p2p.SecretKey, len(confs))
+	for i := range confs {
+		pks[i], sks[i], _ = p2p.GenerateKeys()
+	}
+	for i, cnf := range confs {
+		cnf.P2PPublicKeys = pks
+		cnf.P2PSecretKey = sks[i]
+	}
+}
+
+// AddRMCKeys generates RMC keys for a committee and puts them in the given configs, one for every member.
+// It is useful when testing protocols that multisign messages.
+func AddRMCKeys(confs ...config.Config) {
+	pks := make([]*bn256.VerificationKey, len(confs))
+	sks := make([]*bn256.SecretKey, len(confs))
+	for i := range confs {
+		pks[i], sks[i], _ = bn256.GenerateKeys()
+	}
+	for i, cnf := range confs {
+		cnf.RMCPublicKeys = pks
+		cnf.RMCPrivateKey = sks[i]
+	}
+}
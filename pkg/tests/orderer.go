@@ -36,6 +36,10 @@ func (o orderer) MaxUnits(gomel.EpochID) gomel.SlottedUnits {
 	return nil
 }
 
+func (o orderer) Status() *gomel.Status {
+	return &gomel.Status{}
+}
+
 func (o orderer) SetAlerter(gomel.Alerter) {
 }
 
@@ -0,0 +1,15 @@
+package tests
+
+import (
+	"fmt"
+
+	"gitlab.com/alephledger/consensus-go/pkg/gomel"
+	"gitlab.com/alephledger/core-go/pkg/core"
+)
+
+// Preblock returns a preblock with the data and random bytes determined by the given epoch and level,
+// so that every position gets its own preblock. It carries more than one piece of data to exercise encodings.
+func Preblock(epoch gomel.EpochID, level int) *core.Preblock {
+	data := []core.Data{core.Data(fmt.Sprintf("data %d %d", epoch, level)), core.Data("more")}
+	return core.NewPreblock(data, []byte(fmt.Sprintf("random %d %d", epoch, level)))
+}
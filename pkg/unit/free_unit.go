@@ -28,6 +28,26 @@ func New(creator uint16, epoch gomel.EpochID, parents []gomel.Unit, level int, d
 	return u
 }
 
+// NewSigned constructs a new freeUnit with given set of parents and signs it with provided signer.
+func NewSigned(creator uint16, epoch gomel.EpochID, parents []gomel.Unit, level int, data core.Data, rsData []byte, signer gomel.Signer) (gomel.Unit, error) {
+	crown := gomel.CrownFromParents(parents)
+	height := crown.Heights[creator] + 1
+	id := gomel.ID(height, creator, epoch)
+	pu := &preunit{creator, epoch, height, nil, computeHash(id, crown, data, rsData), crown, data, rsData}
+	signature, err := signer.SignUnit(pu)
+	if err != nil {
+		return nil, err
+	}
+	pu.signature = signature
+	u := &freeUnit{
+		Preunit: pu,
+		parents: parents,
+		level:   level,
+	}
+	u.computeFloor()
+	return u, nil
+}
+
 // FromPreunit creates a new freeUnit based on the given preunit and a list of parents.
 func FromPreunit(pu gomel.Preunit, parents []gomel.Unit) gomel.Unit {
 	u := &freeUnit{